@@ -42,8 +42,8 @@ func (d *Duration) UnmarshalJSON(b []byte) error {
 }
 
 type Configuration struct {
-	Format            string     `json:"format" validate:"required,oneof=xml json"`
-	SyslogServer      string     `json:"syslogServer" validate:"required,hostname_port"`
+	Format            string     `json:"format" validate:"required,oneof=xml json cef leef ecs ocsf gelf template"`
+	SyslogServer      string     `json:"syslogServer" validate:"required_unless=Format gelf,omitempty,hostname_port"`
 	SyslogProtocol    string     `json:"syslogProtocol" validate:"oneof='' tcp udp"`
 	SyslogTag         string     `json:"syslogTag" validate:"required"`
 	DNSServer         string     `json:"dnsServer"`
@@ -55,6 +55,82 @@ type Configuration struct {
 	BatchSize         int        `json:"batchSize" validate:"required,gt=0"`
 	EventID           string     `json:"eventID" validate:"required"`
 	EventCategory     string     `json:"eventCategory" validate:"required"`
+	Header            Header     `json:"header"`
+	GELF              GELFConfig `json:"gelf"`
+	Template          string     `json:"template" validate:"required_if=Format template,omitempty,file"`
+	Fields            Fields     `json:"fields"`
+	AuthResults       string     `json:"authResults" validate:"oneof=array flatten"`
+	Limits            Limits     `json:"limits"`
+	Validation        string     `json:"validation" validate:"oneof=strict lenient"`
+	PublicSuffixList  string     `json:"publicSuffixList" validate:"omitempty,file"`
+	GeoIP             GeoIP      `json:"geoip"`
+	Senders           Senders    `json:"senders"`
+	SPFAnalysis       SPFConfig  `json:"spfAnalysis"`
+	Forwarding        Forwarding `json:"forwarding"`
+	PolicyDrift       DriftCheck `json:"policyDrift"`
+	// KnownQuirks contains the names of the known quirks of every reporter domain
+	KnownQuirks map[string][]string `json:"knownQuirks" validate:"dive,keys,fqdn,endkeys"`
+}
+
+// Limits protects against oversized messages and decompression bombs.
+// Sizes are in bytes, a value of 0 disables the limit.
+type Limits struct {
+	MaxMessageSize      int64 `json:"maxMessageSize" validate:"gte=0"`
+	MaxAttachmentSize   int64 `json:"maxAttachmentSize" validate:"gte=0"`
+	MaxDecompressedSize int64 `json:"maxDecompressedSize" validate:"gte=0"`
+	MaxCompressionRatio int64 `json:"maxCompressionRatio" validate:"gte=0"`
+	MaxRecords          int   `json:"maxRecords" validate:"gte=0"`
+}
+
+// Fields modifies the fields of every output entry. Nested fields
+// are addressed by their path separated by dots.
+type Fields struct {
+	Include []string          `json:"include"`
+	Exclude []string          `json:"exclude"`
+	Rename  map[string]string `json:"rename"`
+	Extra   map[string]string `json:"extra"`
+}
+
+// GeoIP configures the optional enrichment of the source IP from MMDB files
+type GeoIP struct {
+	Databases      []string `json:"databases" validate:"dive,file"`
+	ReloadInterval Duration `json:"reloadInterval" validate:"required"`
+}
+
+// Senders configures the optional classification of the source using an
+// inventory of the known senders
+type Senders struct {
+	Inventory      string   `json:"inventory" validate:"omitempty,file"`
+	ReloadInterval Duration `json:"reloadInterval" validate:"required"`
+}
+
+// SPFConfig configures the optional evaluation of the live SPF record for
+// SPF results which did not pass. An empty list of domains analyzes all
+// domains.
+type SPFConfig struct {
+	Enabled bool     `json:"enabled"`
+	Domains []string `json:"domains" validate:"dive,fqdn"`
+}
+
+// Forwarding configures the detection of forwarded mail
+type Forwarding struct {
+	// PTRSuffixes are added to the built-in list of forwarding services
+	PTRSuffixes []string `json:"ptrSuffixes" validate:"dive,fqdn"`
+}
+
+// DriftCheck configures the comparison of the reported policy with the live
+// DMARC record of the domain. The rua tag is only compared if report
+// addresses are set.
+type DriftCheck struct {
+	Enabled         bool     `json:"enabled"`
+	ReportAddresses []string `json:"reportAddresses" validate:"dive,email"`
+}
+
+// Header contains the device information used in the CEF, LEEF, ECS and OCSF formats
+type Header struct {
+	Vendor  string `json:"vendor" validate:"required"`
+	Product string `json:"product" validate:"required"`
+	Version string `json:"version" validate:"required"`
 }
 
 type IMAPConfig struct {
@@ -67,6 +143,43 @@ type IMAPConfig struct {
 	Timeout    Duration `json:"timeout" validate:"required"`
 }
 
+// GELFConfig configures the transport used when the format is gelf
+type GELFConfig struct {
+	Protocol    string   `json:"protocol" validate:"oneof=udp tcp http"`
+	Server      string   `json:"server" validate:"omitempty,hostname_port|http_url"`
+	Compression string   `json:"compression" validate:"omitempty,oneof=none gzip zlib"`
+	ChunkSize   int      `json:"chunkSize" validate:"gte=0"`
+	Timeout     Duration `json:"timeout" validate:"required"`
+}
+
+// validateGELF requires gelf.server when using the gelf format. Tags like
+// required_if can only reference fields of the same struct, so the format
+// of the parent is checked on the struct level. The compression must be
+// supported by the protocol.
+func validateGELF(sl validator.StructLevel) {
+	c, ok := sl.Current().Interface().(Configuration)
+	if !ok {
+		return
+	}
+	if c.Format == "gelf" && c.GELF.Server == "" {
+		sl.ReportError(c.GELF.Server, "GELF.Server", "Server", "required_if", "Format gelf")
+	}
+	// tcp does not support compression and http only supports gzip
+	switch {
+	case c.GELF.Protocol == "tcp" && c.GELF.Compression != "none",
+		c.GELF.Protocol == "http" && c.GELF.Compression == "zlib":
+		sl.ReportError(c.GELF.Compression, "GELF.Compression", "Compression", "unsupported", c.GELF.Protocol)
+	}
+}
+
+// defaultGELFCompression returns the default compression of the protocol
+func defaultGELFCompression(protocol string) string {
+	if protocol == "tcp" {
+		return "none"
+	}
+	return "gzip"
+}
+
 func GetConfig(f string) (Configuration, error) {
 	if f == "" {
 		return Configuration{}, errors.New("please provide a valid config file")
@@ -92,6 +205,37 @@ func GetConfig(f string) (Configuration, error) {
 		},
 		EventID:       "",
 		EventCategory: "",
+		Header: Header{
+			Vendor:  "firefart",
+			Product: "dmarcsyslogforwarder",
+			Version: "1.0",
+		},
+		AuthResults: "array",
+		Validation:  "lenient",
+		Limits: Limits{
+			MaxMessageSize:      50 * 1024 * 1024,
+			MaxAttachmentSize:   25 * 1024 * 1024,
+			MaxDecompressedSize: 200 * 1024 * 1024,
+			MaxCompressionRatio: 200,
+			MaxRecords:          100000,
+		},
+		GeoIP: GeoIP{
+			ReloadInterval: Duration{
+				Duration: 1 * time.Minute,
+			},
+		},
+		Senders: Senders{
+			ReloadInterval: Duration{
+				Duration: 1 * time.Minute,
+			},
+		},
+		GELF: GELFConfig{
+			Protocol:  "udp",
+			ChunkSize: 1420,
+			Timeout: Duration{
+				Duration: 5 * time.Second,
+			},
+		},
 	}
 
 	b, err := os.ReadFile(f) // nolint: gosec
@@ -105,8 +249,13 @@ func GetConfig(f string) (Configuration, error) {
 	if err = decoder.Decode(&defaults); err != nil {
 		return Configuration{}, err
 	}
+	// the default compression depends on the protocol
+	if defaults.GELF.Compression == "" {
+		defaults.GELF.Compression = defaultGELFCompression(defaults.GELF.Protocol)
+	}
 
 	validate := validator.New(validator.WithRequiredStructEnabled())
+	validate.RegisterStructValidation(validateGELF, Configuration{})
 
 	if err := validate.Struct(defaults); err != nil {
 		var invalidValidationError *validator.InvalidValidationError
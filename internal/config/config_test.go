@@ -1,6 +1,8 @@
 package config
 
 import (
+	"encoding/json"
+	"os"
 	"path"
 	"testing"
 )
@@ -29,3 +31,65 @@ func TestGetConfigInvalid(t *testing.T) {
 		t.Fatal("expected error when reading config file but got none")
 	}
 }
+
+func TestGetConfigGELF(t *testing.T) {
+	t.Parallel()
+
+	base, err := os.ReadFile(path.Join("..", "..", "testdata", "test.json"))
+	if err != nil {
+		t.Fatalf("could not read config: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		format      string
+		gelf        map[string]any
+		compression string
+		wantErr     bool
+	}{
+		{name: "not used", format: "xml", compression: "gzip"},
+		{name: "missing server", format: "gelf", wantErr: true},
+		{name: "udp", format: "gelf", gelf: map[string]any{"protocol": "udp", "server": "graylog:12201"}, compression: "gzip"},
+		{name: "tcp", format: "gelf", gelf: map[string]any{"protocol": "tcp", "server": "graylog:12201"}, compression: "none"},
+		{name: "http", format: "gelf", gelf: map[string]any{"protocol": "http", "server": "http://graylog:12201/gelf"}, compression: "gzip"},
+		{name: "udp zlib", format: "gelf", gelf: map[string]any{"protocol": "udp", "server": "graylog:12201", "compression": "zlib"}, compression: "zlib"},
+		{name: "tcp gzip", format: "gelf", gelf: map[string]any{"protocol": "tcp", "server": "graylog:12201", "compression": "gzip"}, wantErr: true},
+		{name: "http zlib", format: "gelf", gelf: map[string]any{"protocol": "http", "server": "http://graylog:12201/gelf", "compression": "zlib"}, wantErr: true},
+		{name: "invalid server", format: "gelf", gelf: map[string]any{"server": "graylog"}, wantErr: true},
+		{name: "invalid server without gelf format", format: "xml", gelf: map[string]any{"server": "graylog"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			var settings map[string]any
+			if err := json.Unmarshal(base, &settings); err != nil {
+				t.Fatalf("could not parse config: %v", err)
+			}
+			settings["format"] = tt.format
+			if tt.gelf != nil {
+				settings["gelf"] = tt.gelf
+			}
+			b, err := json.Marshal(settings)
+			if err != nil {
+				t.Fatalf("could not marshal config: %v", err)
+			}
+			filename := path.Join(t.TempDir(), "config.json")
+			if err := os.WriteFile(filename, b, 0o600); err != nil {
+				t.Fatalf("could not write config: %v", err)
+			}
+			c, err := GetConfig(filename)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if c.GELF.Compression != tt.compression {
+				t.Errorf("expected compression %q, got %q", tt.compression, c.GELF.Compression)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package dmarc
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/firefart/dmarcsyslogforwarder/internal/psl"
+)
+
+// alignmentStrict is the adkim/aspf value for strict alignment. All other
+// values including an empty value mean relaxed alignment.
+const alignmentStrict = "s"
+
+// organizationalDomain returns the organisational domain (RFC 7489 section
+// 3.2) using the public suffix list. If the domain is a public suffix itself
+// the domain is returned.
+func organizationalDomain(list *psl.List, domain string) string {
+	domain = normalizeDomain(domain)
+	if org := list.OrganizationalDomain(domain); org != "" {
+		return org
+	}
+	return domain
+}
+
+// organizationalDomains returns the unique organisational domains of the
+// domains
+func organizationalDomains(list *psl.List, domains []string) []string {
+	ret := make([]string, 0, len(domains))
+	for _, d := range domains {
+		org := list.OrganizationalDomain(d)
+		if org != "" && !slices.Contains(ret, org) {
+			ret = append(ret, org)
+		}
+	}
+	return ret
+}
+
+// aligned checks if the authenticated domain aligns with the header_from
+// domain. Strict mode requires an exact match, relaxed mode the same
+// organisational domain.
+func aligned(list *psl.List, domain, headerFrom, mode string) bool {
+	domain = normalizeDomain(domain)
+	headerFrom = normalizeDomain(headerFrom)
+	if domain == "" || headerFrom == "" {
+		return false
+	}
+	if domain == headerFrom {
+		return true
+	}
+	if strings.EqualFold(mode, alignmentStrict) {
+		return false
+	}
+	return organizationalDomain(list, domain) == organizationalDomain(list, headerFrom)
+}
+
+// computeAlignment sets the alignment of every auth result and the computed
+// DMARC result of the entry. DMARC passes if at least one DKIM or SPF result
+// passed with an aligned domain. DMARC only uses the SPF result of the
+// MAIL FROM identity (RFC 7489 section 3.1.2), so other scopes like helo are
+// never aligned.
+func computeAlignment(list *psl.List, entry *SyslogEntry) {
+	for i, r := range entry.ResultDkim {
+		entry.ResultDkim[i].Aligned = aligned(list, r.Domain, entry.HeaderFrom, entry.PolicyPublished.Adkim)
+		if entry.ResultDkim[i].Aligned {
+			entry.DKIMAligned = true
+			if strings.EqualFold(r.Result, "pass") {
+				entry.DMARCPass = true
+			}
+		}
+	}
+	for i, r := range entry.ResultSpf {
+		if !isMailFrom(r.Scope) {
+			continue
+		}
+		entry.ResultSpf[i].Aligned = aligned(list, r.Domain, entry.HeaderFrom, entry.PolicyPublished.Aspf)
+		if entry.ResultSpf[i].Aligned {
+			entry.SPFAligned = true
+			if strings.EqualFold(r.Result, "pass") {
+				entry.DMARCPass = true
+			}
+		}
+	}
+}
+
+// isMailFrom checks if the SPF scope is the MAIL FROM identity. Reports
+// without a scope refer to the MAIL FROM identity.
+func isMailFrom(scope string) bool {
+	return scope == "" || strings.EqualFold(scope, "mfrom")
+}
@@ -0,0 +1,140 @@
+package dmarc
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/firefart/dmarcsyslogforwarder/internal/psl"
+)
+
+func TestAligned(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		domain     string
+		headerFrom string
+		mode       string
+		expected   bool
+	}{
+		{domain: "example.com", headerFrom: "example.com", mode: "s", expected: true},
+		{domain: "Example.com.", headerFrom: "example.COM", mode: "s", expected: true},
+		{domain: "mail.example.com", headerFrom: "example.com", mode: "s", expected: false},
+		{domain: "mail.example.com", headerFrom: "example.com", mode: "r", expected: true},
+		{domain: "mail.example.com", headerFrom: "news.example.com", mode: "", expected: true},
+		{domain: "example.net", headerFrom: "example.com", mode: "r", expected: false},
+		// different organisational domains below a public suffix
+		{domain: "a.example.co.uk", headerFrom: "b.example.co.uk", mode: "r", expected: true},
+		{domain: "example.co.uk", headerFrom: "other.co.uk", mode: "r", expected: false},
+		{domain: "foo.github.io", headerFrom: "bar.github.io", mode: "r", expected: false},
+		{domain: "", headerFrom: "example.com", mode: "r", expected: false},
+		{domain: "example.com", headerFrom: "", mode: "r", expected: false},
+	}
+	for _, tt := range tests {
+		if got := aligned(psl.Default(), tt.domain, tt.headerFrom, tt.mode); got != tt.expected {
+			t.Errorf("aligned(%q, %q, %q): expected %t, got %t", tt.domain, tt.headerFrom, tt.mode, tt.expected, got)
+		}
+	}
+}
+
+func TestComputeAlignment(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		entry       SyslogEntry
+		dkimAligned bool
+		spfAligned  bool
+		dmarcPass   bool
+	}{
+		{
+			name: "aligned dkim pass",
+			entry: SyslogEntry{
+				HeaderFrom: "example.com",
+				ResultDkim: []SyslogResultDKIM{{Domain: "esp.example", Result: "pass"}, {Domain: "mail.example.com", Result: "pass"}},
+				ResultSpf:  []SyslogResultSPF{{Domain: "bounce.esp.example", Result: "pass"}},
+			},
+			dkimAligned: true,
+			dmarcPass:   true,
+		},
+		{
+			name: "strict dkim fails alignment",
+			entry: SyslogEntry{
+				HeaderFrom:      "example.com",
+				PolicyPublished: SyslogPolicyPublished{Adkim: "s"},
+				ResultDkim:      []SyslogResultDKIM{{Domain: "mail.example.com", Result: "pass"}},
+			},
+		},
+		{
+			name: "aligned spf pass",
+			entry: SyslogEntry{
+				HeaderFrom: "example.com",
+				ResultDkim: []SyslogResultDKIM{{Domain: "example.com", Result: "fail"}},
+				ResultSpf:  []SyslogResultSPF{{Domain: "bounce.example.com", Result: "pass"}},
+			},
+			dkimAligned: true,
+			spfAligned:  true,
+			dmarcPass:   true,
+		},
+		{
+			name: "aligned but failed",
+			entry: SyslogEntry{
+				HeaderFrom: "example.com",
+				ResultDkim: []SyslogResultDKIM{{Domain: "example.com", Result: "fail"}},
+				ResultSpf:  []SyslogResultSPF{{Domain: "example.com", Result: "softfail"}},
+			},
+			dkimAligned: true,
+			spfAligned:  true,
+		},
+		{
+			name: "aligned helo pass with failed mfrom",
+			entry: SyslogEntry{
+				HeaderFrom: "example.com",
+				ResultSpf: []SyslogResultSPF{
+					{Domain: "mail.example.com", Scope: "helo", Result: "pass"},
+					{Domain: "esp.example", Scope: "mfrom", Result: "fail"},
+				},
+			},
+		},
+		{
+			name: "aligned mfrom pass",
+			entry: SyslogEntry{
+				HeaderFrom: "example.com",
+				ResultSpf:  []SyslogResultSPF{{Domain: "bounce.example.com", Scope: "MFROM", Result: "pass"}},
+			},
+			spfAligned: true,
+			dmarcPass:  true,
+		},
+		{
+			name: "passed but not aligned",
+			entry: SyslogEntry{
+				HeaderFrom: "example.com",
+				ResultDkim: []SyslogResultDKIM{{Domain: "esp.example", Result: "pass"}},
+				ResultSpf:  []SyslogResultSPF{{Domain: "esp.example", Result: "pass"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			entry := tt.entry
+			computeAlignment(psl.Default(), &entry)
+			if entry.DKIMAligned != tt.dkimAligned || entry.SPFAligned != tt.spfAligned || entry.DMARCPass != tt.dmarcPass {
+				t.Fatalf("expected dkim_aligned=%t spf_aligned=%t dmarc_pass=%t, got %t %t %t",
+					tt.dkimAligned, tt.spfAligned, tt.dmarcPass, entry.DKIMAligned, entry.SPFAligned, entry.DMARCPass)
+			}
+		})
+	}
+}
+
+func TestOrganizationalDomains(t *testing.T) {
+	t.Parallel()
+
+	got := organizationalDomains(psl.Default(), []string{"mail-a.example.com.", "mail-b.example.com", "o1.sendgrid.net", "com", ""})
+	expected := []string{"example.com", "sendgrid.net"}
+	if !slices.Equal(got, expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+	if got := organizationalDomains(psl.Default(), nil); got == nil || len(got) != 0 {
+		t.Fatalf("expected an empty list, got %#v", got)
+	}
+}
@@ -0,0 +1,172 @@
+package dmarc
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/firefart/dmarcsyslogforwarder/internal/fields"
+)
+
+// https://www.microfocus.com/documentation/arcsight/arcsight-smartconnectors/pdfdoc/common-event-format-v25/common-event-format-v25.pdf
+var (
+	cefHeaderEscaper    = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\r", " ", "\n", " ")
+	cefExtensionEscaper = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\r", `\r`, "\n", `\n`)
+)
+
+type cefFormatter struct {
+	header DeviceHeader
+	fields *fields.Filter
+}
+
+// Format converts the entry into an ArcSight CEF line
+func (f cefFormatter) Format(entry SyslogEntry) ([]byte, error) {
+	return formatCEF(entry, f.header, f.fields), nil
+}
+
+// FormatFailure converts the failure report into an ArcSight CEF line
+func (f cefFormatter) FormatFailure(entry FailureEntry) ([]byte, error) {
+	return formatFailureCEF(entry, f.header, f.fields), nil
+}
+
+// FormatTLS converts the TLS report entry into an ArcSight CEF line
+func (f cefFormatter) FormatTLS(entry TLSEntry) ([]byte, error) {
+	return formatTLSCEF(entry, f.header, f.fields), nil
+}
+
+// keyValue represents a single extension field. If label is set
+// an additional <key>Label field is written for custom fields. The
+// field is the path of the entry field used by the field filter.
+type keyValue struct {
+	key   string
+	label string
+	field string
+	value string
+}
+
+func formatCEF(entry SyslogEntry, header DeviceHeader, filter *fields.Filter) []byte {
+	extensions := []keyValue{
+		{key: "cat", field: "event_category", value: entry.EventCategory},
+		{key: "rt", field: "date_end", value: strconv.FormatInt(entry.DateEnd*1000, 10)},
+		{key: "start", field: "date_begin", value: strconv.FormatInt(entry.DateBegin*1000, 10)},
+		{key: "end", field: "date_end", value: strconv.FormatInt(entry.DateEnd*1000, 10)},
+		{key: "src", field: "source_ip", value: entry.SourceIP},
+		{key: "shost", field: "source_dns", value: firstNonEmpty(entry.SourceDNS)},
+		{key: "cnt", field: "count", value: strconv.Itoa(entry.Count)},
+		{key: "act", field: "policy_evaluated.disposition", value: entry.PolicyEvaluated.Disposition},
+		{key: "reason", field: "policy_evaluated.reason", value: overrideReasons(entry)},
+		{key: "externalId", field: "report_id", value: entry.ReportID},
+		{key: "dhost", field: "domain", value: entry.Domain},
+		{key: "suser", field: "envelope_from", value: entry.EnvelopeFrom},
+		{key: "duser", field: "envelope_to", value: entry.EnvelopeTo},
+		{key: "cs1", label: "headerFrom", field: "header_from", value: entry.HeaderFrom},
+		{key: "cs2", label: "policyDomain", field: "policy_domain", value: entry.PolicyDomain},
+		{key: "cs3", label: "policyPublished", field: "policy_published.p", value: entry.PolicyPublished.P},
+		{key: "cs4", label: "dkimResult", field: entry.dkimPath("result"), value: joinResults(entry.dkimResults(), dkimResult)},
+		{key: "cs5", label: "spfResult", field: entry.spfPath("result"), value: joinResults(entry.spfResults(), spfResult)},
+		{key: "cs6", label: "reportingOrg", field: "org_name", value: entry.OrgName},
+		{key: "flexString1", label: "dkimAlignment", field: "policy_evaluated.dkim", value: entry.PolicyEvaluated.Dkim},
+		{key: "flexString2", label: "spfAlignment", field: "policy_evaluated.spf", value: entry.PolicyEvaluated.Spf},
+		{key: "outcome", field: "dmarc_pass", value: dmarcResult(entry.DMARCPass)},
+		{key: "cn1", label: "dkimAligned", field: "dkim_aligned", value: strconv.Itoa(boolToInt(entry.DKIMAligned))},
+		{key: "cn2", label: "spfAligned", field: "spf_aligned", value: strconv.Itoa(boolToInt(entry.SPFAligned))},
+		{key: "cn3", label: "likelyForwarded", field: "likely_forwarded", value: strconv.Itoa(boolToInt(entry.LikelyForwarded))},
+		{key: "msg", field: "validation_warnings", value: strings.Join(slices.Concat(entry.ValidationWarnings, entry.DomainMismatches), "; ")},
+		{key: "authResultIndex", field: "auth_result_index", value: formatPosition(entry.AuthResultIndex)},
+		{key: "authResultTotal", field: "auth_result_total", value: formatPosition(entry.AuthResultTotal)},
+	}
+	extensions = append(extensions, enrichmentAttributes(entry)...)
+
+	return writeCEF(header, signatureID(entry.EventID, defaultSignatureID), defaultEventName, severity(entry), extensions, filter)
+}
+
+func formatFailureCEF(entry FailureEntry, header DeviceHeader, filter *fields.Filter) []byte {
+	arrival := strconv.FormatInt(time.Time(entry.ArrivalDateParsed).UnixMilli(), 10)
+	extensions := []keyValue{
+		{key: "cat", field: "event_category", value: entry.EventCategory},
+		{key: "rt", field: "arrival_date_parsed", value: arrival},
+		{key: "start", field: "arrival_date_parsed", value: arrival},
+		{key: "src", field: "source_ip", value: entry.SourceIP},
+		{key: "shost", field: "source_dns", value: firstNonEmpty(entry.SourceDNS)},
+		{key: "cnt", field: "incidents", value: strconv.Itoa(entry.Incidents)},
+		{key: "act", field: "delivery_result", value: entry.DeliveryResult},
+		{key: "reason", field: "auth_failure", value: strings.Join(entry.AuthFailure, ",")},
+		{key: "externalId", field: "original_message_id", value: entry.OriginalMessageID},
+		{key: "msg", field: "original_subject", value: entry.OriginalSubject},
+		{key: "dhost", field: "reported_domain", value: strings.Join(entry.ReportedDomain, ",")},
+		{key: "suser", field: "original_mail_from", value: entry.OriginalMailFrom},
+		{key: "duser", field: "original_rcpt_to", value: strings.Join(entry.OriginalRcptTo, ",")},
+		{key: "cs1", label: "headerFrom", field: "original_from", value: entry.OriginalFrom},
+		{key: "cs2", label: "dkimDomain", field: "dkim_domain", value: entry.DKIMDomain},
+		{key: "cs3", label: "dkimSelector", field: "dkim_selector", value: entry.DKIMSelector},
+		{key: "cs4", label: "identityAlignment", field: "identity_alignment", value: entry.IdentityAlignment},
+		{key: "cs5", label: "feedbackType", field: "feedback_type", value: entry.FeedbackType},
+		{key: "cs6", label: "reportingMTA", field: "reporting_mta", value: entry.ReportingMTA},
+	}
+
+	return writeCEF(header, signatureID(entry.EventID, defaultFailureSignatureID), defaultFailureEventName, failureSeverity(entry), extensions, filter)
+}
+
+func formatTLSCEF(entry TLSEntry, header DeviceHeader, filter *fields.Filter) []byte {
+	count, countField := entry.TotalFailureSessionCount, "total_failure_session_count"
+	if entry.EntryType == TLSEntryFailureDetail {
+		count, countField = entry.FailedSessionCount, "failed_session_count"
+	}
+	extensions := []keyValue{
+		{key: "cat", field: "event_category", value: entry.EventCategory},
+		{key: "rt", field: "date_end", value: strconv.FormatInt(entry.DateEnd*1000, 10)},
+		{key: "start", field: "date_begin", value: strconv.FormatInt(entry.DateBegin*1000, 10)},
+		{key: "end", field: "date_end", value: strconv.FormatInt(entry.DateEnd*1000, 10)},
+		{key: "src", field: "sending_mta_ip", value: entry.SendingMTAIP},
+		{key: "dst", field: "receiving_ip", value: entry.ReceivingIP},
+		{key: "dhost", field: "receiving_mx_hostname", value: entry.ReceivingMXHostname},
+		{key: "cnt", field: countField, value: strconv.Itoa(count)},
+		{key: "outcome", field: "result_type", value: entry.ResultType},
+		{key: "reason", field: "failure_reason_code", value: entry.FailureReasonCode},
+		{key: "msg", field: "additional_information", value: entry.AdditionalInformation},
+		{key: "externalId", field: "report_id", value: entry.ReportID},
+		{key: "cs1", label: "policyDomain", field: "policy_domain", value: entry.PolicyDomain},
+		{key: "cs2", label: "policyType", field: "policy_type", value: entry.PolicyType},
+		{key: "cs3", label: "mxHost", field: "mx_host", value: strings.Join(entry.MXHost, ",")},
+		{key: "cs6", label: "reportingOrg", field: "organization_name", value: entry.OrganizationName},
+		{key: "cn1", label: "successfulSessions", field: "total_successful_session_count", value: strconv.Itoa(entry.TotalSuccessfulSessionCount)},
+		{key: "cn2", label: "failedSessions", field: "total_failure_session_count", value: strconv.Itoa(entry.TotalFailureSessionCount)},
+	}
+
+	return writeCEF(header, signatureID(entry.EventID, defaultTLSSignatureID), tlsEventName(entry), tlsSeverity(entry), extensions, filter)
+}
+
+// writeCEF writes the header and all non empty extensions
+func writeCEF(header DeviceHeader, signature, name string, sev int, extensions []keyValue, filter *fields.Filter) []byte {
+	var sb strings.Builder
+	sb.WriteString("CEF:0|")
+	for _, h := range []string{header.Vendor, header.Product, header.Version, signature, name} {
+		sb.WriteString(cefHeaderEscaper.Replace(h))
+		sb.WriteString("|")
+	}
+	sb.WriteString(strconv.Itoa(sev))
+	sb.WriteString("|")
+
+	first := true
+	for _, e := range filterKeyValues(extensions, filter) {
+		if e.value == "" {
+			continue
+		}
+		if !first {
+			sb.WriteString(" ")
+		}
+		first = false
+		if e.label != "" {
+			sb.WriteString(e.key)
+			sb.WriteString("Label=")
+			sb.WriteString(cefExtensionEscaper.Replace(e.label))
+			sb.WriteString(" ")
+		}
+		sb.WriteString(e.key)
+		sb.WriteString("=")
+		sb.WriteString(cefExtensionEscaper.Replace(e.value))
+	}
+
+	return []byte(sb.String())
+}
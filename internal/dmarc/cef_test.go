@@ -0,0 +1,63 @@
+package dmarc
+
+import "testing"
+
+func TestFormatCEF(t *testing.T) {
+	t.Parallel()
+
+	header := DeviceHeader{
+		Vendor:  "firefart",
+		Product: "dmarc|forwarder",
+		Version: "1.0",
+	}
+
+	entry := SyslogEntry{
+		EventCategory: "mail",
+		DateBegin:     1636416000,
+		DateEnd:       1636502399,
+		SourceIP:      "192.0.2.1",
+		SourceDNS:     []string{"mail.example.com"},
+		Count:         2,
+		HeaderFrom:    "example.com",
+		OrgName:       `a=b\c`,
+	}
+	entry.PolicyEvaluated.Disposition = "reject"
+	entry.PolicyEvaluated.Dkim = "fail"
+	entry.PolicyEvaluated.Spf = "fail"
+
+	expected := `CEF:0|firefart|dmarc\|forwarder|1.0|dmarc-aggregate|DMARC aggregate report record|8|` +
+		`cat=mail rt=1636502399000 start=1636416000000 end=1636502399000 src=192.0.2.1 shost=mail.example.com cnt=2 act=reject ` +
+		`cs1Label=headerFrom cs1=example.com cs6Label=reportingOrg cs6=a\=b\\c ` +
+		`flexString1Label=dkimAlignment flexString1=fail flexString2Label=spfAlignment flexString2=fail outcome=fail cn1Label=dkimAligned cn1=0 cn2Label=spfAligned cn2=0 cn3Label=likelyForwarded cn3=0`
+
+	got := string(formatCEF(entry, header, nil))
+	if got != expected {
+		t.Fatalf("CEF mismatch\nexpected %s\ngot      %s", expected, got)
+	}
+}
+
+func TestFormatCEFEnrichment(t *testing.T) {
+	t.Parallel()
+
+	entry := SyslogEntry{
+		SourceIP:      "192.0.2.1",
+		SourceCountry: "US",
+		SourceASN:     64496,
+		SenderName:    "Example MTAs",
+		SenderClass:   "internal",
+		ResultSpf: []SyslogResultSPF{
+			{Domain: "example.com", Result: "fail", Analysis: &SyslogSPFAnalysis{Result: "pass", Mechanism: "ip4:192.0.2.0/24", Lookups: 3}},
+		},
+		PolicyDrift: []string{"p: reported none, published reject"},
+	}
+
+	expected := `CEF:0||||dmarc-aggregate|DMARC aggregate report record|6|` +
+		`rt=0 start=0 end=0 src=192.0.2.1 cnt=0 cs5Label=spfResult cs5=fail outcome=fail cn1Label=dkimAligned cn1=0 cn2Label=spfAligned cn2=0 cn3Label=likelyForwarded cn3=0 ` +
+		`srcCountry=US srcASN=64496 spfAnalysisResult=pass spfAnalysisMechanism=ip4:192.0.2.0/24 spfLookups=3 spfOverLimit=false ` +
+		`senderName=Example MTAs senderClass=internal policyDrift=p: reported none, published reject`
+
+	got := string(formatCEF(entry, DeviceHeader{}, nil))
+	if got != expected {
+		t.Fatalf("CEF mismatch\nexpected %s\ngot      %s", expected, got)
+	}
+}
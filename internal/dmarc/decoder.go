@@ -0,0 +1,218 @@
+package dmarc
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+)
+
+const xsTag = `<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema" targetNamespace="http://dmarc.org/dmarc-xml/0.1">`
+
+// ReportFile is a single report contained in an attachment. The records are
+// decoded one at a time by NextRecord so only the current record is kept in
+// memory regardless of the size of the report.
+type ReportFile struct {
+	Filename string
+	// Report contains all elements of the report except the records. As
+	// the records are streamed the Records field is always empty.
+	Report *XMLReport
+	// Quirks contains all quirks applied to the report so far
+	Quirks []Quirk
+
+	dec        *xml.Decoder
+	opts       ReadOptions
+	pending    *xml.StartElement // report element read while looking for the root
+	next       *xml.StartElement // start element of the next record
+	done       bool
+	records    int
+	warnings   []string // validation warnings of the report
+	transcoded bool     // content is already converted to UTF-8
+	rootless   bool     // report without the feedback root element
+	domains    reportDomains
+	// reporterKnown is set once the report metadata is decoded
+	reporterKnown bool
+}
+
+// ReadOptions contains the settings used when reading reports
+type ReadOptions struct {
+	Limits Limits
+	// Validation controls how invalid reports are handled, defaults to lenient
+	Validation ValidationMode
+	// OnQuirk is called once per report for every applied quirk. It is
+	// called after the report metadata is decoded so the reporter is known.
+	OnQuirk func(f *ReportFile, q Quirk)
+	// KnownQuirks contains the known quirks of the reporting organisations
+	KnownQuirks QuirkCatalogue
+}
+
+// newReportFile decodes the report up to the first record and validates
+// the report elements
+func newReportFile(filename string, r io.Reader, opts ReadOptions) (*ReportFile, error) {
+	f := &ReportFile{
+		Filename: filename,
+		Report:   &XMLReport{},
+		opts:     opts,
+	}
+	r = f.recoverEncoding(r)
+	// some xmls contain invalid XML by adding an unclosed xs tag
+	r = &stripReader{r: r, strip: []byte(xsTag), onStrip: func() { f.applyQuirk(QuirkXSSchemaTag) }}
+	f.dec = xml.NewDecoder(r)
+	f.dec.CharsetReader = f.charsetReader
+
+	// find the root element
+	for {
+		tok, err := f.dec.Token()
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("no xml root element found")
+		} else if err != nil {
+			return nil, fmt.Errorf("error on xml decode: %w", err)
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			if f.pending, err = f.checkRoot(start); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+
+	err := f.advance()
+	// the domains are the same for all records of the report
+	f.domains = getReportDomains(f.Filename, f.Report)
+	f.reportQuirks()
+	if err != nil {
+		return nil, err
+	}
+	f.warnings = validateReport(f.Report, time.Now())
+	if opts.Validation == ValidationStrict && len(f.warnings) > 0 {
+		return nil, &ValidationError{Warnings: f.warnings}
+	}
+	return f, nil
+}
+
+// advance decodes all report elements up to the next record or the end of
+// the root element. Elements following the records are still added to the
+// report but are only visible after the last record.
+func (f *ReportFile) advance() error {
+	for {
+		var tok xml.Token
+		var err error
+		if f.pending != nil {
+			tok, f.pending = *f.pending, nil
+		} else {
+			tok, err = f.dec.Token()
+		}
+		if f.rootless && errors.Is(err, io.EOF) {
+			f.done = true
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("error on xml decode: %w", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "record":
+				start := t.Copy()
+				f.next = &start
+				return nil
+			case "version":
+				err = f.dec.DecodeElement(&f.Report.Version, &t)
+			case "report_metadata":
+				err = f.dec.DecodeElement(&f.Report.ReportMetadata, &t)
+			case "policy_published":
+				err = f.dec.DecodeElement(&f.Report.PolicyPublished, &t)
+			case "extensions":
+				err = f.dec.DecodeElement(&f.Report.Extensions, &t)
+			default:
+				err = f.dec.Skip()
+			}
+			if err != nil {
+				return fmt.Errorf("error on xml decode of %s: %w", t.Name.Local, err)
+			}
+		case xml.EndElement:
+			// end of the root element
+			f.done = true
+			f.checkTrailing()
+			return nil
+		}
+	}
+}
+
+// NextRecord decodes the next record of the report. It returns io.EOF after
+// the last record.
+func (f *ReportFile) NextRecord() (*Record, error) {
+	if f.next == nil && !f.done {
+		if err := f.advance(); err != nil {
+			return nil, fmt.Errorf("could not parse %s: %w", f.Filename, err)
+		}
+	}
+	if f.next == nil {
+		return nil, io.EOF
+	}
+
+	var record Record
+	if err := f.dec.DecodeElement(&record, f.next); err != nil {
+		return nil, fmt.Errorf("could not parse record of %s: %w", f.Filename, err)
+	}
+	f.next = nil
+
+	f.records++
+	if err := f.opts.Limits.checkRecords(f.records); err != nil {
+		return nil, fmt.Errorf("%s: %w", f.Filename, err)
+	}
+
+	warnings := append(slices.Clone(f.warnings), validateRecord(record)...)
+	if f.opts.Validation == ValidationStrict && len(warnings) > 0 {
+		return nil, fmt.Errorf("record %d of %s: %w", f.records, f.Filename, &ValidationError{Warnings: warnings})
+	}
+	record.ValidationWarnings = warnings
+	return &record, nil
+}
+
+// stripReader removes all occurrences of strip from the underlying reader
+type stripReader struct {
+	r     io.Reader
+	strip []byte
+	buf   []byte // data that may contain the beginning of strip
+	out   []byte // data ready to be returned
+	chunk []byte
+	err   error
+	// onStrip is called if strip was removed
+	onStrip func()
+}
+
+func (s *stripReader) Read(p []byte) (int, error) {
+	if s.chunk == nil {
+		s.chunk = make([]byte, 4096)
+	}
+	for len(s.out) == 0 && s.err == nil {
+		n, err := s.r.Read(s.chunk)
+		s.buf = append(s.buf, s.chunk[:n]...)
+		if bytes.Contains(s.buf, s.strip) {
+			s.buf = bytes.ReplaceAll(s.buf, s.strip, nil)
+			if s.onStrip != nil {
+				s.onStrip()
+			}
+		}
+		s.err = err
+		// keep enough data to detect strip spanning multiple reads
+		keep := len(s.strip) - 1
+		if err != nil {
+			keep = 0
+		}
+		if len(s.buf) > keep {
+			cut := len(s.buf) - keep
+			s.out = append(s.out, s.buf[:cut]...)
+			s.buf = append(s.buf[:0], s.buf[cut:]...)
+		}
+	}
+	n := copy(p, s.out)
+	s.out = s.out[n:]
+	if len(s.out) == 0 && n == 0 {
+		return 0, s.err
+	}
+	return n, nil
+}
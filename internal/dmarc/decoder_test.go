@@ -0,0 +1,118 @@
+package dmarc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestReportFileStreaming(t *testing.T) {
+	t.Parallel()
+
+	var sb strings.Builder
+	sb.WriteString(`<?xml version="1.0"?><feedback><version>1.0</version>`)
+	sb.WriteString(`<report_metadata><org_name>example.net</org_name><report_id>1</report_id></report_metadata>`)
+	sb.WriteString(`<policy_published><domain>example.com</domain><p>reject</p></policy_published>`)
+	for i := range 10000 {
+		fmt.Fprintf(&sb, `<record><row><source_ip>192.0.2.1</source_ip><count>%d</count></row></record>`, i)
+	}
+	sb.WriteString(`</feedback>`)
+
+	f, err := newReportFile("report.xml", strings.NewReader(sb.String()), ReadOptions{})
+	if err != nil {
+		t.Fatalf("could not read report: %v", err)
+	}
+	if f.Report.Version != "1.0" || f.Report.ReportMetadata.ReportID != "1" || f.Report.PolicyPublished.P != "reject" {
+		t.Fatalf("invalid report header %+v", f.Report)
+	}
+	if f.domains.Reporter != "example.net" || f.domains.Policy != "example.com" {
+		t.Fatalf("invalid report domains %+v", f.domains)
+	}
+
+	count := 0
+	for {
+		record, err := f.NextRecord()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			t.Fatalf("could not read record: %v", err)
+		}
+		if record.Row.Count != count {
+			t.Fatalf("expected count %d, got %d", count, record.Row.Count)
+		}
+		count++
+	}
+	if count != 10000 {
+		t.Fatalf("expected 10000 records, got %d", count)
+	}
+	if len(f.Report.Records) != 0 {
+		t.Fatalf("records should not be kept in the report, got %d", len(f.Report.Records))
+	}
+}
+
+func TestReportFileInvalid(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{name: "empty", content: ""},
+		{name: "unclosed root", content: "<feedback>"},
+		{name: "invalid record", content: "<feedback><record><row></record></feedback>"},
+		{name: "unclosed after records", content: "<feedback><record></record>"},
+		{name: "other root", content: "<html><body><record></record></body></html>"},
+		{name: "other namespaced root", content: `<report xmlns="urn:example"><record></record></report>`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			f, err := newReportFile("report.xml", strings.NewReader(tt.content), ReadOptions{})
+			for err == nil {
+				_, err = f.NextRecord()
+			}
+			if errors.Is(err, io.EOF) {
+				t.Fatal("expected an error but got none")
+			}
+		})
+	}
+}
+
+func TestReportFileRecordLimit(t *testing.T) {
+	t.Parallel()
+
+	content := "<feedback><record></record><record></record></feedback>"
+	f, err := newReportFile("report.xml", strings.NewReader(content), ReadOptions{Limits: Limits{MaxRecords: 1}})
+	if err != nil {
+		t.Fatalf("could not read report: %v", err)
+	}
+	if _, err := f.NextRecord(); err != nil {
+		t.Fatalf("first record should be within the limit: %v", err)
+	}
+	if _, err := f.NextRecord(); !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("expected a limit error, got %v", err)
+	}
+}
+
+func TestStripReader(t *testing.T) {
+	t.Parallel()
+
+	content := "<?xml version=\"1.0\"?>" + xsTag + "<feedback>" + strings.Repeat("a", 5000) + xsTag + "</feedback>" + xsTag
+	expected := "<?xml version=\"1.0\"?><feedback>" + strings.Repeat("a", 5000) + "</feedback>"
+
+	// the one byte reader splits the tag across multiple reads
+	for _, r := range []io.Reader{strings.NewReader(content), iotest.OneByteReader(strings.NewReader(content))} {
+		b, err := io.ReadAll(&stripReader{r: r, strip: []byte(xsTag)})
+		if err != nil {
+			t.Fatalf("got unexpected error: %v", err)
+		}
+		if string(b) != expected {
+			t.Fatalf("content mismatch - expected %s got %s", expected, b)
+		}
+	}
+}
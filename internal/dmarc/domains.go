@@ -0,0 +1,117 @@
+package dmarc
+
+import (
+	"fmt"
+	"net/mail"
+	"path/filepath"
+	"strings"
+)
+
+// reportFilename contains the domains of a filename following the RFC 7489
+// naming convention
+type reportFilename struct {
+	Receiver     string
+	PolicyDomain string
+}
+
+func parseReportFilename(filename string) (reportFilename, error) {
+	// filename = receiver "!" policy-domain "!" begin-timestamp
+	//               "!" end-timestamp [ "!" unique-id ] "." extension
+	filename = filepath.Base(filename)
+	parts := strings.Split(filename, "!")
+	if len(parts) < 4 {
+		return reportFilename{}, fmt.Errorf("filename %q does not match RFC", filename)
+	}
+	return reportFilename{
+		Receiver:     normalizeDomain(parts[0]),
+		PolicyDomain: normalizeDomain(parts[1]),
+	}, nil
+}
+
+// reportDomains contains the domains of a report
+type reportDomains struct {
+	// Reporter is the domain of the organisation sending the report
+	Reporter string
+	// Policy is the domain the published policy belongs to
+	Policy string
+	// Mismatches contains the differences between the filename and the
+	// report metadata
+	Mismatches []string
+}
+
+// getReportDomains determines the reporter and the policy domain of the
+// report. The domains of the filename are preferred for the reporter and the
+// report metadata is used as a fallback if the filename does not follow the
+// RFC naming. Both sources are cross-checked if available.
+func getReportDomains(filename string, report *XMLReport) reportDomains {
+	var ret reportDomains
+
+	emailDomain := metadataEmailDomain(report.ReportMetadata.Email)
+	orgDomain := ""
+	if looksLikeDomain(report.ReportMetadata.OrgName) {
+		orgDomain = normalizeDomain(report.ReportMetadata.OrgName)
+	}
+	policy := normalizeDomain(report.PolicyPublished.Domain)
+
+	fn, err := parseReportFilename(filename)
+	if err != nil {
+		ret.Reporter = emailDomain
+		if ret.Reporter == "" {
+			ret.Reporter = orgDomain
+		}
+		ret.Policy = policy
+		return ret
+	}
+
+	ret.Reporter = fn.Receiver
+	if emailDomain != "" || orgDomain != "" {
+		if !relatedDomains(fn.Receiver, emailDomain) && !relatedDomains(fn.Receiver, orgDomain) {
+			ret.Mismatches = append(ret.Mismatches, fmt.Sprintf("filename reporter %q does not match report_metadata (email %q, org_name %q)", fn.Receiver, report.ReportMetadata.Email, report.ReportMetadata.OrgName))
+		}
+	}
+
+	ret.Policy = policy
+	if ret.Policy == "" {
+		ret.Policy = fn.PolicyDomain
+	} else if fn.PolicyDomain != policy {
+		ret.Mismatches = append(ret.Mismatches, fmt.Sprintf("filename policy domain %q does not match policy_published.domain %q", fn.PolicyDomain, policy))
+	}
+	return ret
+}
+
+// metadataEmailDomain returns the domain of the report_metadata email
+func metadataEmailDomain(email string) string {
+	email = strings.TrimSpace(email)
+	if addr, err := mail.ParseAddress(email); err == nil {
+		email = addr.Address
+	}
+	_, domain, found := strings.Cut(email, "@")
+	if !found {
+		return ""
+	}
+	domain = normalizeDomain(strings.TrimSuffix(domain, ">"))
+	if !looksLikeDomain(domain) {
+		return ""
+	}
+	return domain
+}
+
+// looksLikeDomain checks if the org_name is a domain. Some reporters use
+// their domain as org_name while others use the name of the company.
+func looksLikeDomain(s string) bool {
+	s = strings.TrimSpace(s)
+	return strings.Contains(s, ".") && !strings.ContainsAny(s, " \t@/")
+}
+
+func normalizeDomain(domain string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+}
+
+// relatedDomains checks if the domains are equal or one is a subdomain of
+// the other
+func relatedDomains(a, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+	return a == b || strings.HasSuffix(a, "."+b) || strings.HasSuffix(b, "."+a)
+}
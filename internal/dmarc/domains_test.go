@@ -0,0 +1,117 @@
+package dmarc
+
+import "testing"
+
+func TestGetReportDomains(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		filename   string
+		orgName    string
+		email      string
+		policy     string
+		reporter   string
+		policyWant string
+		mismatches int
+	}{
+		{
+			name:       "filename matches metadata",
+			filename:   "google.com!example.com!1636416000!1636502399.xml",
+			orgName:    "google.com",
+			email:      "noreply-dmarc-support@google.com",
+			policy:     "example.com",
+			reporter:   "google.com",
+			policyWant: "example.com",
+		},
+		{
+			name:       "receiver is a subdomain of the email domain",
+			filename:   "mail.example.net!example.com!1636416000!1636502399!123.xml.gz",
+			orgName:    "Example Net",
+			email:      "dmarc@example.net",
+			policy:     "Example.COM",
+			reporter:   "mail.example.net",
+			policyWant: "example.com",
+		},
+		{
+			name:       "no rfc filename uses the email",
+			filename:   "report.xml",
+			orgName:    "Example Net",
+			email:      "Example Reports <dmarc@Example.net>",
+			policy:     "example.com",
+			reporter:   "example.net",
+			policyWant: "example.com",
+		},
+		{
+			name:       "no rfc filename uses the org name",
+			filename:   "report.xml",
+			orgName:    "example.org",
+			policy:     "example.com",
+			reporter:   "example.org",
+			policyWant: "example.com",
+		},
+		{
+			name:       "no rfc filename and no domain in metadata",
+			filename:   "report.xml",
+			orgName:    "Example Org",
+			policy:     "example.com",
+			reporter:   "",
+			policyWant: "example.com",
+		},
+		{
+			name:       "policy domain from filename",
+			filename:   "example.net!example.com!1636416000!1636502399.xml",
+			email:      "dmarc@example.net",
+			reporter:   "example.net",
+			policyWant: "example.com",
+		},
+		{
+			name:       "reporter mismatch",
+			filename:   "example.org!example.com!1636416000!1636502399.xml",
+			orgName:    "Example Net",
+			email:      "dmarc@example.net",
+			policy:     "example.com",
+			reporter:   "example.org",
+			policyWant: "example.com",
+			mismatches: 1,
+		},
+		{
+			name:       "policy mismatch",
+			filename:   "example.net!example.org!1636416000!1636502399.xml",
+			email:      "dmarc@example.net",
+			policy:     "example.com",
+			reporter:   "example.net",
+			policyWant: "example.com",
+			mismatches: 1,
+		},
+		{
+			name:       "both mismatch",
+			filename:   "example.org!example.org!1636416000!1636502399.xml",
+			email:      "dmarc@example.net",
+			policy:     "example.com",
+			reporter:   "example.org",
+			policyWant: "example.com",
+			mismatches: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			report := &XMLReport{}
+			report.ReportMetadata.OrgName = tt.orgName
+			report.ReportMetadata.Email = tt.email
+			report.PolicyPublished.Domain = tt.policy
+
+			got := getReportDomains(tt.filename, report)
+			if got.Reporter != tt.reporter {
+				t.Errorf("expected reporter %q, got %q", tt.reporter, got.Reporter)
+			}
+			if got.Policy != tt.policyWant {
+				t.Errorf("expected policy domain %q, got %q", tt.policyWant, got.Policy)
+			}
+			if len(got.Mismatches) != tt.mismatches {
+				t.Errorf("expected %d mismatches, got %v", tt.mismatches, got.Mismatches)
+			}
+		})
+	}
+}
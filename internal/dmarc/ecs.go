@@ -0,0 +1,505 @@
+package dmarc
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/firefart/dmarcsyslogforwarder/internal/fields"
+)
+
+// https://www.elastic.co/guide/en/ecs/current/ecs-field-reference.html
+const ecsVersion = "8.11.0"
+
+// ecsEntry represents a SyslogEntry mapped to the Elastic Common Schema.
+// Fields without an ECS equivalent are placed under the dmarc key.
+type ecsEntry struct {
+	Timestamp    time.Time         `json:"@timestamp"`
+	ECS          ecsVersionField   `json:"ecs"`
+	Event        ecsEvent          `json:"event"`
+	Observer     ecsObserver       `json:"observer"`
+	Organization ecsOrganization   `json:"organization"`
+	Source       ecsSource         `json:"source"`
+	Destination  *ecsDestination   `json:"destination,omitempty"`
+	Email        *ecsEmail         `json:"email,omitempty"`
+	Related      ecsRelated        `json:"related"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	DMARC        any               `json:"dmarc,omitempty"`
+	TLSRPT       any               `json:"tlsrpt,omitempty"`
+}
+
+type ecsVersionField struct {
+	Version string `json:"version"`
+}
+
+type ecsEvent struct {
+	Kind     string    `json:"kind"`
+	Category []string  `json:"category"`
+	Type     []string  `json:"type"`
+	Module   string    `json:"module"`
+	Dataset  string    `json:"dataset"`
+	Code     string    `json:"code,omitempty"`
+	Action   string    `json:"action,omitempty"`
+	Outcome  string    `json:"outcome"`
+	Reason   string    `json:"reason,omitempty"`
+	Severity int       `json:"severity"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+}
+
+type ecsObserver struct {
+	Vendor  string `json:"vendor"`
+	Product string `json:"product"`
+	Version string `json:"version"`
+	Type    string `json:"type"`
+}
+
+type ecsOrganization struct {
+	Name string `json:"name,omitempty"`
+}
+
+type ecsSource struct {
+	IP               string  `json:"ip,omitempty"`
+	Domain           string  `json:"domain,omitempty"`
+	RegisteredDomain string  `json:"registered_domain,omitempty"`
+	Geo              *ecsGeo `json:"geo,omitempty"`
+	AS               *ecsAS  `json:"as,omitempty"`
+}
+
+type ecsGeo struct {
+	CountryISOCode string `json:"country_iso_code,omitempty"`
+	CityName       string `json:"city_name,omitempty"`
+}
+
+type ecsAS struct {
+	Number       uint            `json:"number,omitempty"`
+	Organization ecsOrganization `json:"organization"`
+}
+
+type ecsDestination struct {
+	IP     string `json:"ip,omitempty"`
+	Domain string `json:"domain,omitempty"`
+}
+
+type ecsEmail struct {
+	From      ecsEmailAddresses `json:"from"`
+	To        ecsEmailAddresses `json:"to"`
+	Sender    ecsEmailAddress   `json:"sender"`
+	Subject   string            `json:"subject,omitempty"`
+	MessageID string            `json:"message_id,omitempty"`
+}
+
+type ecsEmailAddresses struct {
+	Address []string `json:"address,omitempty"`
+}
+
+type ecsEmailAddress struct {
+	Address string `json:"address,omitempty"`
+}
+
+type ecsRelated struct {
+	Hosts []string `json:"hosts,omitempty"`
+	IP    []string `json:"ip,omitempty"`
+}
+
+// ecsMappedFields are the fields of an entry mapped to ECS fields. All
+// other fields are placed below the dmarc key. header_from, envelope_from
+// and envelope_to are domains while the ECS email fields expect addresses,
+// so they are kept below dmarc too.
+var ecsMappedFields = []string{
+	"event_id", "event_category", "report_type",
+	"date_begin", "date_end", "date_begin_parsed", "date_end_parsed",
+	"org_name", "source_ip", "source_dns", "source_dns_string",
+	"source_country", "source_city", "source_asn", "source_as_org",
+}
+
+// ecsDMARCFailure contains the fields of a failure report
+// without an ECS equivalent
+type ecsDMARCFailure struct {
+	ReportType            string   `json:"report_type"`
+	FeedbackType          string   `json:"feedback_type"`
+	UserAgent             string   `json:"user_agent,omitempty"`
+	Version               string   `json:"version,omitempty"`
+	ReportingMTA          string   `json:"reporting_mta,omitempty"`
+	Incidents             int      `json:"incidents"`
+	AuthFailure           []string `json:"auth_failure,omitempty"`
+	ReportedDomain        []string `json:"reported_domain,omitempty"`
+	ReportedURI           []string `json:"reported_uri,omitempty"`
+	DeliveryResult        string   `json:"delivery_result,omitempty"`
+	IdentityAlignment     string   `json:"identity_alignment,omitempty"`
+	AuthenticationResults string   `json:"authentication_results,omitempty"`
+	DKIMDomain            string   `json:"dkim_domain,omitempty"`
+	DKIMIdentity          string   `json:"dkim_identity,omitempty"`
+	DKIMSelector          string   `json:"dkim_selector,omitempty"`
+	SPFDNS                string   `json:"spf_dns,omitempty"`
+}
+
+// ecsTLSRPT contains the fields of a TLS report entry
+// without an ECS equivalent
+type ecsTLSRPT struct {
+	EntryType                   string   `json:"entry_type"`
+	ReportID                    string   `json:"report_id"`
+	ContactInfo                 string   `json:"contact_info,omitempty"`
+	PolicyType                  string   `json:"policy_type"`
+	PolicyString                []string `json:"policy_string,omitempty"`
+	PolicyDomain                string   `json:"policy_domain"`
+	MXHost                      []string `json:"mx_host,omitempty"`
+	TotalSuccessfulSessionCount int      `json:"total_successful_session_count"`
+	TotalFailureSessionCount    int      `json:"total_failure_session_count"`
+	ResultType                  string   `json:"result_type,omitempty"`
+	ReceivingMXHelo             string   `json:"receiving_mx_helo,omitempty"`
+	FailedSessionCount          int      `json:"failed_session_count,omitempty"`
+	AdditionalInformation       string   `json:"additional_information,omitempty"`
+	FailureReasonCode           string   `json:"failure_reason_code,omitempty"`
+}
+
+type ecsFormatter struct {
+	header DeviceHeader
+	fields *fields.Filter
+}
+
+// Format converts the entry into an Elastic Common Schema JSON document
+func (f ecsFormatter) Format(entry SyslogEntry) ([]byte, error) {
+	ecs, err := toECS(entry, f.header, f.fields)
+	if err != nil {
+		return nil, err
+	}
+	return marshalSchema(ecs, f.fields)
+}
+
+// FormatFailure converts the failure report into an Elastic Common Schema JSON document
+func (f ecsFormatter) FormatFailure(entry FailureEntry) ([]byte, error) {
+	ecs, err := toFailureECS(entry, f.header, f.fields)
+	if err != nil {
+		return nil, err
+	}
+	return marshalSchema(ecs, f.fields)
+}
+
+// FormatTLS converts the TLS report entry into an Elastic Common Schema JSON document
+func (f ecsFormatter) FormatTLS(entry TLSEntry) ([]byte, error) {
+	ecs, err := toTLSECS(entry, f.header, f.fields)
+	if err != nil {
+		return nil, err
+	}
+	return marshalSchema(ecs, f.fields)
+}
+
+func toECS(entry SyslogEntry, header DeviceHeader, filter *fields.Filter) (ecsEntry, error) {
+	// the timestamps, the severity and the outcome are mandatory, all other
+	// fields are built from the fields selected by the filter
+	selected, err := selectFields(entry, filter)
+	if err != nil {
+		return ecsEntry{}, err
+	}
+	unmapped, err := unmappedFields(entry, ecsMappedFields, filter)
+	if err != nil {
+		return ecsEntry{}, err
+	}
+
+	outcome := "failure"
+	if evaluatedPass(entry) {
+		outcome = "success"
+	}
+
+	var labels map[string]string
+	if selected.EventCategory != "" {
+		labels = map[string]string{
+			"event_category": selected.EventCategory,
+		}
+	}
+
+	var ips []string
+	if selected.SourceIP != "" {
+		ips = []string{selected.SourceIP}
+	}
+
+	return ecsEntry{
+		Timestamp: time.Time(entry.DateEndParsed).UTC(),
+		ECS: ecsVersionField{
+			Version: ecsVersion,
+		},
+		Event: ecsEvent{
+			Kind:     "event",
+			Category: []string{"email"},
+			Type:     []string{"info"},
+			Module:   "dmarc",
+			Dataset:  "dmarc.aggregate",
+			Code:     selected.EventID,
+			Action:   selected.PolicyEvaluated.Disposition,
+			Outcome:  outcome,
+			Reason:   overrideReasons(selected),
+			Severity: severity(entry),
+			Start:    time.Time(entry.DateBeginParsed).UTC(),
+			End:      time.Time(entry.DateEndParsed).UTC(),
+		},
+		Observer: ecsObserver{
+			Vendor:  header.Vendor,
+			Product: header.Product,
+			Version: header.Version,
+			Type:    "dmarc-report",
+		},
+		Organization: ecsOrganization{
+			Name: selected.OrgName,
+		},
+		Source: ecsSource{
+			IP:               selected.SourceIP,
+			Domain:           firstNonEmpty(selected.SourceDNS),
+			RegisteredDomain: firstNonEmpty(selected.SourceDNSOrgDomains),
+			Geo:              toECSGeo(selected),
+			AS:               toECSAS(selected),
+		},
+		Related: ecsRelated{
+			Hosts: relatedHosts(selected),
+			IP:    ips,
+		},
+		Labels: labels,
+		DMARC:  unmapped,
+	}, nil
+}
+
+func toFailureECS(entry FailureEntry, header DeviceHeader, filter *fields.Filter) (ecsEntry, error) {
+	// the timestamps, the severity and the outcome are mandatory, all other
+	// fields are built from the fields selected by the filter
+	selected, err := selectFields(entry, filter)
+	if err != nil {
+		return ecsEntry{}, err
+	}
+
+	var labels map[string]string
+	if selected.EventCategory != "" {
+		labels = map[string]string{
+			"event_category": selected.EventCategory,
+		}
+	}
+
+	var from []string
+	if selected.OriginalFrom != "" {
+		from = []string{selected.OriginalFrom}
+	}
+	var ips []string
+	if selected.SourceIP != "" {
+		ips = []string{selected.SourceIP}
+	}
+
+	arrival := time.Time(entry.ArrivalDateParsed).UTC()
+	hosts := slices.Concat(selected.SourceDNS, selected.ReportedDomain, []string{selected.DKIMDomain})
+	hosts = slices.DeleteFunc(hosts, func(s string) bool { return s == "" })
+	slices.Sort(hosts)
+
+	// the fields without an ECS equivalent use the names of the entry
+	dmarc, err := unmappedDocument(ecsDMARCFailure{
+		ReportType:            entry.ReportType,
+		FeedbackType:          entry.FeedbackType,
+		UserAgent:             entry.UserAgent,
+		Version:               entry.Version,
+		ReportingMTA:          entry.ReportingMTA,
+		Incidents:             entry.Incidents,
+		AuthFailure:           entry.AuthFailure,
+		ReportedDomain:        entry.ReportedDomain,
+		ReportedURI:           entry.ReportedURI,
+		DeliveryResult:        entry.DeliveryResult,
+		IdentityAlignment:     entry.IdentityAlignment,
+		AuthenticationResults: entry.AuthenticationResults,
+		DKIMDomain:            entry.DKIMDomain,
+		DKIMIdentity:          entry.DKIMIdentity,
+		DKIMSelector:          entry.DKIMSelector,
+		SPFDNS:                entry.SPFDNS,
+	}, filter)
+	if err != nil {
+		return ecsEntry{}, err
+	}
+
+	return ecsEntry{
+		Timestamp: arrival,
+		ECS: ecsVersionField{
+			Version: ecsVersion,
+		},
+		Event: ecsEvent{
+			Kind:     "event",
+			Category: []string{"email"},
+			Type:     []string{"info"},
+			Module:   "dmarc",
+			Dataset:  "dmarc.failure",
+			Code:     selected.EventID,
+			Action:   selected.DeliveryResult,
+			Outcome:  "failure",
+			Reason:   strings.Join(selected.AuthFailure, ","),
+			Severity: failureSeverity(entry),
+			Start:    arrival,
+			End:      arrival,
+		},
+		Observer: ecsObserver{
+			Vendor:  header.Vendor,
+			Product: header.Product,
+			Version: header.Version,
+			Type:    "dmarc-report",
+		},
+		Source: ecsSource{
+			IP:     selected.SourceIP,
+			Domain: firstNonEmpty(selected.SourceDNS),
+		},
+		Email: &ecsEmail{
+			From: ecsEmailAddresses{
+				Address: from,
+			},
+			To: ecsEmailAddresses{
+				Address: selected.OriginalRcptTo,
+			},
+			Sender: ecsEmailAddress{
+				Address: selected.OriginalMailFrom,
+			},
+			Subject:   selected.OriginalSubject,
+			MessageID: selected.OriginalMessageID,
+		},
+		Related: ecsRelated{
+			Hosts: slices.Compact(hosts),
+			IP:    ips,
+		},
+		Labels: labels,
+		DMARC:  dmarc,
+	}, nil
+}
+
+func toTLSECS(entry TLSEntry, header DeviceHeader, filter *fields.Filter) (ecsEntry, error) {
+	// the timestamps, the severity and the outcome are mandatory, all other
+	// fields are built from the fields selected by the filter
+	selected, err := selectFields(entry, filter)
+	if err != nil {
+		return ecsEntry{}, err
+	}
+
+	outcome := "success"
+	if entry.EntryType == TLSEntryFailureDetail || entry.TotalFailureSessionCount > 0 {
+		outcome = "failure"
+	}
+
+	var labels map[string]string
+	if selected.EventCategory != "" {
+		labels = map[string]string{
+			"event_category": selected.EventCategory,
+		}
+	}
+
+	var destination *ecsDestination
+	if selected.ReceivingIP != "" || selected.ReceivingMXHostname != "" {
+		destination = &ecsDestination{
+			IP:     selected.ReceivingIP,
+			Domain: selected.ReceivingMXHostname,
+		}
+	}
+
+	ips := slices.DeleteFunc([]string{selected.SendingMTAIP, selected.ReceivingIP}, func(s string) bool { return s == "" })
+	hosts := slices.Concat([]string{selected.PolicyDomain, selected.ReceivingMXHostname}, selected.MXHost)
+	hosts = slices.DeleteFunc(hosts, func(s string) bool { return s == "" })
+	slices.Sort(hosts)
+
+	// the fields without an ECS equivalent use the names of the entry
+	tlsrpt, err := unmappedDocument(&ecsTLSRPT{
+		EntryType:                   entry.EntryType,
+		ReportID:                    entry.ReportID,
+		ContactInfo:                 entry.ContactInfo,
+		PolicyType:                  entry.PolicyType,
+		PolicyString:                entry.PolicyString,
+		PolicyDomain:                entry.PolicyDomain,
+		MXHost:                      entry.MXHost,
+		TotalSuccessfulSessionCount: entry.TotalSuccessfulSessionCount,
+		TotalFailureSessionCount:    entry.TotalFailureSessionCount,
+		ResultType:                  entry.ResultType,
+		ReceivingMXHelo:             entry.ReceivingMXHelo,
+		FailedSessionCount:          entry.FailedSessionCount,
+		AdditionalInformation:       entry.AdditionalInformation,
+		FailureReasonCode:           entry.FailureReasonCode,
+	}, filter)
+	if err != nil {
+		return ecsEntry{}, err
+	}
+
+	return ecsEntry{
+		Timestamp: time.Time(entry.DateEndParsed).UTC(),
+		ECS: ecsVersionField{
+			Version: ecsVersion,
+		},
+		Event: ecsEvent{
+			Kind:     "event",
+			Category: []string{"email", "network"},
+			Type:     []string{"info"},
+			Module:   "tlsrpt",
+			Dataset:  "tlsrpt." + entry.EntryType,
+			Code:     selected.EventID,
+			Action:   selected.ResultType,
+			Outcome:  outcome,
+			Reason:   selected.FailureReasonCode,
+			Severity: tlsSeverity(entry),
+			Start:    time.Time(entry.DateBeginParsed).UTC(),
+			End:      time.Time(entry.DateEndParsed).UTC(),
+		},
+		Observer: ecsObserver{
+			Vendor:  header.Vendor,
+			Product: header.Product,
+			Version: header.Version,
+			Type:    "tls-report",
+		},
+		Organization: ecsOrganization{
+			Name: selected.OrganizationName,
+		},
+		Source: ecsSource{
+			IP: selected.SendingMTAIP,
+		},
+		Destination: destination,
+		Related: ecsRelated{
+			Hosts: slices.Compact(hosts),
+			IP:    ips,
+		},
+		Labels: labels,
+		TLSRPT: tlsrpt,
+	}, nil
+}
+
+// relatedHosts returns a deduplicated list of all hostnames and
+// domains contained in the entry
+func relatedHosts(entry SyslogEntry) []string {
+	candidates := make([]string, 0, len(entry.SourceDNS)+len(entry.dkimResults())+len(entry.spfResults())+3)
+	candidates = append(candidates, entry.SourceDNS...)
+	candidates = append(candidates,
+		entry.HeaderFrom,
+		entry.EnvelopeFrom,
+		entry.PolicyPublished.Domain,
+	)
+	for _, r := range entry.dkimResults() {
+		candidates = append(candidates, r.Domain)
+	}
+	for _, r := range entry.spfResults() {
+		candidates = append(candidates, r.Domain)
+	}
+
+	seen := make(map[string]struct{}, len(candidates))
+	var hosts []string
+	for _, c := range candidates {
+		if c == "" {
+			continue
+		}
+		if _, ok := seen[c]; ok {
+			continue
+		}
+		seen[c] = struct{}{}
+		hosts = append(hosts, c)
+	}
+	return hosts
+}
+
+// toECSGeo returns the GeoIP location of the source, nil if not available
+func toECSGeo(entry SyslogEntry) *ecsGeo {
+	if entry.SourceCountry == "" && entry.SourceCity == "" {
+		return nil
+	}
+	return &ecsGeo{CountryISOCode: entry.SourceCountry, CityName: entry.SourceCity}
+}
+
+// toECSAS returns the autonomous system of the source, nil if not available
+func toECSAS(entry SyslogEntry) *ecsAS {
+	if entry.SourceASN == 0 && entry.SourceASOrg == "" {
+		return nil
+	}
+	return &ecsAS{Number: entry.SourceASN, Organization: ecsOrganization{Name: entry.SourceASOrg}}
+}
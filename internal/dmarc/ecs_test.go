@@ -0,0 +1,102 @@
+package dmarc
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/firefart/dmarcsyslogforwarder/internal/fields"
+)
+
+func TestToECS(t *testing.T) {
+	t.Parallel()
+
+	entry := SyslogEntry{
+		EventCategory:   "mail",
+		DateBeginParsed: CustomTime(time.Unix(1636416000, 0)),
+		DateEndParsed:   CustomTime(time.Unix(1636502399, 0)),
+		SourceIP:        "192.0.2.1",
+		SourceDNS:       []string{"mail.example.com", "example.com"},
+		HeaderFrom:      "example.com",
+		EnvelopeFrom:    "bounce.example.com",
+	}
+	entry.PolicyEvaluated.Disposition = "none"
+	entry.PolicyEvaluated.Dkim = "pass"
+	entry.PolicyEvaluated.Spf = "fail"
+
+	ecs, err := toECS(entry, DeviceHeader{Vendor: "firefart", Product: "dmarcsyslogforwarder", Version: "1.0"}, nil)
+	if err != nil {
+		t.Fatalf("could not convert: %v", err)
+	}
+	b, err := json.Marshal(ecs)
+	if err != nil {
+		t.Fatalf("could not marshal: %v", err)
+	}
+
+	var doc map[string]any
+	if err := json.Unmarshal(b, &doc); err != nil {
+		t.Fatalf("could not unmarshal: %v", err)
+	}
+
+	if doc["@timestamp"] != "2021-11-09T23:59:59Z" {
+		t.Fatalf("invalid timestamp %v", doc["@timestamp"])
+	}
+	if ecs.Event.Outcome != "success" {
+		t.Fatalf("expected outcome success, got %s", ecs.Event.Outcome)
+	}
+	if ecs.Source.IP != "192.0.2.1" || ecs.Source.Domain != "mail.example.com" {
+		t.Fatalf("invalid source %+v", ecs.Source)
+	}
+	// aggregate reports only contain domains, not email addresses
+	if ecs.Email != nil {
+		t.Fatalf("expected no email fields, got %+v", ecs.Email)
+	}
+	expectedHosts := []string{"mail.example.com", "example.com", "bounce.example.com"}
+	if !slices.Equal(ecs.Related.Hosts, expectedHosts) {
+		t.Fatalf("invalid related.hosts - expected %v got %v", expectedHosts, ecs.Related.Hosts)
+	}
+	if ecs.Labels["event_category"] != "mail" {
+		t.Fatalf("invalid labels %v", ecs.Labels)
+	}
+	if ecs.Source.Geo != nil || ecs.Source.AS != nil {
+		t.Fatalf("expected no geoip fields, got %+v", ecs.Source)
+	}
+
+	entry.SourceCountry = "US"
+	entry.SourceASN = 64496
+	entry.SourceASOrg = "Example AS"
+	entry.SenderClass = "internal"
+	entry.PolicyDrift = []string{"p: reported none, published reject"}
+	ecs, err = toECS(entry, DeviceHeader{}, nil)
+	if err != nil {
+		t.Fatalf("could not convert: %v", err)
+	}
+	if ecs.Source.Geo == nil || ecs.Source.Geo.CountryISOCode != "US" {
+		t.Fatalf("invalid source.geo %+v", ecs.Source.Geo)
+	}
+	if ecs.Source.AS == nil || ecs.Source.AS.Number != 64496 || ecs.Source.AS.Organization.Name != "Example AS" {
+		t.Fatalf("invalid source.as %+v", ecs.Source.AS)
+	}
+
+	// all fields of the entry without an ECS equivalent are below dmarc
+	dmarc, ok := ecs.DMARC.(fields.Document)
+	if !ok {
+		t.Fatalf("invalid dmarc type %T", ecs.DMARC)
+	}
+	m := dmarc.Map()
+	if m["header_from"] != "example.com" || m["envelope_from"] != "bounce.example.com" {
+		t.Fatalf("expected the header and envelope domains below dmarc, got %v", m)
+	}
+	if m["sender_class"] != "internal" {
+		t.Fatalf("expected sender_class below dmarc, got %v", m)
+	}
+	if drift, ok := m["policy_drift"].([]any); !ok || len(drift) != 1 {
+		t.Fatalf("expected policy_drift below dmarc, got %v", m["policy_drift"])
+	}
+	for _, key := range []string{"source_ip", "source_as_org", "event_category", "date_end_parsed"} {
+		if _, ok := m[key]; ok {
+			t.Fatalf("mapped field %s should not be below dmarc", key)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package dmarc
+
+import (
+	"bufio"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/mail"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-message"
+
+	"github.com/firefart/dmarcsyslogforwarder/internal/dns"
+)
+
+const (
+	ReportTypeAggregate = "aggregate"
+	ReportTypeFailure   = "failure"
+)
+
+// FailureReport represents a DMARC failure report in the Abuse Reporting
+// Format https://tools.ietf.org/html/rfc6591
+// https://tools.ietf.org/html/rfc7489#section-7.3
+type FailureReport struct {
+	// fields of the message/feedback-report part
+	FeedbackType          string
+	UserAgent             string
+	Version               string
+	ArrivalDate           string
+	ReportingMTA          string
+	SourceIP              string
+	Incidents             int
+	AuthFailure           []string
+	ReportedDomain        []string
+	ReportedURI           []string
+	DeliveryResult        string
+	IdentityAlignment     string
+	AuthenticationResults string
+	OriginalMailFrom      string
+	OriginalRcptTo        []string
+	DKIMDomain            string
+	DKIMIdentity          string
+	DKIMSelector          string
+	SPFDNS                string
+	// headers of the original message
+	OriginalFrom      string
+	OriginalTo        string
+	OriginalSubject   string
+	OriginalDate      string
+	OriginalMessageID string
+}
+
+// FailureEntry is a single DMARC failure report
+type FailureEntry struct {
+	XMLName               xml.Name   `xml:"failure_entry" json:"-"`                                   // for xml serialisation
+	EventID               string     `xml:"event_id,omitempty" json:"event_id,omitempty"`             // SIEM specific
+	EventCategory         string     `xml:"event_category,omitempty" json:"event_category,omitempty"` // SIEM specific
+	ReportType            string     `xml:"report_type" json:"report_type"`
+	FeedbackType          string     `xml:"feedback_type" json:"feedback_type"`
+	UserAgent             string     `xml:"user_agent" json:"user_agent"`
+	Version               string     `xml:"version" json:"version"`
+	ArrivalDate           string     `xml:"arrival_date" json:"arrival_date"`
+	ArrivalDateParsed     CustomTime `xml:"arrival_date_parsed" json:"arrival_date_parsed"`
+	ReportingMTA          string     `xml:"reporting_mta" json:"reporting_mta"`
+	SourceIP              string     `xml:"source_ip" json:"source_ip"`
+	SourceDNS             []string   `xml:"source_dns>dns" json:"source_dns"`
+	SourceDNSString       string     `xml:"source_dns_string" json:"source_dns_string"`
+	Incidents             int        `xml:"incidents" json:"incidents"`
+	AuthFailure           []string   `xml:"auth_failure" json:"auth_failure"`
+	ReportedDomain        []string   `xml:"reported_domain" json:"reported_domain"`
+	ReportedURI           []string   `xml:"reported_uri" json:"reported_uri"`
+	DeliveryResult        string     `xml:"delivery_result" json:"delivery_result"`
+	IdentityAlignment     string     `xml:"identity_alignment" json:"identity_alignment"`
+	AuthenticationResults string     `xml:"authentication_results" json:"authentication_results"`
+	OriginalMailFrom      string     `xml:"original_mail_from" json:"original_mail_from"`
+	OriginalRcptTo        []string   `xml:"original_rcpt_to" json:"original_rcpt_to"`
+	DKIMDomain            string     `xml:"dkim_domain" json:"dkim_domain"`
+	DKIMIdentity          string     `xml:"dkim_identity" json:"dkim_identity"`
+	DKIMSelector          string     `xml:"dkim_selector" json:"dkim_selector"`
+	SPFDNS                string     `xml:"spf_dns" json:"spf_dns"`
+	OriginalFrom          string     `xml:"original_from" json:"original_from"`
+	OriginalTo            string     `xml:"original_to" json:"original_to"`
+	OriginalSubject       string     `xml:"original_subject" json:"original_subject"`
+	OriginalDate          string     `xml:"original_date" json:"original_date"`
+	OriginalMessageID     string     `xml:"original_message_id" json:"original_message_id"`
+}
+
+// IsFailureReport checks the content type of an email
+// for a multipart/report containing a feedback report
+func IsFailureReport(mediaType string, params map[string]string) bool {
+	return strings.EqualFold(mediaType, "multipart/report") && strings.EqualFold(params["report-type"], "feedback-report")
+}
+
+// ReadFailureReport parses a multipart/report email containing an ARF
+// feedback report and the original message or its headers
+func ReadFailureReport(r io.Reader) (*FailureReport, error) {
+	e, err := message.Read(r)
+	if err != nil && !message.IsUnknownCharset(err) {
+		return nil, fmt.Errorf("could not read message: %w", err)
+	}
+	mr := e.MultipartReader()
+	if mr == nil {
+		return nil, errors.New("failure report is not a multipart message")
+	}
+
+	var report FailureReport
+	found := false
+	for {
+		p, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil && !message.IsUnknownCharset(err) {
+			return nil, fmt.Errorf("could not get next part: %w", err)
+		}
+
+		mediaType, _, err := p.Header.ContentType()
+		if err != nil {
+			continue
+		}
+		switch strings.ToLower(mediaType) {
+		case "message/feedback-report":
+			h, err := readHeader(p.Body)
+			if err != nil {
+				return nil, fmt.Errorf("could not parse feedback report: %w", err)
+			}
+			parseFeedbackReport(&report, h)
+			found = true
+		case "message/rfc822", "text/rfc822-headers", "message/rfc822-headers":
+			h, err := readHeader(p.Body)
+			if err != nil {
+				return nil, fmt.Errorf("could not parse original headers: %w", err)
+			}
+			report.OriginalFrom = decodeHeader(h.Get("From"))
+			report.OriginalTo = decodeHeader(h.Get("To"))
+			report.OriginalSubject = decodeHeader(h.Get("Subject"))
+			report.OriginalDate = h.Get("Date")
+			report.OriginalMessageID = h.Get("Message-ID")
+		}
+	}
+
+	if !found {
+		return nil, errors.New("no feedback report found")
+	}
+	return &report, nil
+}
+
+// readHeader reads a header block. The feedback report is not required to
+// end with an empty line so we add one
+func readHeader(r io.Reader) (textproto.MIMEHeader, error) {
+	reader := textproto.NewReader(bufio.NewReader(io.MultiReader(r, strings.NewReader("\r\n\r\n"))))
+	return reader.ReadMIMEHeader()
+}
+
+// decodeHeader decodes RFC 2047 encoded words and returns the raw
+// value if it can not be decoded
+func decodeHeader(s string) string {
+	dec := new(mime.WordDecoder)
+	decoded, err := dec.DecodeHeader(s)
+	if err != nil {
+		return s
+	}
+	return decoded
+}
+
+func parseFeedbackReport(report *FailureReport, h textproto.MIMEHeader) {
+	report.FeedbackType = h.Get("Feedback-Type")
+	report.UserAgent = h.Get("User-Agent")
+	report.Version = h.Get("Version")
+	report.ArrivalDate = h.Get("Arrival-Date")
+	report.ReportingMTA = h.Get("Reporting-MTA")
+	report.SourceIP = h.Get("Source-IP")
+	report.ReportedDomain = h.Values("Reported-Domain")
+	report.ReportedURI = h.Values("Reported-URI")
+	report.DeliveryResult = h.Get("Delivery-Result")
+	report.IdentityAlignment = h.Get("Identity-Alignment")
+	report.AuthenticationResults = h.Get("Authentication-Results")
+	report.OriginalMailFrom = h.Get("Original-Mail-From")
+	report.OriginalRcptTo = h.Values("Original-Rcpt-To")
+	report.DKIMDomain = h.Get("DKIM-Domain")
+	report.DKIMIdentity = h.Get("DKIM-Identity")
+	report.DKIMSelector = h.Get("DKIM-Selector")
+	report.SPFDNS = h.Get("SPF-DNS")
+	for _, v := range h.Values("Auth-Failure") {
+		report.AuthFailure = append(report.AuthFailure, strings.TrimSpace(v))
+	}
+	// Incidents defaults to 1 if not present
+	report.Incidents = 1
+	if i, err := strconv.Atoi(strings.TrimSpace(h.Get("Incidents"))); err == nil {
+		report.Incidents = i
+	}
+}
+
+// ConvertFailureToSyslog converts the failure report into an entry and
+// serializes it with the formatter
+func ConvertFailureToSyslog(report FailureReport, dns *dns.CachedDNSResolver, opts ConvertOptions, formatter Formatter) ([]byte, error) {
+	return formatter.FormatFailure(convertFailureReport(report, dns, opts))
+}
+
+func convertFailureReport(report FailureReport, dns *dns.CachedDNSResolver, opts ConvertOptions) FailureEntry {
+	domains := []string{}
+	if report.SourceIP != "" {
+		// lookup errors result in an empty list, spoofed sources often
+		// have no PTR record
+		if names, err := dns.CachedDNSLookup(report.SourceIP); err == nil {
+			domains = names
+		}
+	}
+
+	return FailureEntry{
+		EventID:               opts.EventID,
+		EventCategory:         opts.EventCategory,
+		ReportType:            ReportTypeFailure,
+		FeedbackType:          report.FeedbackType,
+		UserAgent:             report.UserAgent,
+		Version:               report.Version,
+		ArrivalDate:           report.ArrivalDate,
+		ArrivalDateParsed:     CustomTime(failureTime(report)),
+		ReportingMTA:          report.ReportingMTA,
+		SourceIP:              report.SourceIP,
+		SourceDNS:             domains,
+		SourceDNSString:       strings.Join(domains, ","),
+		Incidents:             report.Incidents,
+		AuthFailure:           report.AuthFailure,
+		ReportedDomain:        report.ReportedDomain,
+		ReportedURI:           report.ReportedURI,
+		DeliveryResult:        report.DeliveryResult,
+		IdentityAlignment:     report.IdentityAlignment,
+		AuthenticationResults: report.AuthenticationResults,
+		OriginalMailFrom:      report.OriginalMailFrom,
+		OriginalRcptTo:        report.OriginalRcptTo,
+		DKIMDomain:            report.DKIMDomain,
+		DKIMIdentity:          report.DKIMIdentity,
+		DKIMSelector:          report.DKIMSelector,
+		SPFDNS:                report.SPFDNS,
+		OriginalFrom:          report.OriginalFrom,
+		OriginalTo:            report.OriginalTo,
+		OriginalSubject:       report.OriginalSubject,
+		OriginalDate:          report.OriginalDate,
+		OriginalMessageID:     report.OriginalMessageID,
+	}
+}
+
+// failureTime returns the arrival date of the message. If it is missing or
+// invalid the date of the original message or the current time is used
+func failureTime(report FailureReport) time.Time {
+	for _, d := range []string{report.ArrivalDate, report.OriginalDate} {
+		if t, err := mail.ParseDate(d); err == nil {
+			return t
+		}
+	}
+	return time.Now()
+}
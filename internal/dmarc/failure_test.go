@@ -0,0 +1,151 @@
+package dmarc
+
+import (
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/firefart/dmarcsyslogforwarder/internal/dns"
+)
+
+func TestReadFailureReport(t *testing.T) {
+	t.Parallel()
+
+	f, err := os.Open("testdata/failure.eml")
+	if err != nil {
+		t.Fatalf("could not open test file: %v", err)
+	}
+	defer f.Close()
+
+	report, err := ReadFailureReport(f)
+	if err != nil {
+		t.Fatalf("could not read failure report: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		got      string
+		expected string
+	}{
+		{name: "FeedbackType", got: report.FeedbackType, expected: "auth-failure"},
+		{name: "SourceIP", got: report.SourceIP, expected: "192.0.2.1"},
+		{name: "DeliveryResult", got: report.DeliveryResult, expected: "reject"},
+		{name: "DKIMDomain", got: report.DKIMDomain, expected: "example.com"},
+		{name: "DKIMSelector", got: report.DKIMSelector, expected: "s1"},
+		{name: "OriginalMailFrom", got: report.OriginalMailFrom, expected: "<bounce@example.com>"},
+		{name: "OriginalFrom", got: report.OriginalFrom, expected: "Sender <sender@example.com>"},
+		{name: "OriginalSubject", got: report.OriginalSubject, expected: "Earn money €"},
+		{name: "OriginalMessageID", got: report.OriginalMessageID, expected: "<1234@example.com>"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.expected {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.expected, tt.got)
+		}
+	}
+	if report.Incidents != 3 {
+		t.Errorf("expected 3 incidents, got %d", report.Incidents)
+	}
+	if !slices.Equal(report.AuthFailure, []string{"dmarc"}) {
+		t.Errorf("invalid auth failure %v", report.AuthFailure)
+	}
+	if !slices.Equal(report.ReportedDomain, []string{"example.com"}) {
+		t.Errorf("invalid reported domain %v", report.ReportedDomain)
+	}
+	if got := failureTime(*report); !got.Equal(time.Date(2021, 11, 10, 11, 59, 0, 0, time.UTC)) {
+		t.Errorf("invalid arrival date %s", got)
+	}
+}
+
+func TestReadFailureReportInvalid(t *testing.T) {
+	t.Parallel()
+
+	content := "Content-Type: text/plain\r\n\r\nhello"
+	if _, err := ReadFailureReport(strings.NewReader(content)); err == nil {
+		t.Fatal("expected an error on a non multipart message")
+	}
+}
+
+func TestIsFailureReport(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		mediaType string
+		params    map[string]string
+		expected  bool
+	}{
+		{mediaType: "multipart/report", params: map[string]string{"report-type": "feedback-report"}, expected: true},
+		{mediaType: "Multipart/Report", params: map[string]string{"report-type": "Feedback-Report"}, expected: true},
+		{mediaType: "multipart/report", params: map[string]string{"report-type": "delivery-status"}, expected: false},
+		{mediaType: "multipart/mixed", params: nil, expected: false},
+	}
+	for _, tt := range tests {
+		if got := IsFailureReport(tt.mediaType, tt.params); got != tt.expected {
+			t.Errorf("%s %v: expected %t, got %t", tt.mediaType, tt.params, tt.expected, got)
+		}
+	}
+}
+
+func TestFormatFailure(t *testing.T) {
+	t.Parallel()
+
+	entry := FailureEntry{
+		ReportType:        ReportTypeFailure,
+		FeedbackType:      "auth-failure",
+		ArrivalDateParsed: CustomTime(time.Unix(1636545540, 0)),
+		SourceIP:          "192.0.2.1",
+		Incidents:         1,
+		AuthFailure:       []string{"dmarc"},
+		ReportedDomain:    []string{"example.com"},
+		DeliveryResult:    "reject",
+		OriginalFrom:      "sender@example.com",
+		OriginalSubject:   "Earn money",
+	}
+	header := DeviceHeader{Vendor: "firefart", Product: "dmarcsyslogforwarder", Version: "1.0"}
+
+	expected := "CEF:0|firefart|dmarcsyslogforwarder|1.0|dmarc-failure|DMARC failure report|8|" +
+		"rt=1636545540000 start=1636545540000 src=192.0.2.1 cnt=1 act=reject reason=dmarc msg=Earn money dhost=example.com " +
+		"cs1Label=headerFrom cs1=sender@example.com cs5Label=feedbackType cs5=auth-failure"
+	if got := string(formatFailureCEF(entry, header, nil)); got != expected {
+		t.Errorf("CEF mismatch\nexpected %s\ngot      %s", expected, got)
+	}
+
+	for _, format := range []string{"xml", "json", "leef", "ecs", "ocsf", "gelf"} {
+		formatter, err := NewFormatter(format, FormatterOptions{Header: header})
+		if err != nil {
+			t.Fatalf("%s: could not create formatter: %v", format, err)
+		}
+		b, err := formatter.FormatFailure(entry)
+		if err != nil {
+			t.Fatalf("%s: could not format failure: %v", format, err)
+		}
+		if !strings.Contains(string(b), "sender@example.com") {
+			t.Errorf("%s: output does not contain the original from: %s", format, b)
+		}
+	}
+}
+
+func TestConvertFailureUnresolvable(t *testing.T) {
+	t.Parallel()
+
+	// nothing listens on the dns server so every lookup fails
+	resolver := dns.NewCachedDNSResolver(t.Context(), "127.0.0.1:1", 100*time.Millisecond, 100*time.Millisecond, time.Hour, slog.New(slog.DiscardHandler))
+	report := FailureReport{FeedbackType: "auth-failure", SourceIP: "192.0.2.1", Incidents: 1}
+
+	formatter, err := NewFormatter("json", FormatterOptions{})
+	if err != nil {
+		t.Fatalf("could not create formatter: %v", err)
+	}
+	// the result must not change once the failed lookup is cached
+	for range 2 {
+		b, err := ConvertFailureToSyslog(report, resolver, ConvertOptions{}, formatter)
+		if err != nil {
+			t.Fatalf("expected the report to be converted, got %v", err)
+		}
+		if !strings.Contains(string(b), `"source_ip":"192.0.2.1","source_dns":[],"source_dns_string":""`) {
+			t.Fatalf("expected an empty source_dns, got %s", b)
+		}
+	}
+}
@@ -0,0 +1,334 @@
+package dmarc
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/firefart/dmarcsyslogforwarder/internal/fields"
+)
+
+// Formatter serializes a single entry into an output format
+type Formatter interface {
+	Format(entry SyslogEntry) ([]byte, error)
+	FormatFailure(entry FailureEntry) ([]byte, error)
+	FormatTLS(entry TLSEntry) ([]byte, error)
+}
+
+// FormatterOptions contains the settings of the different output formats
+type FormatterOptions struct {
+	// Header is used by the cef, leef, ecs and ocsf formats
+	Header DeviceHeader
+	// Template is required by the template format
+	Template *template.Template
+	// Fields is applied to all formats, the fields are addressed by the
+	// names of the JSON format
+	Fields *fields.Filter
+}
+
+// NewFormatter returns the formatter for the given format
+func NewFormatter(format string, opts FormatterOptions) (Formatter, error) {
+	switch format {
+	case "xml":
+		return xmlFormatter{fields: opts.Fields}, nil
+	case "json":
+		return jsonFormatter{fields: opts.Fields}, nil
+	case "cef":
+		return cefFormatter{header: opts.Header, fields: opts.Fields}, nil
+	case "leef":
+		return leefFormatter{header: opts.Header, fields: opts.Fields}, nil
+	case "ecs":
+		return ecsFormatter{header: opts.Header, fields: opts.Fields}, nil
+	case "ocsf":
+		return ocsfFormatter{header: opts.Header, fields: opts.Fields}, nil
+	case "gelf":
+		return gelfFormatter{fields: opts.Fields}, nil
+	case "template":
+		if opts.Template == nil {
+			return nil, errors.New("template format requires a template")
+		}
+		return templateFormatter{template: opts.Template, fields: opts.Fields}, nil
+	default:
+		return nil, fmt.Errorf("invalid format %s", format)
+	}
+}
+
+type jsonFormatter struct {
+	fields *fields.Filter
+}
+
+// Format converts the entry into JSON
+func (f jsonFormatter) Format(entry SyslogEntry) ([]byte, error) {
+	return marshalJSON(entry, f.fields)
+}
+
+// FormatFailure converts the failure report into JSON
+func (f jsonFormatter) FormatFailure(entry FailureEntry) ([]byte, error) {
+	return marshalJSON(entry, f.fields)
+}
+
+// FormatTLS converts the TLS report entry into JSON
+func (f jsonFormatter) FormatTLS(entry TLSEntry) ([]byte, error) {
+	return marshalJSON(entry, f.fields)
+}
+
+type xmlFormatter struct {
+	fields *fields.Filter
+}
+
+// Format converts the entry into XML
+func (f xmlFormatter) Format(entry SyslogEntry) ([]byte, error) {
+	return marshalXML(entry, "syslog_entry", f.fields)
+}
+
+// FormatFailure converts the failure report into XML
+func (f xmlFormatter) FormatFailure(entry FailureEntry) ([]byte, error) {
+	return marshalXML(entry, "failure_entry", f.fields)
+}
+
+// FormatTLS converts the TLS report entry into XML
+func (f xmlFormatter) FormatTLS(entry TLSEntry) ([]byte, error) {
+	return marshalXML(entry, "tls_entry", f.fields)
+}
+
+// marshalJSON serializes v and applies the field filter
+func marshalJSON(v any, filter *fields.Filter) ([]byte, error) {
+	jsonString, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal JSON: %w", err)
+	}
+	if filter.IsEmpty() {
+		return jsonString, nil
+	}
+	doc, err := fields.ParseJSON(jsonString)
+	if err != nil {
+		return nil, err
+	}
+	jsonString, err = json.Marshal(filter.Apply(doc))
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal JSON: %w", err)
+	}
+	return jsonString, nil
+}
+
+// marshalXML serializes v and applies the field filter. As the filter uses
+// the field names of the JSON format, filtered entries are built from the
+// JSON document. Lists keep the wrapping elements of the xml struct tags.
+func marshalXML(v any, root string, filter *fields.Filter) ([]byte, error) {
+	if filter.IsEmpty() {
+		xmlString, err := xml.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("could not marshal XML: %w", err)
+		}
+		return xmlString, nil
+	}
+	doc, err := entryDocument(v)
+	if err != nil {
+		return nil, err
+	}
+	selected := filter.Select(doc)
+	renamed := wrapXMLLists(selected, filter.Rename(selected), "", xmlWrappers(reflect.TypeOf(v), ""))
+	xmlString, err := filter.Extend(renamed).EncodeXML(root)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal XML: %w", err)
+	}
+	return xmlString, nil
+}
+
+// xmlWrappers returns the lists of t with a wrapping element (a xml tag
+// like "errors>error") indexed by the JSON path of the field. The values are
+// the names of the list elements.
+func xmlWrappers(t reflect.Type, prefix string) map[string]string {
+	wrappers := make(map[string]string)
+	for t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return wrappers
+	}
+	for i := range t.NumField() {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if !field.IsExported() || name == "" || name == "-" {
+			continue
+		}
+		path := name
+		if prefix != "" {
+			path = prefix + "." + name
+		}
+		xmlName, _, _ := strings.Cut(field.Tag.Get("xml"), ",")
+		if _, element, ok := strings.Cut(xmlName, ">"); ok {
+			wrappers[path] = element
+		}
+		maps.Copy(wrappers, xmlWrappers(field.Type, path))
+	}
+	return wrappers
+}
+
+// wrapXMLLists wraps the lists of the renamed document in the elements of
+// the xml struct tags. The paths are taken from the selected document as
+// renaming keeps the structure of the document.
+func wrapXMLLists(selected, renamed fields.Document, prefix string, wrappers map[string]string) fields.Document {
+	out := make(fields.Document, len(renamed))
+	for i, field := range renamed {
+		path := selected[i].Key
+		if prefix != "" {
+			path = prefix + "." + path
+		}
+		switch v := field.Value.(type) {
+		case fields.Document:
+			if s, ok := selected[i].Value.(fields.Document); ok {
+				field.Value = wrapXMLLists(s, v, path, wrappers)
+			}
+		case []any:
+			s, _ := selected[i].Value.([]any)
+			l := make([]any, len(v))
+			for x, e := range v {
+				d, isDoc := e.(fields.Document)
+				sd, isSelectedDoc := s[x].(fields.Document)
+				if isDoc && isSelectedDoc {
+					e = wrapXMLLists(sd, d, path, wrappers)
+				}
+				l[x] = e
+			}
+			field.Value = l
+			if element, ok := wrappers[path]; ok {
+				field.Value = fields.Document{{Key: element, Value: l}}
+			}
+		}
+		out[i] = field
+	}
+	return out
+}
+
+// marshalSchema serializes the document of a schema based format (ECS and
+// OCSF) and adds the extra fields of the filter. The fields of the schema
+// are already selected with selectFields.
+func marshalSchema(v any, filter *fields.Filter) ([]byte, error) {
+	jsonString, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal JSON: %w", err)
+	}
+	if filter.IsEmpty() {
+		return jsonString, nil
+	}
+	doc, err := fields.ParseJSON(jsonString)
+	if err != nil {
+		return nil, err
+	}
+	jsonString, err = json.Marshal(filter.Extend(doc))
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal JSON: %w", err)
+	}
+	return jsonString, nil
+}
+
+// selectFields returns a copy of the entry containing only the fields kept
+// by the filter, all other fields are empty. The ECS and OCSF formats build
+// their fields from it so they are selected by the names of the entry.
+func selectFields[T any](entry T, filter *fields.Filter) (T, error) {
+	var selected T
+	if filter.IsEmpty() {
+		return entry, nil
+	}
+	doc, err := entryDocument(entry)
+	if err != nil {
+		return selected, err
+	}
+	b, err := json.Marshal(filter.Select(doc))
+	if err != nil {
+		return selected, fmt.Errorf("could not marshal JSON: %w", err)
+	}
+	if err := json.Unmarshal(b, &selected); err != nil {
+		return selected, fmt.Errorf("could not unmarshal JSON: %w", err)
+	}
+	return selected, nil
+}
+
+// unmappedDocument selects and renames the fields of a schema based format
+// without an equivalent in the schema. They use the names of the entry so
+// they are filtered like the fields of the JSON format. It returns nil if no
+// field is selected.
+func unmappedDocument(v any, filter *fields.Filter) (any, error) {
+	if filter.IsEmpty() {
+		return v, nil
+	}
+	doc, err := entryDocument(v)
+	if err != nil {
+		return nil, err
+	}
+	return nonEmpty(filter.Rename(filter.Select(doc))), nil
+}
+
+// nonEmpty returns nil for empty documents so they are omitted
+func nonEmpty(doc fields.Document) any {
+	if len(doc) == 0 {
+		return nil
+	}
+	return doc
+}
+
+// entryDocument converts the entry into the generic document also used by
+// the field filter of the JSON format
+func entryDocument(v any) (fields.Document, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal JSON: %w", err)
+	}
+	return fields.ParseJSON(b)
+}
+
+// unmappedFields returns the fields of the entry selected by the filter
+// except the ones mapped to fields of a schema. The ECS and OCSF formats use
+// it for the fields without an equivalent, so new fields of the entry show up
+// without changes there. It returns nil if no field is selected.
+func unmappedFields(entry SyslogEntry, mapped []string, filter *fields.Filter) (any, error) {
+	doc, err := entryDocument(entry)
+	if err != nil {
+		return nil, err
+	}
+	doc = slices.DeleteFunc(filter.Select(doc), func(f fields.Field) bool {
+		return slices.Contains(mapped, f.Key)
+	})
+	return nonEmpty(filter.Rename(doc)), nil
+}
+
+// filterKeyValues applies the field filter to the flat key value lists of
+// the CEF and LEEF formats. The values are selected by the path of the entry
+// field they are built from. Renamed fields with a label get the new label,
+// all other fields the new key.
+func filterKeyValues(kvs []keyValue, filter *fields.Filter) []keyValue {
+	if filter.IsEmpty() {
+		return kvs
+	}
+	ret := make([]keyValue, 0, len(kvs))
+	for _, kv := range kvs {
+		if kv.field != "" && !filter.Keep(kv.field) {
+			continue
+		}
+		if name, ok := filter.Renamed(kv.field); ok {
+			if kv.label != "" {
+				kv.label = name
+			} else {
+				kv.key = name
+			}
+		}
+		ret = append(ret, kv)
+	}
+	for _, e := range filter.Extend(nil) {
+		value := fmt.Sprint(e.Value)
+		i := slices.IndexFunc(ret, func(kv keyValue) bool { return kv.key == e.Key })
+		if i >= 0 {
+			ret[i].value = value
+			continue
+		}
+		ret = append(ret, keyValue{key: e.Key, value: value})
+	}
+	return ret
+}
@@ -0,0 +1,137 @@
+package dmarc
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"github.com/firefart/dmarcsyslogforwarder/internal/fields"
+)
+
+func TestNewFormatter(t *testing.T) {
+	t.Parallel()
+
+	for _, format := range []string{"xml", "json", "cef", "leef", "ecs", "ocsf", "gelf"} {
+		if _, err := NewFormatter(format, FormatterOptions{}); err != nil {
+			t.Fatalf("got unexpected error for format %s: %v", format, err)
+		}
+	}
+	if _, err := NewFormatter("template", FormatterOptions{}); err == nil {
+		t.Fatal("expected an error on template format without a template")
+	}
+	if _, err := NewFormatter("invalid", FormatterOptions{}); err == nil {
+		t.Fatal("expected an error on invalid format")
+	}
+}
+
+func TestFormatterFields(t *testing.T) {
+	t.Parallel()
+
+	entry := SyslogEntry{
+		SourceIP:   "192.0.2.1",
+		Count:      1,
+		HeaderFrom: "example.com",
+		Errors:     []string{"error1", "error2"},
+		SourceDNS:  []string{"mail.example.com"},
+	}
+
+	// without a filter the output must not change
+	f, err := NewFormatter("xml", FormatterOptions{})
+	if err != nil {
+		t.Fatalf("could not create formatter: %v", err)
+	}
+	got, err := f.Format(entry)
+	if err != nil {
+		t.Fatalf("could not format: %v", err)
+	}
+	expected, err := xml.Marshal(entry)
+	if err != nil {
+		t.Fatalf("could not marshal: %v", err)
+	}
+	if string(got) != string(expected) {
+		t.Fatalf("xml mismatch\nexpected %s\ngot      %s", expected, got)
+	}
+
+	// field names are the names of the JSON format in all output formats
+	filter := fields.NewFilter([]string{"source_ip", "count", "errors"}, nil, map[string]string{"source_ip": "src"}, map[string]string{"tenant": "acme"})
+	sourceIP := fields.NewFilter([]string{"source_ip"}, nil, nil, nil)
+	tests := []struct {
+		format   string
+		filter   *fields.Filter
+		expected string
+		contains []string
+		missing  []string
+	}{
+		{
+			format:   "json",
+			filter:   filter,
+			expected: `{"errors":["error1","error2"],"src":"192.0.2.1","count":1,"tenant":"acme"}`,
+		},
+		{
+			format:   "xml",
+			filter:   filter,
+			expected: `<syslog_entry><errors><error>error1</error><error>error2</error></errors><src>192.0.2.1</src><count>1</count><tenant>acme</tenant></syslog_entry>`,
+		},
+		{
+			format:   "xml",
+			filter:   fields.NewFilter([]string{"source_dns"}, nil, nil, nil),
+			expected: `<syslog_entry><source_dns><dns>mail.example.com</dns></source_dns></syslog_entry>`,
+		},
+		{
+			format:   "leef",
+			filter:   fields.NewFilter(nil, []string{"count", "source_dns"}, map[string]string{"source_ip": "sourceIP"}, map[string]string{"tenant": "acme"}),
+			expected: "LEEF:2.0||||dmarc-aggregate|x09|sev=6\tdevTime=0\tsourceIP=192.0.2.1\theaderFrom=example.com\tdkimAligned=false\tspfAligned=false\tdmarcPass=false\tlikelyForwarded=false\ttenant=acme",
+		},
+		{
+			format:   "cef",
+			filter:   sourceIP,
+			expected: "CEF:0||||dmarc-aggregate|DMARC aggregate report record|6|src=192.0.2.1",
+		},
+		{
+			format:   "cef",
+			filter:   fields.NewFilter([]string{"source_ip", "count"}, nil, map[string]string{"source_ip": "sourceAddress"}, nil),
+			expected: "CEF:0||||dmarc-aggregate|DMARC aggregate report record|6|sourceAddress=192.0.2.1 cnt=1",
+		},
+		{
+			format:   "gelf",
+			filter:   sourceIP,
+			contains: []string{`"_source_ip":"192.0.2.1"`, `"short_message":`},
+			missing:  []string{`"_count"`, `"_header_from"`},
+		},
+		{
+			format:   "ecs",
+			filter:   sourceIP,
+			contains: []string{`"source":{"ip":"192.0.2.1"}`, `"@timestamp":`, `"outcome":"failure"`},
+			missing:  []string{`"header_from"`, `"domain":"mail.example.com"`},
+		},
+		{
+			format:   "ocsf",
+			filter:   sourceIP,
+			contains: []string{`"src_endpoint":{"ip":"192.0.2.1"}`, `"status":"Failure"`},
+			missing:  []string{`"unmapped"`, `"hostname"`},
+		},
+	}
+	for _, tt := range tests {
+		f, err := NewFormatter(tt.format, FormatterOptions{Fields: tt.filter})
+		if err != nil {
+			t.Fatalf("could not create formatter: %v", err)
+		}
+		got, err := f.Format(entry)
+		if err != nil {
+			t.Fatalf("could not format: %v", err)
+		}
+		if tt.expected != "" && string(got) != tt.expected {
+			t.Fatalf("%s mismatch\nexpected %s\ngot      %s", tt.format, tt.expected, got)
+		}
+		for _, s := range tt.contains {
+			if !strings.Contains(string(got), s) {
+				t.Fatalf("%s output does not contain %s: %s", tt.format, s, got)
+			}
+		}
+		for _, s := range tt.missing {
+			if strings.Contains(string(got), s) {
+				t.Fatalf("%s output contains %s: %s", tt.format, s, got)
+			}
+		}
+	}
+}
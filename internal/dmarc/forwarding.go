@@ -0,0 +1,105 @@
+package dmarc
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/firefart/dmarcsyslogforwarder/internal/senders"
+)
+
+// defaultForwarderSuffixes contains the PTR suffixes of well known
+// forwarding services
+var defaultForwarderSuffixes = []string{
+	"forwardemail.net",
+	"improvmx.com",
+	"pobox.com",
+	"simplelogin.co",
+}
+
+// forwardingReasons are the policy override reasons set by receivers that
+// detected forwarded mail
+var forwardingReasons = map[string]string{
+	"forwarded":         "the receiver reported the message as forwarded",
+	"trusted_forwarder": "the receiver reported the message from a trusted forwarder",
+	"mailing_list":      "the receiver reported the message from a mailing list",
+	"local_policy":      "the receiver applied a local policy, which is commonly used for forwarded mail",
+}
+
+const arcExplanation = "the message was arc sealed by an intermediary"
+
+// detectForwarding sets likely_forwarded and the explanation of the entry.
+// Forwarding breaks SPF as the forwarder is not authorized to send for the
+// domain, while DKIM signatures usually survive. Every indicator found is
+// added to the explanation.
+func detectForwarding(entry *SyslogEntry, suffixes []string) {
+	var explanation []string
+
+	for _, r := range entry.PolicyEvaluated.Reason {
+		if text, ok := forwardingReasons[strings.ToLower(r.Type)]; ok && !slices.Contains(explanation, text) {
+			explanation = append(explanation, text)
+		}
+		// Microsoft and Google mention a passed ARC chain in the comment
+		if strings.Contains(strings.ToLower(r.Comment), "arc=pass") && !slices.Contains(explanation, arcExplanation) {
+			explanation = append(explanation, arcExplanation)
+		}
+	}
+
+	if d := alignedDKIMPass(*entry); d != "" && !spfPassed(*entry) {
+		explanation = append(explanation, fmt.Sprintf("spf did not pass while the aligned dkim signature of %s passed", d))
+	}
+
+	for _, name := range verifiedNames(*entry) {
+		if suffix := forwarderSuffix(name, suffixes); suffix != "" {
+			explanation = append(explanation, fmt.Sprintf("the source %s belongs to the forwarding service %s", name, suffix))
+			break
+		}
+	}
+
+	if entry.SenderClass == senders.ClassForwarder {
+		explanation = append(explanation, fmt.Sprintf("the source is the forwarder %s of the sender inventory", entry.SenderName))
+	}
+
+	entry.LikelyForwarded = len(explanation) > 0
+	entry.ForwardingExplanation = strings.Join(explanation, "; ")
+}
+
+// alignedDKIMPass returns the domain of the first passed and aligned DKIM
+// signature
+func alignedDKIMPass(entry SyslogEntry) string {
+	for _, r := range entry.ResultDkim {
+		if r.Aligned && strings.EqualFold(r.Result, "pass") {
+			return r.Domain
+		}
+	}
+	return ""
+}
+
+// spfPassed checks if any SPF result of the MAIL FROM identity passed.
+// Records without SPF results use the evaluated SPF result of the receiver.
+func spfPassed(entry SyslogEntry) bool {
+	if len(entry.ResultSpf) == 0 {
+		return strings.EqualFold(entry.PolicyEvaluated.Spf, "pass")
+	}
+	for _, r := range entry.ResultSpf {
+		if isMailFrom(r.Scope) && strings.EqualFold(r.Result, "pass") {
+			return true
+		}
+	}
+	return false
+}
+
+// forwarderSuffix returns the matching suffix of the default and the
+// configured forwarder suffixes
+func forwarderSuffix(name string, suffixes []string) string {
+	name = normalizeDomain(name)
+	for _, list := range [][]string{defaultForwarderSuffixes, suffixes} {
+		for _, s := range list {
+			s = normalizeDomain(s)
+			if s != "" && (name == s || strings.HasSuffix(name, "."+s)) {
+				return s
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,138 @@
+package dmarc
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDetectForwarding(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		entry     SyslogEntry
+		suffixes  []string
+		forwarded bool
+		contains  []string
+	}{
+		{
+			name: "direct mail",
+			entry: SyslogEntry{
+				ResultDkim: []SyslogResultDKIM{{Domain: "example.com", Result: "pass", Aligned: true}},
+				ResultSpf:  []SyslogResultSPF{{Domain: "example.com", Result: "pass", Aligned: true}},
+			},
+		},
+		{
+			name: "override reasons",
+			entry: SyslogEntry{
+				PolicyEvaluated: SyslogPolicyEvaluated{Reason: []SyslogPolicyOverrideReason{
+					{Type: "mailing_list"},
+					{Type: "Forwarded"},
+					{Type: "forwarded"},
+					{Type: "sampled_out"},
+				}},
+			},
+			forwarded: true,
+			contains:  []string{"mailing list", "as forwarded"},
+		},
+		{
+			name: "arc",
+			entry: SyslogEntry{
+				PolicyEvaluated: SyslogPolicyEvaluated{Reason: []SyslogPolicyOverrideReason{
+					{Type: "local_policy", Comment: "arc=pass ams.d=google.com ams.s=arc-20160816 cv=pass"},
+				}},
+			},
+			forwarded: true,
+			contains:  []string{"local policy", "arc sealed"},
+		},
+		{
+			name: "spf fail with aligned dkim pass",
+			entry: SyslogEntry{
+				ResultDkim: []SyslogResultDKIM{{Domain: "example.com", Result: "pass", Aligned: true}},
+				ResultSpf:  []SyslogResultSPF{{Domain: "lists.example.org", Result: "fail"}},
+			},
+			forwarded: true,
+			contains:  []string{"dkim signature of example.com passed"},
+		},
+		{
+			name: "helo pass with failed mfrom",
+			entry: SyslogEntry{
+				ResultDkim: []SyslogResultDKIM{{Domain: "example.com", Result: "pass", Aligned: true}},
+				ResultSpf: []SyslogResultSPF{
+					{Domain: "mx.lists.example.org", Scope: "helo", Result: "pass"},
+					{Domain: "lists.example.org", Scope: "mfrom", Result: "fail"},
+				},
+			},
+			forwarded: true,
+			contains:  []string{"dkim signature of example.com passed"},
+		},
+		{
+			name: "unaligned dkim pass",
+			entry: SyslogEntry{
+				ResultDkim: []SyslogResultDKIM{{Domain: "esp.example", Result: "pass"}},
+				ResultSpf:  []SyslogResultSPF{{Domain: "example.com", Result: "fail"}},
+			},
+		},
+		{
+			name: "evaluated spf without results",
+			entry: SyslogEntry{
+				PolicyEvaluated: SyslogPolicyEvaluated{Spf: "fail"},
+				ResultDkim:      []SyslogResultDKIM{{Domain: "example.com", Result: "pass", Aligned: true}},
+			},
+			forwarded: true,
+		},
+		{
+			name: "built-in forwarder suffix",
+			entry: SyslogEntry{
+				SourceDNSVerified: []SyslogDNSName{{Name: "mx1.forwardemail.net", Verified: true}},
+			},
+			forwarded: true,
+			contains:  []string{"forwarding service forwardemail.net"},
+		},
+		{
+			name: "unverified forwarder suffix",
+			entry: SyslogEntry{
+				SourceDNSVerified: []SyslogDNSName{{Name: "mx1.forwardemail.net"}},
+			},
+		},
+		{
+			name: "configured forwarder suffix",
+			entry: SyslogEntry{
+				SourceDNSVerified: []SyslogDNSName{{Name: "relay.Lists.Example.org.", Verified: true}},
+			},
+			suffixes:  []string{"lists.example.org"},
+			forwarded: true,
+			contains:  []string{"forwarding service lists.example.org"},
+		},
+		{
+			name: "sender inventory",
+			entry: SyslogEntry{
+				SenderName:  "University Alumni",
+				SenderClass: "forwarder",
+			},
+			forwarded: true,
+			contains:  []string{"forwarder University Alumni"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			entry := tt.entry
+			detectForwarding(&entry, tt.suffixes)
+			if entry.LikelyForwarded != tt.forwarded {
+				t.Fatalf("expected likely_forwarded=%t, got %t (%q)", tt.forwarded, entry.LikelyForwarded, entry.ForwardingExplanation)
+			}
+			if !tt.forwarded && entry.ForwardingExplanation != "" {
+				t.Fatalf("expected no explanation, got %q", entry.ForwardingExplanation)
+			}
+			for _, c := range tt.contains {
+				if !strings.Contains(entry.ForwardingExplanation, c) {
+					t.Fatalf("expected the explanation to contain %q, got %q", c, entry.ForwardingExplanation)
+				}
+			}
+			if strings.Count(entry.ForwardingExplanation, "as forwarded") > 1 {
+				t.Fatalf("expected no duplicate explanations, got %q", entry.ForwardingExplanation)
+			}
+		})
+	}
+}
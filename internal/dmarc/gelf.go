@@ -0,0 +1,299 @@
+package dmarc
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/firefart/dmarcsyslogforwarder/internal/fields"
+)
+
+// https://go2docs.graylog.org/current/getting_in_log_data/gelf.html#GELFPayloadSpecification
+const gelfVersion = "1.1"
+
+type gelfFormatter struct {
+	fields *fields.Filter
+}
+
+// Format converts the entry into a GELF 1.1 message
+func (f gelfFormatter) Format(entry SyslogEntry) ([]byte, error) {
+	jsonString, err := json.Marshal(toGELF(entry, f.fields))
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal GELF: %w", err)
+	}
+	return jsonString, nil
+}
+
+// FormatFailure converts the failure report into a GELF 1.1 message
+func (f gelfFormatter) FormatFailure(entry FailureEntry) ([]byte, error) {
+	jsonString, err := json.Marshal(toFailureGELF(entry, f.fields))
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal GELF: %w", err)
+	}
+	return jsonString, nil
+}
+
+// FormatTLS converts the TLS report entry into a GELF 1.1 message
+func (f gelfFormatter) FormatTLS(entry TLSEntry) ([]byte, error) {
+	jsonString, err := json.Marshal(toTLSGELF(entry, f.fields))
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal GELF: %w", err)
+	}
+	return jsonString, nil
+}
+
+func toGELF(entry SyslogEntry, filter *fields.Filter) map[string]any {
+	msg := map[string]any{
+		"version":       gelfVersion,
+		"host":          entry.Domain,
+		"short_message": gelfShortMessage(entry),
+		"timestamp":     time.Time(entry.DateEndParsed).Unix(),
+		"level":         gelfLevel(severity(entry)),
+	}
+
+	additional := []gelfField{
+		{key: "event_id", field: "event_id", value: entry.EventID},
+		{key: "event_category", field: "event_category", value: entry.EventCategory},
+		{key: "report_type", field: "report_type", value: entry.ReportType},
+		{key: "report_version", field: "version", value: entry.Version},
+		{key: "domain", field: "domain", value: entry.Domain},
+		{key: "reporter_domain", field: "reporter_domain", value: entry.ReporterDomain},
+		{key: "policy_domain", field: "policy_domain", value: entry.PolicyDomain},
+		{key: "date_begin", field: "date_begin", value: entry.DateBegin},
+		{key: "date_end", field: "date_end", value: entry.DateEnd},
+		{key: "report_id", field: "report_id", value: entry.ReportID},
+		{key: "org_name", field: "org_name", value: entry.OrgName},
+		{key: "email", field: "email", value: entry.Email},
+		{key: "extra_contact_info", field: "extra_contact_info", value: entry.ExtraContactInfo},
+		{key: "errors", field: "errors", value: strings.Join(entry.Errors, ", ")},
+		{key: "generator", field: "generator", value: entry.Generator},
+		{key: "source_ip", field: "source_ip", value: entry.SourceIP},
+		{key: "source_dns", field: "source_dns", value: entry.SourceDNSString},
+		{key: "source_dns_org_domains", field: "source_dns_org_domains", value: strings.Join(entry.SourceDNSOrgDomains, ", ")},
+		{key: "source_dns_verified", field: "source_dns_verified", value: strings.Join(verifiedNames(entry), ", ")},
+		{key: "source_country", field: "source_country", value: entry.SourceCountry},
+		{key: "source_city", field: "source_city", value: entry.SourceCity},
+		{key: "source_asn", field: "source_asn", value: formatASN(entry.SourceASN)},
+		{key: "source_as_org", field: "source_as_org", value: entry.SourceASOrg},
+		{key: "count", field: "count", value: entry.Count},
+		{key: "envelope_to", field: "envelope_to", value: entry.EnvelopeTo},
+		{key: "header_from", field: "header_from", value: entry.HeaderFrom},
+		{key: "header_from_org_domain", field: "header_from_org_domain", value: entry.HeaderFromOrgDomain},
+		{key: "envelope_from", field: "envelope_from", value: entry.EnvelopeFrom},
+		{key: "policy_published_domain", field: "policy_published.domain", value: entry.PolicyPublished.Domain},
+		{key: "policy_published_adkim", field: "policy_published.adkim", value: entry.PolicyPublished.Adkim},
+		{key: "policy_published_aspf", field: "policy_published.aspf", value: entry.PolicyPublished.Aspf},
+		{key: "policy_published_p", field: "policy_published.p", value: entry.PolicyPublished.P},
+		{key: "policy_published_sp", field: "policy_published.sp", value: entry.PolicyPublished.Sp},
+		{key: "policy_published_pct", field: "policy_published.pct", value: entry.PolicyPublished.Pct},
+		{key: "policy_published_fo", field: "policy_published.fo", value: entry.PolicyPublished.Fo},
+		{key: "policy_published_np", field: "policy_published.np", value: entry.PolicyPublished.Np},
+		{key: "policy_published_testing", field: "policy_published.testing", value: entry.PolicyPublished.Testing},
+		{key: "policy_published_discovery_method", field: "policy_published.discovery_method", value: entry.PolicyPublished.DiscoveryMethod},
+		{key: "policy_evaluated_disposition", field: "policy_evaluated.disposition", value: entry.PolicyEvaluated.Disposition},
+		{key: "policy_evaluated_dkim", field: "policy_evaluated.dkim", value: entry.PolicyEvaluated.Dkim},
+		{key: "policy_evaluated_spf", field: "policy_evaluated.spf", value: entry.PolicyEvaluated.Spf},
+		{key: "policy_evaluated_reason", field: "policy_evaluated.reason", value: overrideReasons(entry)},
+		{key: "spf_domain", field: entry.spfPath("domain"), value: joinResults(entry.spfResults(), spfDomain)},
+		{key: "spf_scope", field: entry.spfPath("scope"), value: joinResults(entry.spfResults(), spfScope)},
+		{key: "spf_result", field: entry.spfPath("result"), value: joinResults(entry.spfResults(), spfResult)},
+		{key: "spf_human_result", field: entry.spfPath("human_result"), value: joinResults(entry.spfResults(), spfHumanResult)},
+		{key: "spf_analysis_result", field: entry.spfPath("analysis.result"), value: joinResults(entry.spfResults(), spfAnalysisResult)},
+		{key: "spf_analysis_mechanism", field: entry.spfPath("analysis.mechanism"), value: joinResults(entry.spfResults(), spfAnalysisMechanism)},
+		{key: "spf_analysis_lookups", field: entry.spfPath("analysis.lookups"), value: joinResults(entry.spfResults(), spfAnalysisLookups)},
+		{key: "spf_analysis_over_limit", field: entry.spfPath("analysis.over_limit"), value: joinResults(entry.spfResults(), spfAnalysisOverLimit)},
+		{key: "dkim_domain", field: entry.dkimPath("domain"), value: joinResults(entry.dkimResults(), dkimDomain)},
+		{key: "dkim_selector", field: entry.dkimPath("selector"), value: joinResults(entry.dkimResults(), dkimSelector)},
+		{key: "dkim_result", field: entry.dkimPath("result"), value: joinResults(entry.dkimResults(), dkimResult)},
+		{key: "dkim_human_result", field: entry.dkimPath("human_result"), value: joinResults(entry.dkimResults(), dkimHumanResult)},
+		{key: "dkim_aligned", field: "dkim_aligned", value: boolToInt(entry.DKIMAligned)},
+		{key: "spf_aligned", field: "spf_aligned", value: boolToInt(entry.SPFAligned)},
+		{key: "dmarc_pass", field: "dmarc_pass", value: boolToInt(entry.DMARCPass)},
+		{key: "sender_name", field: "sender_name", value: entry.SenderName},
+		{key: "sender_class", field: "sender_class", value: entry.SenderClass},
+		{key: "likely_forwarded", field: "likely_forwarded", value: boolToInt(entry.LikelyForwarded)},
+		{key: "forwarding_explanation", field: "forwarding_explanation", value: entry.ForwardingExplanation},
+		{key: "policy_drift", field: "policy_drift", value: strings.Join(entry.PolicyDrift, "; ")},
+		{key: "validation_warnings", field: "validation_warnings", value: strings.Join(entry.ValidationWarnings, "; ")},
+		{key: "domain_mismatches", field: "domain_mismatches", value: strings.Join(entry.DomainMismatches, "; ")},
+	}
+	// only flattened entries have a position
+	if entry.AuthResultTotal > 0 {
+		additional = append(additional,
+			gelfField{key: "auth_result_index", field: "auth_result_index", value: entry.AuthResultIndex},
+			gelfField{key: "auth_result_total", field: "auth_result_total", value: entry.AuthResultTotal},
+		)
+	}
+	for _, e := range entry.Extensions {
+		additional = append(additional, gelfField{key: "extension_" + e.Name, field: "extensions", value: e.Value})
+	}
+	addGELFFields(msg, additional, filter)
+
+	return msg
+}
+
+func toFailureGELF(entry FailureEntry, filter *fields.Filter) map[string]any {
+	msg := map[string]any{
+		"version":       gelfVersion,
+		"host":          firstNonEmpty(entry.ReportedDomain),
+		"short_message": gelfFailureShortMessage(entry),
+		"timestamp":     time.Time(entry.ArrivalDateParsed).Unix(),
+		"level":         gelfLevel(failureSeverity(entry)),
+	}
+
+	additional := []gelfField{
+		{key: "event_id", field: "event_id", value: entry.EventID},
+		{key: "event_category", field: "event_category", value: entry.EventCategory},
+		{key: "report_type", field: "report_type", value: entry.ReportType},
+		{key: "feedback_type", field: "feedback_type", value: entry.FeedbackType},
+		{key: "user_agent", field: "user_agent", value: entry.UserAgent},
+		{key: "report_version", field: "version", value: entry.Version},
+		{key: "arrival_date", field: "arrival_date", value: entry.ArrivalDate},
+		{key: "reporting_mta", field: "reporting_mta", value: entry.ReportingMTA},
+		{key: "source_ip", field: "source_ip", value: entry.SourceIP},
+		{key: "source_dns", field: "source_dns", value: entry.SourceDNSString},
+		{key: "incidents", field: "incidents", value: entry.Incidents},
+		{key: "auth_failure", field: "auth_failure", value: strings.Join(entry.AuthFailure, ", ")},
+		{key: "reported_domain", field: "reported_domain", value: strings.Join(entry.ReportedDomain, ", ")},
+		{key: "reported_uri", field: "reported_uri", value: strings.Join(entry.ReportedURI, ", ")},
+		{key: "delivery_result", field: "delivery_result", value: entry.DeliveryResult},
+		{key: "identity_alignment", field: "identity_alignment", value: entry.IdentityAlignment},
+		{key: "authentication_results", field: "authentication_results", value: entry.AuthenticationResults},
+		{key: "original_mail_from", field: "original_mail_from", value: entry.OriginalMailFrom},
+		{key: "original_rcpt_to", field: "original_rcpt_to", value: strings.Join(entry.OriginalRcptTo, ", ")},
+		{key: "dkim_domain", field: "dkim_domain", value: entry.DKIMDomain},
+		{key: "dkim_identity", field: "dkim_identity", value: entry.DKIMIdentity},
+		{key: "dkim_selector", field: "dkim_selector", value: entry.DKIMSelector},
+		{key: "spf_dns", field: "spf_dns", value: entry.SPFDNS},
+		{key: "original_from", field: "original_from", value: entry.OriginalFrom},
+		{key: "original_to", field: "original_to", value: entry.OriginalTo},
+		{key: "original_subject", field: "original_subject", value: entry.OriginalSubject},
+		{key: "original_date", field: "original_date", value: entry.OriginalDate},
+		{key: "original_message_id", field: "original_message_id", value: entry.OriginalMessageID},
+	}
+	addGELFFields(msg, additional, filter)
+
+	return msg
+}
+
+func toTLSGELF(entry TLSEntry, filter *fields.Filter) map[string]any {
+	msg := map[string]any{
+		"version":       gelfVersion,
+		"host":          entry.PolicyDomain,
+		"short_message": gelfTLSShortMessage(entry),
+		"timestamp":     time.Time(entry.DateEndParsed).Unix(),
+		"level":         gelfLevel(tlsSeverity(entry)),
+	}
+
+	additional := []gelfField{
+		{key: "event_id", field: "event_id", value: entry.EventID},
+		{key: "event_category", field: "event_category", value: entry.EventCategory},
+		{key: "report_type", field: "report_type", value: entry.ReportType},
+		{key: "entry_type", field: "entry_type", value: entry.EntryType},
+		{key: "organization_name", field: "organization_name", value: entry.OrganizationName},
+		{key: "contact_info", field: "contact_info", value: entry.ContactInfo},
+		{key: "report_id", field: "report_id", value: entry.ReportID},
+		{key: "date_begin", field: "date_begin", value: entry.DateBegin},
+		{key: "date_end", field: "date_end", value: entry.DateEnd},
+		{key: "policy_type", field: "policy_type", value: entry.PolicyType},
+		{key: "policy_string", field: "policy_string", value: strings.Join(entry.PolicyString, "; ")},
+		{key: "policy_domain", field: "policy_domain", value: entry.PolicyDomain},
+		{key: "mx_host", field: "mx_host", value: strings.Join(entry.MXHost, ", ")},
+		{key: "total_successful_session_count", field: "total_successful_session_count", value: entry.TotalSuccessfulSessionCount},
+		{key: "total_failure_session_count", field: "total_failure_session_count", value: entry.TotalFailureSessionCount},
+	}
+	if entry.EntryType == TLSEntryFailureDetail {
+		additional = append(additional,
+			gelfField{key: "result_type", field: "result_type", value: entry.ResultType},
+			gelfField{key: "sending_mta_ip", field: "sending_mta_ip", value: entry.SendingMTAIP},
+			gelfField{key: "receiving_mx_hostname", field: "receiving_mx_hostname", value: entry.ReceivingMXHostname},
+			gelfField{key: "receiving_mx_helo", field: "receiving_mx_helo", value: entry.ReceivingMXHelo},
+			gelfField{key: "receiving_ip", field: "receiving_ip", value: entry.ReceivingIP},
+			gelfField{key: "failed_session_count", field: "failed_session_count", value: entry.FailedSessionCount},
+			gelfField{key: "additional_information", field: "additional_information", value: entry.AdditionalInformation},
+			gelfField{key: "failure_reason_code", field: "failure_reason_code", value: entry.FailureReasonCode},
+		)
+	}
+	addGELFFields(msg, additional, filter)
+
+	return msg
+}
+
+// gelfField is an additional field of a GELF message. The field is the path
+// of the entry field used by the field filter.
+type gelfField struct {
+	key   string
+	field string
+	value any
+}
+
+// addGELFFields adds the selected fields and the extra fields of the filter
+// as additional fields to the message
+func addGELFFields(msg map[string]any, additional []gelfField, filter *fields.Filter) {
+	for _, f := range additional {
+		// do not send empty additional fields
+		if s, ok := f.value.(string); ok && s == "" {
+			continue
+		}
+		if !filter.Keep(f.field) {
+			continue
+		}
+		key := f.key
+		if name, ok := filter.Renamed(f.field); ok {
+			key = name
+		}
+		msg["_"+key] = f.value
+	}
+	for _, e := range filter.Extend(nil) {
+		msg["_"+e.Key] = e.Value
+	}
+}
+
+// gelfShortMessage creates a human readable summary of the entry
+func gelfShortMessage(entry SyslogEntry) string {
+	result := "fail"
+	if evaluatedPass(entry) {
+		result = "pass"
+	}
+	source := entry.SourceIP
+	if host := firstNonEmpty(entry.SourceDNS); host != "" {
+		source = fmt.Sprintf("%s (%s)", entry.SourceIP, host)
+	}
+	return fmt.Sprintf("DMARC %s for %s from %s: %d message(s), disposition %s, reported by %s",
+		result, entry.HeaderFrom, source, entry.Count, entry.PolicyEvaluated.Disposition, entry.OrgName)
+}
+
+// gelfFailureShortMessage creates a human readable summary of the failure report
+func gelfFailureShortMessage(entry FailureEntry) string {
+	source := entry.SourceIP
+	if host := firstNonEmpty(entry.SourceDNS); host != "" {
+		source = fmt.Sprintf("%s (%s)", entry.SourceIP, host)
+	}
+	return fmt.Sprintf("DMARC failure report for %s from %s: %s failure, delivery result %s, reported by %s",
+		entry.OriginalFrom, source, strings.Join(entry.AuthFailure, ","), entry.DeliveryResult, entry.ReportingMTA)
+}
+
+// gelfTLSShortMessage creates a human readable summary of the TLS report entry
+func gelfTLSShortMessage(entry TLSEntry) string {
+	if entry.EntryType == TLSEntryFailureDetail {
+		return fmt.Sprintf("TLS failure %s for %s on %s: %d failed session(s), reported by %s",
+			entry.ResultType, entry.PolicyDomain, entry.ReceivingMXHostname, entry.FailedSessionCount, entry.OrganizationName)
+	}
+	return fmt.Sprintf("TLS report for %s (%s): %d successful and %d failed session(s), reported by %s",
+		entry.PolicyDomain, entry.PolicyType, entry.TotalSuccessfulSessionCount, entry.TotalFailureSessionCount, entry.OrganizationName)
+}
+
+// gelfLevel maps the 0-10 severity to a syslog level
+func gelfLevel(sev int) int {
+	switch {
+	case sev >= 8:
+		return 3 // error
+	case sev >= 6:
+		return 4 // warning
+	case sev >= 3:
+		return 5 // notice
+	default:
+		return 6 // informational
+	}
+}
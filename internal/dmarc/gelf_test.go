@@ -0,0 +1,50 @@
+package dmarc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestToGELF(t *testing.T) {
+	t.Parallel()
+
+	entry := SyslogEntry{
+		Domain:        "google.com",
+		DateEnd:       1636502399,
+		DateEndParsed: CustomTime(time.Unix(1636502399, 0)),
+		OrgName:       "google.com",
+		SourceIP:      "192.0.2.1",
+		SourceDNS:     []string{"mail.example.com"},
+		Count:         3,
+		HeaderFrom:    "example.com",
+		SenderName:    "Example MTAs",
+		SenderClass:   "internal",
+	}
+	entry.PolicyEvaluated.Disposition = "quarantine"
+	entry.PolicyEvaluated.Dkim = "fail"
+	entry.PolicyEvaluated.Spf = "fail"
+	entry.ResultDkim = []SyslogResultDKIM{{Result: "fail"}, {Result: "pass"}}
+
+	msg := toGELF(entry, nil)
+
+	expected := map[string]any{
+		"version":       "1.1",
+		"host":          "google.com",
+		"short_message": "DMARC fail for example.com from 192.0.2.1 (mail.example.com): 3 message(s), disposition quarantine, reported by google.com",
+		"timestamp":     int64(1636502399),
+		"level":         4,
+		"_source_ip":    "192.0.2.1",
+		"_dkim_result":  "fail,pass",
+		"_count":        3,
+		"_sender_name":  "Example MTAs",
+		"_sender_class": "internal",
+	}
+	for k, v := range expected {
+		if msg[k] != v {
+			t.Fatalf("field %s mismatch - expected %v got %v", k, v, msg[k])
+		}
+	}
+	if _, ok := msg["_envelope_from"]; ok {
+		t.Fatal("empty fields should not be included")
+	}
+}
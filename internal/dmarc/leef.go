@@ -0,0 +1,169 @@
+package dmarc
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/firefart/dmarcsyslogforwarder/internal/fields"
+)
+
+// https://www.ibm.com/docs/en/dsm?topic=overview-leef-event-components
+var (
+	leefHeaderEscaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\r", " ", "\n", " ")
+	// LEEF has no escaping for the delimiter inside values so we
+	// replace it and all line breaks with a space
+	leefValueEscaper = strings.NewReplacer("\t", " ", "\r", " ", "\n", " ")
+)
+
+type leefFormatter struct {
+	header DeviceHeader
+	fields *fields.Filter
+}
+
+// Format converts the entry into a QRadar LEEF 2.0 line
+func (f leefFormatter) Format(entry SyslogEntry) ([]byte, error) {
+	return formatLEEF(entry, f.header, f.fields), nil
+}
+
+// FormatFailure converts the failure report into a QRadar LEEF 2.0 line
+func (f leefFormatter) FormatFailure(entry FailureEntry) ([]byte, error) {
+	return formatFailureLEEF(entry, f.header, f.fields), nil
+}
+
+// FormatTLS converts the TLS report entry into a QRadar LEEF 2.0 line
+func (f leefFormatter) FormatTLS(entry TLSEntry) ([]byte, error) {
+	return formatTLSLEEF(entry, f.header, f.fields), nil
+}
+
+func formatLEEF(entry SyslogEntry, header DeviceHeader, filter *fields.Filter) []byte {
+	// LEEF severities range from 1 to 10
+	attributes := []keyValue{
+		{key: "cat", field: "event_category", value: entry.EventCategory},
+		{key: "sev", value: strconv.Itoa(max(severity(entry), 1))},
+		{key: "devTime", field: "date_end", value: strconv.FormatInt(entry.DateEnd*1000, 10)},
+		{key: "src", field: "source_ip", value: entry.SourceIP},
+		{key: "srcHostName", field: "source_dns", value: firstNonEmpty(entry.SourceDNS)},
+		{key: "count", field: "count", value: strconv.Itoa(entry.Count)},
+		{key: "disposition", field: "policy_evaluated.disposition", value: entry.PolicyEvaluated.Disposition},
+		{key: "dkimAlignment", field: "policy_evaluated.dkim", value: entry.PolicyEvaluated.Dkim},
+		{key: "spfAlignment", field: "policy_evaluated.spf", value: entry.PolicyEvaluated.Spf},
+		{key: "reason", field: "policy_evaluated.reason", value: overrideReasons(entry)},
+		{key: "reportID", field: "report_id", value: entry.ReportID},
+		{key: "reportingOrg", field: "org_name", value: entry.OrgName},
+		{key: "domain", field: "domain", value: entry.Domain},
+		{key: "headerFrom", field: "header_from", value: entry.HeaderFrom},
+		{key: "envelopeFrom", field: "envelope_from", value: entry.EnvelopeFrom},
+		{key: "envelopeTo", field: "envelope_to", value: entry.EnvelopeTo},
+		{key: "policyDomain", field: "policy_domain", value: entry.PolicyDomain},
+		{key: "policyPublished", field: "policy_published.p", value: entry.PolicyPublished.P},
+		{key: "policyNonExistent", field: "policy_published.np", value: entry.PolicyPublished.Np},
+		{key: "policyTesting", field: "policy_published.testing", value: entry.PolicyPublished.Testing},
+		{key: "discoveryMethod", field: "policy_published.discovery_method", value: entry.PolicyPublished.DiscoveryMethod},
+		{key: "generator", field: "generator", value: entry.Generator},
+		{key: "dkimDomain", field: entry.dkimPath("domain"), value: joinResults(entry.dkimResults(), dkimDomain)},
+		{key: "dkimResult", field: entry.dkimPath("result"), value: joinResults(entry.dkimResults(), dkimResult)},
+		{key: "spfDomain", field: entry.spfPath("domain"), value: joinResults(entry.spfResults(), spfDomain)},
+		{key: "spfResult", field: entry.spfPath("result"), value: joinResults(entry.spfResults(), spfResult)},
+		{key: "dkimAligned", field: "dkim_aligned", value: strconv.FormatBool(entry.DKIMAligned)},
+		{key: "spfAligned", field: "spf_aligned", value: strconv.FormatBool(entry.SPFAligned)},
+		{key: "dmarcPass", field: "dmarc_pass", value: strconv.FormatBool(entry.DMARCPass)},
+		{key: "likelyForwarded", field: "likely_forwarded", value: strconv.FormatBool(entry.LikelyForwarded)},
+		{key: "authResultIndex", field: "auth_result_index", value: formatPosition(entry.AuthResultIndex)},
+		{key: "authResultTotal", field: "auth_result_total", value: formatPosition(entry.AuthResultTotal)},
+	}
+	attributes = append(attributes, enrichmentAttributes(entry)...)
+	attributes = append(attributes,
+		keyValue{key: "validationWarnings", field: "validation_warnings", value: strings.Join(entry.ValidationWarnings, "; ")},
+		keyValue{key: "domainMismatches", field: "domain_mismatches", value: strings.Join(entry.DomainMismatches, "; ")},
+	)
+
+	return writeLEEF(header, signatureID(entry.EventID, defaultSignatureID), attributes, filter)
+}
+
+func formatFailureLEEF(entry FailureEntry, header DeviceHeader, filter *fields.Filter) []byte {
+	attributes := []keyValue{
+		{key: "cat", field: "event_category", value: entry.EventCategory},
+		{key: "sev", value: strconv.Itoa(failureSeverity(entry))},
+		{key: "devTime", field: "arrival_date_parsed", value: strconv.FormatInt(time.Time(entry.ArrivalDateParsed).UnixMilli(), 10)},
+		{key: "src", field: "source_ip", value: entry.SourceIP},
+		{key: "srcHostName", field: "source_dns", value: firstNonEmpty(entry.SourceDNS)},
+		{key: "count", field: "incidents", value: strconv.Itoa(entry.Incidents)},
+		{key: "feedbackType", field: "feedback_type", value: entry.FeedbackType},
+		{key: "authFailure", field: "auth_failure", value: strings.Join(entry.AuthFailure, ",")},
+		{key: "deliveryResult", field: "delivery_result", value: entry.DeliveryResult},
+		{key: "identityAlignment", field: "identity_alignment", value: entry.IdentityAlignment},
+		{key: "reportedDomain", field: "reported_domain", value: strings.Join(entry.ReportedDomain, ",")},
+		{key: "reportingMTA", field: "reporting_mta", value: entry.ReportingMTA},
+		{key: "headerFrom", field: "original_from", value: entry.OriginalFrom},
+		{key: "envelopeFrom", field: "original_mail_from", value: entry.OriginalMailFrom},
+		{key: "envelopeTo", field: "original_rcpt_to", value: strings.Join(entry.OriginalRcptTo, ",")},
+		{key: "subject", field: "original_subject", value: entry.OriginalSubject},
+		{key: "messageID", field: "original_message_id", value: entry.OriginalMessageID},
+		{key: "dkimDomain", field: "dkim_domain", value: entry.DKIMDomain},
+		{key: "dkimSelector", field: "dkim_selector", value: entry.DKIMSelector},
+		{key: "dkimIdentity", field: "dkim_identity", value: entry.DKIMIdentity},
+		{key: "spfDNS", field: "spf_dns", value: entry.SPFDNS},
+	}
+
+	return writeLEEF(header, signatureID(entry.EventID, defaultFailureSignatureID), attributes, filter)
+}
+
+func formatTLSLEEF(entry TLSEntry, header DeviceHeader, filter *fields.Filter) []byte {
+	// the count is only present on failure details
+	var count string
+	if entry.EntryType == TLSEntryFailureDetail {
+		count = strconv.Itoa(entry.FailedSessionCount)
+	}
+	attributes := []keyValue{
+		{key: "cat", field: "event_category", value: entry.EventCategory},
+		{key: "sev", value: strconv.Itoa(tlsSeverity(entry))},
+		{key: "devTime", field: "date_end", value: strconv.FormatInt(entry.DateEnd*1000, 10)},
+		{key: "entryType", field: "entry_type", value: entry.EntryType},
+		{key: "reportID", field: "report_id", value: entry.ReportID},
+		{key: "reportingOrg", field: "organization_name", value: entry.OrganizationName},
+		{key: "policyDomain", field: "policy_domain", value: entry.PolicyDomain},
+		{key: "policyType", field: "policy_type", value: entry.PolicyType},
+		{key: "policyString", field: "policy_string", value: strings.Join(entry.PolicyString, "; ")},
+		{key: "mxHost", field: "mx_host", value: strings.Join(entry.MXHost, ",")},
+		{key: "successfulSessions", field: "total_successful_session_count", value: strconv.Itoa(entry.TotalSuccessfulSessionCount)},
+		{key: "failedSessions", field: "total_failure_session_count", value: strconv.Itoa(entry.TotalFailureSessionCount)},
+		{key: "resultType", field: "result_type", value: entry.ResultType},
+		{key: "src", field: "sending_mta_ip", value: entry.SendingMTAIP},
+		{key: "dst", field: "receiving_ip", value: entry.ReceivingIP},
+		{key: "dstHostName", field: "receiving_mx_hostname", value: entry.ReceivingMXHostname},
+		{key: "receivingMXHelo", field: "receiving_mx_helo", value: entry.ReceivingMXHelo},
+		{key: "count", field: "failed_session_count", value: count},
+		{key: "failureReasonCode", field: "failure_reason_code", value: entry.FailureReasonCode},
+		{key: "additionalInformation", field: "additional_information", value: entry.AdditionalInformation},
+	}
+	return writeLEEF(header, signatureID(entry.EventID, defaultTLSSignatureID), attributes, filter)
+}
+
+// writeLEEF writes the header and all non empty attributes
+func writeLEEF(header DeviceHeader, eventID string, attributes []keyValue, filter *fields.Filter) []byte {
+	var sb strings.Builder
+	sb.WriteString("LEEF:2.0|")
+	for _, h := range []string{header.Vendor, header.Product, header.Version, eventID} {
+		sb.WriteString(leefHeaderEscaper.Replace(h))
+		sb.WriteString("|")
+	}
+	// use a tab as the delimiter between attributes
+	sb.WriteString("x09|")
+
+	first := true
+	for _, a := range filterKeyValues(attributes, filter) {
+		if a.value == "" {
+			continue
+		}
+		if !first {
+			sb.WriteString("\t")
+		}
+		first = false
+		sb.WriteString(a.key)
+		sb.WriteString("=")
+		sb.WriteString(leefValueEscaper.Replace(a.value))
+	}
+
+	return []byte(sb.String())
+}
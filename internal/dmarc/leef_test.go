@@ -0,0 +1,34 @@
+package dmarc
+
+import "testing"
+
+func TestFormatLEEF(t *testing.T) {
+	t.Parallel()
+
+	header := DeviceHeader{
+		Vendor:  "firefart",
+		Product: "dmarcsyslogforwarder",
+		Version: "1.0",
+	}
+
+	entry := SyslogEntry{
+		EventID:    "12345",
+		DateEnd:    1636502399,
+		SourceIP:   "192.0.2.1",
+		Count:      1,
+		HeaderFrom: "example.com",
+		OrgName:    "multi\tline\norg",
+	}
+	entry.PolicyEvaluated.Disposition = "none"
+	entry.PolicyEvaluated.Dkim = "pass"
+	entry.PolicyEvaluated.Spf = "pass"
+
+	expected := "LEEF:2.0|firefart|dmarcsyslogforwarder|1.0|12345|x09|" +
+		"sev=1\tdevTime=1636502399000\tsrc=192.0.2.1\tcount=1\tdisposition=none\tdkimAlignment=pass\tspfAlignment=pass\t" +
+		"reportingOrg=multi line org\theaderFrom=example.com\tdkimAligned=false\tspfAligned=false\tdmarcPass=false\tlikelyForwarded=false"
+
+	got := string(formatLEEF(entry, header, nil))
+	if got != expected {
+		t.Fatalf("LEEF mismatch\nexpected %q\ngot      %q", expected, got)
+	}
+}
@@ -0,0 +1,103 @@
+package dmarc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrLimitExceeded is returned if a report exceeds one of the configured limits
+var ErrLimitExceeded = errors.New("limit exceeded")
+
+// LimitError contains the details of an exceeded limit
+type LimitError struct {
+	// Limit is the name of the exceeded limit
+	Limit string
+	// Max is the configured maximum
+	Max int64
+	// Size is the size of the input if it is known
+	Size int64
+}
+
+func (e *LimitError) Error() string {
+	if e.Size > 0 {
+		return fmt.Sprintf("%s of %d exceeds the limit of %d", e.Limit, e.Size, e.Max)
+	}
+	return fmt.Sprintf("%s exceeds the limit of %d", e.Limit, e.Max)
+}
+
+func (e *LimitError) Unwrap() error {
+	return ErrLimitExceeded
+}
+
+// Limits protects against oversized reports and decompression bombs.
+// A zero value disables the limit.
+type Limits struct {
+	// MaxMessageSize is the maximum size of the raw email in bytes
+	MaxMessageSize int64
+	// MaxAttachmentSize is the maximum size of a single attachment in bytes
+	MaxAttachmentSize int64
+	// MaxDecompressedSize is the maximum size of all decompressed reports of an attachment in bytes
+	MaxDecompressedSize int64
+	// MaxCompressionRatio is the maximum ratio between the decompressed and the compressed size
+	MaxCompressionRatio int64
+	// MaxRecords is the maximum number of records in a single report
+	MaxRecords int
+}
+
+// checkLimit returns a LimitError if the size exceeds the maximum. A maximum
+// of 0 disables the limit.
+func checkLimit(name string, maxSize, size int64) error {
+	if maxSize > 0 && size > maxSize {
+		return &LimitError{Limit: name, Max: maxSize, Size: size}
+	}
+	return nil
+}
+
+// CheckMessageSize enforces the maximum size of the raw email. The size is
+// checked before the message is downloaded.
+func (l Limits) CheckMessageSize(size int64) error {
+	return checkLimit("message size", l.MaxMessageSize, size)
+}
+
+// ReadLimited reads all data from the reader and returns a LimitError
+// if it contains more than limit bytes. A limit of 0 reads everything.
+func ReadLimited(r io.Reader, limit int64, name string) ([]byte, error) {
+	if limit <= 0 {
+		return io.ReadAll(r)
+	}
+	b, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > limit {
+		return nil, &LimitError{Limit: name, Max: limit}
+	}
+	return b, nil
+}
+
+// readDecompressed reads the decompressed data and enforces the
+// decompressed size and the compression ratio
+func (l Limits) readDecompressed(r io.Reader, compressedSize int64) ([]byte, error) {
+	limit, name := l.MaxDecompressedSize, "decompressed size"
+	if l.MaxCompressionRatio > 0 {
+		ratioLimit := max(compressedSize, 1) * l.MaxCompressionRatio
+		if limit <= 0 || ratioLimit < limit {
+			limit, name = ratioLimit, "compression ratio"
+		}
+	}
+	b, err := ReadLimited(r, limit, name)
+	if err != nil {
+		var limitErr *LimitError
+		if errors.As(err, &limitErr) && limitErr.Limit == "compression ratio" {
+			limitErr.Max = l.MaxCompressionRatio
+		}
+		return nil, err
+	}
+	return b, nil
+}
+
+// checkRecords enforces the maximum number of records
+func (l Limits) checkRecords(count int) error {
+	return checkLimit("record count", int64(l.MaxRecords), int64(count))
+}
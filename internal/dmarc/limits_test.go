@@ -0,0 +1,163 @@
+package dmarc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"testing"
+)
+
+func TestReadFileLimits(t *testing.T) {
+	t.Parallel()
+
+	// highly compressible content simulating a decompression bomb
+	bomb := []byte("<feedback>" + strings.Repeat(" ", 1024*1024) + "</feedback>")
+	records := []byte("<feedback>" + strings.Repeat("<record></record>", 3) + "</feedback>")
+
+	tests := []struct {
+		name     string
+		filename string
+		content  []byte
+		limits   Limits
+		limit    string
+	}{
+		{name: "attachment size", filename: "report.xml", content: records, limits: Limits{MaxAttachmentSize: 10}, limit: "attachment size"},
+		{name: "decompressed size gzip", filename: "report.xml.gz", content: createGZ(t, bomb), limits: Limits{MaxDecompressedSize: 1024}, limit: "decompressed size"},
+		{name: "decompressed size zip", filename: "report.zip", content: createZIP(t, archiveFile{name: "report.xml", content: bomb}), limits: Limits{MaxDecompressedSize: 1024}, limit: "decompressed size"},
+		{name: "compression ratio", filename: "report.xml.gz", content: createGZ(t, bomb), limits: Limits{MaxCompressionRatio: 10}, limit: "compression ratio"},
+		{name: "compression ratio zip", filename: "report.zip", content: createZIP(t, archiveFile{name: "report.xml", content: bomb}), limits: Limits{MaxCompressionRatio: 10}, limit: "compression ratio"},
+		{name: "total decompressed size", filename: "report.zip", content: createZIP(t, archiveFile{name: "a.xml", content: records}, archiveFile{name: "b.xml", content: records}), limits: Limits{MaxDecompressedSize: int64(len(records)) + 1}, limit: "decompressed size"},
+		{name: "record count", filename: "report.xml", content: records, limits: Limits{MaxRecords: 2}, limit: "record count"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := readReports(tt.filename, "", tt.content, tt.limits)
+			if !errors.Is(err, ErrLimitExceeded) {
+				t.Fatalf("expected a limit error, got %v", err)
+			}
+			var limitErr *LimitError
+			if !errors.As(err, &limitErr) {
+				t.Fatalf("expected a LimitError, got %T", err)
+			}
+			if limitErr.Limit != tt.limit {
+				t.Errorf("expected limit %q, got %q", tt.limit, limitErr.Limit)
+			}
+		})
+	}
+}
+
+func TestReadFileWithinLimits(t *testing.T) {
+	t.Parallel()
+
+	content := []byte("<feedback><record></record></feedback>")
+	limits := Limits{
+		MaxAttachmentSize:   1024,
+		MaxDecompressedSize: 1024,
+		MaxCompressionRatio: 100,
+		MaxRecords:          1,
+	}
+	files, err := readReports("report.xml.gz", "", createGZ(t, content), limits)
+	if err != nil {
+		t.Fatalf("got unexpected error: %v", err)
+	}
+	if len(files) != 1 || len(files[0].Report.Records) != 1 {
+		t.Fatalf("invalid result %+v", files)
+	}
+}
+
+func TestReadFileNestedWithinLimits(t *testing.T) {
+	t.Parallel()
+
+	// random data does not compress well so the compressed layers are
+	// a large part of the decompressed size
+	random := make([]byte, 4096)
+	_, _ = rand.NewChaCha8([32]byte{}).Read(random)
+	report := []byte(fmt.Sprintf("<feedback><!-- %x --><record></record></feedback>", random))
+	limits := Limits{MaxDecompressedSize: int64(len(report))}
+
+	tests := []struct {
+		name     string
+		filename string
+		content  []byte
+	}{
+		{name: "gzip inside zip", filename: "report.zip", content: createZIP(t, archiveFile{name: "report.xml.gz", content: createGZ(t, report)})},
+		{name: "gzip inside gzip", filename: "report.xml.gz.gz", content: createGZ(t, createGZ(t, report))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			files, err := readReports(tt.filename, "", tt.content, limits)
+			if err != nil {
+				t.Fatalf("got unexpected error: %v", err)
+			}
+			if len(files) != 1 || len(files[0].Report.Records) != 1 {
+				t.Fatalf("invalid result %+v", files)
+			}
+		})
+	}
+
+	// the payload itself is still limited
+	if _, err := readReports("report.zip", "", tests[0].content, Limits{MaxDecompressedSize: limits.MaxDecompressedSize - 1}); !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("expected a limit error, got %v", err)
+	}
+}
+
+func TestReadLimited(t *testing.T) {
+	t.Parallel()
+
+	if _, err := ReadLimited(bytes.NewReader(make([]byte, 11)), 10, "test"); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("expected a limit error, got %v", err)
+	}
+	b, err := ReadLimited(bytes.NewReader(make([]byte, 10)), 10, "test")
+	if err != nil || len(b) != 10 {
+		t.Errorf("expected 10 bytes without error, got %d and %v", len(b), err)
+	}
+	if b, err := ReadLimited(bytes.NewReader(make([]byte, 100)), 0, "test"); err != nil || len(b) != 100 {
+		t.Errorf("expected an unlimited read, got %d and %v", len(b), err)
+	}
+}
+
+func TestCheckMessageSize(t *testing.T) {
+	t.Parallel()
+
+	limits := Limits{MaxMessageSize: 10}
+	if err := limits.CheckMessageSize(10); err != nil {
+		t.Errorf("expected no error within the limit, got %v", err)
+	}
+	var limitErr *LimitError
+	if err := limits.CheckMessageSize(11); !errors.As(err, &limitErr) || limitErr.Limit != "message size" || limitErr.Size != 11 {
+		t.Errorf("expected a message size limit error, got %v", err)
+	}
+	if err := (Limits{}).CheckMessageSize(1 << 40); err != nil {
+		t.Errorf("expected no error without a limit, got %v", err)
+	}
+}
+
+func TestCheckFileLimits(t *testing.T) {
+	t.Parallel()
+
+	content := []byte("\xef\xbb\xbf<feedback>" + strings.Repeat("<record></record>", 3) + "</feedback>")
+	quirks := 0
+	opts := ReadOptions{
+		Limits:  Limits{MaxRecords: 2},
+		OnQuirk: func(*ReportFile, Quirk) { quirks++ },
+	}
+	if err := CheckFile("report.xml", "", content, opts); !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("expected a limit error, got %v", err)
+	}
+	if quirks != 0 {
+		t.Fatalf("expected no quirks to be reported, got %d", quirks)
+	}
+
+	opts.Limits.MaxRecords = 3
+	if err := CheckFile("report.xml", "", content, opts); err != nil {
+		t.Fatalf("got unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,490 @@
+package dmarc
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/firefart/dmarcsyslogforwarder/internal/fields"
+)
+
+// https://schema.ocsf.io/1.1.0/classes/email_activity
+const (
+	ocsfVersion          = "1.1.0"
+	ocsfCategoryUID      = 4 // Network Activity
+	ocsfCategoryName     = "Network Activity"
+	ocsfClassUID         = 4009 // Email Activity
+	ocsfClassName        = "Email Activity"
+	ocsfActivityReceive  = 2
+	ocsfActivityName     = "Receive"
+	ocsfDirectionInbound = 1
+	ocsfStatusSuccess    = 1
+	ocsfStatusFailure    = 2
+)
+
+// ocsfEntry represents a SyslogEntry mapped to the OCSF Email Activity class.
+// Fields without an OCSF equivalent are placed in the unmapped object.
+type ocsfEntry struct {
+	ActivityID   int            `json:"activity_id"`
+	ActivityName string         `json:"activity_name"`
+	CategoryUID  int            `json:"category_uid"`
+	CategoryName string         `json:"category_name"`
+	ClassUID     int            `json:"class_uid"`
+	ClassName    string         `json:"class_name"`
+	TypeUID      int            `json:"type_uid"`
+	TypeName     string         `json:"type_name"`
+	SeverityID   int            `json:"severity_id"`
+	Severity     string         `json:"severity"`
+	StatusID     int            `json:"status_id"`
+	Status       string         `json:"status"`
+	DirectionID  int            `json:"direction_id"`
+	Direction    string         `json:"direction"`
+	Disposition  string         `json:"disposition,omitempty"`
+	Time         int64          `json:"time"`
+	StartTime    int64          `json:"start_time"`
+	EndTime      int64          `json:"end_time"`
+	Count        int            `json:"count"`
+	Message      string         `json:"message"`
+	Metadata     ocsfMetadata   `json:"metadata"`
+	SrcEndpoint  ocsfEndpoint   `json:"src_endpoint"`
+	DstEndpoint  *ocsfEndpoint  `json:"dst_endpoint,omitempty"`
+	Email        *ocsfEmail     `json:"email,omitempty"`
+	EmailAuth    *ocsfEmailAuth `json:"email_auth,omitempty"`
+	Unmapped     any            `json:"unmapped,omitempty"`
+}
+
+type ocsfMetadata struct {
+	Version      string      `json:"version"`
+	Product      ocsfProduct `json:"product"`
+	UID          string      `json:"uid,omitempty"`
+	EventCode    string      `json:"event_code,omitempty"`
+	Labels       []string    `json:"labels,omitempty"`
+	OriginalTime string      `json:"original_time"`
+}
+
+type ocsfProduct struct {
+	VendorName string `json:"vendor_name"`
+	Name       string `json:"name"`
+	Version    string `json:"version"`
+}
+
+type ocsfEndpoint struct {
+	IP               string                `json:"ip,omitempty"`
+	Hostname         string                `json:"hostname,omitempty"`
+	Location         *ocsfLocation         `json:"location,omitempty"`
+	AutonomousSystem *ocsfAutonomousSystem `json:"autonomous_system,omitempty"`
+}
+
+type ocsfLocation struct {
+	Country string `json:"country,omitempty"`
+	City    string `json:"city,omitempty"`
+}
+
+type ocsfAutonomousSystem struct {
+	Number uint   `json:"number,omitempty"`
+	Name   string `json:"name,omitempty"`
+}
+
+type ocsfEmail struct {
+	From       string `json:"from,omitempty"`
+	SMTPFrom   string `json:"smtp_from,omitempty"`
+	SMTPTo     string `json:"smtp_to,omitempty"`
+	Subject    string `json:"subject,omitempty"`
+	MessageUID string `json:"message_uid,omitempty"`
+}
+
+type ocsfEmailAuth struct {
+	DKIM          string `json:"dkim,omitempty"`
+	DKIMDomain    string `json:"dkim_domain,omitempty"`
+	DKIMSignature string `json:"dkim_signature,omitempty"`
+	DMARC         string `json:"dmarc"`
+	DMARCOverride string `json:"dmarc_override,omitempty"`
+	DMARCPolicy   string `json:"dmarc_policy,omitempty"`
+	SPF           string `json:"spf,omitempty"`
+}
+
+// ocsfMappedFields are the fields of an entry mapped to OCSF attributes.
+// All other fields are placed in the unmapped object. header_from,
+// envelope_from and envelope_to are domains while the OCSF email attributes
+// expect addresses, so they are unmapped too.
+var ocsfMappedFields = []string{
+	"event_id", "event_category", "report_type", "report_id", "count",
+	"date_begin", "date_end", "date_begin_parsed", "date_end_parsed",
+	"source_ip", "source_dns_string",
+	"source_country", "source_city", "source_asn", "source_as_org",
+}
+
+// ocsfFailureUnmapped contains the fields of a failure report
+// without an OCSF equivalent
+type ocsfFailureUnmapped struct {
+	ReportType            string   `json:"report_type"`
+	FeedbackType          string   `json:"feedback_type"`
+	UserAgent             string   `json:"user_agent,omitempty"`
+	Version               string   `json:"version,omitempty"`
+	ReportingMTA          string   `json:"reporting_mta,omitempty"`
+	SourceDNS             []string `json:"source_dns,omitempty"`
+	AuthFailure           []string `json:"auth_failure,omitempty"`
+	ReportedDomain        []string `json:"reported_domain,omitempty"`
+	ReportedURI           []string `json:"reported_uri,omitempty"`
+	DeliveryResult        string   `json:"delivery_result,omitempty"`
+	IdentityAlignment     string   `json:"identity_alignment,omitempty"`
+	AuthenticationResults string   `json:"authentication_results,omitempty"`
+	DKIMIdentity          string   `json:"dkim_identity,omitempty"`
+	SPFDNS                string   `json:"spf_dns,omitempty"`
+}
+
+// ocsfTLSUnmapped contains the fields of a TLS report entry
+// without an OCSF equivalent
+type ocsfTLSUnmapped struct {
+	ReportType                  string   `json:"report_type"`
+	EntryType                   string   `json:"entry_type"`
+	OrganizationName            string   `json:"organization_name"`
+	ContactInfo                 string   `json:"contact_info,omitempty"`
+	PolicyType                  string   `json:"policy_type"`
+	PolicyString                []string `json:"policy_string,omitempty"`
+	PolicyDomain                string   `json:"policy_domain"`
+	MXHost                      []string `json:"mx_host,omitempty"`
+	TotalSuccessfulSessionCount int      `json:"total_successful_session_count"`
+	TotalFailureSessionCount    int      `json:"total_failure_session_count"`
+	ResultType                  string   `json:"result_type,omitempty"`
+	ReceivingMXHelo             string   `json:"receiving_mx_helo,omitempty"`
+	AdditionalInformation       string   `json:"additional_information,omitempty"`
+	FailureReasonCode           string   `json:"failure_reason_code,omitempty"`
+}
+
+type ocsfFormatter struct {
+	header DeviceHeader
+	fields *fields.Filter
+}
+
+// Format converts the entry into an OCSF Email Activity JSON document
+func (f ocsfFormatter) Format(entry SyslogEntry) ([]byte, error) {
+	ocsf, err := toOCSF(entry, f.header, f.fields)
+	if err != nil {
+		return nil, err
+	}
+	return marshalSchema(ocsf, f.fields)
+}
+
+// FormatFailure converts the failure report into an OCSF Email Activity JSON document
+func (f ocsfFormatter) FormatFailure(entry FailureEntry) ([]byte, error) {
+	ocsf, err := toFailureOCSF(entry, f.header, f.fields)
+	if err != nil {
+		return nil, err
+	}
+	return marshalSchema(ocsf, f.fields)
+}
+
+// FormatTLS converts the TLS report entry into an OCSF Email Activity JSON document
+func (f ocsfFormatter) FormatTLS(entry TLSEntry) ([]byte, error) {
+	ocsf, err := toTLSOCSF(entry, f.header, f.fields)
+	if err != nil {
+		return nil, err
+	}
+	return marshalSchema(ocsf, f.fields)
+}
+
+func toOCSF(entry SyslogEntry, header DeviceHeader, filter *fields.Filter) (ocsfEntry, error) {
+	// the times, the severity, the status and the message are mandatory, all
+	// other fields are built from the fields selected by the filter
+	selected, err := selectFields(entry, filter)
+	if err != nil {
+		return ocsfEntry{}, err
+	}
+
+	unmapped, err := unmappedFields(entry, ocsfMappedFields, filter)
+	if err != nil {
+		return ocsfEntry{}, err
+	}
+
+	statusID, status, dmarcResult := ocsfStatusFailure, "Failure", "fail"
+	if evaluatedPass(entry) {
+		statusID, status, dmarcResult = ocsfStatusSuccess, "Success", "pass"
+	}
+
+	severityID, severityName := ocsfSeverity(severity(entry))
+
+	var labels []string
+	if selected.EventCategory != "" {
+		labels = []string{selected.EventCategory}
+	}
+
+	signatures := make([]string, 0, len(selected.dkimResults()))
+	for _, r := range selected.dkimResults() {
+		if r.Selector != "" {
+			signatures = append(signatures, fmt.Sprintf("d=%s; s=%s", r.Domain, r.Selector))
+		}
+	}
+
+	return ocsfEntry{
+		ActivityID:   ocsfActivityReceive,
+		ActivityName: ocsfActivityName,
+		CategoryUID:  ocsfCategoryUID,
+		CategoryName: ocsfCategoryName,
+		ClassUID:     ocsfClassUID,
+		ClassName:    ocsfClassName,
+		TypeUID:      ocsfClassUID*100 + ocsfActivityReceive,
+		TypeName:     fmt.Sprintf("%s: %s", ocsfClassName, ocsfActivityName),
+		SeverityID:   severityID,
+		Severity:     severityName,
+		StatusID:     statusID,
+		Status:       status,
+		DirectionID:  ocsfDirectionInbound,
+		Direction:    "Inbound",
+		Disposition:  selected.PolicyEvaluated.Disposition,
+		Time:         time.Time(entry.DateEndParsed).UnixMilli(),
+		StartTime:    time.Time(entry.DateBeginParsed).UnixMilli(),
+		EndTime:      time.Time(entry.DateEndParsed).UnixMilli(),
+		Count:        selected.Count,
+		Message:      fmt.Sprintf("DMARC %s for %s from %s", dmarcResult, entry.HeaderFrom, entry.SourceIP),
+		Metadata: ocsfMetadata{
+			Version: ocsfVersion,
+			Product: ocsfProduct{
+				VendorName: header.Vendor,
+				Name:       header.Product,
+				Version:    header.Version,
+			},
+			UID:          selected.ReportID,
+			EventCode:    selected.EventID,
+			Labels:       labels,
+			OriginalTime: time.Time(entry.DateEndParsed).Format(time.RFC822Z),
+		},
+		SrcEndpoint: ocsfEndpoint{
+			IP:               selected.SourceIP,
+			Hostname:         firstNonEmpty(selected.SourceDNS),
+			Location:         toOCSFLocation(selected),
+			AutonomousSystem: toOCSFAutonomousSystem(selected),
+		},
+		EmailAuth: &ocsfEmailAuth{
+			DKIM:          joinResults(selected.dkimResults(), dkimResult),
+			DKIMDomain:    joinResults(selected.dkimResults(), dkimDomain),
+			DKIMSignature: strings.Join(signatures, ", "),
+			DMARC:         dmarcResult,
+			DMARCOverride: overrideReasons(selected),
+			DMARCPolicy:   selected.PolicyPublished.P,
+			SPF:           joinResults(selected.spfResults(), spfResult),
+		},
+		Unmapped: unmapped,
+	}, nil
+}
+
+func toFailureOCSF(entry FailureEntry, header DeviceHeader, filter *fields.Filter) (ocsfEntry, error) {
+	// the times, the severity, the status and the message are mandatory, all
+	// other fields are built from the fields selected by the filter
+	selected, err := selectFields(entry, filter)
+	if err != nil {
+		return ocsfEntry{}, err
+	}
+
+	severityID, severityName := ocsfSeverity(failureSeverity(entry))
+
+	var labels []string
+	if selected.EventCategory != "" {
+		labels = []string{selected.EventCategory}
+	}
+
+	var signature string
+	if selected.DKIMSelector != "" {
+		signature = fmt.Sprintf("d=%s; s=%s", selected.DKIMDomain, selected.DKIMSelector)
+	}
+
+	arrival := time.Time(entry.ArrivalDateParsed)
+	// the fields without an OCSF equivalent use the names of the entry
+	unmapped, err := unmappedDocument(ocsfFailureUnmapped{
+		ReportType:            entry.ReportType,
+		FeedbackType:          entry.FeedbackType,
+		UserAgent:             entry.UserAgent,
+		Version:               entry.Version,
+		ReportingMTA:          entry.ReportingMTA,
+		SourceDNS:             entry.SourceDNS,
+		AuthFailure:           entry.AuthFailure,
+		ReportedDomain:        entry.ReportedDomain,
+		ReportedURI:           entry.ReportedURI,
+		DeliveryResult:        entry.DeliveryResult,
+		IdentityAlignment:     entry.IdentityAlignment,
+		AuthenticationResults: entry.AuthenticationResults,
+		DKIMIdentity:          entry.DKIMIdentity,
+		SPFDNS:                entry.SPFDNS,
+	}, filter)
+	if err != nil {
+		return ocsfEntry{}, err
+	}
+
+	return ocsfEntry{
+		ActivityID:   ocsfActivityReceive,
+		ActivityName: ocsfActivityName,
+		CategoryUID:  ocsfCategoryUID,
+		CategoryName: ocsfCategoryName,
+		ClassUID:     ocsfClassUID,
+		ClassName:    ocsfClassName,
+		TypeUID:      ocsfClassUID*100 + ocsfActivityReceive,
+		TypeName:     fmt.Sprintf("%s: %s", ocsfClassName, ocsfActivityName),
+		SeverityID:   severityID,
+		Severity:     severityName,
+		StatusID:     ocsfStatusFailure,
+		Status:       "Failure",
+		DirectionID:  ocsfDirectionInbound,
+		Direction:    "Inbound",
+		Disposition:  selected.DeliveryResult,
+		Time:         arrival.UnixMilli(),
+		StartTime:    arrival.UnixMilli(),
+		EndTime:      arrival.UnixMilli(),
+		Count:        selected.Incidents,
+		Message:      fmt.Sprintf("DMARC failure report for %s from %s", entry.OriginalFrom, entry.SourceIP),
+		Metadata: ocsfMetadata{
+			Version: ocsfVersion,
+			Product: ocsfProduct{
+				VendorName: header.Vendor,
+				Name:       header.Product,
+				Version:    header.Version,
+			},
+			EventCode:    selected.EventID,
+			Labels:       labels,
+			OriginalTime: entry.ArrivalDate,
+		},
+		SrcEndpoint: ocsfEndpoint{
+			IP:       selected.SourceIP,
+			Hostname: firstNonEmpty(selected.SourceDNS),
+		},
+		Email: &ocsfEmail{
+			From:       selected.OriginalFrom,
+			SMTPFrom:   selected.OriginalMailFrom,
+			SMTPTo:     strings.Join(selected.OriginalRcptTo, ","),
+			Subject:    selected.OriginalSubject,
+			MessageUID: selected.OriginalMessageID,
+		},
+		EmailAuth: &ocsfEmailAuth{
+			DKIMDomain:    selected.DKIMDomain,
+			DKIMSignature: signature,
+			DMARC:         "fail",
+		},
+		Unmapped: unmapped,
+	}, nil
+}
+
+func toTLSOCSF(entry TLSEntry, header DeviceHeader, filter *fields.Filter) (ocsfEntry, error) {
+	// the times, the severity, the status and the message are mandatory, all
+	// other fields are built from the fields selected by the filter
+	selected, err := selectFields(entry, filter)
+	if err != nil {
+		return ocsfEntry{}, err
+	}
+
+	statusID, status := ocsfStatusSuccess, "Success"
+	if entry.EntryType == TLSEntryFailureDetail || entry.TotalFailureSessionCount > 0 {
+		statusID, status = ocsfStatusFailure, "Failure"
+	}
+
+	severityID, severityName := ocsfSeverity(tlsSeverity(entry))
+
+	var labels []string
+	if selected.EventCategory != "" {
+		labels = []string{selected.EventCategory}
+	}
+
+	count := selected.TotalFailureSessionCount
+	message := fmt.Sprintf("TLS report for %s: %d successful and %d failed sessions",
+		entry.PolicyDomain, entry.TotalSuccessfulSessionCount, entry.TotalFailureSessionCount)
+	var dst *ocsfEndpoint
+	if entry.EntryType == TLSEntryFailureDetail {
+		count = selected.FailedSessionCount
+		message = fmt.Sprintf("TLS failure %s for %s on %s: %d failed sessions",
+			entry.ResultType, entry.PolicyDomain, entry.ReceivingMXHostname, entry.FailedSessionCount)
+		dst = &ocsfEndpoint{
+			IP:       selected.ReceivingIP,
+			Hostname: selected.ReceivingMXHostname,
+		}
+	}
+
+	// the fields without an OCSF equivalent use the names of the entry
+	unmapped, err := unmappedDocument(ocsfTLSUnmapped{
+		ReportType:                  entry.ReportType,
+		EntryType:                   entry.EntryType,
+		OrganizationName:            entry.OrganizationName,
+		ContactInfo:                 entry.ContactInfo,
+		PolicyType:                  entry.PolicyType,
+		PolicyString:                entry.PolicyString,
+		PolicyDomain:                entry.PolicyDomain,
+		MXHost:                      entry.MXHost,
+		TotalSuccessfulSessionCount: entry.TotalSuccessfulSessionCount,
+		TotalFailureSessionCount:    entry.TotalFailureSessionCount,
+		ResultType:                  entry.ResultType,
+		ReceivingMXHelo:             entry.ReceivingMXHelo,
+		AdditionalInformation:       entry.AdditionalInformation,
+		FailureReasonCode:           entry.FailureReasonCode,
+	}, filter)
+	if err != nil {
+		return ocsfEntry{}, err
+	}
+
+	return ocsfEntry{
+		ActivityID:   ocsfActivityReceive,
+		ActivityName: ocsfActivityName,
+		CategoryUID:  ocsfCategoryUID,
+		CategoryName: ocsfCategoryName,
+		ClassUID:     ocsfClassUID,
+		ClassName:    ocsfClassName,
+		TypeUID:      ocsfClassUID*100 + ocsfActivityReceive,
+		TypeName:     fmt.Sprintf("%s: %s", ocsfClassName, ocsfActivityName),
+		SeverityID:   severityID,
+		Severity:     severityName,
+		StatusID:     statusID,
+		Status:       status,
+		DirectionID:  ocsfDirectionInbound,
+		Direction:    "Inbound",
+		Time:         time.Time(entry.DateEndParsed).UnixMilli(),
+		StartTime:    time.Time(entry.DateBeginParsed).UnixMilli(),
+		EndTime:      time.Time(entry.DateEndParsed).UnixMilli(),
+		Count:        count,
+		Message:      message,
+		Metadata: ocsfMetadata{
+			Version: ocsfVersion,
+			Product: ocsfProduct{
+				VendorName: header.Vendor,
+				Name:       header.Product,
+				Version:    header.Version,
+			},
+			UID:          selected.ReportID,
+			EventCode:    selected.EventID,
+			Labels:       labels,
+			OriginalTime: time.Time(entry.DateEndParsed).Format(time.RFC822Z),
+		},
+		SrcEndpoint: ocsfEndpoint{
+			IP: selected.SendingMTAIP,
+		},
+		DstEndpoint: dst,
+		Unmapped:    unmapped,
+	}, nil
+}
+
+// ocsfSeverity maps the 0-10 severity to the OCSF severity enum
+func ocsfSeverity(sev int) (int, string) {
+	switch {
+	case sev <= 1:
+		return 1, "Informational"
+	case sev <= 3:
+		return 2, "Low"
+	case sev <= 6:
+		return 3, "Medium"
+	case sev <= 8:
+		return 4, "High"
+	default:
+		return 5, "Critical"
+	}
+}
+
+// toOCSFLocation returns the GeoIP location of the source, nil if not available
+func toOCSFLocation(entry SyslogEntry) *ocsfLocation {
+	if entry.SourceCountry == "" && entry.SourceCity == "" {
+		return nil
+	}
+	return &ocsfLocation{Country: entry.SourceCountry, City: entry.SourceCity}
+}
+
+// toOCSFAutonomousSystem returns the autonomous system of the source, nil if not available
+func toOCSFAutonomousSystem(entry SyslogEntry) *ocsfAutonomousSystem {
+	if entry.SourceASN == 0 && entry.SourceASOrg == "" {
+		return nil
+	}
+	return &ocsfAutonomousSystem{Number: entry.SourceASN, Name: entry.SourceASOrg}
+}
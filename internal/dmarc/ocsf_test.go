@@ -0,0 +1,83 @@
+package dmarc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/firefart/dmarcsyslogforwarder/internal/fields"
+)
+
+func TestToOCSF(t *testing.T) {
+	t.Parallel()
+
+	entry := SyslogEntry{
+		DateBeginParsed: CustomTime(time.Unix(1636416000, 0)),
+		DateEndParsed:   CustomTime(time.Unix(1636502399, 0)),
+		SourceIP:        "192.0.2.1",
+		HeaderFrom:      "example.com",
+	}
+	entry.PolicyEvaluated.Disposition = "reject"
+	entry.PolicyEvaluated.Dkim = "fail"
+	entry.PolicyEvaluated.Spf = "fail"
+	entry.ResultDkim = []SyslogResultDKIM{
+		{Domain: "example.com", Selector: "s1"},
+		{Domain: "esp.example", Selector: "s2"},
+	}
+
+	ocsf, err := toOCSF(entry, DeviceHeader{Vendor: "firefart", Product: "dmarcsyslogforwarder", Version: "1.0"}, nil)
+	if err != nil {
+		t.Fatalf("could not convert: %v", err)
+	}
+
+	if ocsf.TypeUID != 400902 {
+		t.Fatalf("invalid type_uid %d", ocsf.TypeUID)
+	}
+	if ocsf.StatusID != ocsfStatusFailure || ocsf.EmailAuth.DMARC != "fail" {
+		t.Fatalf("expected failure, got status %d and dmarc %s", ocsf.StatusID, ocsf.EmailAuth.DMARC)
+	}
+	if ocsf.SeverityID != 4 {
+		t.Fatalf("expected severity id 4, got %d", ocsf.SeverityID)
+	}
+	if ocsf.Time != 1636502399000 || ocsf.StartTime != 1636416000000 {
+		t.Fatalf("invalid times %d %d", ocsf.Time, ocsf.StartTime)
+	}
+	if ocsf.EmailAuth.DKIMSignature != "d=example.com; s=s1, d=esp.example; s=s2" {
+		t.Fatalf("invalid dkim signature %s", ocsf.EmailAuth.DKIMSignature)
+	}
+	if ocsf.Email != nil {
+		t.Fatalf("expected no email attributes for domains, got %+v", ocsf.Email)
+	}
+	if ocsf.SrcEndpoint.Location != nil || ocsf.SrcEndpoint.AutonomousSystem != nil {
+		t.Fatalf("expected no geoip fields, got %+v", ocsf.SrcEndpoint)
+	}
+
+	entry.SourceCountry = "US"
+	entry.SourceCity = "Example City"
+	entry.SourceASN = 64496
+	entry.SenderName = "Example MTAs"
+	ocsf, err = toOCSF(entry, DeviceHeader{}, nil)
+	if err != nil {
+		t.Fatalf("could not convert: %v", err)
+	}
+	if l := ocsf.SrcEndpoint.Location; l == nil || l.Country != "US" || l.City != "Example City" {
+		t.Fatalf("invalid src_endpoint.location %+v", l)
+	}
+	if as := ocsf.SrcEndpoint.AutonomousSystem; as == nil || as.Number != 64496 {
+		t.Fatalf("invalid src_endpoint.autonomous_system %+v", as)
+	}
+
+	// all fields of the entry without an OCSF attribute are unmapped
+	unmapped, ok := ocsf.Unmapped.(fields.Document)
+	if !ok {
+		t.Fatalf("invalid unmapped type %T", ocsf.Unmapped)
+	}
+	m := unmapped.Map()
+	if m["sender_name"] != "Example MTAs" {
+		t.Fatalf("expected sender_name in unmapped, got %v", m)
+	}
+	for _, key := range []string{"source_ip", "source_country", "source_asn", "date_begin"} {
+		if _, ok := m[key]; ok {
+			t.Fatalf("mapped field %s should not be unmapped", key)
+		}
+	}
+}
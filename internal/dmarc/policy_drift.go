@@ -0,0 +1,104 @@
+package dmarc
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/firefart/dmarcsyslogforwarder/internal/dmarcrecord"
+	"github.com/firefart/dmarcsyslogforwarder/internal/psl"
+)
+
+// checkPolicyDrift looks up the live DMARC record of the policy domain and
+// compares it with the policy published in the report. A difference means
+// the reporter used a stale cache or the record changed during the report
+// period. If report addresses are set the live record must list at least one
+// of them, otherwise future reports will not reach the mailbox.
+func checkPolicyDrift(entry *SyslogEntry, fetcher *dmarcrecord.Fetcher, reportAddresses []string, list *psl.List) {
+	if fetcher == nil {
+		return
+	}
+	domain := normalizeDomain(entry.PolicyPublished.Domain)
+	if domain == "" {
+		domain = normalizeDomain(entry.HeaderFrom)
+	}
+	if domain == "" {
+		return
+	}
+
+	record, err := fetcher.Lookup(domain)
+	// the policy of the organisational domain applies if the domain itself
+	// does not publish a record (RFC 7489 section 6.6.3)
+	if errors.Is(err, dmarcrecord.ErrNoRecord) {
+		if org := list.OrganizationalDomain(domain); org != "" && org != domain {
+			domain = org
+			record, err = fetcher.Lookup(domain)
+		}
+	}
+
+	live := &SyslogPolicyLive{Domain: domain}
+	entry.PolicyLive = live
+	if errors.Is(err, dmarcrecord.ErrNoRecord) {
+		live.Error = err.Error()
+		entry.PolicyDrift = []string{"record: reported but no longer published"}
+		return
+	}
+	if err != nil {
+		// lookup errors do not indicate a drift
+		live.Error = err.Error()
+		return
+	}
+
+	live.Record = record.Raw
+	live.P = record.P
+	live.Sp = record.SP
+	live.Np = record.NP
+	live.Pct = record.Pct
+	live.Adkim = record.ADKIM
+	live.Aspf = record.ASPF
+	live.Rua = record.RUA
+
+	reported := entry.PolicyPublished
+	p := strings.ToLower(reported.P)
+	drift := []struct {
+		tag       string
+		reported  string
+		published string
+	}{
+		{tag: "p", reported: p, published: record.P},
+		{tag: "sp", reported: withDefault(reported.Sp, p), published: record.SP},
+		{tag: "pct", reported: withDefault(reported.Pct, "100"), published: strconv.Itoa(record.Pct)},
+		{tag: "adkim", reported: withDefault(reported.Adkim, "r"), published: record.ADKIM},
+		{tag: "aspf", reported: withDefault(reported.Aspf, "r"), published: record.ASPF},
+		// np is only part of DMARCbis reports
+		{tag: "np", reported: strings.ToLower(reported.Np), published: record.NP},
+	}
+	for _, d := range drift {
+		// tags missing in the report can not be compared
+		if d.reported == "" || strings.EqualFold(d.reported, d.published) {
+			continue
+		}
+		entry.PolicyDrift = append(entry.PolicyDrift, fmt.Sprintf("%s: reported %s, published %s", d.tag, d.reported, d.published))
+	}
+
+	// reports do not contain the rua tag, so it is compared with the mailbox
+	if len(reportAddresses) > 0 && !slices.ContainsFunc(reportAddresses, record.ListsReportAddress) {
+		published := "none"
+		if len(record.RUA) > 0 {
+			published = strings.Join(record.RUA, ", ")
+		}
+		entry.PolicyDrift = append(entry.PolicyDrift, fmt.Sprintf("rua: report mailbox %s not listed, published %s", strings.Join(reportAddresses, ", "), published))
+	}
+}
+
+// withDefault returns the trimmed and lowercased value or the default if
+// the value is empty
+func withDefault(value, def string) string {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "" {
+		return def
+	}
+	return value
+}
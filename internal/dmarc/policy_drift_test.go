@@ -0,0 +1,138 @@
+package dmarc
+
+import (
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/firefart/dmarcsyslogforwarder/internal/dmarcrecord"
+	"github.com/firefart/dmarcsyslogforwarder/internal/psl"
+)
+
+// txtResolver answers TXT lookups from static records
+type txtResolver map[string][]string
+
+func (r txtResolver) LookupTXT(domain string) ([]string, error) {
+	if domain == "_dmarc.timeout.com" {
+		return nil, &net.DNSError{Err: "timeout", Name: domain, IsTimeout: true}
+	}
+	records, ok := r[domain]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: domain, IsNotFound: true}
+	}
+	return records, nil
+}
+
+func TestCheckPolicyDrift(t *testing.T) {
+	t.Parallel()
+
+	fetcher := dmarcrecord.NewFetcher(txtResolver{
+		"_dmarc.example.com": {"v=DMARC1; p=reject; rua=mailto:dmarc@example.com"},
+		"_dmarc.example.net": {"v=DMARC1; p=quarantine; sp=none; pct=50; adkim=s"},
+	}, time.Hour)
+
+	tests := []struct {
+		name       string
+		published  SyslogPolicyPublished
+		headerFrom string
+		addresses  []string
+		liveDomain string
+		drift      []string
+		liveError  bool
+	}{
+		{
+			name:       "no drift with defaults",
+			published:  SyslogPolicyPublished{Domain: "example.com", P: "Reject"},
+			liveDomain: "example.com",
+		},
+		{
+			name:       "no drift with explicit values",
+			published:  SyslogPolicyPublished{Domain: "example.net", P: "quarantine", Sp: "none", Pct: "50", Adkim: "s", Aspf: "r"},
+			liveDomain: "example.net",
+		},
+		{
+			name:       "stale policy",
+			published:  SyslogPolicyPublished{Domain: "example.net", P: "none", Pct: "100", Aspf: "s", Np: "reject"},
+			liveDomain: "example.net",
+			drift: []string{
+				"p: reported none, published quarantine",
+				"pct: reported 100, published 50",
+				"adkim: reported r, published s",
+				"aspf: reported s, published r",
+				"np: reported reject, published none",
+			},
+		},
+		{
+			name:       "organisational domain",
+			published:  SyslogPolicyPublished{Domain: "mail.example.com", P: "quarantine"},
+			liveDomain: "example.com",
+			drift:      []string{"p: reported quarantine, published reject", "sp: reported quarantine, published reject"},
+		},
+		{
+			name:       "header from",
+			published:  SyslogPolicyPublished{P: "reject"},
+			headerFrom: "example.com",
+			liveDomain: "example.com",
+		},
+		{
+			name:       "report mailbox listed",
+			published:  SyslogPolicyPublished{Domain: "example.com", P: "reject"},
+			addresses:  []string{"reports@example.org", "DMARC@example.com"},
+			liveDomain: "example.com",
+		},
+		{
+			name:       "report mailbox not listed",
+			published:  SyslogPolicyPublished{Domain: "example.com", P: "reject"},
+			addresses:  []string{"reports@example.org"},
+			liveDomain: "example.com",
+			drift:      []string{"rua: report mailbox reports@example.org not listed, published mailto:dmarc@example.com"},
+		},
+		{
+			name:       "no rua",
+			published:  SyslogPolicyPublished{Domain: "example.net", P: "quarantine", Sp: "none", Pct: "50", Adkim: "s"},
+			addresses:  []string{"reports@example.org"},
+			liveDomain: "example.net",
+			drift:      []string{"rua: report mailbox reports@example.org not listed, published none"},
+		},
+		{
+			name:       "removed record",
+			published:  SyslogPolicyPublished{Domain: "example.org", P: "reject"},
+			liveDomain: "example.org",
+			drift:      []string{"record: reported but no longer published"},
+			liveError:  true,
+		},
+		{
+			name:       "lookup error",
+			published:  SyslogPolicyPublished{Domain: "timeout.com", P: "reject"},
+			liveDomain: "timeout.com",
+			liveError:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			entry := SyslogEntry{PolicyPublished: tt.published, HeaderFrom: tt.headerFrom}
+			checkPolicyDrift(&entry, fetcher, tt.addresses, psl.Default())
+			if entry.PolicyLive == nil {
+				t.Fatal("expected the live policy to be set")
+			}
+			if entry.PolicyLive.Domain != tt.liveDomain {
+				t.Errorf("expected live domain %q, got %q", tt.liveDomain, entry.PolicyLive.Domain)
+			}
+			if (entry.PolicyLive.Error != "") != tt.liveError {
+				t.Errorf("unexpected live error %q", entry.PolicyLive.Error)
+			}
+			if !slices.Equal(entry.PolicyDrift, tt.drift) {
+				t.Errorf("expected drift %q, got %q", tt.drift, entry.PolicyDrift)
+			}
+		})
+	}
+
+	// the check is disabled without a fetcher
+	entry := SyslogEntry{PolicyPublished: SyslogPolicyPublished{Domain: "example.com", P: "none"}}
+	checkPolicyDrift(&entry, nil, []string{"reports@example.org"}, psl.Default())
+	if entry.PolicyLive != nil || entry.PolicyDrift != nil {
+		t.Fatalf("expected no live policy without a fetcher, got %+v", entry.PolicyLive)
+	}
+}
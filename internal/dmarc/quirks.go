@@ -0,0 +1,281 @@
+package dmarc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/emersion/go-message/charset"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// Quirk is a known problem of malformed reports that is fixed while reading
+// the report
+type Quirk struct {
+	Name        string
+	Description string
+}
+
+var (
+	QuirkXSSchemaTag     = Quirk{Name: "xs-schema-tag", Description: "unclosed xs:schema tag of report.xsd inside the report"}
+	QuirkBOM             = Quirk{Name: "bom", Description: "UTF-8 byte order mark before the XML content"}
+	QuirkUTF16           = Quirk{Name: "utf-16", Description: "report is encoded as UTF-16"}
+	QuirkInvalidUTF8     = Quirk{Name: "invalid-utf8", Description: "report is declared as UTF-8 but contains Latin-1 (Windows-1252) bytes"}
+	QuirkUnknownEncoding = Quirk{Name: "unknown-encoding", Description: "unknown encoding declaration, the report is read as UTF-8"}
+	QuirkNamespacedRoot  = Quirk{Name: "namespaced-root", Description: "feedback root element with an unknown namespace"}
+	QuirkMissingFeedback = Quirk{Name: "missing-feedback", Description: "report elements without the feedback root element"}
+	QuirkTrailingGarbage = Quirk{Name: "trailing-garbage", Description: "data after the end of the root element"}
+)
+
+// Quirks is the catalogue of all known quirks
+var Quirks = []Quirk{
+	QuirkXSSchemaTag,
+	QuirkBOM,
+	QuirkUTF16,
+	QuirkInvalidUTF8,
+	QuirkUnknownEncoding,
+	QuirkNamespacedRoot,
+	QuirkMissingFeedback,
+	QuirkTrailingGarbage,
+}
+
+// QuirkCatalogue contains the known quirks of the reporting organisations
+// keyed by the reporter domain
+type QuirkCatalogue map[string][]Quirk
+
+// ParseQuirkCatalogue creates the catalogue from the quirk names of every
+// reporter domain
+func ParseQuirkCatalogue(known map[string][]string) (QuirkCatalogue, error) {
+	c := make(QuirkCatalogue, len(known))
+	for reporter, names := range known {
+		domain := normalizeDomain(reporter)
+		if domain == "" {
+			return nil, fmt.Errorf("invalid reporter domain %q", reporter)
+		}
+		for _, name := range names {
+			i := slices.IndexFunc(Quirks, func(q Quirk) bool { return q.Name == name })
+			if i < 0 {
+				return nil, fmt.Errorf("unknown quirk %q for reporter %s", name, reporter)
+			}
+			c[domain] = append(c[domain], Quirks[i])
+		}
+	}
+	return c, nil
+}
+
+// Known checks if the quirk is known for the reporter domain. Subdomains of
+// a reporter match too.
+func (c QuirkCatalogue) Known(reporter string, q Quirk) bool {
+	reporter = normalizeDomain(reporter)
+	for domain, quirks := range c {
+		if (reporter == domain || strings.HasSuffix(reporter, "."+domain)) && slices.Contains(quirks, q) {
+			return true
+		}
+	}
+	return false
+}
+
+// dmarcNamespaces contains the namespaces used by RFC 7489 and DMARCbis reports
+var dmarcNamespaces = []string{
+	"http://dmarc.org/dmarc-xml/0.1",
+	"urn:ietf:params:xml:ns:dmarc-2.0",
+}
+
+// reportElements are the child elements of the feedback element
+var reportElements = []string{"version", "report_metadata", "policy_published", "extensions", "record"}
+
+var (
+	utf8BOM    = []byte{0xef, 0xbb, 0xbf}
+	utf16LEBOM = []byte{0xff, 0xfe}
+	utf16BEBOM = []byte{0xfe, 0xff}
+
+	encodingDeclaration = regexp.MustCompile(`^\s*<\?xml[^>]*encoding\s*=\s*["']([^"']+)["']`)
+)
+
+// applyQuirk records the quirk and notifies the handler once per report.
+// Quirks applied before the report metadata is decoded are reported by
+// reportQuirks as the reporter is not known yet.
+func (f *ReportFile) applyQuirk(q Quirk) {
+	if slices.Contains(f.Quirks, q) {
+		return
+	}
+	f.Quirks = append(f.Quirks, q)
+	if f.opts.OnQuirk != nil && f.reporterKnown {
+		f.opts.OnQuirk(f, q)
+	}
+}
+
+// reportQuirks notifies the handler of all quirks applied so far. It is
+// called once the report metadata is decoded.
+func (f *ReportFile) reportQuirks() {
+	f.reporterKnown = true
+	if f.opts.OnQuirk == nil {
+		return
+	}
+	for _, q := range f.Quirks {
+		f.opts.OnQuirk(f, q)
+	}
+}
+
+// ReporterDomain returns the domain of the reporting organisation
+func (f *ReportFile) ReporterDomain() string {
+	return f.domains.Reporter
+}
+
+// KnownQuirk checks if the quirk is listed in the catalogue for the reporter
+// of the report
+func (f *ReportFile) KnownQuirk(q Quirk) bool {
+	return f.opts.KnownQuirks.Known(f.domains.Reporter, q)
+}
+
+// Reporter returns the organisation of the report. If the report metadata is
+// not decoded yet the receiver part of the filename is used.
+func (f *ReportFile) Reporter() string {
+	if f.Report.ReportMetadata.OrgName != "" {
+		return f.Report.ReportMetadata.OrgName
+	}
+	if fn, err := parseReportFilename(f.Filename); err == nil {
+		return fn.Receiver
+	}
+	return metadataEmailDomain(f.Report.ReportMetadata.Email)
+}
+
+// recoverEncoding removes byte order marks and converts the content to UTF-8
+// if needed. Other encodings are handled by the charset reader of the decoder.
+func (f *ReportFile) recoverEncoding(r io.Reader) io.Reader {
+	br := bufio.NewReaderSize(r, sniffSize)
+	// errors are returned on the next read
+	head, _ := br.Peek(sniffSize)
+
+	switch {
+	case bytes.HasPrefix(head, utf8BOM):
+		f.applyQuirk(QuirkBOM)
+		_, _ = br.Discard(len(utf8BOM))
+	case bytes.HasPrefix(head, utf16LEBOM), bytes.HasPrefix(head, utf16BEBOM):
+		f.applyQuirk(QuirkUTF16)
+		f.transcoded = true
+		return transform.NewReader(br, unicode.UTF16(unicode.BigEndian, unicode.ExpectBOM).NewDecoder())
+	}
+
+	if m := encodingDeclaration.FindSubmatch(bytes.TrimPrefix(head, utf8BOM)); m != nil && !isUTF8(string(m[1])) {
+		// handled by the charset reader
+		return br
+	}
+	return &latin1Reader{r: br, onInvalid: func() { f.applyQuirk(QuirkInvalidUTF8) }}
+}
+
+func isUTF8(label string) bool {
+	return strings.EqualFold(label, "utf-8") || strings.EqualFold(label, "utf8")
+}
+
+// charsetReader converts the declared encoding to UTF-8. Reports with an
+// unknown encoding are read as UTF-8.
+func (f *ReportFile) charsetReader(label string, input io.Reader) (io.Reader, error) {
+	if f.transcoded || isUTF8(label) {
+		return input, nil
+	}
+	r, err := charset.Reader(label, input)
+	if err != nil {
+		f.applyQuirk(QuirkUnknownEncoding)
+		return &latin1Reader{r: input, onInvalid: func() { f.applyQuirk(QuirkInvalidUTF8) }}, nil
+	}
+	return r, nil
+}
+
+// checkRoot checks the first element of the report. If it is a report element
+// instead of the root element it is returned so it can be decoded. Any other
+// element than feedback is not a DMARC report.
+func (f *ReportFile) checkRoot(start xml.StartElement) (*xml.StartElement, error) {
+	if slices.Contains(reportElements, start.Name.Local) {
+		f.applyQuirk(QuirkMissingFeedback)
+		f.rootless = true
+		return &start, nil
+	}
+	if start.Name.Local != "feedback" {
+		return nil, fmt.Errorf("invalid root element %s, expected feedback", start.Name.Local)
+	}
+	if start.Name.Space != "" && !slices.Contains(dmarcNamespaces, start.Name.Space) {
+		f.applyQuirk(QuirkNamespacedRoot)
+	}
+	return nil, nil
+}
+
+// checkTrailing checks for data after the end of the root element. Only the
+// first token is read so large trailing data is not processed.
+func (f *ReportFile) checkTrailing() {
+	for {
+		tok, err := f.dec.Token()
+		if errors.Is(err, io.EOF) {
+			return
+		} else if err != nil {
+			f.applyQuirk(QuirkTrailingGarbage)
+			return
+		}
+		switch t := tok.(type) {
+		case xml.Comment, xml.ProcInst, xml.Directive:
+			continue
+		case xml.CharData:
+			if len(bytes.TrimSpace(t)) == 0 {
+				continue
+			}
+		}
+		f.applyQuirk(QuirkTrailingGarbage)
+		return
+	}
+}
+
+// latin1Reader replaces invalid UTF-8 bytes by their Windows-1252 character
+type latin1Reader struct {
+	r         io.Reader
+	onInvalid func()
+	pending   []byte // incomplete rune at the end of the last read
+	out       []byte
+	chunk     []byte
+	err       error
+}
+
+func (l *latin1Reader) Read(p []byte) (int, error) {
+	if l.chunk == nil {
+		l.chunk = make([]byte, 4096)
+	}
+	for len(l.out) == 0 && l.err == nil {
+		n, err := l.r.Read(l.chunk)
+		l.err = err
+		data := append(l.pending, l.chunk[:n]...)
+		l.pending = nil
+		for i := 0; i < len(data); {
+			if data[i] < utf8.RuneSelf {
+				l.out = append(l.out, data[i])
+				i++
+				continue
+			}
+			if !utf8.FullRune(data[i:]) && err == nil {
+				l.pending = append([]byte(nil), data[i:]...)
+				break
+			}
+			r, size := utf8.DecodeRune(data[i:])
+			if r == utf8.RuneError && size == 1 {
+				l.onInvalid()
+				l.out = utf8.AppendRune(l.out, charmap.Windows1252.DecodeByte(data[i]))
+			} else {
+				l.out = append(l.out, data[i:i+size]...)
+			}
+			i += size
+		}
+	}
+	n := copy(p, l.out)
+	l.out = l.out[n:]
+	if len(l.out) == 0 && n == 0 {
+		return 0, l.err
+	}
+	return n, nil
+}
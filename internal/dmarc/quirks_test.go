@@ -0,0 +1,160 @@
+package dmarc
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// TestSyntheticQuirks reads synthetic samples of every quirk. The samples are
+// written by hand and do not come from real reporters.
+func TestSyntheticQuirks(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		filename string
+		quirks   []string
+		orgName  string
+	}{
+		{filename: "xs-schema-tag.xml", quirks: []string{"xs-schema-tag"}, orgName: "Reporter Example"},
+		{filename: "bom.xml", quirks: []string{"bom"}, orgName: "Reporter Example"},
+		{filename: "utf-16.xml", quirks: []string{"utf-16"}, orgName: "Réporter Exämple"},
+		{filename: "invalid-utf8.xml", quirks: []string{"invalid-utf8"}, orgName: "Société Exemple"},
+		{filename: "iso-8859-1.xml", quirks: nil, orgName: "Société Exemple"},
+		{filename: "unknown-encoding.xml", quirks: []string{"unknown-encoding"}, orgName: "Reporter Example"},
+		{filename: "namespaced-root.xml", quirks: []string{"namespaced-root"}, orgName: "Reporter Example"},
+		{filename: "missing-feedback.xml", quirks: []string{"missing-feedback"}, orgName: "Reporter Example"},
+		{filename: "trailing-garbage.xml", quirks: []string{"trailing-garbage"}, orgName: "Reporter Example"},
+	}
+
+	// all samples are sent by reporter.example
+	catalogue, err := ParseQuirkCatalogue(map[string][]string{
+		"reporter.example": {"bom", "xs-schema-tag"},
+		"other.example":    {"utf-16"},
+	})
+	if err != nil {
+		t.Fatalf("could not parse catalogue: %v", err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filename, func(t *testing.T) {
+			t.Parallel()
+
+			content, err := os.ReadFile(filepath.Join("testdata", "synthetic-quirks", tt.filename))
+			if err != nil {
+				t.Fatalf("could not read test file: %v", err)
+			}
+
+			var logged []string
+			opts := ReadOptions{
+				Validation:  ValidationStrict,
+				KnownQuirks: catalogue,
+				OnQuirk: func(f *ReportFile, q Quirk) {
+					logged = append(logged, q.Name)
+					// the reporter is known even for quirks applied before the metadata
+					if f.ReporterDomain() != "reporter.example" {
+						t.Errorf("expected reporter domain reporter.example, got %q", f.ReporterDomain())
+					}
+					if known := q == QuirkBOM || q == QuirkXSSchemaTag; f.KnownQuirk(q) != known {
+						t.Errorf("expected known=%t for quirk %s", known, q.Name)
+					}
+				},
+			}
+			var records []Record
+			var report *XMLReport
+			err = ReadFile(tt.filename, "", content, opts, func(f *ReportFile) error {
+				report = f.Report
+				for {
+					record, err := f.NextRecord()
+					if errors.Is(err, io.EOF) {
+						return nil
+					} else if err != nil {
+						return err
+					}
+					records = append(records, *record)
+				}
+			})
+			if err != nil {
+				t.Fatalf("could not read report: %v", err)
+			}
+
+			if !slices.Equal(logged, tt.quirks) {
+				t.Errorf("expected quirks %v, got %v", tt.quirks, logged)
+			}
+			if report.ReportMetadata.OrgName != tt.orgName {
+				t.Errorf("expected org name %q, got %q", tt.orgName, report.ReportMetadata.OrgName)
+			}
+			if report.PolicyPublished.P != "reject" {
+				t.Errorf("invalid policy published %+v", report.PolicyPublished)
+			}
+			if len(records) != 1 || records[0].Row.SourceIP != "192.0.2.25" || records[0].Row.Count != 2 {
+				t.Fatalf("invalid records %+v", records)
+			}
+		})
+	}
+}
+
+func TestQuirkCatalogue(t *testing.T) {
+	t.Parallel()
+
+	catalogue, err := ParseQuirkCatalogue(map[string][]string{
+		"Reporter.Example.": {"bom", "utf-16"},
+		"other.example":     {"trailing-garbage"},
+	})
+	if err != nil {
+		t.Fatalf("could not parse catalogue: %v", err)
+	}
+
+	tests := []struct {
+		reporter string
+		quirk    Quirk
+		expected bool
+	}{
+		{reporter: "reporter.example", quirk: QuirkBOM, expected: true},
+		{reporter: "mail.reporter.example", quirk: QuirkUTF16, expected: true},
+		{reporter: "reporter.example", quirk: QuirkTrailingGarbage, expected: false},
+		{reporter: "other.example", quirk: QuirkTrailingGarbage, expected: true},
+		{reporter: "other.example", quirk: QuirkBOM, expected: false},
+		{reporter: "notreporter.example", quirk: QuirkBOM, expected: false},
+		{reporter: "", quirk: QuirkBOM, expected: false},
+	}
+	for _, tt := range tests {
+		if got := catalogue.Known(tt.reporter, tt.quirk); got != tt.expected {
+			t.Errorf("Known(%q, %s): expected %t, got %t", tt.reporter, tt.quirk.Name, tt.expected, got)
+		}
+	}
+
+	if _, err := ParseQuirkCatalogue(map[string][]string{"reporter.example": {"unknown"}}); err == nil {
+		t.Error("expected an error on an unknown quirk")
+	}
+	if _, err := ParseQuirkCatalogue(map[string][]string{"": {"bom"}}); err == nil {
+		t.Error("expected an error on an empty reporter domain")
+	}
+}
+
+func TestLatin1Reader(t *testing.T) {
+	t.Parallel()
+
+	// valid multi byte runes must survive reads split inside the rune
+	content := "caf\xe9 \xe2\x82\xac \x80" + strings.Repeat("ü", 3000)
+	expected := "café € €" + strings.Repeat("ü", 3000)
+
+	for _, r := range []io.Reader{strings.NewReader(content), iotest.OneByteReader(strings.NewReader(content))} {
+		invalid := 0
+		b, err := io.ReadAll(&latin1Reader{r: r, onInvalid: func() { invalid++ }})
+		if err != nil {
+			t.Fatalf("got unexpected error: %v", err)
+		}
+		if string(b) != expected {
+			t.Fatalf("content mismatch - expected %q got %q", expected, b)
+		}
+		if invalid != 2 {
+			t.Fatalf("expected 2 invalid bytes, got %d", invalid)
+		}
+	}
+}
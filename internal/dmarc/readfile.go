@@ -2,19 +2,24 @@ package dmarc
 
 import (
 	"archive/zip"
+	"bufio"
 	"bytes"
+	"compress/bzip2"
 	"compress/gzip"
-	"encoding/xml"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"path/filepath"
 	"strings"
-)
 
-const xsTag = `<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema" targetNamespace="http://dmarc.org/dmarc-xml/0.1">`
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+
+	"github.com/firefart/dmarcsyslogforwarder/internal/helper"
+)
 
-func readGZ(content []byte) ([]byte, error) {
+func readGZ(content []byte, limits Limits) ([]byte, error) {
 	buf := bytes.NewBuffer(content)
 	gz, err := gzip.NewReader(buf)
 	if err != nil {
@@ -22,68 +27,303 @@ func readGZ(content []byte) ([]byte, error) {
 	}
 	defer gz.Close()
 
-	xmlContent, err := io.ReadAll(gz)
+	xmlContent, err := limits.readDecompressed(gz, int64(len(content)))
 	if err != nil {
 		return nil, fmt.Errorf("could not read: %w", err)
 	}
 	return xmlContent, nil
 }
 
-func readZIP(content []byte) ([]byte, string, error) {
-	buf := bytes.NewReader(content)
-	r, err := zip.NewReader(buf, int64(len(content)))
-	if err != nil {
-		return nil, "", fmt.Errorf("could not open zip: %w", err)
-	}
-	for _, f := range r.File {
-		if f.FileInfo().IsDir() {
-			continue
-		}
-		x, err := f.Open()
+// decompressors contains the readers of all supported single file compression formats
+var decompressors = map[helper.FileType]func(io.Reader) (io.ReadCloser, error){
+	helper.FileTypeGzip: func(r io.Reader) (io.ReadCloser, error) {
+		return gzip.NewReader(r)
+	},
+	helper.FileTypeBzip2: func(r io.Reader) (io.ReadCloser, error) {
+		return io.NopCloser(bzip2.NewReader(r)), nil
+	},
+	helper.FileTypeXz: func(r io.Reader) (io.ReadCloser, error) {
+		x, err := xz.NewReader(r)
 		if err != nil {
-			return nil, "", fmt.Errorf("could not open file %s inside zip: %w", f.Name, err)
+			return nil, err
 		}
-		xmlContent, err := io.ReadAll(x)
+		return io.NopCloser(x), nil
+	},
+	helper.FileTypeZstd: func(r io.Reader) (io.ReadCloser, error) {
+		z, err := zstd.NewReader(r)
 		if err != nil {
-			return nil, "", fmt.Errorf("could not read file %s inside zip: %w", f.Name, err)
+			return nil, err
 		}
-		// only use first file in the zip file
-		return xmlContent, f.FileInfo().Name(), nil
+		return z.IOReadCloser(), nil
+	},
+}
+
+// maxNestingDepth limits the number of nested archives
+const maxNestingDepth = 3
+
+// sniffSize is the number of bytes used to detect the file type
+const sniffSize = 512
+
+// detectFileType detects the type of the file by its magic bytes. If
+// the content can not be detected the media type and the file extension
+// are used as a hint.
+func detectFileType(filename, mediaType string, content []byte) helper.FileType {
+	if t := helper.DetectFileType(content); t != helper.FileTypeUnknown {
+		return t
+	}
+
+	switch strings.ToLower(mediaType) {
+	case "application/gzip", "application/x-gzip", "application/gzip-compressed", "application/x-gzip-compressed":
+		return helper.FileTypeGzip
+	case "application/zip", "application/x-zip", "application/x-zip-compressed":
+		return helper.FileTypeZip
+	case "text/xml", "application/xml":
+		return helper.FileTypeXML
+	case "application/json", "application/tlsrpt+json":
+		return helper.FileTypeJSON
+	case "application/tlsrpt+gzip":
+		return helper.FileTypeGzip
+	case "application/x-bzip2", "application/x-bzip":
+		return helper.FileTypeBzip2
+	case "application/x-xz":
+		return helper.FileTypeXz
+	case "application/zstd":
+		return helper.FileTypeZstd
 	}
-	return nil, "", errors.New("no valid file found within zip archive")
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".gz", ".gzip":
+		return helper.FileTypeGzip
+	case ".zip":
+		return helper.FileTypeZip
+	case ".xml":
+		return helper.FileTypeXML
+	case ".json":
+		return helper.FileTypeJSON
+	case ".bz2":
+		return helper.FileTypeBzip2
+	case ".xz":
+		return helper.FileTypeXz
+	case ".zst", ".zstd":
+		return helper.FileTypeZstd
+	}
+
+	return helper.FileTypeUnknown
 }
 
-func ReadFile(filename string, content []byte) (string, *XMLReport, error) {
-	var xmlContent []byte
-	var xmlFilename string
-	var err error
+// trimExtension removes the compression extension from the filename
+func trimExtension(filename string) string {
 	ext := filepath.Ext(filename)
-	switch ext {
-	case ".xml":
-		xmlContent = content
-		xmlFilename = filename
-	case ".gz":
-		xmlContent, err = readGZ(content)
-		if err != nil {
-			return "", nil, err
+	switch strings.ToLower(ext) {
+	case ".gz", ".gzip", ".bz2", ".xz", ".zst", ".zstd":
+		return strings.TrimSuffix(filename, ext)
+	default:
+		return filename
+	}
+}
+
+// extractor walks through all reports of an attachment and keeps track of
+// the total decompressed size. The payload is the file type of the reports,
+// XML for DMARC and JSON for TLS reports.
+type extractor struct {
+	limits  Limits
+	payload helper.FileType
+	total   int64
+}
+
+// supported checks if the file type is either a report or an archive
+func (e *extractor) supported(t helper.FileType) bool {
+	if t == e.payload {
+		return true
+	}
+	_, ok := decompressors[t]
+	return ok || t == helper.FileTypeZip
+}
+
+// limitedReader enforces the decompressed size and the compression ratio of
+// a single layer while the decompressed data is read. The intermediate data of
+// nested archives is not counted towards the total decompressed size.
+type limitedReader struct {
+	r        io.Reader
+	e        *extractor
+	read     int64
+	maxRatio int64 // maximum size allowed by the compression ratio, 0 disables the check
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+	if maxSize := l.e.limits.MaxDecompressedSize; maxSize > 0 && l.read > maxSize {
+		return 0, &LimitError{Limit: "decompressed size", Max: maxSize}
+	}
+	if l.maxRatio > 0 && l.read > l.maxRatio {
+		return 0, &LimitError{Limit: "compression ratio", Max: l.e.limits.MaxCompressionRatio}
+	}
+	return n, err
+}
+
+// payloadReader counts the decompressed reports towards the total
+// decompressed size of the attachment
+type payloadReader struct {
+	r io.Reader
+	e *extractor
+}
+
+func (p *payloadReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.e.total += int64(n)
+	if maxSize := p.e.limits.MaxDecompressedSize; maxSize > 0 && p.e.total > maxSize {
+		return 0, &LimitError{Limit: "decompressed size", Max: maxSize}
+	}
+	return n, err
+}
+
+// limit wraps the decompressed reader. The compression ratio is only checked
+// if the compressed size is known, nested streams are covered by the
+// decompressed size limit.
+func (e *extractor) limit(r io.Reader, compressedSize int64) io.Reader {
+	l := &limitedReader{r: r, e: e}
+	if e.limits.MaxCompressionRatio > 0 && compressedSize >= 0 {
+		l.maxRatio = max(compressedSize, 1) * e.limits.MaxCompressionRatio
+	}
+	return l
+}
+
+// walk calls fn for every report of the content. Archives are extracted
+// recursively so nested archives like a gzip inside a zip are supported.
+// The size is the size of the content or -1 if unknown.
+func (e *extractor) walk(filename, mediaType string, r io.Reader, size int64, depth int, fn func(name string, r io.Reader) error) error {
+	if depth > maxNestingDepth {
+		return fmt.Errorf("%s: too many nested archives", filename)
+	}
+
+	br := bufio.NewReaderSize(r, sniffSize)
+	// errors are returned on the next read
+	head, _ := br.Peek(sniffSize)
+
+	switch t := detectFileType(filename, mediaType, head); t {
+	case e.payload:
+		// only the innermost payload counts towards the decompressed size,
+		// uncompressed attachments are covered by the attachment size
+		if depth > 0 {
+			return fn(filename, &payloadReader{r: br, e: e})
 		}
-		xmlFilename = strings.TrimRight(filename, ".gz")
-	case ".zip":
-		xmlContent, xmlFilename, err = readZIP(content)
+		return fn(filename, br)
+	case helper.FileTypeGzip, helper.FileTypeBzip2, helper.FileTypeXz, helper.FileTypeZstd:
+		d, err := decompressors[t](br)
 		if err != nil {
-			return "", nil, err
+			return fmt.Errorf("could not %s read: %w", t, err)
 		}
+		defer d.Close()
+		return e.walk(trimExtension(filename), "", e.limit(d, size), -1, depth+1, fn)
+	case helper.FileTypeZip:
+		// zip files need random access so nested archives are read into memory
+		ra, ok := r.(io.ReaderAt)
+		if !ok || size < 0 {
+			content, err := io.ReadAll(br)
+			if err != nil {
+				return fmt.Errorf("could not read: %w", err)
+			}
+			ra, size = bytes.NewReader(content), int64(len(content))
+		}
+		return e.walkZIP(ra, size, depth, fn)
 	default:
-		return "", nil, fmt.Errorf("unknown extension %s", ext)
+		return fmt.Errorf("unsupported file type of %s (content-type %q)", filename, mediaType)
 	}
-	// some xmls contain invalid XML by adding an unclosed xs tag
-	xmlContent = bytes.ReplaceAll(xmlContent, []byte(xsTag), []byte(""))
+}
 
-	// parse XML into object
-	var xmlDocument XMLReport
-	if err := xml.Unmarshal(xmlContent, &xmlDocument); err != nil {
-		return "", nil, fmt.Errorf("error on xml unmarshal: %w", err)
+// walkZIP walks through all supported files contained in the zip archive.
+// Files that are neither a report nor a supported archive are skipped.
+func (e *extractor) walkZIP(ra io.ReaderAt, size int64, depth int, fn func(name string, r io.Reader) error) error {
+	r, err := zip.NewReader(ra, size)
+	if err != nil {
+		return fmt.Errorf("could not open zip: %w", err)
 	}
+	found := 0
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		// the declared size can be forged so the content is limited while reading too
+		if err := checkLimit("decompressed size", e.limits.MaxDecompressedSize, int64(min(f.UncompressedSize64, math.MaxInt64))); err != nil {
+			return fmt.Errorf("file %s inside zip: %w", f.Name, err)
+		}
+		ok, err := e.walkZIPFile(f, depth, fn)
+		if err != nil {
+			return err
+		}
+		if ok {
+			found++
+		}
+	}
+	if found == 0 {
+		return fmt.Errorf("no %s file found within zip archive", e.payload)
+	}
+	return nil
+}
+
+// walkZIPFile walks through a single file of a zip archive. It returns false
+// if the file is not supported.
+func (e *extractor) walkZIPFile(f *zip.File, depth int, fn func(name string, r io.Reader) error) (bool, error) {
+	x, err := f.Open()
+	if err != nil {
+		return false, fmt.Errorf("could not open file %s inside zip: %w", f.Name, err)
+	}
+	defer x.Close()
 
-	return xmlFilename, &xmlDocument, nil
+	br := bufio.NewReaderSize(e.limit(x, int64(min(f.CompressedSize64, math.MaxInt64))), sniffSize)
+	head, err := br.Peek(sniffSize)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return false, fmt.Errorf("could not read file %s inside zip: %w", f.Name, err)
+	}
+	if !e.supported(detectFileType(f.Name, "", head)) {
+		return false, nil
+	}
+	if err := e.walk(f.FileInfo().Name(), "", br, -1, depth+1, fn); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ReadFile reads all reports contained in the attachment and calls fn for
+// every report. The records are decoded while fn calls NextRecord so large
+// reports are never kept in memory. The type of the attachment is detected
+// by its content, the media type and the extension of the filename are only
+// used if the content can not be detected. Attachments exceeding the limits
+// return a LimitError, invalid reports return a ValidationError in strict mode.
+// As the limits and the validation are enforced while streaming, records
+// before the error are already passed to fn. Use CheckFile to reject the
+// attachment before the first record is processed.
+func ReadFile(filename, mediaType string, content []byte, opts ReadOptions, fn func(*ReportFile) error) error {
+	limits := opts.Limits
+	if err := checkLimit("attachment size", limits.MaxAttachmentSize, int64(len(content))); err != nil {
+		return err
+	}
+
+	e := extractor{limits: limits, payload: helper.FileTypeXML}
+	return e.walk(filename, mediaType, bytes.NewReader(content), int64(len(content)), 0, func(name string, r io.Reader) error {
+		f, err := newReportFile(name, r, opts)
+		if err != nil {
+			return fmt.Errorf("could not parse %s: %w", name, err)
+		}
+		return fn(f)
+	})
+}
+
+// CheckFile decodes all reports and records of the attachment without
+// processing them. It returns the same errors as ReadFile so an attachment
+// exceeding the limits is rejected before the first record is sent. Quirks
+// are not reported as the attachment is read again by ReadFile. The records
+// are not kept so the attachment is decompressed and parsed twice.
+func CheckFile(filename, mediaType string, content []byte, opts ReadOptions) error {
+	opts.OnQuirk = nil
+	return ReadFile(filename, mediaType, content, opts, func(f *ReportFile) error {
+		for {
+			if _, err := f.NextRecord(); errors.Is(err, io.EOF) {
+				return nil
+			} else if err != nil {
+				return err
+			}
+		}
+	})
 }
@@ -1,7 +1,17 @@
 package dmarc
 
 import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
 	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+
+	"github.com/firefart/dmarcsyslogforwarder/internal/helper"
 )
 
 func TestReadGZ(t *testing.T) {
@@ -35,7 +45,7 @@ func TestReadGZ(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			t.Parallel() // marks each test case as capable of running in parallel with each other
 
-			plainContent, err := readGZ(tt.content)
+			plainContent, err := readGZ(tt.content, Limits{})
 			if !tt.valid && err == nil {
 				t.Fatal("expected an error but got none")
 			} else if tt.valid && err != nil {
@@ -56,18 +66,36 @@ func TestReadZIP(t *testing.T) {
 	t.Parallel()
 
 	tests := []struct {
-		name             string
-		content          []byte
-		valid            bool
-		expectedFilename string
-		expectedPlain    string
+		name          string
+		content       []byte
+		valid         bool
+		expectedFiles []archiveFile
 	}{
 		{
-			name:             "valid zip",
-			content:          []byte{0x50, 0x4b, 0x03, 0x04, 0x0a, 0x00, 0x00, 0x00, 0x00, 0x00, 0x67, 0x9b, 0x30, 0x53, 0xc6, 0x35, 0xb9, 0x3b, 0x05, 0x00, 0x00, 0x00, 0x05, 0x00, 0x00, 0x00, 0x08, 0x00, 0x1c, 0x00, 0x74, 0x65, 0x73, 0x74, 0x2e, 0x74, 0x78, 0x74, 0x55, 0x54, 0x09, 0x00, 0x03, 0x71, 0x7e, 0x43, 0x61, 0xb9, 0x82, 0x43, 0x61, 0x75, 0x78, 0x0b, 0x00, 0x01, 0x04, 0xe8, 0x03, 0x00, 0x00, 0x04, 0xe8, 0x03, 0x00, 0x00, 0x74, 0x65, 0x73, 0x74, 0x0a, 0x50, 0x4b, 0x01, 0x02, 0x1e, 0x03, 0x0a, 0x00, 0x00, 0x00, 0x00, 0x00, 0x67, 0x9b, 0x30, 0x53, 0xc6, 0x35, 0xb9, 0x3b, 0x05, 0x00, 0x00, 0x00, 0x05, 0x00, 0x00, 0x00, 0x08, 0x00, 0x18, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0xa4, 0x81, 0x00, 0x00, 0x00, 0x00, 0x74, 0x65, 0x73, 0x74, 0x2e, 0x74, 0x78, 0x74, 0x55, 0x54, 0x05, 0x00, 0x03, 0x71, 0x7e, 0x43, 0x61, 0x75, 0x78, 0x0b, 0x00, 0x01, 0x04, 0xe8, 0x03, 0x00, 0x00, 0x04, 0xe8, 0x03, 0x00, 0x00, 0x50, 0x4b, 0x05, 0x06, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x01, 0x00, 0x4e, 0x00, 0x00, 0x00, 0x47, 0x00, 0x00, 0x00, 0x00, 0x00},
-			valid:            true,
-			expectedFilename: "test.txt",
-			expectedPlain:    "test\n",
+			name: "valid zip",
+			content: createZIP(t,
+				archiveFile{name: "report.xml", content: []byte("<feedback></feedback>")},
+			),
+			valid:         true,
+			expectedFiles: []archiveFile{{name: "report.xml", content: []byte("<feedback></feedback>")}},
+		},
+		{
+			name: "multiple reports",
+			content: createZIP(t,
+				archiveFile{name: "folder/report1.xml", content: []byte("<feedback>1</feedback>")},
+				archiveFile{name: "readme.txt", content: []byte("test\n")},
+				archiveFile{name: "report2", content: []byte("\n<?xml version=\"1.0\"?><feedback>2</feedback>")},
+			),
+			valid: true,
+			expectedFiles: []archiveFile{
+				{name: "report1.xml", content: []byte("<feedback>1</feedback>")},
+				{name: "report2", content: []byte("\n<?xml version=\"1.0\"?><feedback>2</feedback>")},
+			},
+		},
+		{
+			name:    "zip without xml files",
+			content: []byte{0x50, 0x4b, 0x03, 0x04, 0x0a, 0x00, 0x00, 0x00, 0x00, 0x00, 0x67, 0x9b, 0x30, 0x53, 0xc6, 0x35, 0xb9, 0x3b, 0x05, 0x00, 0x00, 0x00, 0x05, 0x00, 0x00, 0x00, 0x08, 0x00, 0x1c, 0x00, 0x74, 0x65, 0x73, 0x74, 0x2e, 0x74, 0x78, 0x74, 0x55, 0x54, 0x09, 0x00, 0x03, 0x71, 0x7e, 0x43, 0x61, 0xb9, 0x82, 0x43, 0x61, 0x75, 0x78, 0x0b, 0x00, 0x01, 0x04, 0xe8, 0x03, 0x00, 0x00, 0x04, 0xe8, 0x03, 0x00, 0x00, 0x74, 0x65, 0x73, 0x74, 0x0a, 0x50, 0x4b, 0x01, 0x02, 0x1e, 0x03, 0x0a, 0x00, 0x00, 0x00, 0x00, 0x00, 0x67, 0x9b, 0x30, 0x53, 0xc6, 0x35, 0xb9, 0x3b, 0x05, 0x00, 0x00, 0x00, 0x05, 0x00, 0x00, 0x00, 0x08, 0x00, 0x18, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0xa4, 0x81, 0x00, 0x00, 0x00, 0x00, 0x74, 0x65, 0x73, 0x74, 0x2e, 0x74, 0x78, 0x74, 0x55, 0x54, 0x05, 0x00, 0x03, 0x71, 0x7e, 0x43, 0x61, 0x75, 0x78, 0x0b, 0x00, 0x01, 0x04, 0xe8, 0x03, 0x00, 0x00, 0x04, 0xe8, 0x03, 0x00, 0x00, 0x50, 0x4b, 0x05, 0x06, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x01, 0x00, 0x4e, 0x00, 0x00, 0x00, 0x47, 0x00, 0x00, 0x00, 0x00, 0x00},
+			valid:   false,
 		},
 		{
 			name:    "password protected zip",
@@ -80,11 +108,9 @@ func TestReadZIP(t *testing.T) {
 			valid:   false,
 		},
 		{
-			name:             "valid zip with folders and multiple files",
-			content:          []byte{0x50, 0x4b, 0x03, 0x04, 0x14, 0x00, 0x00, 0x00, 0x00, 0x00, 0x23, 0x9f, 0x30, 0x53, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x09, 0x00, 0x00, 0x00, 0x66, 0x6f, 0x6c, 0x64, 0x65, 0x72, 0x20, 0x31, 0x2f, 0x50, 0x4b, 0x03, 0x04, 0x0a, 0x00, 0x00, 0x00, 0x00, 0x00, 0x15, 0x9f, 0x30, 0x53, 0xc6, 0x35, 0xb9, 0x3b, 0x05, 0x00, 0x00, 0x00, 0x05, 0x00, 0x00, 0x00, 0x11, 0x00, 0x00, 0x00, 0x66, 0x6f, 0x6c, 0x64, 0x65, 0x72, 0x20, 0x31, 0x2f, 0x74, 0x65, 0x73, 0x74, 0x2e, 0x74, 0x78, 0x74, 0x74, 0x65, 0x73, 0x74, 0x0a, 0x50, 0x4b, 0x03, 0x04, 0x0a, 0x00, 0x00, 0x00, 0x00, 0x00, 0x31, 0x9f, 0x30, 0x53, 0x80, 0x7a, 0x99, 0xfb, 0x07, 0x00, 0x00, 0x00, 0x07, 0x00, 0x00, 0x00, 0x12, 0x00, 0x00, 0x00, 0x66, 0x6f, 0x6c, 0x64, 0x65, 0x72, 0x20, 0x31, 0x2f, 0x74, 0x65, 0x73, 0x74, 0x32, 0x2e, 0x74, 0x78, 0x74, 0x69, 0x6e, 0x76, 0x61, 0x6c, 0x69, 0x64, 0x50, 0x4b, 0x03, 0x04, 0x14, 0x00, 0x00, 0x00, 0x00, 0x00, 0x25, 0x9f, 0x30, 0x53, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x09, 0x00, 0x00, 0x00, 0x66, 0x6f, 0x6c, 0x64, 0x65, 0x72, 0x20, 0x32, 0x2f, 0x50, 0x4b, 0x03, 0x04, 0x0a, 0x00, 0x00, 0x00, 0x00, 0x00, 0x31, 0x9f, 0x30, 0x53, 0x80, 0x7a, 0x99, 0xfb, 0x07, 0x00, 0x00, 0x00, 0x07, 0x00, 0x00, 0x00, 0x14, 0x00, 0x00, 0x00, 0x66, 0x6f, 0x6c, 0x64, 0x65, 0x72, 0x20, 0x32, 0x2f, 0x69, 0x6e, 0x76, 0x61, 0x6c, 0x69, 0x64, 0x2e, 0x74, 0x78, 0x74, 0x69, 0x6e, 0x76, 0x61, 0x6c, 0x69, 0x64, 0x50, 0x4b, 0x03, 0x04, 0x0a, 0x00, 0x00, 0x00, 0x00, 0x00, 0x31, 0x9f, 0x30, 0x53, 0x80, 0x7a, 0x99, 0xfb, 0x07, 0x00, 0x00, 0x00, 0x07, 0x00, 0x00, 0x00, 0x0b, 0x00, 0x00, 0x00, 0x69, 0x6e, 0x76, 0x61, 0x6c, 0x69, 0x64, 0x2e, 0x74, 0x78, 0x74, 0x69, 0x6e, 0x76, 0x61, 0x6c, 0x69, 0x64, 0x50, 0x4b, 0x01, 0x02, 0x3f, 0x00, 0x14, 0x00, 0x00, 0x00, 0x00, 0x00, 0x23, 0x9f, 0x30, 0x53, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x09, 0x00, 0x24, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x10, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x66, 0x6f, 0x6c, 0x64, 0x65, 0x72, 0x20, 0x31, 0x2f, 0x0a, 0x00, 0x20, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x18, 0x00, 0x02, 0xd2, 0xba, 0x42, 0x24, 0xab, 0xd7, 0x01, 0x02, 0xd2, 0xba, 0x42, 0x24, 0xab, 0xd7, 0x01, 0x02, 0xd2, 0xba, 0x42, 0x24, 0xab, 0xd7, 0x01, 0x50, 0x4b, 0x01, 0x02, 0x3f, 0x00, 0x0a, 0x00, 0x00, 0x00, 0x00, 0x00, 0x15, 0x9f, 0x30, 0x53, 0xc6, 0x35, 0xb9, 0x3b, 0x05, 0x00, 0x00, 0x00, 0x05, 0x00, 0x00, 0x00, 0x11, 0x00, 0x24, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x20, 0x00, 0x00, 0x00, 0x27, 0x00, 0x00, 0x00, 0x66, 0x6f, 0x6c, 0x64, 0x65, 0x72, 0x20, 0x31, 0x2f, 0x74, 0x65, 0x73, 0x74, 0x2e, 0x74, 0x78, 0x74, 0x0a, 0x00, 0x20, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x18, 0x00, 0xee, 0xe6, 0x4c, 0x34, 0x24, 0xab, 0xd7, 0x01, 0x61, 0xcd, 0x8a, 0x50, 0x24, 0xab, 0xd7, 0x01, 0xc3, 0xbf, 0x4c, 0x34, 0x24, 0xab, 0xd7, 0x01, 0x50, 0x4b, 0x01, 0x02, 0x3f, 0x00, 0x0a, 0x00, 0x00, 0x00, 0x00, 0x00, 0x31, 0x9f, 0x30, 0x53, 0x80, 0x7a, 0x99, 0xfb, 0x07, 0x00, 0x00, 0x00, 0x07, 0x00, 0x00, 0x00, 0x12, 0x00, 0x24, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x20, 0x00, 0x00, 0x00, 0x5b, 0x00, 0x00, 0x00, 0x66, 0x6f, 0x6c, 0x64, 0x65, 0x72, 0x20, 0x31, 0x2f, 0x74, 0x65, 0x73, 0x74, 0x32, 0x2e, 0x74, 0x78, 0x74, 0x0a, 0x00, 0x20, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x18, 0x00, 0x0e, 0x1f, 0x45, 0x53, 0x24, 0xab, 0xd7, 0x01, 0xbc, 0xc5, 0xe6, 0xb0, 0x24, 0xab, 0xd7, 0x01, 0x96, 0x27, 0xc2, 0x4d, 0x24, 0xab, 0xd7, 0x01, 0x50, 0x4b, 0x01, 0x02, 0x3f, 0x00, 0x14, 0x00, 0x00, 0x00, 0x00, 0x00, 0x25, 0x9f, 0x30, 0x53, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x09, 0x00, 0x24, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x10, 0x00, 0x00, 0x00, 0x92, 0x00, 0x00, 0x00, 0x66, 0x6f, 0x6c, 0x64, 0x65, 0x72, 0x20, 0x32, 0x2f, 0x0a, 0x00, 0x20, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x18, 0x00, 0x4e, 0x66, 0x07, 0x45, 0x24, 0xab, 0xd7, 0x01, 0x4e, 0x66, 0x07, 0x45, 0x24, 0xab, 0xd7, 0x01, 0x4e, 0x66, 0x07, 0x45, 0x24, 0xab, 0xd7, 0x01, 0x50, 0x4b, 0x01, 0x02, 0x3f, 0x00, 0x0a, 0x00, 0x00, 0x00, 0x00, 0x00, 0x31, 0x9f, 0x30, 0x53, 0x80, 0x7a, 0x99, 0xfb, 0x07, 0x00, 0x00, 0x00, 0x07, 0x00, 0x00, 0x00, 0x14, 0x00, 0x24, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x20, 0x00, 0x00, 0x00, 0xb9, 0x00, 0x00, 0x00, 0x66, 0x6f, 0x6c, 0x64, 0x65, 0x72, 0x20, 0x32, 0x2f, 0x69, 0x6e, 0x76, 0x61, 0x6c, 0x69, 0x64, 0x2e, 0x74, 0x78, 0x74, 0x0a, 0x00, 0x20, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x18, 0x00, 0x0e, 0x1f, 0x45, 0x53, 0x24, 0xab, 0xd7, 0x01, 0x75, 0x8c, 0x78, 0x56, 0x24, 0xab, 0xd7, 0x01, 0x96, 0x27, 0xc2, 0x4d, 0x24, 0xab, 0xd7, 0x01, 0x50, 0x4b, 0x01, 0x02, 0x3f, 0x00, 0x0a, 0x00, 0x00, 0x00, 0x00, 0x00, 0x31, 0x9f, 0x30, 0x53, 0x80, 0x7a, 0x99, 0xfb, 0x07, 0x00, 0x00, 0x00, 0x07, 0x00, 0x00, 0x00, 0x0b, 0x00, 0x24, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x20, 0x00, 0x00, 0x00, 0xf2, 0x00, 0x00, 0x00, 0x69, 0x6e, 0x76, 0x61, 0x6c, 0x69, 0x64, 0x2e, 0x74, 0x78, 0x74, 0x0a, 0x00, 0x20, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x18, 0x00, 0x0e, 0x1f, 0x45, 0x53, 0x24, 0xab, 0xd7, 0x01, 0xc1, 0x89, 0x91, 0xa9, 0x24, 0xab, 0xd7, 0x01, 0x96, 0x27, 0xc2, 0x4d, 0x24, 0xab, 0xd7, 0x01, 0x50, 0x4b, 0x05, 0x06, 0x00, 0x00, 0x00, 0x00, 0x06, 0x00, 0x06, 0x00, 0x40, 0x02, 0x00, 0x00, 0x22, 0x01, 0x00, 0x00, 0x00, 0x00},
-			valid:            true,
-			expectedFilename: "test.txt",
-			expectedPlain:    "test\n",
+			name:    "zip with folders and without xml files",
+			content: []byte{0x50, 0x4b, 0x03, 0x04, 0x14, 0x00, 0x00, 0x00, 0x00, 0x00, 0x23, 0x9f, 0x30, 0x53, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x09, 0x00, 0x00, 0x00, 0x66, 0x6f, 0x6c, 0x64, 0x65, 0x72, 0x20, 0x31, 0x2f, 0x50, 0x4b, 0x03, 0x04, 0x0a, 0x00, 0x00, 0x00, 0x00, 0x00, 0x15, 0x9f, 0x30, 0x53, 0xc6, 0x35, 0xb9, 0x3b, 0x05, 0x00, 0x00, 0x00, 0x05, 0x00, 0x00, 0x00, 0x11, 0x00, 0x00, 0x00, 0x66, 0x6f, 0x6c, 0x64, 0x65, 0x72, 0x20, 0x31, 0x2f, 0x74, 0x65, 0x73, 0x74, 0x2e, 0x74, 0x78, 0x74, 0x74, 0x65, 0x73, 0x74, 0x0a, 0x50, 0x4b, 0x03, 0x04, 0x0a, 0x00, 0x00, 0x00, 0x00, 0x00, 0x31, 0x9f, 0x30, 0x53, 0x80, 0x7a, 0x99, 0xfb, 0x07, 0x00, 0x00, 0x00, 0x07, 0x00, 0x00, 0x00, 0x12, 0x00, 0x00, 0x00, 0x66, 0x6f, 0x6c, 0x64, 0x65, 0x72, 0x20, 0x31, 0x2f, 0x74, 0x65, 0x73, 0x74, 0x32, 0x2e, 0x74, 0x78, 0x74, 0x69, 0x6e, 0x76, 0x61, 0x6c, 0x69, 0x64, 0x50, 0x4b, 0x03, 0x04, 0x14, 0x00, 0x00, 0x00, 0x00, 0x00, 0x25, 0x9f, 0x30, 0x53, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x09, 0x00, 0x00, 0x00, 0x66, 0x6f, 0x6c, 0x64, 0x65, 0x72, 0x20, 0x32, 0x2f, 0x50, 0x4b, 0x03, 0x04, 0x0a, 0x00, 0x00, 0x00, 0x00, 0x00, 0x31, 0x9f, 0x30, 0x53, 0x80, 0x7a, 0x99, 0xfb, 0x07, 0x00, 0x00, 0x00, 0x07, 0x00, 0x00, 0x00, 0x14, 0x00, 0x00, 0x00, 0x66, 0x6f, 0x6c, 0x64, 0x65, 0x72, 0x20, 0x32, 0x2f, 0x69, 0x6e, 0x76, 0x61, 0x6c, 0x69, 0x64, 0x2e, 0x74, 0x78, 0x74, 0x69, 0x6e, 0x76, 0x61, 0x6c, 0x69, 0x64, 0x50, 0x4b, 0x03, 0x04, 0x0a, 0x00, 0x00, 0x00, 0x00, 0x00, 0x31, 0x9f, 0x30, 0x53, 0x80, 0x7a, 0x99, 0xfb, 0x07, 0x00, 0x00, 0x00, 0x07, 0x00, 0x00, 0x00, 0x0b, 0x00, 0x00, 0x00, 0x69, 0x6e, 0x76, 0x61, 0x6c, 0x69, 0x64, 0x2e, 0x74, 0x78, 0x74, 0x69, 0x6e, 0x76, 0x61, 0x6c, 0x69, 0x64, 0x50, 0x4b, 0x01, 0x02, 0x3f, 0x00, 0x14, 0x00, 0x00, 0x00, 0x00, 0x00, 0x23, 0x9f, 0x30, 0x53, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x09, 0x00, 0x24, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x10, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x66, 0x6f, 0x6c, 0x64, 0x65, 0x72, 0x20, 0x31, 0x2f, 0x0a, 0x00, 0x20, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x18, 0x00, 0x02, 0xd2, 0xba, 0x42, 0x24, 0xab, 0xd7, 0x01, 0x02, 0xd2, 0xba, 0x42, 0x24, 0xab, 0xd7, 0x01, 0x02, 0xd2, 0xba, 0x42, 0x24, 0xab, 0xd7, 0x01, 0x50, 0x4b, 0x01, 0x02, 0x3f, 0x00, 0x0a, 0x00, 0x00, 0x00, 0x00, 0x00, 0x15, 0x9f, 0x30, 0x53, 0xc6, 0x35, 0xb9, 0x3b, 0x05, 0x00, 0x00, 0x00, 0x05, 0x00, 0x00, 0x00, 0x11, 0x00, 0x24, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x20, 0x00, 0x00, 0x00, 0x27, 0x00, 0x00, 0x00, 0x66, 0x6f, 0x6c, 0x64, 0x65, 0x72, 0x20, 0x31, 0x2f, 0x74, 0x65, 0x73, 0x74, 0x2e, 0x74, 0x78, 0x74, 0x0a, 0x00, 0x20, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x18, 0x00, 0xee, 0xe6, 0x4c, 0x34, 0x24, 0xab, 0xd7, 0x01, 0x61, 0xcd, 0x8a, 0x50, 0x24, 0xab, 0xd7, 0x01, 0xc3, 0xbf, 0x4c, 0x34, 0x24, 0xab, 0xd7, 0x01, 0x50, 0x4b, 0x01, 0x02, 0x3f, 0x00, 0x0a, 0x00, 0x00, 0x00, 0x00, 0x00, 0x31, 0x9f, 0x30, 0x53, 0x80, 0x7a, 0x99, 0xfb, 0x07, 0x00, 0x00, 0x00, 0x07, 0x00, 0x00, 0x00, 0x12, 0x00, 0x24, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x20, 0x00, 0x00, 0x00, 0x5b, 0x00, 0x00, 0x00, 0x66, 0x6f, 0x6c, 0x64, 0x65, 0x72, 0x20, 0x31, 0x2f, 0x74, 0x65, 0x73, 0x74, 0x32, 0x2e, 0x74, 0x78, 0x74, 0x0a, 0x00, 0x20, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x18, 0x00, 0x0e, 0x1f, 0x45, 0x53, 0x24, 0xab, 0xd7, 0x01, 0xbc, 0xc5, 0xe6, 0xb0, 0x24, 0xab, 0xd7, 0x01, 0x96, 0x27, 0xc2, 0x4d, 0x24, 0xab, 0xd7, 0x01, 0x50, 0x4b, 0x01, 0x02, 0x3f, 0x00, 0x14, 0x00, 0x00, 0x00, 0x00, 0x00, 0x25, 0x9f, 0x30, 0x53, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x09, 0x00, 0x24, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x10, 0x00, 0x00, 0x00, 0x92, 0x00, 0x00, 0x00, 0x66, 0x6f, 0x6c, 0x64, 0x65, 0x72, 0x20, 0x32, 0x2f, 0x0a, 0x00, 0x20, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x18, 0x00, 0x4e, 0x66, 0x07, 0x45, 0x24, 0xab, 0xd7, 0x01, 0x4e, 0x66, 0x07, 0x45, 0x24, 0xab, 0xd7, 0x01, 0x4e, 0x66, 0x07, 0x45, 0x24, 0xab, 0xd7, 0x01, 0x50, 0x4b, 0x01, 0x02, 0x3f, 0x00, 0x0a, 0x00, 0x00, 0x00, 0x00, 0x00, 0x31, 0x9f, 0x30, 0x53, 0x80, 0x7a, 0x99, 0xfb, 0x07, 0x00, 0x00, 0x00, 0x07, 0x00, 0x00, 0x00, 0x14, 0x00, 0x24, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x20, 0x00, 0x00, 0x00, 0xb9, 0x00, 0x00, 0x00, 0x66, 0x6f, 0x6c, 0x64, 0x65, 0x72, 0x20, 0x32, 0x2f, 0x69, 0x6e, 0x76, 0x61, 0x6c, 0x69, 0x64, 0x2e, 0x74, 0x78, 0x74, 0x0a, 0x00, 0x20, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x18, 0x00, 0x0e, 0x1f, 0x45, 0x53, 0x24, 0xab, 0xd7, 0x01, 0x75, 0x8c, 0x78, 0x56, 0x24, 0xab, 0xd7, 0x01, 0x96, 0x27, 0xc2, 0x4d, 0x24, 0xab, 0xd7, 0x01, 0x50, 0x4b, 0x01, 0x02, 0x3f, 0x00, 0x0a, 0x00, 0x00, 0x00, 0x00, 0x00, 0x31, 0x9f, 0x30, 0x53, 0x80, 0x7a, 0x99, 0xfb, 0x07, 0x00, 0x00, 0x00, 0x07, 0x00, 0x00, 0x00, 0x0b, 0x00, 0x24, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x20, 0x00, 0x00, 0x00, 0xf2, 0x00, 0x00, 0x00, 0x69, 0x6e, 0x76, 0x61, 0x6c, 0x69, 0x64, 0x2e, 0x74, 0x78, 0x74, 0x0a, 0x00, 0x20, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x18, 0x00, 0x0e, 0x1f, 0x45, 0x53, 0x24, 0xab, 0xd7, 0x01, 0xc1, 0x89, 0x91, 0xa9, 0x24, 0xab, 0xd7, 0x01, 0x96, 0x27, 0xc2, 0x4d, 0x24, 0xab, 0xd7, 0x01, 0x50, 0x4b, 0x05, 0x06, 0x00, 0x00, 0x00, 0x00, 0x06, 0x00, 0x06, 0x00, 0x40, 0x02, 0x00, 0x00, 0x22, 0x01, 0x00, 0x00, 0x00, 0x00},
+			valid:   false,
 		},
 	}
 
@@ -92,20 +118,250 @@ func TestReadZIP(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			t.Parallel() // marks each test case as capable of running in parallel with each other
 
-			plainContent, filename, err := readZIP(tt.content)
+			files, err := extractFiles("report.zip", tt.content)
 			if !tt.valid && err == nil {
 				t.Fatal("expected an error but got none")
 			} else if tt.valid && err != nil {
 				t.Fatalf("got unexpected error: %v", err)
 			}
 
-			if tt.valid && filename != tt.expectedFilename {
-				t.Fatalf("zip filename mismatch - expected %s got %s", tt.expectedFilename, filename)
+			if len(files) != len(tt.expectedFiles) {
+				t.Fatalf("expected %d files, got %d", len(tt.expectedFiles), len(files))
+			}
+			for i, f := range files {
+				if f.name != tt.expectedFiles[i].name {
+					t.Fatalf("zip filename mismatch - expected %s got %s", tt.expectedFiles[i].name, f.name)
+				}
+				if !bytes.Equal(f.content, tt.expectedFiles[i].content) {
+					t.Fatalf("zip content mismatch - expected %s got %s", tt.expectedFiles[i].content, f.content)
+				}
 			}
+		})
+	}
+}
 
-			if tt.valid && string(plainContent) != tt.expectedPlain {
-				t.Fatalf("zip content mismatch - expected %s got %s", tt.expectedPlain, plainContent)
+func TestReadFileMultipleReports(t *testing.T) {
+	t.Parallel()
+
+	content := createZIP(t,
+		archiveFile{name: "google.com!example.com!1636416000!1636502399.xml", content: []byte("<feedback><report_metadata><report_id>1</report_id></report_metadata></feedback>")},
+		archiveFile{name: "google.com!example.org!1636416000!1636502399.xml", content: []byte("<feedback><report_metadata><report_id>2</report_id></report_metadata></feedback>")},
+	)
+	files, err := readReports("reports.zip", "application/zip", content, Limits{})
+	if err != nil {
+		t.Fatalf("got unexpected error: %v", err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("expected 2 reports, got %d", len(files))
+	}
+	if files[1].Filename != "google.com!example.org!1636416000!1636502399.xml" || files[1].Report.ReportMetadata.ReportID != "2" {
+		t.Fatalf("invalid second report %+v", files[1])
+	}
+
+	invalid := createZIP(t,
+		archiveFile{name: "report1.xml", content: []byte("<feedback></feedback>")},
+		archiveFile{name: "report2.xml", content: []byte("<feedback>")},
+	)
+	if _, err := readReports("reports.zip", "application/zip", invalid, Limits{}); err == nil {
+		t.Fatal("expected an error on an invalid report")
+	}
+}
+
+func TestReadFileContentSniffing(t *testing.T) {
+	t.Parallel()
+
+	report := []byte(`<?xml version="1.0" encoding="UTF-8" ?><feedback><report_metadata><report_id>1</report_id></report_metadata></feedback>`)
+	gz := createGZ(t, report)
+
+	tests := []struct {
+		name             string
+		filename         string
+		mediaType        string
+		content          []byte
+		valid            bool
+		expectedFilename string
+	}{
+		{name: "gzip extension", filename: "report.xml.gzip", content: gz, valid: true, expectedFilename: "report.xml"},
+		{name: "uppercase extension", filename: "REPORT.XML.GZ", content: gz, valid: true, expectedFilename: "REPORT.XML"},
+		{name: "no extension", filename: "report", mediaType: "application/octet-stream", content: gz, valid: true, expectedFilename: "report"},
+		{
+			name:             "zip with wrong extension",
+			filename:         "report.dat",
+			mediaType:        "application/octet-stream",
+			content:          createZIP(t, archiveFile{name: "report.xml", content: report}),
+			valid:            true,
+			expectedFilename: "report.xml",
+		},
+		{
+			name:             "gzip inside zip",
+			filename:         "report.zip",
+			mediaType:        "application/zip",
+			content:          createZIP(t, archiveFile{name: "report.xml.gz", content: gz}),
+			valid:            true,
+			expectedFilename: "report.xml",
+		},
+		{name: "xml without declaration", filename: "report.txt", content: []byte("<feedback></feedback>"), valid: true, expectedFilename: "report.txt"},
+		{name: "xml by media type", filename: "report", mediaType: "text/xml", content: []byte("\n<ns:feedback xmlns:ns=\"urn:x\"></ns:feedback>"), valid: true, expectedFilename: "report"},
+		{name: "invalid content with xml extension", filename: "report.xml", content: []byte("hello"), valid: false},
+		{name: "unknown content", filename: "report.dat", mediaType: "application/octet-stream", content: []byte("hello"), valid: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			files, err := readReports(tt.filename, tt.mediaType, tt.content, Limits{})
+			if !tt.valid {
+				if err == nil {
+					t.Fatal("expected an error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got unexpected error: %v", err)
+			}
+			if len(files) != 1 {
+				t.Fatalf("expected 1 report, got %d", len(files))
+			}
+			if files[0].Filename != tt.expectedFilename {
+				t.Fatalf("filename mismatch - expected %s got %s", tt.expectedFilename, files[0].Filename)
+			}
+		})
+	}
+}
+
+func TestReadFileCompression(t *testing.T) {
+	t.Parallel()
+
+	report := []byte("<feedback><report_metadata><report_id>1</report_id></report_metadata></feedback>")
+
+	var xzBuf bytes.Buffer
+	xw, err := xz.NewWriter(&xzBuf)
+	if err != nil {
+		t.Fatalf("could not create xz writer: %v", err)
+	}
+	if _, err := xw.Write(report); err != nil {
+		t.Fatalf("could not write xz: %v", err)
+	}
+	if err := xw.Close(); err != nil {
+		t.Fatalf("could not close xz: %v", err)
+	}
+
+	zw, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatalf("could not create zstd writer: %v", err)
+	}
+	zstdContent := zw.EncodeAll(report, nil)
+	if err := zw.Close(); err != nil {
+		t.Fatalf("could not close zstd: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		filename string
+		content  []byte
+	}{
+		{
+			name:     "bzip2",
+			filename: "report.xml.bz2",
+			content:  []byte{0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0x81, 0x7e, 0x96, 0xf2, 0x00, 0x00, 0x03, 0x9b, 0x80, 0x00, 0x00, 0xa0, 0x05, 0x00, 0x00, 0xbf, 0x2a, 0xd4, 0x00, 0x20, 0x00, 0x50, 0xa6, 0x99, 0x18, 0x98, 0x98, 0x81, 0x55, 0x34, 0x11, 0xa1, 0x93, 0x4d, 0x27, 0x2a, 0x29, 0x05, 0xf8, 0x8c, 0x4e, 0x36, 0x97, 0x48, 0x54, 0xab, 0x28, 0x60, 0xcb, 0x66, 0x99, 0xcf, 0xad, 0x21, 0xb2, 0xe5, 0x6c, 0x8d, 0x97, 0x3b, 0x59, 0xf8, 0xbb, 0x92, 0x29, 0xc2, 0x84, 0x84, 0x0b, 0xf4, 0xb7, 0x90},
+		},
+		{name: "xz", filename: "report.xml.xz", content: xzBuf.Bytes()},
+		{name: "zstd", filename: "report.xml.zst", content: zstdContent},
+		{name: "zstd inside zip", filename: "report.zip", content: createZIP(t, archiveFile{name: "report.xml.zst", content: zstdContent})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			files, err := readReports(tt.filename, "application/octet-stream", tt.content, Limits{})
+			if err != nil {
+				t.Fatalf("got unexpected error: %v", err)
+			}
+			if len(files) != 1 {
+				t.Fatalf("expected 1 report, got %d", len(files))
+			}
+			if files[0].Filename != "report.xml" {
+				t.Fatalf("filename mismatch - expected report.xml got %s", files[0].Filename)
+			}
+			if files[0].Report.ReportMetadata.ReportID != "1" {
+				t.Fatalf("invalid report %+v", files[0].Report)
 			}
 		})
 	}
 }
+
+// archiveFile is a single file extracted from an archive
+type archiveFile struct {
+	name    string
+	content []byte
+}
+
+// extractFiles returns all XML files contained in the content
+func extractFiles(filename string, content []byte) ([]archiveFile, error) {
+	e := extractor{payload: helper.FileTypeXML}
+	var files []archiveFile
+	err := e.walk(filename, "", bytes.NewReader(content), int64(len(content)), 0, func(name string, r io.Reader) error {
+		b, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		files = append(files, archiveFile{name: name, content: b})
+		return nil
+	})
+	return files, err
+}
+
+// readReports reads all reports of the attachment including their records
+func readReports(filename, mediaType string, content []byte, limits Limits) ([]ReportFile, error) {
+	var reports []ReportFile
+	err := ReadFile(filename, mediaType, content, ReadOptions{Limits: limits}, func(f *ReportFile) error {
+		for {
+			record, err := f.NextRecord()
+			if errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				return err
+			}
+			f.Report.Records = append(f.Report.Records, *record)
+		}
+		reports = append(reports, *f)
+		return nil
+	})
+	return reports, err
+}
+
+func createGZ(t *testing.T, content []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(content); err != nil {
+		t.Fatalf("could not write gzip: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("could not close gzip: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func createZIP(t *testing.T, files ...archiveFile) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, f := range files {
+		fw, err := w.Create(f.name)
+		if err != nil {
+			t.Fatalf("could not create zip file: %v", err)
+		}
+		if _, err := fw.Write(f.content); err != nil {
+			t.Fatalf("could not write zip file: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("could not close zip: %v", err)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,250 @@
+package dmarc
+
+import (
+	"strconv"
+	"strings"
+)
+
+// DeviceHeader holds the vendor information used in the
+// header of the CEF and LEEF formats
+type DeviceHeader struct {
+	Vendor  string
+	Product string
+	Version string
+}
+
+const (
+	defaultSignatureID        = "dmarc-aggregate"
+	defaultEventName          = "DMARC aggregate report record"
+	defaultFailureSignatureID = "dmarc-failure"
+	defaultFailureEventName   = "DMARC failure report"
+	defaultTLSSignatureID     = "tlsrpt"
+	defaultTLSPolicyName      = "TLS report policy summary"
+	defaultTLSFailureName     = "TLS report failure detail"
+)
+
+// severity calculates a SIEM severity in the range of 0-10 based
+// on the evaluated disposition and the DKIM and SPF alignment results
+func severity(entry SyslogEntry) int {
+	dkimPass := strings.EqualFold(entry.PolicyEvaluated.Dkim, "pass")
+	spfPass := strings.EqualFold(entry.PolicyEvaluated.Spf, "pass")
+	dmarcPass := evaluatedPass(entry)
+
+	switch strings.ToLower(entry.PolicyEvaluated.Disposition) {
+	case "reject":
+		if dmarcPass {
+			// rejected because of a local policy
+			return 6
+		}
+		return 8
+	case "quarantine":
+		if dmarcPass {
+			return 5
+		}
+		return 7
+	default:
+		switch {
+		case dkimPass && spfPass:
+			return 1
+		case dmarcPass:
+			return 3
+		default:
+			// failed DMARC but the policy did not tell the receiver to act
+			return 6
+		}
+	}
+}
+
+// evaluatedPass returns true if the record passed the DMARC evaluation
+// of the receiver. DMARC passes if at least one of the mechanisms passes
+// and is aligned.
+func evaluatedPass(entry SyslogEntry) bool {
+	return strings.EqualFold(entry.PolicyEvaluated.Dkim, "pass") || strings.EqualFold(entry.PolicyEvaluated.Spf, "pass")
+}
+
+// dmarcResult returns the computed DMARC result as text
+func dmarcResult(pass bool) string {
+	if pass {
+		return "pass"
+	}
+	return "fail"
+}
+
+// boolToInt converts computed flags for formats without a boolean type
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// failureSeverity calculates the SIEM severity of a failure report. As
+// every failure report is a DMARC failure it is based on the delivery result.
+func failureSeverity(entry FailureEntry) int {
+	switch strings.ToLower(entry.DeliveryResult) {
+	case "reject":
+		return 8
+	case "spam", "policy":
+		return 7
+	default:
+		return 6
+	}
+}
+
+// tlsSeverity calculates the SIEM severity of a TLS report entry
+func tlsSeverity(entry TLSEntry) int {
+	switch {
+	case entry.EntryType == TLSEntryFailureDetail:
+		return 6
+	case entry.TotalFailureSessionCount == 0:
+		return 1
+	case entry.TotalSuccessfulSessionCount == 0:
+		// no session could be established at all
+		return 8
+	default:
+		return 4
+	}
+}
+
+// tlsEventName returns the CEF event name of the TLS report entry
+func tlsEventName(entry TLSEntry) string {
+	if entry.EntryType == TLSEntryFailureDetail {
+		return defaultTLSFailureName
+	}
+	return defaultTLSPolicyName
+}
+
+// signatureID returns the event id or a sane default if none is configured
+func signatureID(eventID, fallback string) string {
+	if eventID != "" {
+		return eventID
+	}
+	return fallback
+}
+
+// firstNonEmpty returns the first non empty string of the slice
+func firstNonEmpty(s []string) string {
+	for _, x := range s {
+		if x != "" {
+			return x
+		}
+	}
+	return ""
+}
+
+// verifiedNames returns the forward-confirmed source_dns names
+func verifiedNames(entry SyslogEntry) []string {
+	var ret []string
+	for _, n := range entry.SourceDNSVerified {
+		if n.Verified {
+			ret = append(ret, n.Name)
+		}
+	}
+	return ret
+}
+
+// hostNameVerified returns if the first source_dns name is forward-confirmed,
+// empty if there is no name
+func hostNameVerified(entry SyslogEntry) string {
+	for _, n := range entry.SourceDNSVerified {
+		if n.Name != "" {
+			return strconv.FormatBool(n.Verified)
+		}
+	}
+	return ""
+}
+
+// formatPosition returns the position of a flattened entry as text, empty
+// if the auth results are not flattened
+func formatPosition(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return strconv.Itoa(n)
+}
+
+// formatASN returns the AS number as text, unknown AS numbers are empty
+func formatASN(asn uint) string {
+	if asn == 0 {
+		return ""
+	}
+	return strconv.FormatUint(uint64(asn), 10)
+}
+
+// overrideReasons joins all policy override reasons to a single string
+func overrideReasons(entry SyslogEntry) string {
+	reasons := make([]string, 0, len(entry.PolicyEvaluated.Reason))
+	for _, r := range entry.PolicyEvaluated.Reason {
+		reasons = append(reasons, r.Type)
+	}
+	return strings.Join(reasons, ",")
+}
+
+// joinResults joins a value of all auth results with a comma. The
+// positions of the values match the order of the results.
+func joinResults[T any](results []T, value func(T) string) string {
+	values := make([]string, len(results))
+	empty := true
+	for i, r := range results {
+		values[i] = value(r)
+		if values[i] != "" {
+			empty = false
+		}
+	}
+	if empty {
+		return ""
+	}
+	return strings.Join(values, ",")
+}
+
+func dkimDomain(r SyslogResultDKIM) string      { return r.Domain }
+func dkimSelector(r SyslogResultDKIM) string    { return r.Selector }
+func dkimResult(r SyslogResultDKIM) string      { return r.Result }
+func dkimHumanResult(r SyslogResultDKIM) string { return r.HumanResult }
+func spfDomain(r SyslogResultSPF) string        { return r.Domain }
+func spfScope(r SyslogResultSPF) string         { return r.Scope }
+func spfResult(r SyslogResultSPF) string        { return r.Result }
+func spfHumanResult(r SyslogResultSPF) string   { return r.HumanResult }
+
+// spfAnalysis returns a value of the SPF analysis, empty if the result was
+// not analyzed
+func spfAnalysis(value func(SyslogSPFAnalysis) string) func(SyslogResultSPF) string {
+	return func(r SyslogResultSPF) string {
+		if r.Analysis == nil {
+			return ""
+		}
+		return value(*r.Analysis)
+	}
+}
+
+var (
+	spfAnalysisResult    = spfAnalysis(func(a SyslogSPFAnalysis) string { return a.Result })
+	spfAnalysisMechanism = spfAnalysis(func(a SyslogSPFAnalysis) string { return a.Mechanism })
+	spfAnalysisLookups   = spfAnalysis(func(a SyslogSPFAnalysis) string { return strconv.Itoa(a.Lookups) })
+	spfAnalysisOverLimit = spfAnalysis(func(a SyslogSPFAnalysis) string { return strconv.FormatBool(a.OverLimit) })
+)
+
+// enrichmentAttributes returns the fields computed by the enrichments
+// (reverse DNS, GeoIP, sender inventory, SPF analysis, forwarding detection
+// and policy drift) for the flat CEF and LEEF formats. CEF has no dictionary
+// keys for them so both formats use the same custom keys. New enrichments
+// are added here.
+func enrichmentAttributes(entry SyslogEntry) []keyValue {
+	return []keyValue{
+		{key: "srcHostNameVerified", field: "source_dns_verified", value: hostNameVerified(entry)},
+		{key: "srcOrgDomains", field: "source_dns_org_domains", value: strings.Join(entry.SourceDNSOrgDomains, ",")},
+		{key: "srcCountry", field: "source_country", value: entry.SourceCountry},
+		{key: "srcCity", field: "source_city", value: entry.SourceCity},
+		{key: "srcASN", field: "source_asn", value: formatASN(entry.SourceASN)},
+		{key: "srcASOrg", field: "source_as_org", value: entry.SourceASOrg},
+		{key: "headerFromOrgDomain", field: "header_from_org_domain", value: entry.HeaderFromOrgDomain},
+		{key: "spfAnalysisResult", field: entry.spfPath("analysis.result"), value: joinResults(entry.spfResults(), spfAnalysisResult)},
+		{key: "spfAnalysisMechanism", field: entry.spfPath("analysis.mechanism"), value: joinResults(entry.spfResults(), spfAnalysisMechanism)},
+		{key: "spfLookups", field: entry.spfPath("analysis.lookups"), value: joinResults(entry.spfResults(), spfAnalysisLookups)},
+		{key: "spfOverLimit", field: entry.spfPath("analysis.over_limit"), value: joinResults(entry.spfResults(), spfAnalysisOverLimit)},
+		{key: "senderName", field: "sender_name", value: entry.SenderName},
+		{key: "senderClass", field: "sender_class", value: entry.SenderClass},
+		{key: "forwardingExplanation", field: "forwarding_explanation", value: entry.ForwardingExplanation},
+		{key: "policyDrift", field: "policy_drift", value: strings.Join(entry.PolicyDrift, "; ")},
+	}
+}
@@ -0,0 +1,83 @@
+package dmarc
+
+import "testing"
+
+func TestSeverity(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		disposition string
+		dkim        string
+		spf         string
+		expected    int
+	}{
+		{name: "pass", disposition: "none", dkim: "pass", spf: "pass", expected: 1},
+		{name: "dkim only", disposition: "none", dkim: "pass", spf: "fail", expected: 3},
+		{name: "fail without action", disposition: "none", dkim: "fail", spf: "fail", expected: 6},
+		{name: "quarantine", disposition: "quarantine", dkim: "fail", spf: "fail", expected: 7},
+		{name: "reject", disposition: "reject", dkim: "fail", spf: "fail", expected: 8},
+		{name: "reject with local policy", disposition: "reject", dkim: "pass", spf: "fail", expected: 6},
+		{name: "uppercase", disposition: "REJECT", dkim: "FAIL", spf: "FAIL", expected: 8},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			entry := SyslogEntry{}
+			entry.PolicyEvaluated.Disposition = tt.disposition
+			entry.PolicyEvaluated.Dkim = tt.dkim
+			entry.PolicyEvaluated.Spf = tt.spf
+			if got := severity(entry); got != tt.expected {
+				t.Fatalf("severity mismatch - expected %d got %d", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestVerifiedNames(t *testing.T) {
+	t.Parallel()
+
+	entry := SyslogEntry{
+		SourceDNSVerified: []SyslogDNSName{
+			{Name: "spoofed.example.net"},
+			{Name: "mail.example.com", Verified: true},
+		},
+	}
+	if got := verifiedNames(entry); len(got) != 1 || got[0] != "mail.example.com" {
+		t.Fatalf("invalid verified names %v", got)
+	}
+	if got := hostNameVerified(entry); got != "false" {
+		t.Fatalf("expected the first name to be unverified, got %q", got)
+	}
+	if got := hostNameVerified(SyslogEntry{}); got != "" {
+		t.Fatalf("expected an empty value without names, got %q", got)
+	}
+}
+
+func TestSPFAnalysisFields(t *testing.T) {
+	t.Parallel()
+
+	results := []SyslogResultSPF{
+		{Domain: "example.com", Result: "pass"},
+		{Domain: "bounce.example.com", Result: "fail", Analysis: &SyslogSPFAnalysis{Result: "fail", Mechanism: "-all", Lookups: 12, OverLimit: true}},
+	}
+	tests := []struct {
+		value    func(SyslogResultSPF) string
+		expected string
+	}{
+		{value: spfAnalysisResult, expected: ",fail"},
+		{value: spfAnalysisMechanism, expected: ",-all"},
+		{value: spfAnalysisLookups, expected: ",12"},
+		{value: spfAnalysisOverLimit, expected: ",true"},
+	}
+	for _, tt := range tests {
+		if got := joinResults(results, tt.value); got != tt.expected {
+			t.Errorf("expected %q, got %q", tt.expected, got)
+		}
+	}
+	if got := joinResults(results[:1], spfAnalysisResult); got != "" {
+		t.Fatalf("expected an empty value without analysis, got %q", got)
+	}
+}
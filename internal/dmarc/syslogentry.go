@@ -4,13 +4,67 @@ import (
 	"encoding/json"
 	"encoding/xml"
 	"fmt"
-	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/firefart/dmarcsyslogforwarder/internal/dmarcrecord"
 	"github.com/firefart/dmarcsyslogforwarder/internal/dns"
+	"github.com/firefart/dmarcsyslogforwarder/internal/geoip"
+	"github.com/firefart/dmarcsyslogforwarder/internal/psl"
+	"github.com/firefart/dmarcsyslogforwarder/internal/senders"
+	"github.com/firefart/dmarcsyslogforwarder/internal/spf"
 )
 
+// MarshalJSON omits result_dkim and result_spf of flattened entries as they
+// contain the single auth result in auth_result_dkim or auth_result_spf
+func (e SyslogEntry) MarshalJSON() ([]byte, error) {
+	type entry SyslogEntry
+	if e.AuthResultTotal == 0 {
+		return json.Marshal(entry(e))
+	}
+	return json.Marshal(struct {
+		entry
+		ResultSpf  []SyslogResultSPF  `json:"result_spf,omitempty"`
+		ResultDkim []SyslogResultDKIM `json:"result_dkim,omitempty"`
+	}{entry: entry(e)})
+}
+
+// dkimResults returns all DKIM results of the entry including the single
+// result of a flattened entry
+func (e SyslogEntry) dkimResults() []SyslogResultDKIM {
+	if e.AuthResultDKIM != nil {
+		return []SyslogResultDKIM{*e.AuthResultDKIM}
+	}
+	return e.ResultDkim
+}
+
+// spfResults returns all SPF results of the entry including the single
+// result of a flattened entry
+func (e SyslogEntry) spfResults() []SyslogResultSPF {
+	if e.AuthResultSPF != nil {
+		return []SyslogResultSPF{*e.AuthResultSPF}
+	}
+	return e.ResultSpf
+}
+
+// dkimPath returns the path of a field of the DKIM results used by the
+// field filter
+func (e SyslogEntry) dkimPath(name string) string {
+	if e.AuthResultTotal > 0 {
+		return "auth_result_dkim." + name
+	}
+	return "result_dkim." + name
+}
+
+// spfPath returns the path of a field of the SPF results used by the field
+// filter
+func (e SyslogEntry) spfPath(name string) string {
+	if e.AuthResultTotal > 0 {
+		return "auth_result_spf." + name
+	}
+	return "result_spf." + name
+}
+
 type CustomTime time.Time
 
 func (t CustomTime) MarshalJSON() ([]byte, error) {
@@ -18,6 +72,19 @@ func (t CustomTime) MarshalJSON() ([]byte, error) {
 	return []byte(stamp), nil
 }
 
+func (t *CustomTime) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	parsed, err := time.Parse(time.RFC822Z, s)
+	if err != nil {
+		return err
+	}
+	*t = CustomTime(parsed)
+	return nil
+}
+
 func (t CustomTime) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
 	stamp := time.Time(t).Format(time.RFC822Z)
 	return e.EncodeElement(stamp, start)
@@ -27,8 +94,12 @@ type SyslogEntry struct {
 	XMLName          xml.Name              `xml:"syslog_entry" json:"-"`                                    // for xml serialisation
 	EventID          string                `xml:"event_id,omitempty" json:"event_id,omitempty"`             // SIEM specific
 	EventCategory    string                `xml:"event_category,omitempty" json:"event_category,omitempty"` // SIEM specific
+	ReportType       string                `xml:"report_type" json:"report_type"`
 	Version          string                `xml:"version" json:"version"`
-	Domain           string                `xml:"domain" json:"domain"`
+	Domain           string                `xml:"domain" json:"domain"` // same as reporter_domain, kept for compatibility
+	ReporterDomain   string                `xml:"reporter_domain" json:"reporter_domain"`
+	PolicyDomain     string                `xml:"policy_domain" json:"policy_domain"`
+	DomainMismatches []string              `xml:"domain_mismatches>mismatch,omitempty" json:"domain_mismatches,omitempty"`
 	DateBegin        int64                 `xml:"date_begin" json:"date_begin"`
 	DateEnd          int64                 `xml:"date_end" json:"date_end"`
 	DateBeginParsed  CustomTime            `xml:"date_begin_parsed" json:"date_begin_parsed"`
@@ -38,17 +109,59 @@ type SyslogEntry struct {
 	Email            string                `xml:"email" json:"email"`
 	ExtraContactInfo string                `xml:"extra_contact_info" json:"extra_contact_info"`
 	Errors           []string              `xml:"errors>error" json:"errors"`
+	Generator        string                `xml:"generator,omitempty" json:"generator,omitempty"` // DMARCbis
 	SourceIP         string                `xml:"source_ip" json:"source_ip"`
 	SourceDNS        []string              `xml:"source_dns>dns" json:"source_dns"`
 	SourceDNSString  string                `xml:"source_dns_string" json:"source_dns_string"`
+	SourceCountry    string                `xml:"source_country,omitempty" json:"source_country,omitempty"` // GeoIP
+	SourceCity       string                `xml:"source_city,omitempty" json:"source_city,omitempty"`       // GeoIP
+	SourceASN        uint                  `xml:"source_asn,omitempty" json:"source_asn,omitempty"`         // GeoIP
+	SourceASOrg      string                `xml:"source_as_org,omitempty" json:"source_as_org,omitempty"`   // GeoIP
 	Count            int                   `xml:"count" json:"count"`
 	EnvelopeTo       string                `xml:"envelope_to" json:"envelope_to"`
 	HeaderFrom       string                `xml:"header_from" json:"header_from"`
 	EnvelopeFrom     string                `xml:"envelope_from" json:"envelope_from"`
 	PolicyPublished  SyslogPolicyPublished `xml:"policy_published" json:"policy_published"`
 	PolicyEvaluated  SyslogPolicyEvaluated `xml:"policy_evaluated" json:"policy_evaluated"`
-	ResultSpf        SyslogResultSPF       `xml:"result_spf" json:"result_spf"`
-	ResultDkim       SyslogResultDKIM      `xml:"result_dkim" json:"result_dkim"`
+	ResultSpf        []SyslogResultSPF     `xml:"result_spf" json:"result_spf"`
+	ResultDkim       []SyslogResultDKIM    `xml:"result_dkim" json:"result_dkim"`
+	Extensions       []SyslogExtension     `xml:"extensions>extension,omitempty" json:"extensions,omitempty"` // DMARCbis
+	// organisational domains determined with the public suffix list
+	HeaderFromOrgDomain string   `xml:"header_from_org_domain" json:"header_from_org_domain"`
+	SourceDNSOrgDomains []string `xml:"source_dns_org_domains>domain" json:"source_dns_org_domains"`
+	// SourceDNSVerified contains the forward-confirmation of every source_dns name
+	SourceDNSVerified []SyslogDNSName `xml:"source_dns_verified>dns" json:"source_dns_verified"`
+	// computed alignment of the auth results with header_from
+	DKIMAligned bool `xml:"dkim_aligned" json:"dkim_aligned"`
+	SPFAligned  bool `xml:"spf_aligned" json:"spf_aligned"`
+	DMARCPass   bool `xml:"dmarc_pass" json:"dmarc_pass"`
+	// classification of the source using the sender inventory
+	SenderName  string `xml:"sender_name,omitempty" json:"sender_name,omitempty"`
+	SenderClass string `xml:"sender_class,omitempty" json:"sender_class,omitempty"`
+	// heuristic detection of forwarded mail and mailing lists
+	LikelyForwarded       bool   `xml:"likely_forwarded" json:"likely_forwarded"`
+	ForwardingExplanation string `xml:"forwarding_explanation,omitempty" json:"forwarding_explanation,omitempty"`
+	// live DMARC record of the policy domain and the differences to the
+	// reported policy
+	PolicyLive  *SyslogPolicyLive `xml:"policy_live,omitempty" json:"policy_live,omitempty"`
+	PolicyDrift []string          `xml:"policy_drift>difference,omitempty" json:"policy_drift,omitempty"`
+	// position of the entry if the auth results are flattened. Only the
+	// entry with index 1 should be used to sum up the count.
+	AuthResultIndex int `xml:"auth_result_index,omitempty" json:"auth_result_index,omitempty"`
+	AuthResultTotal int `xml:"auth_result_total,omitempty" json:"auth_result_total,omitempty"`
+	// the single auth result of a flattened entry, result_dkim and
+	// result_spf are omitted
+	AuthResultDKIM *SyslogResultDKIM `xml:"auth_result_dkim,omitempty" json:"auth_result_dkim,omitempty"`
+	AuthResultSPF  *SyslogResultSPF  `xml:"auth_result_spf,omitempty" json:"auth_result_spf,omitempty"`
+	// ValidationWarnings contains the problems found in lenient validation mode
+	ValidationWarnings []string `xml:"validation_warnings>warning,omitempty" json:"validation_warnings,omitempty"`
+}
+
+// SyslogDNSName is a PTR name of the source IP. Verified is set if the
+// name resolves back to the source IP.
+type SyslogDNSName struct {
+	Name     string `xml:"name" json:"name"`
+	Verified bool   `xml:"verified" json:"verified"`
 }
 
 type SyslogPolicyPublished struct {
@@ -59,6 +172,25 @@ type SyslogPolicyPublished struct {
 	Sp     string `xml:"sp" json:"sp"`
 	Pct    string `xml:"pct" json:"pct"`
 	Fo     string `xml:"fo" json:"fo"`
+	// DMARCbis
+	Np              string `xml:"np,omitempty" json:"np,omitempty"`
+	Testing         string `xml:"testing,omitempty" json:"testing,omitempty"`
+	DiscoveryMethod string `xml:"discovery_method,omitempty" json:"discovery_method,omitempty"`
+}
+
+// SyslogPolicyLive is the DMARC record currently published by the domain.
+// Missing tags contain their default values.
+type SyslogPolicyLive struct {
+	Domain string   `xml:"domain" json:"domain"`
+	Record string   `xml:"record,omitempty" json:"record,omitempty"`
+	P      string   `xml:"p,omitempty" json:"p,omitempty"`
+	Sp     string   `xml:"sp,omitempty" json:"sp,omitempty"`
+	Np     string   `xml:"np,omitempty" json:"np,omitempty"`
+	Pct    int      `xml:"pct,omitempty" json:"pct,omitempty"`
+	Adkim  string   `xml:"adkim,omitempty" json:"adkim,omitempty"`
+	Aspf   string   `xml:"aspf,omitempty" json:"aspf,omitempty"`
+	Rua    []string `xml:"rua>uri,omitempty" json:"rua,omitempty"`
+	Error  string   `xml:"error,omitempty" json:"error,omitempty"`
 }
 
 type SyslogPolicyEvaluated struct {
@@ -69,9 +201,28 @@ type SyslogPolicyEvaluated struct {
 }
 
 type SyslogResultSPF struct {
-	Domain string `xml:"domain" json:"domain"`
-	Scope  string `xml:"scope" json:"scope"`
-	Result string `xml:"result" json:"result"`
+	Domain      string `xml:"domain" json:"domain"`
+	Scope       string `xml:"scope" json:"scope"`
+	Result      string `xml:"result" json:"result"`
+	HumanResult string `xml:"human_result,omitempty" json:"human_result,omitempty"` // DMARCbis
+	Aligned     bool   `xml:"aligned" json:"aligned"`
+
+	// Analysis explains the result using the live SPF record, only set if
+	// the SPF analysis is enabled
+	Analysis *SyslogSPFAnalysis `xml:"analysis,omitempty" json:"analysis,omitempty"`
+}
+
+// SyslogSPFAnalysis is the evaluation of the current SPF record of the
+// domain for the source IP
+type SyslogSPFAnalysis struct {
+	Result      string `xml:"result" json:"result"`
+	Authorized  bool   `xml:"authorized" json:"authorized"`
+	Mechanism   string `xml:"mechanism" json:"mechanism"`
+	Record      string `xml:"record" json:"record"`
+	Lookups     int    `xml:"lookups" json:"lookups"`
+	VoidLookups int    `xml:"void_lookups" json:"void_lookups"`
+	OverLimit   bool   `xml:"over_limit" json:"over_limit"`
+	Error       string `xml:"error,omitempty" json:"error,omitempty"`
 }
 
 type SyslogResultDKIM struct {
@@ -79,6 +230,14 @@ type SyslogResultDKIM struct {
 	Selector    string `xml:"selector" json:"selector"`
 	Result      string `xml:"result" json:"result"`
 	HumanResult string `xml:"human_result" json:"human_result"`
+	Aligned     bool   `xml:"aligned" json:"aligned"`
+}
+
+// SyslogExtension contains a report extension. The value is the raw content
+// of the extension element.
+type SyslogExtension struct {
+	Name  string `xml:"name" json:"name"`
+	Value string `xml:"value" json:"value"`
 }
 
 type SyslogPolicyOverrideReason struct {
@@ -86,123 +245,246 @@ type SyslogPolicyOverrideReason struct {
 	Comment string `xml:"comment" json:"comment"`
 }
 
-func ConvertToSyslogJSON(filename string, report XMLReport, dns *dns.CachedDNSResolver, eventID, eventCategory string) ([][]byte, error) {
-	reports, err := convertXMLToSyslog(filename, report, dns, eventID, eventCategory)
-	if err != nil {
-		return nil, err
-	}
+// ConvertOptions contains the settings used when converting a report
+// into syslog entries
+type ConvertOptions struct {
+	EventID       string
+	EventCategory string
+	// FlattenAuthResults creates one entry per DKIM and SPF result
+	// instead of emitting all results as arrays in a single entry
+	FlattenAuthResults bool
+	// PublicSuffixList is used to determine organisational domains,
+	// defaults to the embedded list
+	PublicSuffixList *psl.List
+	// GeoIP enriches the source IP, the enrichment is disabled if nil
+	GeoIP *geoip.DB
+	// Senders classifies the source, the classification is disabled if nil
+	Senders *senders.Inventory
+	// SPFAnalyzer explains failed SPF results, the analysis is disabled
+	// if nil
+	SPFAnalyzer *spf.Analyzer
+	// ForwarderSuffixes are PTR suffixes of forwarding services in addition
+	// to the built-in list
+	ForwarderSuffixes []string
+	// PolicyFetcher looks up the live DMARC record to detect policy drift,
+	// the check is disabled if nil
+	PolicyFetcher *dmarcrecord.Fetcher
+	// ReportAddresses are the addresses of the report mailbox. The policy
+	// drift check lists a drift if the live rua tag contains none of them.
+	ReportAddresses []string
+}
 
+// ConvertRecordToSyslog converts a single record of the report and
+// serializes the entries with the provided formatter. Multiple entries are
+// returned if the auth results are flattened.
+func ConvertRecordToSyslog(f *ReportFile, record Record, dns *dns.CachedDNSResolver, opts ConvertOptions, formatter Formatter) ([][]byte, error) {
 	var ret [][]byte
-	for _, report := range reports {
-		jsonString, err := json.Marshal(report)
+	for _, entry := range convertRecord(f.domains, f.Report, record, dns, opts) {
+		b, err := formatter.Format(entry)
 		if err != nil {
-			return nil, fmt.Errorf("could not marshal JSON: %w", err)
+			return nil, err
 		}
-		ret = append(ret, jsonString)
+		ret = append(ret, b)
 	}
 	return ret, nil
 }
 
-func ConvertToSyslogXML(filename string, report XMLReport, dns *dns.CachedDNSResolver, eventID, eventCategory string) ([][]byte, error) {
-	reports, err := convertXMLToSyslog(filename, report, dns, eventID, eventCategory)
-	if err != nil {
-		return nil, err
+func convertRecord(reportDomains reportDomains, report *XMLReport, record Record, dns *dns.CachedDNSResolver, opts ConvertOptions) []SyslogEntry {
+	// lookup errors result in an empty list
+	names, _ := dns.CachedVerifiedDNSLookup(record.Row.SourceIP)
+	domains := make([]string, 0, len(names))
+	verified := make([]SyslogDNSName, 0, len(names))
+	for _, n := range names {
+		domains = append(domains, n.Name)
+		verified = append(verified, SyslogDNSName{Name: n.Name, Verified: n.Verified})
 	}
 
-	var ret [][]byte
-	for _, report := range reports {
-		xmlString, err := xml.Marshal(report)
-		if err != nil {
-			return nil, fmt.Errorf("could not marshal XML: %w", err)
+	geo := opts.GeoIP.Lookup(record.Row.SourceIP)
+
+	var reasons []SyslogPolicyOverrideReason
+	for _, r := range record.Row.PolicyEvaluated.Reason {
+		// too error prone:
+		// nolint:staticcheck
+		tmp := SyslogPolicyOverrideReason{
+			Type:    r.Type,
+			Comment: r.Comment,
 		}
-		ret = append(ret, xmlString)
+		reasons = append(reasons, tmp)
 	}
-	return ret, nil
+
+	var spfResults []SyslogResultSPF
+	for _, r := range record.AuthResults.Spf {
+		spfResults = append(spfResults, SyslogResultSPF{
+			Domain:      r.Domain,
+			Scope:       r.Scope,
+			Result:      r.Result,
+			HumanResult: r.HumanResult,
+			Analysis:    analyzeSPF(opts.SPFAnalyzer, r, record.Row.SourceIP),
+		})
+	}
+
+	var dkimResults []SyslogResultDKIM
+	for _, r := range record.AuthResults.Dkim {
+		dkimResults = append(dkimResults, SyslogResultDKIM{
+			Domain:      r.Domain,
+			Selector:    r.Selector,
+			Result:      r.Result,
+			HumanResult: r.HumanResult,
+		})
+	}
+
+	syslog := SyslogEntry{
+		Version:          report.Version,
+		Domain:           reportDomains.Reporter,
+		ReporterDomain:   reportDomains.Reporter,
+		PolicyDomain:     reportDomains.Policy,
+		DomainMismatches: reportDomains.Mismatches,
+		DateBegin:        report.ReportMetadata.DateRange.Begin,
+		DateEnd:          report.ReportMetadata.DateRange.End,
+		DateBeginParsed:  CustomTime(time.Unix(report.ReportMetadata.DateRange.Begin, 0)),
+		DateEndParsed:    CustomTime(time.Unix(report.ReportMetadata.DateRange.End, 0)),
+		ReportID:         report.ReportMetadata.ReportID,
+		OrgName:          report.ReportMetadata.OrgName,
+		Email:            report.ReportMetadata.Email,
+		ExtraContactInfo: report.ReportMetadata.ExtraContactInfo,
+		Errors:           report.ReportMetadata.Error,
+		Generator:        report.ReportMetadata.Generator,
+		SourceIP:         record.Row.SourceIP,
+		SourceDNS:        domains,
+		SourceDNSString:  strings.Join(domains, ", "),
+		SourceCountry:    geo.Country,
+		SourceCity:       geo.City,
+		SourceASN:        geo.ASN,
+		SourceASOrg:      geo.ASOrg,
+		Count:            record.Row.Count,
+		EnvelopeTo:       record.Identifiers.EnvelopeTo,
+		EnvelopeFrom:     record.Identifiers.EnvelopeFrom,
+		HeaderFrom:       record.Identifiers.HeaderFrom,
+		PolicyPublished: SyslogPolicyPublished{
+			Domain: report.PolicyPublished.Domain,
+			Adkim:  report.PolicyPublished.Adkim,
+			Aspf:   report.PolicyPublished.Aspf,
+			P:      report.PolicyPublished.P,
+			Sp:     report.PolicyPublished.Sp,
+			Pct:    report.PolicyPublished.Pct,
+			Fo:     report.PolicyPublished.Fo,
+
+			Np:              report.PolicyPublished.Np,
+			Testing:         report.PolicyPublished.Testing,
+			DiscoveryMethod: report.PolicyPublished.DiscoveryMethod,
+		},
+		PolicyEvaluated: SyslogPolicyEvaluated{
+			Disposition: record.Row.PolicyEvaluated.Disposition,
+			Dkim:        record.Row.PolicyEvaluated.Dkim,
+			Spf:         record.Row.PolicyEvaluated.Spf,
+			Reason:      reasons,
+		},
+		ResultSpf:          spfResults,
+		ResultDkim:         dkimResults,
+		Extensions:         convertExtensions(report.Extensions, record.Extensions),
+		ValidationWarnings: record.ValidationWarnings,
+		ReportType:         ReportTypeAggregate,
+		EventID:            opts.EventID,
+		EventCategory:      opts.EventCategory,
+	}
+	list := opts.PublicSuffixList
+	if list == nil {
+		list = psl.Default()
+	}
+	syslog.HeaderFromOrgDomain = list.OrganizationalDomain(syslog.HeaderFrom)
+	syslog.SourceDNSOrgDomains = organizationalDomains(list, domains)
+	syslog.SourceDNSVerified = verified
+	computeAlignment(list, &syslog)
+	sender := opts.Senders.Classify(senders.Source{
+		IP:          syslog.SourceIP,
+		Hostnames:   verifiedNames(syslog),
+		DKIMDomains: passedDKIMDomains(syslog),
+	})
+	syslog.SenderName = sender.Name
+	syslog.SenderClass = sender.Class
+	detectForwarding(&syslog, opts.ForwarderSuffixes)
+	checkPolicyDrift(&syslog, opts.PolicyFetcher, opts.ReportAddresses, list)
+	if opts.FlattenAuthResults {
+		return flattenAuthResults(syslog)
+	}
+	return []SyslogEntry{syslog}
 }
 
-func convertXMLToSyslog(filename string, report XMLReport, dns *dns.CachedDNSResolver, eventID, eventCategory string) ([]SyslogEntry, error) {
-	reportingDomain, err := getDomainFromFilename(filename)
-	if err != nil {
-		return nil, err
+// analyzeSPF evaluates the current SPF record of a result which did not pass
+func analyzeSPF(analyzer *spf.Analyzer, r SPFAuthResult, ip string) *SyslogSPFAnalysis {
+	if strings.EqualFold(r.Result, spf.ResultPass) {
+		return nil
 	}
-	syslogs := make([]SyslogEntry, len(report.Records))
-	for i, record := range report.Records {
-		domains, err := dns.CachedDNSLookup(record.Row.SourceIP)
-		if err != nil {
-			domains = []string{}
-		}
+	result := analyzer.Analyze(r.Domain, ip)
+	if result == nil {
+		return nil
+	}
+	return &SyslogSPFAnalysis{
+		Result:      result.Result,
+		Authorized:  result.Authorized(),
+		Mechanism:   result.Mechanism,
+		Record:      result.Record,
+		Lookups:     result.Lookups,
+		VoidLookups: result.VoidLookups,
+		OverLimit:   result.OverLimit,
+		Error:       result.Error,
+	}
+}
 
-		var reasons []SyslogPolicyOverrideReason
-		for _, r := range record.Row.PolicyEvaluated.Reason {
-			// too error prone:
-			// nolint:staticcheck
-			tmp := SyslogPolicyOverrideReason{
-				Type:    r.Type,
-				Comment: r.Comment,
-			}
-			reasons = append(reasons, tmp)
+// passedDKIMDomains returns the domains of all passed DKIM signatures
+func passedDKIMDomains(entry SyslogEntry) []string {
+	var ret []string
+	for _, r := range entry.ResultDkim {
+		if strings.EqualFold(r.Result, "pass") {
+			ret = append(ret, r.Domain)
 		}
+	}
+	return ret
+}
 
-		syslog := SyslogEntry{
-			Version:          report.Version,
-			Domain:           reportingDomain,
-			DateBegin:        report.ReportMetadata.DateRange.Begin,
-			DateEnd:          report.ReportMetadata.DateRange.End,
-			DateBeginParsed:  CustomTime(time.Unix(report.ReportMetadata.DateRange.Begin, 0)),
-			DateEndParsed:    CustomTime(time.Unix(report.ReportMetadata.DateRange.End, 0)),
-			ReportID:         report.ReportMetadata.ReportID,
-			OrgName:          report.ReportMetadata.OrgName,
-			Email:            report.ReportMetadata.Email,
-			ExtraContactInfo: report.ReportMetadata.ExtraContactInfo,
-			Errors:           report.ReportMetadata.Error,
-			SourceIP:         record.Row.SourceIP,
-			SourceDNS:        domains,
-			SourceDNSString:  strings.Join(domains, ", "),
-			Count:            record.Row.Count,
-			EnvelopeTo:       record.Identifiers.EnvelopeTo,
-			EnvelopeFrom:     record.Identifiers.EnvelopeFrom,
-			HeaderFrom:       record.Identifiers.HeaderFrom,
-			PolicyPublished: SyslogPolicyPublished{
-				Domain: report.PolicyPublished.Domain,
-				Adkim:  report.PolicyPublished.Adkim,
-				Aspf:   report.PolicyPublished.Aspf,
-				P:      report.PolicyPublished.P,
-				Sp:     report.PolicyPublished.Sp,
-				Pct:    report.PolicyPublished.Pct,
-				Fo:     report.PolicyPublished.Fo,
-			},
-			PolicyEvaluated: SyslogPolicyEvaluated{
-				Disposition: record.Row.PolicyEvaluated.Disposition,
-				Dkim:        record.Row.PolicyEvaluated.Dkim,
-				Spf:         record.Row.PolicyEvaluated.Spf,
-				Reason:      reasons,
-			},
-			ResultSpf: SyslogResultSPF{
-				Domain: record.AuthResults.Spf.Domain,
-				Scope:  record.AuthResults.Spf.Scope,
-				Result: record.AuthResults.Spf.Result,
-			},
-			ResultDkim: SyslogResultDKIM{
-				Domain:      record.AuthResults.Dkim.Domain,
-				Selector:    record.AuthResults.Dkim.Selector,
-				Result:      record.AuthResults.Dkim.Result,
-				HumanResult: record.AuthResults.Dkim.HumanResult,
-			},
-			EventID:       eventID,
-			EventCategory: eventCategory,
+// convertExtensions combines the report and the record extensions
+func convertExtensions(extensions ...Extensions) []SyslogExtension {
+	var ret []SyslogExtension
+	for _, e := range extensions {
+		for _, x := range e.Extension {
+			ret = append(ret, SyslogExtension{
+				Name:  x.XMLName.Local,
+				Value: strings.TrimSpace(x.InnerXML),
+			})
 		}
-		syslogs[i] = syslog
 	}
-	return syslogs, nil
+	return ret
 }
 
-func getDomainFromFilename(filename string) (string, error) {
-	// filename = receiver "!" policy-domain "!" begin-timestamp
-	//               "!" end-timestamp [ "!" unique-id ] "." extension
-	filename = filepath.Base(filename)
-	parts := strings.Split(filename, "!")
-	if len(parts) < 4 {
-		return "", fmt.Errorf("filename %q does not match RFC", filename)
+// flattenAuthResults splits the entry into one entry per DKIM and SPF
+// result. Every returned entry contains exactly one auth result as a single
+// object in auth_result_dkim or auth_result_spf, all other fields (including
+// the count) are copied. The entries are numbered with auth_result_index and
+// auth_result_total so the count of a record can be summed up without
+// counting it once per auth result.
+func flattenAuthResults(entry SyslogEntry) []SyslogEntry {
+	dkim, spf := entry.ResultDkim, entry.ResultSpf
+	entry.ResultDkim = nil
+	entry.ResultSpf = nil
+	if len(dkim)+len(spf) == 0 {
+		entry.AuthResultIndex = 1
+		entry.AuthResultTotal = 1
+		return []SyslogEntry{entry}
+	}
+	entries := make([]SyslogEntry, 0, len(dkim)+len(spf))
+	for _, r := range dkim {
+		e := entry
+		e.AuthResultDKIM = &r
+		entries = append(entries, e)
+	}
+	for _, r := range spf {
+		e := entry
+		e.AuthResultSPF = &r
+		entries = append(entries, e)
+	}
+	for i := range entries {
+		entries[i].AuthResultIndex = i + 1
+		entries[i].AuthResultTotal = len(entries)
 	}
-	return parts[0], nil
+	return entries
 }
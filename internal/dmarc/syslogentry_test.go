@@ -0,0 +1,190 @@
+package dmarc
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func TestMultipleAuthResults(t *testing.T) {
+	t.Parallel()
+
+	content := `<feedback><record>
+<row><source_ip>192.0.2.1</source_ip><count>1</count></row>
+<auth_results>
+<dkim><domain>example.com</domain><selector>s1</selector><result>pass</result></dkim>
+<dkim><domain>esp.example</domain><selector>s2</selector><result>fail</result></dkim>
+<spf><domain>example.com</domain><scope>mfrom</scope><result>pass</result></spf>
+</auth_results>
+</record></feedback>`
+
+	var report XMLReport
+	if err := xml.Unmarshal([]byte(content), &report); err != nil {
+		t.Fatalf("could not unmarshal: %v", err)
+	}
+	if len(report.Records) != 1 {
+		t.Fatalf("expected 1 record, got %d", len(report.Records))
+	}
+	record := report.Records[0]
+	if len(record.AuthResults.Dkim) != 2 {
+		t.Fatalf("expected 2 dkim results, got %d", len(record.AuthResults.Dkim))
+	}
+	if record.AuthResults.Dkim[1].Domain != "esp.example" {
+		t.Fatalf("invalid second dkim result %+v", record.AuthResults.Dkim[1])
+	}
+	if len(record.AuthResults.Spf) != 1 {
+		t.Fatalf("expected 1 spf result, got %d", len(record.AuthResults.Spf))
+	}
+}
+
+func TestFlattenAuthResults(t *testing.T) {
+	t.Parallel()
+
+	entry := SyslogEntry{
+		SourceIP: "192.0.2.1",
+		Count:    5,
+		ResultDkim: []SyslogResultDKIM{
+			{Domain: "example.com", Result: "pass"},
+			{Domain: "esp.example", Result: "fail"},
+		},
+		ResultSpf: []SyslogResultSPF{
+			{Domain: "example.com", Result: "pass"},
+		},
+	}
+
+	entries := flattenAuthResults(entry)
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(entries))
+	}
+	for i, e := range entries {
+		if e.ResultDkim != nil || e.ResultSpf != nil {
+			t.Fatalf("entry %d still contains the result arrays: %+v", i, e)
+		}
+		if (e.AuthResultDKIM == nil) == (e.AuthResultSPF == nil) {
+			t.Fatalf("entry %d does not contain exactly one auth result: %+v", i, e)
+		}
+		if e.SourceIP != entry.SourceIP || e.Count != entry.Count {
+			t.Fatalf("entry %d has invalid fields: %+v", i, e)
+		}
+		if e.AuthResultIndex != i+1 || e.AuthResultTotal != 3 {
+			t.Fatalf("entry %d has an invalid position %d of %d", i, e.AuthResultIndex, e.AuthResultTotal)
+		}
+	}
+	if entries[0].AuthResultDKIM.Domain != "example.com" || entries[1].AuthResultDKIM.Domain != "esp.example" {
+		t.Fatalf("invalid order of entries: %+v", entries)
+	}
+	if entries[2].AuthResultSPF.Domain != "example.com" {
+		t.Fatalf("invalid order of entries: %+v", entries)
+	}
+
+	tests := []struct {
+		name     string
+		entry    SyslogEntry
+		contains []string
+		missing  []string
+	}{
+		{
+			name:     "dkim",
+			entry:    entries[1],
+			contains: []string{`"auth_result_dkim":{"domain":"esp.example"`, `"auth_result_index":2,"auth_result_total":3`},
+			missing:  []string{`"result_dkim"`, `"result_spf"`, `"auth_result_spf"`},
+		},
+		{
+			name:     "spf",
+			entry:    entries[2],
+			contains: []string{`"auth_result_spf":{"domain":"example.com"`},
+			missing:  []string{`"result_dkim"`, `"result_spf"`, `"auth_result_dkim"`},
+		},
+		{
+			name:     "unflattened",
+			entry:    entry,
+			contains: []string{`"result_spf":[{`, `"result_dkim":[{`},
+			missing:  []string{`"auth_result_dkim"`, `"auth_result_spf"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			out, err := json.Marshal(tt.entry)
+			if err != nil {
+				t.Fatalf("could not marshal entry: %v", err)
+			}
+			for _, s := range tt.contains {
+				if !strings.Contains(string(out), s) {
+					t.Errorf("output does not contain %s: %s", s, out)
+				}
+			}
+			for _, s := range tt.missing {
+				if strings.Contains(string(out), s) {
+					t.Errorf("output contains %s: %s", s, out)
+				}
+			}
+		})
+	}
+
+	// entries with a single result are only numbered
+	single := SyslogEntry{ResultDkim: []SyslogResultDKIM{{Domain: "example.com"}}}
+	if got := flattenAuthResults(single); len(got) != 1 || got[0].AuthResultDKIM == nil || got[0].ResultDkim != nil || got[0].AuthResultIndex != 1 || got[0].AuthResultTotal != 1 {
+		t.Fatalf("invalid flattened entry %+v", got)
+	}
+}
+
+func TestDMARCbisReport(t *testing.T) {
+	t.Parallel()
+
+	content := `<?xml version="1.0" encoding="UTF-8"?>
+<feedback xmlns="urn:ietf:params:xml:ns:dmarc-2.0">
+<version>1.0</version>
+<report_metadata>
+<org_name>example.net</org_name>
+<email>dmarc@example.net</email>
+<report_id>123</report_id>
+<date_range><begin>1700000000</begin><end>1700086399</end></date_range>
+<generator>Example Reporter 1.2</generator>
+</report_metadata>
+<policy_published>
+<domain>example.com</domain>
+<p>reject</p>
+<sp>quarantine</sp>
+<np>reject</np>
+<testing>n</testing>
+<discovery_method>treewalk</discovery_method>
+</policy_published>
+<extensions><ext:foo xmlns:ext="urn:example">bar</ext:foo></extensions>
+<record>
+<row><source_ip>192.0.2.1</source_ip><count>1</count></row>
+<auth_results>
+<spf><domain>example.com</domain><result>pass</result><human_result>ok</human_result></spf>
+</auth_results>
+<extensions><baz>qux</baz></extensions>
+</record>
+</feedback>`
+
+	var report XMLReport
+	if err := xml.Unmarshal([]byte(content), &report); err != nil {
+		t.Fatalf("could not unmarshal: %v", err)
+	}
+	if report.ReportMetadata.Generator != "Example Reporter 1.2" {
+		t.Fatalf("invalid generator %q", report.ReportMetadata.Generator)
+	}
+	p := report.PolicyPublished
+	if p.Np != "reject" || p.Testing != "n" || p.DiscoveryMethod != "treewalk" {
+		t.Fatalf("invalid policy published %+v", p)
+	}
+	if len(report.Records) != 1 || report.Records[0].AuthResults.Spf[0].HumanResult != "ok" {
+		t.Fatalf("invalid records %+v", report.Records)
+	}
+
+	extensions := convertExtensions(report.Extensions, report.Records[0].Extensions)
+	expected := []SyslogExtension{{Name: "foo", Value: "bar"}, {Name: "baz", Value: "qux"}}
+	if len(extensions) != len(expected) {
+		t.Fatalf("expected %d extensions, got %+v", len(expected), extensions)
+	}
+	for i := range expected {
+		if extensions[i] != expected[i] {
+			t.Fatalf("expected extension %+v, got %+v", expected[i], extensions[i])
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package dmarc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/firefart/dmarcsyslogforwarder/internal/fields"
+)
+
+var templateEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+
+// templateFuncs are the helper functions available in user defined templates
+var templateFuncs = template.FuncMap{
+	// join joins all elements of a list with the separator
+	"join": func(list any, sep string) string {
+		switch v := list.(type) {
+		case nil:
+			return ""
+		case []string:
+			return strings.Join(v, sep)
+		case []any:
+			s := make([]string, len(v))
+			for i, x := range v {
+				s[i] = fmt.Sprint(x)
+			}
+			return strings.Join(s, sep)
+		default:
+			return fmt.Sprint(v)
+		}
+	},
+	// escape escapes backslashes, quotes and control characters so
+	// the value can be used inside a quoted string
+	"escape": func(v any) string {
+		if v == nil {
+			return ""
+		}
+		return templateEscaper.Replace(fmt.Sprint(v))
+	},
+	// json returns the value as a JSON literal, strings are quoted
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	},
+	// formatTime formats an unix timestamp with the go layout string
+	"formatTime": func(v any, layout string) (string, error) {
+		ts, err := strconv.ParseInt(fmt.Sprint(v), 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("invalid timestamp %v: %w", v, err)
+		}
+		return time.Unix(ts, 0).UTC().Format(layout), nil
+	},
+}
+
+// ParseTemplate parses a user defined text/template file
+func ParseTemplate(filename string) (*template.Template, error) {
+	content, err := os.ReadFile(filename) // nolint: gosec
+	if err != nil {
+		return nil, fmt.Errorf("could not read template %s: %w", filename, err)
+	}
+	tmpl, err := template.New(filepath.Base(filename)).Funcs(templateFuncs).Option("missingkey=zero").Parse(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse template %s: %w", filename, err)
+	}
+	return tmpl, nil
+}
+
+type templateFormatter struct {
+	template *template.Template
+	fields   *fields.Filter
+}
+
+// Format renders the entry with the user defined template. The template
+// receives the entry with the same field names as the JSON output.
+func (f templateFormatter) Format(entry SyslogEntry) ([]byte, error) {
+	return renderTemplate(f.template, entry, f.fields)
+}
+
+// FormatFailure renders the failure report with the user defined template.
+// The report_type field can be used to distinguish the entries.
+func (f templateFormatter) FormatFailure(entry FailureEntry) ([]byte, error) {
+	return renderTemplate(f.template, entry, f.fields)
+}
+
+// FormatTLS renders the TLS report entry with the user defined template
+func (f templateFormatter) FormatTLS(entry TLSEntry) ([]byte, error) {
+	return renderTemplate(f.template, entry, f.fields)
+}
+
+func renderTemplate(tmpl *template.Template, entry any, filter *fields.Filter) ([]byte, error) {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal JSON: %w", err)
+	}
+	doc, err := fields.ParseJSON(b)
+	if err != nil {
+		return nil, err
+	}
+	data := filter.Apply(doc).Map()
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("could not execute template: %w", err)
+	}
+	// one entry per line so we do not need the trailing newline of the template file
+	return bytes.TrimRight(buf.Bytes(), "\r\n"), nil
+}
@@ -0,0 +1,63 @@
+package dmarc
+
+import (
+	"path"
+	"testing"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	t.Parallel()
+
+	tmpl, err := ParseTemplate(path.Join("..", "..", "testdata", "template.tmpl"))
+	if err != nil {
+		t.Fatalf("could not parse template: %v", err)
+	}
+
+	entry := SyslogEntry{
+		ReportID:   "1234",
+		OrgName:    `google "mail"`,
+		DateBegin:  1636416000,
+		DateEnd:    1636502399,
+		SourceIP:   "192.0.2.1",
+		SourceDNS:  []string{"a.example.com", "b.example.com"},
+		Count:      2,
+		HeaderFrom: "example.com",
+	}
+	entry.PolicyEvaluated.Disposition = "none"
+	entry.PolicyEvaluated.Dkim = "pass"
+	entry.PolicyEvaluated.Spf = "fail"
+
+	got, err := renderTemplate(tmpl, entry, nil)
+	if err != nil {
+		t.Fatalf("could not render template: %v", err)
+	}
+
+	expected := `report_id="1234" org="google \"mail\"" begin="2021-11-09T00:00:00Z" end="2021-11-09T23:59:59Z" src="192.0.2.1" src_dns="a.example.com,b.example.com" count=2 header_from="example.com" disposition="none" dkim="pass" spf="fail"`
+	if string(got) != expected {
+		t.Fatalf("template mismatch\nexpected %s\ngot      %s", expected, got)
+	}
+}
+
+func TestTemplateFuncs(t *testing.T) {
+	t.Parallel()
+
+	jsonFunc, ok := templateFuncs["json"].(func(any) (string, error))
+	if !ok {
+		t.Fatal("invalid json func")
+	}
+	s, err := jsonFunc("a\"b")
+	if err != nil {
+		t.Fatalf("got unexpected error: %v", err)
+	}
+	if s != `"a\"b"` {
+		t.Fatalf("invalid json quoting: %s", s)
+	}
+
+	formatTime, ok := templateFuncs["formatTime"].(func(any, string) (string, error))
+	if !ok {
+		t.Fatal("invalid formatTime func")
+	}
+	if _, err := formatTime("invalid", "2006"); err == nil {
+		t.Fatal("expected an error on invalid timestamp")
+	}
+}
@@ -0,0 +1,193 @@
+package dmarc
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/firefart/dmarcsyslogforwarder/internal/helper"
+)
+
+const (
+	ReportTypeTLS = "tlsrpt"
+
+	TLSEntryPolicy        = "policy"
+	TLSEntryFailureDetail = "failure_detail"
+)
+
+// TLSReport represents a SMTP TLS report
+// https://tools.ietf.org/html/rfc8460#section-4.4
+type TLSReport struct {
+	OrganizationName string `json:"organization-name"`
+	DateRange        struct {
+		StartDatetime time.Time `json:"start-datetime"`
+		EndDatetime   time.Time `json:"end-datetime"`
+	} `json:"date-range"`
+	ContactInfo string            `json:"contact-info"`
+	ReportID    string            `json:"report-id"`
+	Policies    []TLSReportPolicy `json:"policies"`
+}
+
+// TLSReportPolicy contains the results of a single policy
+type TLSReportPolicy struct {
+	Policy struct {
+		PolicyType   string   `json:"policy-type"`
+		PolicyString []string `json:"policy-string"`
+		PolicyDomain string   `json:"policy-domain"`
+		MXHost       []string `json:"mx-host"`
+	} `json:"policy"`
+	Summary struct {
+		TotalSuccessfulSessionCount int `json:"total-successful-session-count"`
+		TotalFailureSessionCount    int `json:"total-failure-session-count"`
+	} `json:"summary"`
+	FailureDetails []TLSReportFailureDetail `json:"failure-details"`
+}
+
+// TLSReportFailureDetail contains a single failure of a policy
+type TLSReportFailureDetail struct {
+	ResultType            string `json:"result-type"`
+	SendingMTAIP          string `json:"sending-mta-ip"`
+	ReceivingMXHostname   string `json:"receiving-mx-hostname"`
+	ReceivingMXHelo       string `json:"receiving-mx-helo"`
+	ReceivingIP           string `json:"receiving-ip"`
+	FailedSessionCount    int    `json:"failed-session-count"`
+	AdditionalInformation string `json:"additional-information"`
+	FailureReasonCode     string `json:"failure-reason-code"`
+}
+
+// TLSEntry is a single policy or failure detail of a TLS report. Failure
+// detail entries also contain the fields of their policy.
+type TLSEntry struct {
+	XMLName                     xml.Name   `xml:"tls_entry" json:"-"`                                       // for xml serialisation
+	EventID                     string     `xml:"event_id,omitempty" json:"event_id,omitempty"`             // SIEM specific
+	EventCategory               string     `xml:"event_category,omitempty" json:"event_category,omitempty"` // SIEM specific
+	ReportType                  string     `xml:"report_type" json:"report_type"`
+	EntryType                   string     `xml:"entry_type" json:"entry_type"`
+	OrganizationName            string     `xml:"organization_name" json:"organization_name"`
+	ContactInfo                 string     `xml:"contact_info" json:"contact_info"`
+	ReportID                    string     `xml:"report_id" json:"report_id"`
+	DateBegin                   int64      `xml:"date_begin" json:"date_begin"`
+	DateEnd                     int64      `xml:"date_end" json:"date_end"`
+	DateBeginParsed             CustomTime `xml:"date_begin_parsed" json:"date_begin_parsed"`
+	DateEndParsed               CustomTime `xml:"date_end_parsed" json:"date_end_parsed"`
+	PolicyType                  string     `xml:"policy_type" json:"policy_type"`
+	PolicyString                []string   `xml:"policy_string" json:"policy_string"`
+	PolicyDomain                string     `xml:"policy_domain" json:"policy_domain"`
+	MXHost                      []string   `xml:"mx_host" json:"mx_host"`
+	TotalSuccessfulSessionCount int        `xml:"total_successful_session_count" json:"total_successful_session_count"`
+	TotalFailureSessionCount    int        `xml:"total_failure_session_count" json:"total_failure_session_count"`
+	// only set on failure details
+	ResultType            string `xml:"result_type,omitempty" json:"result_type,omitempty"`
+	SendingMTAIP          string `xml:"sending_mta_ip,omitempty" json:"sending_mta_ip,omitempty"`
+	ReceivingMXHostname   string `xml:"receiving_mx_hostname,omitempty" json:"receiving_mx_hostname,omitempty"`
+	ReceivingMXHelo       string `xml:"receiving_mx_helo,omitempty" json:"receiving_mx_helo,omitempty"`
+	ReceivingIP           string `xml:"receiving_ip,omitempty" json:"receiving_ip,omitempty"`
+	FailedSessionCount    int    `xml:"failed_session_count,omitempty" json:"failed_session_count,omitempty"`
+	AdditionalInformation string `xml:"additional_information,omitempty" json:"additional_information,omitempty"`
+	FailureReasonCode     string `xml:"failure_reason_code,omitempty" json:"failure_reason_code,omitempty"`
+}
+
+// IsTLSReport checks the media type and the filename of an attachment
+// for a TLS report. The media types are application/tlsrpt+gzip and
+// application/tlsrpt+json but some reporters send application/gzip so
+// we also check the filename (including compressed files like .json.zst).
+func IsTLSReport(filename, mediaType string) bool {
+	mediaType = strings.ToLower(mediaType)
+	if mediaType == "application/tlsrpt+gzip" || mediaType == "application/tlsrpt+json" {
+		return true
+	}
+	return strings.HasSuffix(strings.ToLower(trimExtension(filename)), ".json")
+}
+
+// ReadTLSReport parses a TLS report. Compressed reports are extracted the
+// same way as DMARC reports so the type is detected by the content and the
+// limits are enforced. Attachments that do not contain exactly one report
+// with an organization-name and policies return an error.
+func ReadTLSReport(filename, mediaType string, content []byte, limits Limits) (*TLSReport, error) {
+	if err := checkLimit("attachment size", limits.MaxAttachmentSize, int64(len(content))); err != nil {
+		return nil, err
+	}
+
+	var report *TLSReport
+	e := extractor{limits: limits, payload: helper.FileTypeJSON}
+	err := e.walk(filename, mediaType, bytes.NewReader(content), int64(len(content)), 0, func(name string, r io.Reader) error {
+		if report != nil {
+			return fmt.Errorf("%s: attachment contains more than one tls report", name)
+		}
+		var tmp TLSReport
+		if err := json.NewDecoder(r).Decode(&tmp); err != nil {
+			return fmt.Errorf("could not parse %s: %w", name, err)
+		}
+		if tmp.OrganizationName == "" {
+			return fmt.Errorf("%s: missing organization-name", name)
+		}
+		if len(tmp.Policies) == 0 {
+			return fmt.Errorf("%s: missing policies", name)
+		}
+		report = &tmp
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// ConvertTLSToSyslog splits the report into policy and failure detail
+// entries and serializes every entry with the provided formatter
+func ConvertTLSToSyslog(report TLSReport, opts ConvertOptions, formatter Formatter) ([][]byte, error) {
+	var ret [][]byte
+	for _, entry := range convertTLSReport(report, opts) {
+		b, err := formatter.FormatTLS(entry)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, b)
+	}
+	return ret, nil
+}
+
+func convertTLSReport(report TLSReport, opts ConvertOptions) []TLSEntry {
+	var entries []TLSEntry
+	for _, p := range report.Policies {
+		policy := TLSEntry{
+			EventID:                     opts.EventID,
+			EventCategory:               opts.EventCategory,
+			ReportType:                  ReportTypeTLS,
+			EntryType:                   TLSEntryPolicy,
+			OrganizationName:            report.OrganizationName,
+			ContactInfo:                 report.ContactInfo,
+			ReportID:                    report.ReportID,
+			DateBegin:                   report.DateRange.StartDatetime.Unix(),
+			DateEnd:                     report.DateRange.EndDatetime.Unix(),
+			DateBeginParsed:             CustomTime(report.DateRange.StartDatetime),
+			DateEndParsed:               CustomTime(report.DateRange.EndDatetime),
+			PolicyType:                  p.Policy.PolicyType,
+			PolicyString:                p.Policy.PolicyString,
+			PolicyDomain:                p.Policy.PolicyDomain,
+			MXHost:                      p.Policy.MXHost,
+			TotalSuccessfulSessionCount: p.Summary.TotalSuccessfulSessionCount,
+			TotalFailureSessionCount:    p.Summary.TotalFailureSessionCount,
+		}
+		entries = append(entries, policy)
+
+		for _, f := range p.FailureDetails {
+			detail := policy
+			detail.EntryType = TLSEntryFailureDetail
+			detail.ResultType = f.ResultType
+			detail.SendingMTAIP = f.SendingMTAIP
+			detail.ReceivingMXHostname = f.ReceivingMXHostname
+			detail.ReceivingMXHelo = f.ReceivingMXHelo
+			detail.ReceivingIP = f.ReceivingIP
+			detail.FailedSessionCount = f.FailedSessionCount
+			detail.AdditionalInformation = f.AdditionalInformation
+			detail.FailureReasonCode = f.FailureReasonCode
+			entries = append(entries, detail)
+		}
+	}
+	return entries
+}
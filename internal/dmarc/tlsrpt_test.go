@@ -0,0 +1,192 @@
+package dmarc
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestIsTLSReport(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		filename  string
+		mediaType string
+		expected  bool
+	}{
+		{filename: "google.com!example.com!1634256000!1634342399!001.json.gz", mediaType: "application/tlsrpt+gzip", expected: true},
+		{filename: "report", mediaType: "application/tlsrpt+json", expected: true},
+		{filename: "google.com!example.com!1634256000!1634342399!001.json.gz", mediaType: "application/gzip", expected: true},
+		{filename: "google.com!example.com!1636416000!1636502399.xml.gz", mediaType: "application/gzip", expected: false},
+		{filename: "google.com!example.com!1636416000!1636502399.zip", mediaType: "application/zip", expected: false},
+		{filename: "report.json.zst", mediaType: "application/octet-stream", expected: true},
+	}
+	for _, tt := range tests {
+		if got := IsTLSReport(tt.filename, tt.mediaType); got != tt.expected {
+			t.Errorf("%s %s: expected %t, got %t", tt.filename, tt.mediaType, tt.expected, got)
+		}
+	}
+}
+
+func TestReadTLSReport(t *testing.T) {
+	t.Parallel()
+
+	content, err := os.ReadFile("testdata/tlsrpt.json")
+	if err != nil {
+		t.Fatalf("could not read test file: %v", err)
+	}
+	zw, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatalf("could not create zstd writer: %v", err)
+	}
+	zstdContent := zw.EncodeAll(content, nil)
+	if err := zw.Close(); err != nil {
+		t.Fatalf("could not close zstd: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		filename  string
+		mediaType string
+		content   []byte
+	}{
+		{name: "json", filename: "report.json", mediaType: "application/tlsrpt+json", content: content},
+		{name: "json without extension", filename: "report", mediaType: "application/octet-stream", content: content},
+		{name: "gzip", filename: "report.json.gz", mediaType: "application/tlsrpt+gzip", content: createGZ(t, content)},
+		{name: "gzip without extension", filename: "report", mediaType: "application/octet-stream", content: createGZ(t, content)},
+		{name: "zstd", filename: "report.json.zst", mediaType: "application/octet-stream", content: zstdContent},
+		{name: "zip", filename: "report.zip", mediaType: "application/zip", content: createZIP(t, archiveFile{name: "report.json", content: content})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			report, err := ReadTLSReport(tt.filename, tt.mediaType, tt.content, Limits{})
+			if err != nil {
+				t.Fatalf("could not read report: %v", err)
+			}
+			if report.OrganizationName != "Company-X" {
+				t.Errorf("invalid organization %q", report.OrganizationName)
+			}
+			if len(report.Policies) != 1 || len(report.Policies[0].FailureDetails) != 3 {
+				t.Fatalf("invalid policies %+v", report.Policies)
+			}
+		})
+	}
+
+	report, err := ReadTLSReport("report.json", "", content, Limits{})
+	if err != nil {
+		t.Fatalf("could not read report: %v", err)
+	}
+	entries := convertTLSReport(*report, ConvertOptions{EventCategory: "mail"})
+	if len(entries) != 4 {
+		t.Fatalf("expected 4 entries, got %d", len(entries))
+	}
+	if entries[0].EntryType != TLSEntryPolicy || entries[0].TotalFailureSessionCount != 303 {
+		t.Errorf("invalid policy entry %+v", entries[0])
+	}
+	detail := entries[2]
+	if detail.EntryType != TLSEntryFailureDetail || detail.ResultType != "starttls-not-supported" ||
+		detail.FailedSessionCount != 200 || detail.PolicyDomain != "company-y.example" || detail.EventCategory != "mail" {
+		t.Errorf("invalid failure detail entry %+v", detail)
+	}
+	if entries[0].DateBegin != 1459468800 {
+		t.Errorf("invalid date begin %d", entries[0].DateBegin)
+	}
+}
+
+func TestReadTLSReportInvalid(t *testing.T) {
+	t.Parallel()
+
+	content, err := os.ReadFile("testdata/tlsrpt.json")
+	if err != nil {
+		t.Fatalf("could not read test file: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		filename string
+		content  []byte
+		limits   Limits
+		expected string
+		limit    bool
+	}{
+		{name: "xml", filename: "report.json", content: []byte("<feedback></feedback>"), expected: "unsupported file type"},
+		{name: "invalid json", filename: "report.json", content: []byte(`{"organization-name": `), expected: "could not parse"},
+		{name: "other json", filename: "report.json", content: []byte(`{"name": "test"}`), expected: "missing organization-name"},
+		{name: "without policies", filename: "report.json", content: []byte(`{"organization-name": "Company-X", "policies": []}`), expected: "missing policies"},
+		{name: "other json inside gzip", filename: "report.json.gz", content: createGZ(t, []byte(`{"name": "test"}`)), expected: "missing organization-name"},
+		{name: "attachment size", filename: "report.json", content: content, limits: Limits{MaxAttachmentSize: 10}, limit: true},
+		{name: "decompressed size", filename: "report.json.gz", content: createGZ(t, content), limits: Limits{MaxDecompressedSize: 100}, limit: true},
+		{
+			name:     "multiple reports",
+			filename: "report.zip",
+			content:  createZIP(t, archiveFile{name: "1.json", content: content}, archiveFile{name: "2.json", content: content}),
+			expected: "more than one tls report",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := ReadTLSReport(tt.filename, "", tt.content, tt.limits)
+			if err == nil {
+				t.Fatal("expected an error but got none")
+			}
+			if tt.limit != errors.Is(err, ErrLimitExceeded) {
+				t.Fatalf("invalid limit error: %v", err)
+			}
+			if !strings.Contains(err.Error(), tt.expected) {
+				t.Fatalf("expected error %q, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestFormatTLS(t *testing.T) {
+	t.Parallel()
+
+	entry := TLSEntry{
+		ReportType:               ReportTypeTLS,
+		EntryType:                TLSEntryFailureDetail,
+		OrganizationName:         "Company-X",
+		ReportID:                 "1234",
+		DateBegin:                1459468800,
+		DateEnd:                  1459555199,
+		PolicyType:               "sts",
+		PolicyDomain:             "company-y.example",
+		TotalFailureSessionCount: 303,
+		ResultType:               "certificate-expired",
+		SendingMTAIP:             "192.0.2.1",
+		ReceivingMXHostname:      "mx1.mail.company-y.example",
+		FailedSessionCount:       100,
+	}
+	header := DeviceHeader{Vendor: "firefart", Product: "dmarcsyslogforwarder", Version: "1.0"}
+
+	expected := "CEF:0|firefart|dmarcsyslogforwarder|1.0|tlsrpt|TLS report failure detail|6|" +
+		"rt=1459555199000 start=1459468800000 end=1459555199000 src=192.0.2.1 dhost=mx1.mail.company-y.example cnt=100 " +
+		"outcome=certificate-expired externalId=1234 cs1Label=policyDomain cs1=company-y.example cs2Label=policyType cs2=sts " +
+		"cs6Label=reportingOrg cs6=Company-X cn1Label=successfulSessions cn1=0 cn2Label=failedSessions cn2=303"
+	if got := string(formatTLSCEF(entry, header, nil)); got != expected {
+		t.Errorf("CEF mismatch\nexpected %s\ngot      %s", expected, got)
+	}
+
+	for _, format := range []string{"xml", "json", "leef", "ecs", "ocsf", "gelf"} {
+		formatter, err := NewFormatter(format, FormatterOptions{Header: header})
+		if err != nil {
+			t.Fatalf("%s: could not create formatter: %v", format, err)
+		}
+		b, err := formatter.FormatTLS(entry)
+		if err != nil {
+			t.Fatalf("%s: could not format tls entry: %v", format, err)
+		}
+		if !strings.Contains(string(b), "certificate-expired") {
+			t.Errorf("%s: output does not contain the result type: %s", format, b)
+		}
+	}
+}
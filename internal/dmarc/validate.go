@@ -0,0 +1,157 @@
+package dmarc
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ValidationMode controls how reports violating report.xsd are handled
+type ValidationMode string
+
+const (
+	// ValidationLenient forwards invalid reports and adds the problems as validation warnings
+	ValidationLenient ValidationMode = "lenient"
+	// ValidationStrict rejects invalid reports
+	ValidationStrict ValidationMode = "strict"
+)
+
+// ErrInvalidReport is returned in strict mode if a report is not valid
+var ErrInvalidReport = errors.New("invalid report")
+
+// ValidationError contains all problems of an invalid report
+type ValidationError struct {
+	Warnings []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("report is not valid: %s", strings.Join(e.Warnings, "; "))
+}
+
+func (e *ValidationError) Unwrap() error {
+	return ErrInvalidReport
+}
+
+// allowed values of report.xsd. The values added by DMARCbis are also allowed.
+var (
+	validAlignments      = []string{"r", "s"}
+	validDispositions    = []string{"none", "quarantine", "reject"}
+	validEvaluated       = []string{"none", "pass", "quarantine", "reject"} // DMARCbis adds pass
+	validDMARCResults    = []string{"pass", "fail"}
+	validOverrideReasons = []string{"forwarded", "sampled_out", "trusted_forwarder", "mailing_list", "local_policy", "other", "policy_test_mode"}
+	validDKIMResults     = []string{"none", "pass", "fail", "policy", "neutral", "temperror", "permerror"}
+	validSPFScopes       = []string{"helo", "mfrom"}
+	validSPFResults      = []string{"none", "neutral", "pass", "fail", "softfail", "temperror", "permerror"}
+	validTesting         = []string{"y", "n"}
+	validDiscovery       = []string{"psl", "treewalk"}
+)
+
+// allowedClockSkew is the time a report may end in the future
+const allowedClockSkew = 24 * time.Hour
+
+// validator collects the validation warnings
+type validator struct {
+	warnings []string
+}
+
+func (v *validator) required(name, value string) {
+	if strings.TrimSpace(value) == "" {
+		v.warnings = append(v.warnings, fmt.Sprintf("%s: missing", name))
+	}
+}
+
+// enum checks the value against the allowed values. Empty values are
+// not checked, use required for mandatory elements.
+func (v *validator) enum(name, value string, allowed []string) {
+	if value != "" && !slices.Contains(allowed, value) {
+		v.warnings = append(v.warnings, fmt.Sprintf("%s: invalid value %q", name, value))
+	}
+}
+
+func (v *validator) add(format string, a ...any) {
+	v.warnings = append(v.warnings, fmt.Sprintf(format, a...))
+}
+
+// validateReport checks all elements of the report except the records
+func validateReport(report *XMLReport, now time.Time) []string {
+	var v validator
+
+	m := report.ReportMetadata
+	v.required("report_metadata.org_name", m.OrgName)
+	v.required("report_metadata.email", m.Email)
+	v.required("report_metadata.report_id", m.ReportID)
+	begin, end := m.DateRange.Begin, m.DateRange.End
+	switch {
+	case begin <= 0 || end <= 0:
+		v.add("report_metadata.date_range: missing")
+	case begin > end:
+		v.add("report_metadata.date_range: begin %d is after end %d", begin, end)
+	case time.Unix(end, 0).After(now.Add(allowedClockSkew)):
+		v.add("report_metadata.date_range: end %d is in the future", end)
+	}
+
+	p := report.PolicyPublished
+	v.required("policy_published.domain", p.Domain)
+	v.required("policy_published.p", p.P)
+	v.enum("policy_published.p", p.P, validDispositions)
+	v.enum("policy_published.sp", p.Sp, validDispositions)
+	v.enum("policy_published.np", p.Np, validDispositions)
+	v.enum("policy_published.adkim", p.Adkim, validAlignments)
+	v.enum("policy_published.aspf", p.Aspf, validAlignments)
+	v.enum("policy_published.testing", p.Testing, validTesting)
+	v.enum("policy_published.discovery_method", p.DiscoveryMethod, validDiscovery)
+	if p.Pct != "" {
+		if pct, err := strconv.Atoi(p.Pct); err != nil || pct < 0 || pct > 100 {
+			v.add("policy_published.pct: invalid value %q", p.Pct)
+		}
+	}
+
+	return v.warnings
+}
+
+// validateRecord checks a single record of the report
+func validateRecord(record Record) []string {
+	var v validator
+
+	row := record.Row
+	if _, err := netip.ParseAddr(row.SourceIP); err != nil {
+		v.add("row.source_ip: invalid ip %q", row.SourceIP)
+	}
+	if row.Count < 0 {
+		v.add("row.count: invalid value %d", row.Count)
+	}
+	pe := row.PolicyEvaluated
+	v.required("row.policy_evaluated.disposition", pe.Disposition)
+	v.enum("row.policy_evaluated.disposition", pe.Disposition, validEvaluated)
+	v.required("row.policy_evaluated.dkim", pe.Dkim)
+	v.enum("row.policy_evaluated.dkim", pe.Dkim, validDMARCResults)
+	v.required("row.policy_evaluated.spf", pe.Spf)
+	v.enum("row.policy_evaluated.spf", pe.Spf, validDMARCResults)
+	for _, r := range pe.Reason {
+		v.enum("row.policy_evaluated.reason.type", r.Type, validOverrideReasons)
+	}
+
+	v.required("identifiers.header_from", record.Identifiers.HeaderFrom)
+
+	for _, r := range record.AuthResults.Dkim {
+		v.required("auth_results.dkim.domain", r.Domain)
+		v.required("auth_results.dkim.result", r.Result)
+		v.enum("auth_results.dkim.result", r.Result, validDKIMResults)
+	}
+	if len(record.AuthResults.Spf) == 0 {
+		v.add("auth_results.spf: missing")
+	}
+	for _, r := range record.AuthResults.Spf {
+		v.required("auth_results.spf.domain", r.Domain)
+		// the scope was removed in DMARCbis
+		v.enum("auth_results.spf.scope", r.Scope, validSPFScopes)
+		v.required("auth_results.spf.result", r.Result)
+		v.enum("auth_results.spf.result", r.Result, validSPFResults)
+	}
+
+	return v.warnings
+}
@@ -0,0 +1,190 @@
+package dmarc
+
+import (
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+const validReport = `<feedback>
+<version>1.0</version>
+<report_metadata>
+<org_name>example.net</org_name>
+<email>dmarc@example.net</email>
+<report_id>1</report_id>
+<date_range><begin>1700000000</begin><end>1700086399</end></date_range>
+</report_metadata>
+<policy_published><domain>example.com</domain><adkim>r</adkim><aspf>r</aspf><p>reject</p><sp>reject</sp><pct>100</pct></policy_published>
+<record>
+<row><source_ip>192.0.2.1</source_ip><count>1</count><policy_evaluated><disposition>none</disposition><dkim>pass</dkim><spf>pass</spf></policy_evaluated></row>
+<identifiers><header_from>example.com</header_from></identifiers>
+<auth_results>
+<dkim><domain>example.com</domain><result>pass</result></dkim>
+<spf><domain>example.com</domain><scope>mfrom</scope><result>pass</result></spf>
+</auth_results>
+</record>
+</feedback>`
+
+func TestValidateReport(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1700100000, 0)
+	tests := []struct {
+		name     string
+		modify   func(r *XMLReport)
+		expected []string
+	}{
+		{name: "valid", modify: func(_ *XMLReport) {}},
+		{
+			name:     "missing org name",
+			modify:   func(r *XMLReport) { r.ReportMetadata.OrgName = "" },
+			expected: []string{"report_metadata.org_name: missing"},
+		},
+		{
+			name:     "begin after end",
+			modify:   func(r *XMLReport) { r.ReportMetadata.DateRange.Begin = 1700086400 },
+			expected: []string{"report_metadata.date_range: begin 1700086400 is after end 1700086399"},
+		},
+		{
+			name:     "end in the future",
+			modify:   func(r *XMLReport) { r.ReportMetadata.DateRange.End = 1800000000 },
+			expected: []string{"report_metadata.date_range: end 1800000000 is in the future"},
+		},
+		{
+			name:     "invalid policy",
+			modify:   func(r *XMLReport) { r.PolicyPublished.P = "block" },
+			expected: []string{`policy_published.p: invalid value "block"`},
+		},
+		{
+			name:     "invalid pct",
+			modify:   func(r *XMLReport) { r.PolicyPublished.Pct = "150" },
+			expected: []string{`policy_published.pct: invalid value "150"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			f, err := newReportFile("report.xml", strings.NewReader(validReport), ReadOptions{})
+			if err != nil {
+				t.Fatalf("could not read report: %v", err)
+			}
+			tt.modify(f.Report)
+			if got := validateReport(f.Report, now); !slices.Equal(got, tt.expected) {
+				t.Fatalf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestValidateRecord(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		replace  [2]string
+		expected []string
+	}{
+		{name: "valid"},
+		{
+			name:     "invalid ip",
+			replace:  [2]string{"192.0.2.1", "192.0.2"},
+			expected: []string{`row.source_ip: invalid ip "192.0.2"`},
+		},
+		{
+			name:     "invalid disposition",
+			replace:  [2]string{"<disposition>none", "<disposition>drop"},
+			expected: []string{`row.policy_evaluated.disposition: invalid value "drop"`},
+		},
+		{
+			name:     "invalid dkim result",
+			replace:  [2]string{"<dkim>pass", "<dkim>ok"},
+			expected: []string{`row.policy_evaluated.dkim: invalid value "ok"`},
+		},
+		{
+			name:     "invalid spf scope",
+			replace:  [2]string{"mfrom", "from"},
+			expected: []string{`auth_results.spf.scope: invalid value "from"`},
+		},
+		{
+			name:     "missing spf result",
+			replace:  [2]string{"<spf><domain>example.com</domain><scope>mfrom</scope><result>pass</result></spf>", ""},
+			expected: []string{"auth_results.spf: missing"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			content := validReport
+			if tt.replace[0] != "" {
+				content = strings.Replace(content, tt.replace[0], tt.replace[1], 1)
+			}
+			f, err := newReportFile("report.xml", strings.NewReader(content), ReadOptions{})
+			if err != nil {
+				t.Fatalf("could not read report: %v", err)
+			}
+			record, err := f.NextRecord()
+			if err != nil {
+				t.Fatalf("could not read record: %v", err)
+			}
+			if got := validateRecord(*record); !slices.Equal(got, tt.expected) {
+				t.Fatalf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestValidationModes(t *testing.T) {
+	t.Parallel()
+
+	invalid := strings.Replace(validReport, "192.0.2.1", "invalid", 1)
+
+	// lenient mode forwards the record with warnings
+	f, err := newReportFile("report.xml", strings.NewReader(invalid), ReadOptions{Validation: ValidationLenient})
+	if err != nil {
+		t.Fatalf("could not read report: %v", err)
+	}
+	record, err := f.NextRecord()
+	if err != nil {
+		t.Fatalf("could not read record: %v", err)
+	}
+	if !slices.Equal(record.ValidationWarnings, []string{`row.source_ip: invalid ip "invalid"`}) {
+		t.Fatalf("invalid validation warnings %q", record.ValidationWarnings)
+	}
+	if _, err := f.NextRecord(); !errors.Is(err, io.EOF) {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+
+	// strict mode rejects the record
+	f, err = newReportFile("report.xml", strings.NewReader(invalid), ReadOptions{Validation: ValidationStrict})
+	if err != nil {
+		t.Fatalf("could not read report: %v", err)
+	}
+	_, err = f.NextRecord()
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) || !errors.Is(err, ErrInvalidReport) {
+		t.Fatalf("expected a validation error, got %v", err)
+	}
+
+	// strict mode rejects invalid report elements before the first record
+	noOrg := strings.Replace(validReport, "<org_name>example.net</org_name>", "", 1)
+	if _, err := newReportFile("report.xml", strings.NewReader(noOrg), ReadOptions{Validation: ValidationStrict}); !errors.Is(err, ErrInvalidReport) {
+		t.Fatalf("expected a validation error, got %v", err)
+	}
+
+	// CheckFile rejects the report if any record is invalid
+	xmlRecord := validReport[strings.Index(validReport, "<record>") : strings.Index(validReport, "</record>")+len("</record>")]
+	threeRecords := strings.Replace(validReport, xmlRecord, xmlRecord+strings.Replace(xmlRecord, "192.0.2.1", "invalid", 1)+xmlRecord, 1)
+	if err := CheckFile("report.xml", "", []byte(threeRecords), ReadOptions{Validation: ValidationStrict}); !errors.As(err, &validationErr) {
+		t.Fatalf("expected a validation error, got %v", err)
+	}
+	if err := CheckFile("report.xml", "", []byte(threeRecords), ReadOptions{Validation: ValidationLenient}); err != nil {
+		t.Fatalf("expected no error in lenient mode, got %v", err)
+	}
+}
@@ -1,8 +1,12 @@
 package dmarc
 
+import "encoding/xml"
+
 // XMLReport represents the top element of a DMARC report
 // https://tools.ietf.org/html/rfc7489#appendix-C
 // also see report.xsd in this repository
+// The fields added by DMARCbis are optional so RFC 7489 reports are still
+// supported https://datatracker.ietf.org/doc/draft-ietf-dmarc-aggregate-reporting/
 type XMLReport struct {
 	Version        string `xml:"version"`
 	ReportMetadata struct {
@@ -14,18 +18,35 @@ type XMLReport struct {
 			Begin int64 `xml:"begin"`
 			End   int64 `xml:"end"`
 		} `xml:"date_range"`
-		Error []string `xml:"error"`
+		Error     []string `xml:"error"`
+		Generator string   `xml:"generator"` // DMARCbis
 	} `xml:"report_metadata" `
 	PolicyPublished struct {
-		Domain string `xml:"domain"`
-		Adkim  string `xml:"adkim"`
-		Aspf   string `xml:"aspf"`
-		P      string `xml:"p"`
-		Sp     string `xml:"sp"`
-		Pct    string `xml:"pct"`
-		Fo     string `xml:"fo" `
+		Domain          string `xml:"domain"`
+		Adkim           string `xml:"adkim"`
+		Aspf            string `xml:"aspf"`
+		P               string `xml:"p"`
+		Sp              string `xml:"sp"`
+		Pct             string `xml:"pct"` // removed in DMARCbis
+		Fo              string `xml:"fo" `
+		Np              string `xml:"np"`               // DMARCbis
+		Testing         string `xml:"testing"`          // DMARCbis
+		DiscoveryMethod string `xml:"discovery_method"` // DMARCbis
 	} `xml:"policy_published"`
-	Records []Record `xml:"record"`
+	Extensions Extensions `xml:"extensions"` // DMARCbis
+	Records    []Record   `xml:"record"`
+}
+
+// Extensions contains all elements of an extensions element
+type Extensions struct {
+	Extension []Extension `xml:",any"`
+}
+
+// Extension represents a single report extension. As the content
+// is not specified it's kept as raw XML.
+type Extension struct {
+	XMLName  xml.Name
+	InnerXML string `xml:",innerxml"`
 }
 
 // Record represents the record element of a DMARC report
@@ -45,19 +66,31 @@ type Record struct {
 		HeaderFrom   string `xml:"header_from"`
 		EnvelopeFrom string `xml:"envelope_from"`
 	} `xml:"identifiers"`
+	// a record can contain multiple DKIM and SPF results
 	AuthResults struct {
-		Spf struct {
-			Domain string `xml:"domain"`
-			Scope  string `xml:"scope"`
-			Result string `xml:"result"`
-		} `xml:"spf"`
-		Dkim struct {
-			Domain      string `xml:"domain"`
-			Selector    string `xml:"selector"`
-			Result      string `xml:"result"`
-			HumanResult string `xml:"human_result"`
-		} `xml:"dkim"`
+		Spf  []SPFAuthResult  `xml:"spf"`
+		Dkim []DKIMAuthResult `xml:"dkim"`
 	} `xml:"auth_results"`
+	Extensions Extensions `xml:"extensions"` // DMARCbis
+	// ValidationWarnings contains the problems of the record and the report
+	// found by the validation, it's not part of the XML
+	ValidationWarnings []string `xml:"-"`
+}
+
+// SPFAuthResult represents the spf element of the auth_results
+type SPFAuthResult struct {
+	Domain      string `xml:"domain"`
+	Scope       string `xml:"scope"`
+	Result      string `xml:"result"`
+	HumanResult string `xml:"human_result"` // DMARCbis
+}
+
+// DKIMAuthResult represents the dkim element of the auth_results
+type DKIMAuthResult struct {
+	Domain      string `xml:"domain"`
+	Selector    string `xml:"selector"`
+	Result      string `xml:"result"`
+	HumanResult string `xml:"human_result"`
 }
 
 // PolicyOverrideReason represents the reason element of a DMARC report
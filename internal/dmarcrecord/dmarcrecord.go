@@ -0,0 +1,238 @@
+// Package dmarcrecord looks up and parses the published DMARC record
+// (RFC 7489 section 6.3) of a domain.
+package dmarcrecord
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNoRecord is returned if the domain does not publish a DMARC record
+var ErrNoRecord = errors.New("no dmarc record found")
+
+// Resolver is used for the TXT lookups. Not existing names should return a
+// *net.DNSError with IsNotFound set.
+type Resolver interface {
+	LookupTXT(domain string) ([]string, error)
+}
+
+// Record is a parsed DMARC record. Missing optional tags contain their
+// default values, so the record reflects the effective policy.
+type Record struct {
+	Raw   string
+	P     string
+	SP    string
+	NP    string
+	Pct   int
+	ADKIM string
+	ASPF  string
+	FO    string
+	RUA   []string
+	RUF   []string
+}
+
+// Parse parses the TXT record
+func Parse(txt string) (Record, error) {
+	r := Record{Raw: txt, Pct: 100, ADKIM: "r", ASPF: "r", FO: "0"}
+	tags := make(map[string]string)
+	for i, part := range strings.Split(txt, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		key, value, found := strings.Cut(part, "=")
+		if !found {
+			return r, fmt.Errorf("invalid tag %q", part)
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+		if i == 0 && (key != "v" || !strings.EqualFold(value, "DMARC1")) {
+			return r, errors.New("record does not start with v=DMARC1")
+		}
+		// the first occurrence of a tag is used
+		if _, ok := tags[key]; !ok {
+			tags[key] = value
+		}
+	}
+	if len(tags) == 0 {
+		return r, errors.New("empty record")
+	}
+
+	r.RUA = splitURIs(tags["rua"])
+	r.RUF = splitURIs(tags["ruf"])
+
+	var ok bool
+	var err error
+	if r.P, ok = tags["p"]; !ok {
+		// a record without a policy but with rua is treated as p=none
+		// (RFC 7489 section 6.6.3)
+		if len(r.RUA) == 0 {
+			return r, errors.New("missing p tag")
+		}
+		r.P = "none"
+	}
+	r.P = strings.ToLower(r.P)
+	if !validPolicy(r.P) {
+		return r, fmt.Errorf("invalid p tag %q", r.P)
+	}
+	r.SP = r.P
+	if sp, ok := tags["sp"]; ok {
+		r.SP = strings.ToLower(sp)
+		if !validPolicy(r.SP) {
+			return r, fmt.Errorf("invalid sp tag %q", sp)
+		}
+	}
+	// np is defined by DMARCbis and defaults to sp
+	r.NP = r.SP
+	if np, ok := tags["np"]; ok {
+		r.NP = strings.ToLower(np)
+		if !validPolicy(r.NP) {
+			return r, fmt.Errorf("invalid np tag %q", np)
+		}
+	}
+	if pct, ok := tags["pct"]; ok {
+		n, err := strconv.Atoi(pct)
+		if err != nil || n < 0 || n > 100 {
+			return r, fmt.Errorf("invalid pct tag %q", pct)
+		}
+		r.Pct = n
+	}
+	if r.ADKIM, err = alignment(tags, "adkim"); err != nil {
+		return r, err
+	}
+	if r.ASPF, err = alignment(tags, "aspf"); err != nil {
+		return r, err
+	}
+	if fo, ok := tags["fo"]; ok {
+		r.FO = fo
+	}
+	return r, nil
+}
+
+func validPolicy(p string) bool {
+	return p == "none" || p == "quarantine" || p == "reject"
+}
+
+func alignment(tags map[string]string, key string) (string, error) {
+	value, ok := tags[key]
+	if !ok {
+		return "r", nil
+	}
+	value = strings.ToLower(value)
+	if value != "r" && value != "s" {
+		return "", fmt.Errorf("invalid %s tag %q", key, value)
+	}
+	return value, nil
+}
+
+func splitURIs(s string) []string {
+	var ret []string
+	for _, uri := range strings.Split(s, ",") {
+		if uri = strings.TrimSpace(uri); uri != "" {
+			ret = append(ret, uri)
+		}
+	}
+	return ret
+}
+
+// ListsReportAddress checks if the email address is one of the aggregate
+// report URIs. The mailto scheme and the optional size limit (RFC 7489
+// section 6.2) are ignored.
+func (r Record) ListsReportAddress(address string) bool {
+	for _, uri := range r.RUA {
+		scheme, addr, found := strings.Cut(uri, ":")
+		if !found || !strings.EqualFold(scheme, "mailto") {
+			continue
+		}
+		addr, _, _ = strings.Cut(addr, "!")
+		if strings.EqualFold(strings.TrimSpace(addr), strings.TrimSpace(address)) {
+			return true
+		}
+	}
+	return false
+}
+
+// isDMARCRecord checks if the TXT record is a DMARC record
+func isDMARCRecord(txt string) bool {
+	first, _, _ := strings.Cut(txt, ";")
+	key, value, found := strings.Cut(first, "=")
+	return found && strings.TrimSpace(key) == "v" && strings.EqualFold(strings.TrimSpace(value), "DMARC1")
+}
+
+type cacheEntry struct {
+	record    Record
+	err       error
+	timestamp time.Time
+}
+
+// Fetcher looks up DMARC records and caches the results
+type Fetcher struct {
+	resolver     Resolver
+	cacheTimeout time.Duration
+	mutex        sync.Mutex
+	cache        map[string]cacheEntry
+}
+
+// NewFetcher creates a fetcher using the resolver
+func NewFetcher(resolver Resolver, cacheTimeout time.Duration) *Fetcher {
+	return &Fetcher{
+		resolver:     resolver,
+		cacheTimeout: cacheTimeout,
+		cache:        make(map[string]cacheEntry),
+	}
+}
+
+// Lookup returns the DMARC record published at _dmarc.<domain>. ErrNoRecord
+// is returned if the domain does not publish a record.
+func (f *Fetcher) Lookup(domain string) (Record, error) {
+	domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+	f.mutex.Lock()
+	if entry, ok := f.cache[domain]; ok && time.Since(entry.timestamp) < f.cacheTimeout {
+		f.mutex.Unlock()
+		return entry.record, entry.err
+	}
+	f.mutex.Unlock()
+
+	record, temporary, err := f.lookup(domain)
+	// temporary errors are retried on the next lookup
+	if !temporary {
+		f.mutex.Lock()
+		f.cache[domain] = cacheEntry{record: record, err: err, timestamp: time.Now()}
+		f.mutex.Unlock()
+	}
+	return record, err
+}
+
+func (f *Fetcher) lookup(domain string) (Record, bool, error) {
+	txt, err := f.resolver.LookupTXT("_dmarc." + domain)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return Record{}, false, ErrNoRecord
+		}
+		return Record{}, true, fmt.Errorf("could not lookup dmarc record of %s: %w", domain, err)
+	}
+	var records []string
+	for _, t := range txt {
+		if isDMARCRecord(t) {
+			records = append(records, t)
+		}
+	}
+	switch len(records) {
+	case 0:
+		return Record{}, false, ErrNoRecord
+	case 1:
+	default:
+		return Record{}, false, fmt.Errorf("multiple dmarc records found for %s", domain)
+	}
+	record, err := Parse(records[0])
+	if err != nil {
+		return Record{}, false, fmt.Errorf("invalid dmarc record of %s: %w", domain, err)
+	}
+	return record, false, nil
+}
@@ -0,0 +1,180 @@
+package dmarcrecord
+
+import (
+	"errors"
+	"net"
+	"slices"
+	"testing"
+	"time"
+)
+
+// fakeResolver answers from static records
+type fakeResolver struct {
+	txt     map[string][]string
+	fail    map[string]bool
+	queries int
+}
+
+func (f *fakeResolver) LookupTXT(domain string) ([]string, error) {
+	f.queries++
+	if f.fail[domain] {
+		return nil, &net.DNSError{Err: "timeout", Name: domain, IsTimeout: true}
+	}
+	records, ok := f.txt[domain]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: domain, IsNotFound: true}
+	}
+	return records, nil
+}
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		txt      string
+		expected Record
+		wantErr  bool
+	}{
+		{
+			name:     "defaults",
+			txt:      "v=DMARC1; p=reject",
+			expected: Record{P: "reject", SP: "reject", NP: "reject", Pct: 100, ADKIM: "r", ASPF: "r", FO: "0"},
+		},
+		{
+			name: "all tags",
+			txt:  "v=DMARC1;p=Quarantine;sp=none;np=reject;pct=50;adkim=s;aspf=S;fo=1;rua=mailto:a@example.com, mailto:b@example.com;ruf=mailto:f@example.com",
+			expected: Record{
+				P: "quarantine", SP: "none", NP: "reject", Pct: 50, ADKIM: "s", ASPF: "s", FO: "1",
+				RUA: []string{"mailto:a@example.com", "mailto:b@example.com"},
+				RUF: []string{"mailto:f@example.com"},
+			},
+		},
+		{
+			name:     "np defaults to sp",
+			txt:      "v=DMARC1; p=reject; sp=quarantine;",
+			expected: Record{P: "reject", SP: "quarantine", NP: "quarantine", Pct: 100, ADKIM: "r", ASPF: "r", FO: "0"},
+		},
+		{
+			name:     "missing policy with rua",
+			txt:      "v=DMARC1; rua=mailto:a@example.com",
+			expected: Record{P: "none", SP: "none", NP: "none", Pct: 100, ADKIM: "r", ASPF: "r", FO: "0", RUA: []string{"mailto:a@example.com"}},
+		},
+		{
+			name:     "first tag wins",
+			txt:      "v=DMARC1; p=none; p=reject",
+			expected: Record{P: "none", SP: "none", NP: "none", Pct: 100, ADKIM: "r", ASPF: "r", FO: "0"},
+		},
+		{name: "missing policy", txt: "v=DMARC1; pct=100", wantErr: true},
+		{name: "wrong version", txt: "v=DMARC2; p=none", wantErr: true},
+		{name: "version not first", txt: "p=none; v=DMARC1", wantErr: true},
+		{name: "invalid policy", txt: "v=DMARC1; p=block", wantErr: true},
+		{name: "invalid sp", txt: "v=DMARC1; p=none; sp=block", wantErr: true},
+		{name: "invalid pct", txt: "v=DMARC1; p=none; pct=101", wantErr: true},
+		{name: "invalid alignment", txt: "v=DMARC1; p=none; adkim=x", wantErr: true},
+		{name: "invalid tag", txt: "v=DMARC1; p", wantErr: true},
+		{name: "empty", txt: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			r, err := Parse(tt.txt)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %+v", r)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if r.Raw != tt.txt {
+				t.Errorf("expected raw record %q, got %q", tt.txt, r.Raw)
+			}
+			if r.P != tt.expected.P || r.SP != tt.expected.SP || r.NP != tt.expected.NP || r.Pct != tt.expected.Pct ||
+				r.ADKIM != tt.expected.ADKIM || r.ASPF != tt.expected.ASPF || r.FO != tt.expected.FO ||
+				!slices.Equal(r.RUA, tt.expected.RUA) || !slices.Equal(r.RUF, tt.expected.RUF) {
+				t.Errorf("expected %+v, got %+v", tt.expected, r)
+			}
+		})
+	}
+}
+
+func TestListsReportAddress(t *testing.T) {
+	t.Parallel()
+
+	r, err := Parse("v=DMARC1; p=none; rua=mailto:dmarc@example.com!10m, MAILTO:Reports@Example.NET, https://example.org/report")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tests := []struct {
+		address  string
+		expected bool
+	}{
+		{address: "dmarc@example.com", expected: true},
+		{address: "reports@example.net", expected: true},
+		{address: "other@example.com", expected: false},
+		{address: "example.org/report", expected: false},
+		{address: "", expected: false},
+	}
+	for _, tt := range tests {
+		if got := r.ListsReportAddress(tt.address); got != tt.expected {
+			t.Errorf("ListsReportAddress(%q): expected %t, got %t", tt.address, tt.expected, got)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	t.Parallel()
+
+	fake := &fakeResolver{
+		txt: map[string][]string{
+			"_dmarc.example.com": {"some other record", "v=DMARC1; p=reject; rua=mailto:dmarc@example.com"},
+			"_dmarc.example.net": {"v=DMARC1; p=none", "v=DMARC1; p=reject"},
+			"_dmarc.example.org": {"v=spf1 -all"},
+			"_dmarc.invalid.com": {"v=DMARC1; p=block"},
+		},
+		fail: map[string]bool{"_dmarc.timeout.com": true},
+	}
+	f := NewFetcher(fake, time.Hour)
+
+	r, err := f.Lookup("Example.COM.")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if r.P != "reject" || len(r.RUA) != 1 || r.RUA[0] != "mailto:dmarc@example.com" {
+		t.Fatalf("invalid record %+v", r)
+	}
+
+	if _, err := f.Lookup("example.net"); err == nil || errors.Is(err, ErrNoRecord) {
+		t.Fatalf("expected an error on multiple records, got %v", err)
+	}
+	if _, err := f.Lookup("example.org"); !errors.Is(err, ErrNoRecord) {
+		t.Fatalf("expected ErrNoRecord without a dmarc record, got %v", err)
+	}
+	if _, err := f.Lookup("missing.com"); !errors.Is(err, ErrNoRecord) {
+		t.Fatalf("expected ErrNoRecord on a missing name, got %v", err)
+	}
+	if _, err := f.Lookup("invalid.com"); err == nil {
+		t.Fatal("expected an error on an invalid record")
+	}
+
+	// all results above are cached
+	queries := fake.queries
+	for _, d := range []string{"example.com", "example.net", "example.org", "missing.com", "invalid.com"} {
+		_, _ = f.Lookup(d)
+	}
+	if fake.queries != queries {
+		t.Fatalf("expected cached results, got %d new queries", fake.queries-queries)
+	}
+
+	// temporary errors are not cached
+	for range 2 {
+		if _, err := f.Lookup("timeout.com"); err == nil || errors.Is(err, ErrNoRecord) {
+			t.Fatalf("expected a lookup error, got %v", err)
+		}
+	}
+	if fake.queries != queries+2 {
+		t.Fatalf("expected temporary errors to be retried, got %d queries", fake.queries-queries)
+	}
+}
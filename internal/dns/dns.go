@@ -4,21 +4,38 @@ import (
 	"context"
 	"log/slog"
 	"net"
+	"net/netip"
 	"strings"
 	"sync"
 	"time"
 )
 
+// Name is a PTR name of an IP address
+type Name struct {
+	Name string
+	// Verified is set if the name resolves back to the IP address
+	// (forward-confirmed reverse DNS)
+	Verified bool
+}
+
 type cacheEntry struct {
-	domains   []string
+	names     []Name
 	timestamp time.Time
 }
 
+// resolver contains the used methods of net.Resolver
+type resolver interface {
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+}
+
 type CachedDNSResolver struct {
 	ctx          context.Context
 	timeout      time.Duration
 	cacheTimeout time.Duration
-	resolver     *net.Resolver
+	resolver     resolver
 	mutex        sync.RWMutex
 	dnsCache     map[string]cacheEntry
 	logger       *slog.Logger
@@ -50,6 +67,21 @@ func NewCachedDNSResolver(ctx context.Context, server string, connectTimeout, ti
 // CachedDNSLookup performs a DNS lookup and caches the result to
 // not hammer your DNS server.
 func (r *CachedDNSResolver) CachedDNSLookup(ip string) ([]string, error) {
+	names, err := r.CachedVerifiedDNSLookup(ip)
+	if err != nil {
+		return nil, err
+	}
+	domains := make([]string, len(names))
+	for i, n := range names {
+		domains[i] = n.Name
+	}
+	return domains, nil
+}
+
+// CachedVerifiedDNSLookup performs a DNS lookup and verifies every returned
+// name by resolving its A and AAAA records. As anybody can set the PTR
+// records of their own IP space only verified names can be trusted.
+func (r *CachedDNSResolver) CachedVerifiedDNSLookup(ip string) ([]Name, error) {
 	r.logger.Debug("resolving dns", slog.String("ip", ip))
 	val := r.getCacheEntry(ip)
 	if val != nil {
@@ -62,29 +94,110 @@ func (r *CachedDNSResolver) CachedDNSLookup(ip string) ([]string, error) {
 	domains, err := r.resolver.LookupAddr(ctx, ip)
 	if err != nil {
 		// store dummy entry so we do not reresolve the ip
-		r.updateCache(ip, []string{})
+		r.updateCache(ip, []Name{})
 		return nil, err
 	}
 
-	// remove trailing dot from domains
-	for i := range domains {
-		domains[i] = strings.TrimSuffix(domains[i], ".")
+	names := make([]Name, len(domains))
+	for i, domain := range domains {
+		// remove trailing dot from domains
+		domain = strings.TrimSuffix(domain, ".")
+		names[i] = Name{Name: domain, Verified: r.forwardConfirmed(ip, domain)}
 	}
-	r.updateCache(ip, domains)
-	return domains, nil
+	r.updateCache(ip, names)
+	return names, nil
+}
+
+// forwardConfirmed checks if the name resolves to the ip
+func (r *CachedDNSResolver) forwardConfirmed(ip, name string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(r.ctx, r.timeout)
+	defer cancel()
+
+	ips, err := r.resolver.LookupIPAddr(ctx, name)
+	if err != nil {
+		r.logger.Debug("could not verify dns name", slog.String("ip", ip), slog.String("name", name), slog.String("err", err.Error()))
+		return false
+	}
+	for _, x := range ips {
+		if a, ok := netip.AddrFromSlice(x.IP); ok && a.Unmap() == addr.Unmap() {
+			return true
+		}
+	}
+	return false
+}
+
+// LookupTXT returns the TXT records of the domain. Unlike the PTR lookups the
+// result is not cached.
+func (r *CachedDNSResolver) LookupTXT(domain string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(r.ctx, r.timeout)
+	defer cancel()
+	return r.resolver.LookupTXT(ctx, domain)
+}
+
+// LookupIP returns the IPv4 and IPv6 addresses of the host
+func (r *CachedDNSResolver) LookupIP(host string) ([]netip.Addr, error) {
+	ctx, cancel := context.WithTimeout(r.ctx, r.timeout)
+	defer cancel()
+	ips, err := r.resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]netip.Addr, 0, len(ips))
+	for _, x := range ips {
+		if a, ok := netip.AddrFromSlice(x.IP); ok {
+			ret = append(ret, a.Unmap())
+		}
+	}
+	return ret, nil
+}
+
+// LookupMX returns the hosts of the MX records of the domain without the
+// trailing dot
+func (r *CachedDNSResolver) LookupMX(domain string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(r.ctx, r.timeout)
+	defer cancel()
+	mx, err := r.resolver.LookupMX(ctx, domain)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]string, len(mx))
+	for i, x := range mx {
+		ret[i] = strings.TrimSuffix(x.Host, ".")
+	}
+	return ret, nil
+}
+
+// LookupAddr returns the PTR names of the ip without the trailing dot.
+// Unlike CachedDNSLookup the names are not verified and not cached.
+func (r *CachedDNSResolver) LookupAddr(ip string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(r.ctx, r.timeout)
+	defer cancel()
+	names, err := r.resolver.LookupAddr(ctx, ip)
+	if err != nil {
+		return nil, err
+	}
+	for i := range names {
+		names[i] = strings.TrimSuffix(names[i], ".")
+	}
+	return names, nil
 }
 
-func (r *CachedDNSResolver) updateCache(ip string, domains []string) {
+func (r *CachedDNSResolver) updateCache(ip string, names []Name) {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 	entry := cacheEntry{
-		domains:   domains,
+		names:     names,
 		timestamp: time.Now(),
 	}
 	r.dnsCache[ip] = entry
 }
 
-func (r *CachedDNSResolver) getCacheEntry(ip string) []string {
+func (r *CachedDNSResolver) getCacheEntry(ip string) []Name {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 	if val, ok := r.dnsCache[ip]; ok {
@@ -95,7 +208,7 @@ func (r *CachedDNSResolver) getCacheEntry(ip string) []string {
 			delete(r.dnsCache, ip)
 			return nil
 		}
-		return val.domains
+		return val.names
 	}
 	return nil
 }
@@ -1,7 +1,10 @@
 package dns
 
 import (
+	"context"
+	"errors"
 	"log/slog"
+	"net"
 	"testing"
 	"time"
 )
@@ -13,7 +16,7 @@ func TestGetCacheEntry(t *testing.T) {
 	logger := slog.New(slog.DiscardHandler)
 
 	dns := NewCachedDNSResolver(t.Context(), "8.8.8.8:53", 1*time.Second, 10*time.Second, 1*time.Microsecond, logger)
-	dns.updateCache("1.1.1.1", []string{"asdf.com", "ghjkl.com"})
+	dns.updateCache("1.1.1.1", []Name{{Name: "asdf.com"}, {Name: "ghjkl.com"}})
 	time.Sleep(1 * time.Microsecond)
 	res := dns.getCacheEntry("1.1.1.1")
 	if res != nil {
@@ -21,7 +24,7 @@ func TestGetCacheEntry(t *testing.T) {
 	}
 
 	dns = NewCachedDNSResolver(t.Context(), "8.8.8.8:53", 1*time.Second, 10*time.Second, 1*time.Hour, logger)
-	dns.updateCache("1.1.1.1", []string{"asdf.com", "ghjkl.com"})
+	dns.updateCache("1.1.1.1", []Name{{Name: "asdf.com"}, {Name: "ghjkl.com"}})
 	res = dns.getCacheEntry("1.1.1.1")
 	if res == nil {
 		t.Fatal("cache expired and should not be")
@@ -29,7 +32,141 @@ func TestGetCacheEntry(t *testing.T) {
 	if len(res) != 2 {
 		t.Fatalf("wrong cache size returned: %d", len(res))
 	}
-	if res[0] != "asdf.com" && res[1] != "ghjkl.com" {
+	if res[0].Name != "asdf.com" && res[1].Name != "ghjkl.com" {
 		t.Fatalf("wrong domains returned, got %v", res)
 	}
 }
+
+// fakeResolver answers from static records
+type fakeResolver struct {
+	ptr     map[string][]string
+	hosts   map[string][]string
+	txt     map[string][]string
+	mx      map[string][]string
+	lookups int
+}
+
+func (f *fakeResolver) LookupAddr(_ context.Context, addr string) ([]string, error) {
+	f.lookups++
+	names, ok := f.ptr[addr]
+	if !ok {
+		return nil, errors.New("no such host")
+	}
+	return names, nil
+}
+
+func (f *fakeResolver) LookupIPAddr(_ context.Context, host string) ([]net.IPAddr, error) {
+	ips, ok := f.hosts[host]
+	if !ok {
+		return nil, errors.New("no such host")
+	}
+	ret := make([]net.IPAddr, len(ips))
+	for i, ip := range ips {
+		ret[i] = net.IPAddr{IP: net.ParseIP(ip)}
+	}
+	return ret, nil
+}
+
+func (f *fakeResolver) LookupTXT(_ context.Context, name string) ([]string, error) {
+	records, ok := f.txt[name]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return records, nil
+}
+
+func (f *fakeResolver) LookupMX(_ context.Context, name string) ([]*net.MX, error) {
+	hosts, ok := f.mx[name]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	ret := make([]*net.MX, len(hosts))
+	for i, h := range hosts {
+		ret[i] = &net.MX{Host: h, Pref: uint16(i)}
+	}
+	return ret, nil
+}
+
+func TestCachedVerifiedDNSLookup(t *testing.T) {
+	t.Parallel()
+
+	fake := &fakeResolver{
+		ptr: map[string][]string{
+			"192.0.2.1":   {"mail.example.com.", "spoofed.example.net.", "missing.example.org."},
+			"2001:db8::1": {"mail6.example.com."},
+		},
+		hosts: map[string][]string{
+			"mail.example.com":    {"192.0.2.2", "192.0.2.1"},
+			"spoofed.example.net": {"198.51.100.1"},
+			"mail6.example.com":   {"2001:db8:0:0::1"},
+		},
+	}
+	r := NewCachedDNSResolver(t.Context(), "", time.Second, time.Second, time.Hour, slog.New(slog.DiscardHandler))
+	r.resolver = fake
+
+	names, err := r.CachedVerifiedDNSLookup("192.0.2.1")
+	if err != nil {
+		t.Fatalf("could not lookup: %v", err)
+	}
+	expected := []Name{
+		{Name: "mail.example.com", Verified: true},
+		{Name: "spoofed.example.net", Verified: false},
+		{Name: "missing.example.org", Verified: false},
+	}
+	if len(names) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, names)
+	}
+	for i := range expected {
+		if names[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, names)
+		}
+	}
+
+	names, err = r.CachedVerifiedDNSLookup("2001:db8::1")
+	if err != nil || len(names) != 1 || !names[0].Verified {
+		t.Fatalf("expected a verified ipv6 name, got %v (%v)", names, err)
+	}
+
+	// the result is cached for both lookup methods
+	domains, err := r.CachedDNSLookup("192.0.2.1")
+	if err != nil || len(domains) != 3 || domains[0] != "mail.example.com" {
+		t.Fatalf("invalid cached domains %v (%v)", domains, err)
+	}
+	if fake.lookups != 2 {
+		t.Fatalf("expected 2 ptr lookups, got %d", fake.lookups)
+	}
+
+	if _, err := r.CachedVerifiedDNSLookup("198.51.100.1"); err == nil {
+		t.Fatal("expected an error on a failed lookup")
+	}
+}
+
+func TestLookups(t *testing.T) {
+	t.Parallel()
+
+	fake := &fakeResolver{
+		ptr:   map[string][]string{"192.0.2.1": {"mail.example.com."}},
+		hosts: map[string][]string{"mail.example.com": {"::ffff:192.0.2.1", "2001:db8::1"}},
+		txt:   map[string][]string{"example.com": {"v=spf1 -all"}},
+		mx:    map[string][]string{"example.com": {"mail.example.com."}},
+	}
+	r := NewCachedDNSResolver(t.Context(), "", time.Second, time.Second, time.Hour, slog.New(slog.DiscardHandler))
+	r.resolver = fake
+
+	if txt, err := r.LookupTXT("example.com"); err != nil || len(txt) != 1 || txt[0] != "v=spf1 -all" {
+		t.Fatalf("invalid txt records %v (%v)", txt, err)
+	}
+	ips, err := r.LookupIP("mail.example.com")
+	if err != nil || len(ips) != 2 || ips[0].String() != "192.0.2.1" || ips[1].String() != "2001:db8::1" {
+		t.Fatalf("invalid ips %v (%v)", ips, err)
+	}
+	if mx, err := r.LookupMX("example.com"); err != nil || len(mx) != 1 || mx[0] != "mail.example.com" {
+		t.Fatalf("invalid mx records %v (%v)", mx, err)
+	}
+	if names, err := r.LookupAddr("192.0.2.1"); err != nil || len(names) != 1 || names[0] != "mail.example.com" {
+		t.Fatalf("invalid ptr records %v (%v)", names, err)
+	}
+	if _, err := r.LookupTXT("example.net"); err == nil {
+		t.Fatal("expected an error on a missing record")
+	}
+}
@@ -0,0 +1,248 @@
+package fields
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Field is a single key value pair of a document. The value can be a
+// scalar, a nested Document or a list ([]any) of scalars or documents.
+type Field struct {
+	Key   string
+	Value any
+}
+
+// Document is an ordered list of fields. In contrast to a map it keeps
+// the order of the fields and allows duplicate keys (repeated XML elements).
+type Document []Field
+
+// Map converts the document into a generic map. On duplicate keys the
+// last value wins.
+func (d Document) Map() map[string]any {
+	m := make(map[string]any, len(d))
+	for _, f := range d {
+		m[f.Key] = toGeneric(f.Value)
+	}
+	return m
+}
+
+func toGeneric(v any) any {
+	switch x := v.(type) {
+	case Document:
+		return x.Map()
+	case []any:
+		l := make([]any, len(x))
+		for i, e := range x {
+			l[i] = toGeneric(e)
+		}
+		return l
+	default:
+		return v
+	}
+}
+
+// MarshalJSON serializes the document as a JSON object keeping the field order
+func (d Document) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, f := range d {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(f.Key)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		value, err := json.Marshal(f.Value)
+		if err != nil {
+			return nil, fmt.Errorf("could not marshal field %s: %w", f.Key, err)
+		}
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// ParseJSON parses a JSON object into a document keeping the field order.
+// Numbers are kept as json.Number.
+func ParseJSON(b []byte) (Document, error) {
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	v, err := parseJSONValue(decoder)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse JSON: %w", err)
+	}
+	doc, ok := v.(Document)
+	if !ok {
+		return nil, errors.New("JSON is not an object")
+	}
+	return doc, nil
+}
+
+func parseJSONValue(decoder *json.Decoder) (any, error) {
+	t, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	delim, ok := t.(json.Delim)
+	if !ok {
+		// scalar value
+		return t, nil
+	}
+	switch delim {
+	case '{':
+		doc := Document{}
+		for decoder.More() {
+			keyToken, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			key, ok := keyToken.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid key %v", keyToken)
+			}
+			value, err := parseJSONValue(decoder)
+			if err != nil {
+				return nil, err
+			}
+			doc = append(doc, Field{Key: key, Value: value})
+		}
+		// consume closing }
+		if _, err := decoder.Token(); err != nil {
+			return nil, err
+		}
+		return doc, nil
+	case '[':
+		list := []any{}
+		for decoder.More() {
+			value, err := parseJSONValue(decoder)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		// consume closing ]
+		if _, err := decoder.Token(); err != nil {
+			return nil, err
+		}
+		return list, nil
+	default:
+		return nil, fmt.Errorf("unexpected delimiter %s", delim)
+	}
+}
+
+// EncodeXML serializes the document as XML with the given root element.
+// Lists are written as repeated elements.
+func (d Document) EncodeXML(root string) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := xml.NewEncoder(&buf)
+	start := xml.StartElement{Name: xml.Name{Local: root}}
+	if err := encoder.EncodeToken(start); err != nil {
+		return nil, err
+	}
+	if err := d.encodeXML(encoder); err != nil {
+		return nil, err
+	}
+	if err := encoder.EncodeToken(start.End()); err != nil {
+		return nil, err
+	}
+	if err := encoder.Flush(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (d Document) encodeXML(encoder *xml.Encoder) error {
+	for _, f := range d {
+		if err := encodeXMLValue(encoder, f.Key, f.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func encodeXMLValue(encoder *xml.Encoder, key string, value any) error {
+	start := xml.StartElement{Name: xml.Name{Local: key}}
+	switch v := value.(type) {
+	case []any:
+		for _, e := range v {
+			if err := encodeXMLValue(encoder, key, e); err != nil {
+				return err
+			}
+		}
+		return nil
+	case Document:
+		if err := encoder.EncodeToken(start); err != nil {
+			return err
+		}
+		if err := v.encodeXML(encoder); err != nil {
+			return err
+		}
+		return encoder.EncodeToken(start.End())
+	case nil:
+		return encoder.EncodeElement("", start)
+	default:
+		return encoder.EncodeElement(fmt.Sprint(v), start)
+	}
+}
+
+// ParseXML parses a XML document. It returns the name of the root element
+// and its children. Elements containing other elements are returned as
+// nested documents, all other elements as strings. Attributes are ignored.
+func ParseXML(b []byte) (string, Document, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(b))
+	for {
+		t, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			return "", nil, errors.New("no root element found")
+		} else if err != nil {
+			return "", nil, fmt.Errorf("could not parse XML: %w", err)
+		}
+		if start, ok := t.(xml.StartElement); ok {
+			v, err := parseXMLElement(decoder)
+			if err != nil {
+				return "", nil, fmt.Errorf("could not parse XML: %w", err)
+			}
+			doc, ok := v.(Document)
+			if !ok {
+				// root element without children
+				doc = Document{}
+			}
+			return start.Name.Local, doc, nil
+		}
+	}
+}
+
+// parseXMLElement parses the content of an element until the matching end element
+func parseXMLElement(decoder *xml.Decoder) (any, error) {
+	var children Document
+	var text strings.Builder
+	for {
+		t, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch x := t.(type) {
+		case xml.StartElement:
+			v, err := parseXMLElement(decoder)
+			if err != nil {
+				return nil, err
+			}
+			children = append(children, Field{Key: x.Name.Local, Value: v})
+		case xml.CharData:
+			text.Write(x)
+		case xml.EndElement:
+			if children != nil {
+				return children, nil
+			}
+			return text.String(), nil
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package fields
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestJSONRoundtrip(t *testing.T) {
+	t.Parallel()
+
+	input := `{"z":1,"a":[1,"2",true,null,{"b":1.5}],"m":{}}`
+	doc, err := ParseJSON([]byte(input))
+	if err != nil {
+		t.Fatalf("could not parse JSON: %v", err)
+	}
+	got, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatalf("could not marshal JSON: %v", err)
+	}
+	if string(got) != input {
+		t.Fatalf("JSON mismatch\nexpected %s\ngot      %s", input, got)
+	}
+
+	if _, err := ParseJSON([]byte(`[1,2]`)); err == nil {
+		t.Fatal("expected an error on a non object")
+	}
+}
+
+func TestXMLRoundtrip(t *testing.T) {
+	t.Parallel()
+
+	input := `<root><a>1</a><b><c>2</c></b><errors><error>x</error><error>y</error></errors><empty></empty></root>`
+	root, doc, err := ParseXML([]byte(input))
+	if err != nil {
+		t.Fatalf("could not parse XML: %v", err)
+	}
+	if root != "root" {
+		t.Fatalf("invalid root element %s", root)
+	}
+	got, err := doc.EncodeXML(root)
+	if err != nil {
+		t.Fatalf("could not encode XML: %v", err)
+	}
+	if string(got) != input {
+		t.Fatalf("XML mismatch\nexpected %s\ngot      %s", input, got)
+	}
+
+	filtered, err := NewFilter(nil, []string{"errors.error"}, map[string]string{"b.c": "d"}, map[string]string{"tenant": "acme"}).Apply(doc).EncodeXML(root)
+	if err != nil {
+		t.Fatalf("could not encode XML: %v", err)
+	}
+	expected := `<root><a>1</a><b><d>2</d></b><errors></errors><empty></empty><tenant>acme</tenant></root>`
+	if string(filtered) != expected {
+		t.Fatalf("XML mismatch\nexpected %s\ngot      %s", expected, filtered)
+	}
+}
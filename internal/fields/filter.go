@@ -0,0 +1,200 @@
+package fields
+
+import (
+	"slices"
+	"strings"
+)
+
+// Filter selects, renames and adds fields of an output document. Nested
+// fields are addressed by their path separated by dots, for example
+// policy_evaluated.disposition.
+type Filter struct {
+	include   map[string]struct{}
+	ancestors map[string]struct{}
+	exclude   map[string]struct{}
+	rename    map[string]string
+	extra     Document
+}
+
+// NewFilter creates a new filter. If include is not empty only the included
+// fields (and all of their children) are kept. Excludes are applied after
+// includes, renames only change the last part of the path. Extra fields are
+// added to the top level of the document and overwrite existing fields.
+func NewFilter(include, exclude []string, rename map[string]string, extra map[string]string) *Filter {
+	f := &Filter{
+		include:   make(map[string]struct{}, len(include)),
+		ancestors: make(map[string]struct{}),
+		exclude:   make(map[string]struct{}, len(exclude)),
+		rename:    rename,
+	}
+	for _, i := range include {
+		f.include[i] = struct{}{}
+		// all parents need to be kept too, otherwise we can not reach the field
+		parts := strings.Split(i, ".")
+		for x := 1; x < len(parts); x++ {
+			f.ancestors[strings.Join(parts[:x], ".")] = struct{}{}
+		}
+	}
+	for _, e := range exclude {
+		f.exclude[e] = struct{}{}
+	}
+
+	keys := make([]string, 0, len(extra))
+	for k := range extra {
+		keys = append(keys, k)
+	}
+	// sort so the output is stable
+	slices.Sort(keys)
+	for _, k := range keys {
+		f.extra = append(f.extra, Field{Key: k, Value: extra[k]})
+	}
+	return f
+}
+
+// IsEmpty returns true if the filter does not modify documents
+func (f *Filter) IsEmpty() bool {
+	return f == nil || (len(f.include) == 0 && len(f.exclude) == 0 && len(f.rename) == 0 && len(f.extra) == 0)
+}
+
+// Apply returns a filtered copy of the document
+func (f *Filter) Apply(doc Document) Document {
+	if f.IsEmpty() {
+		return doc
+	}
+	return f.Extend(f.Rename(f.Select(doc)))
+}
+
+// Select returns a copy of the document containing the included fields
+// without the excluded ones. Fields are not renamed.
+func (f *Filter) Select(doc Document) Document {
+	if f == nil || (len(f.include) == 0 && len(f.exclude) == 0) {
+		return doc
+	}
+	return f.selectFields(doc, "", len(f.include) == 0)
+}
+
+// Rename returns a copy of the document with all renamed fields
+func (f *Filter) Rename(doc Document) Document {
+	if f == nil || len(f.rename) == 0 {
+		return doc
+	}
+	return f.renameFields(doc, "")
+}
+
+// Extend returns a copy of the document with the extra fields added to the
+// top level. Existing fields with the same name are overwritten.
+func (f *Filter) Extend(doc Document) Document {
+	if f == nil || len(f.extra) == 0 {
+		return doc
+	}
+	out := slices.Clone(doc)
+	for _, e := range f.extra {
+		replaced := false
+		for i := range out {
+			if out[i].Key == e.Key {
+				out[i].Value = e.Value
+				replaced = true
+			}
+		}
+		if !replaced {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// Keep returns true if the field with the path is kept by Select. Formats
+// with their own field names use it to select a field by the path of the
+// field it is built from.
+func (f *Filter) Keep(path string) bool {
+	if f == nil {
+		return true
+	}
+	included := len(f.include) == 0
+	parts := strings.Split(path, ".")
+	for x := 1; x <= len(parts); x++ {
+		p := strings.Join(parts[:x], ".")
+		if _, ok := f.exclude[p]; ok {
+			return false
+		}
+		if _, ok := f.include[p]; ok {
+			included = true
+		}
+	}
+	if included {
+		return true
+	}
+	// only some children of the field are included
+	_, ok := f.ancestors[path]
+	return ok
+}
+
+// Renamed returns the new name of the field with the path if it is renamed
+func (f *Filter) Renamed(path string) (string, bool) {
+	if f == nil {
+		return "", false
+	}
+	name, ok := f.rename[path]
+	return name, ok
+}
+
+// selectFields filters a document. included indicates that a parent of the
+// current document is explicitly included so all children are kept.
+func (f *Filter) selectFields(doc Document, prefix string, included bool) Document {
+	out := Document{}
+	for _, field := range doc {
+		path := joinPath(prefix, field.Key)
+		if _, ok := f.exclude[path]; ok {
+			continue
+		}
+		_, isIncluded := f.include[path]
+		_, isAncestor := f.ancestors[path]
+		if !included && !isIncluded && !isAncestor {
+			continue
+		}
+		field.Value = mapValue(field.Value, path, func(d Document, path string) Document {
+			return f.selectFields(d, path, included || isIncluded)
+		})
+		out = append(out, field)
+	}
+	return out
+}
+
+// renameFields renames the fields of a document, only the last part of the
+// path is changed
+func (f *Filter) renameFields(doc Document, prefix string) Document {
+	out := make(Document, 0, len(doc))
+	for _, field := range doc {
+		path := joinPath(prefix, field.Key)
+		field.Value = mapValue(field.Value, path, f.renameFields)
+		if newKey, ok := f.rename[path]; ok {
+			field.Key = newKey
+		}
+		out = append(out, field)
+	}
+	return out
+}
+
+// mapValue calls fn for all nested documents of the value. Documents inside
+// lists have the same path as the list.
+func mapValue(value any, path string, fn func(Document, string) Document) any {
+	switch v := value.(type) {
+	case Document:
+		return fn(v, path)
+	case []any:
+		l := make([]any, len(v))
+		for i, e := range v {
+			l[i] = mapValue(e, path, fn)
+		}
+		return l
+	default:
+		return value
+	}
+}
+
+func joinPath(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
@@ -0,0 +1,164 @@
+package fields
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	t.Parallel()
+
+	input := `{"a":"1","b":{"c":"2","d":"3"},"e":[{"f":"4","g":"5"},{"f":"6","g":"7"}],"h":"8"}`
+
+	tests := []struct {
+		name     string
+		filter   *Filter
+		expected string
+	}{
+		{
+			name:     "nil filter",
+			filter:   nil,
+			expected: input,
+		},
+		{
+			name:     "empty filter",
+			filter:   NewFilter(nil, nil, nil, nil),
+			expected: input,
+		},
+		{
+			name:     "include",
+			filter:   NewFilter([]string{"a", "b.d", "e.f"}, nil, nil, nil),
+			expected: `{"a":"1","b":{"d":"3"},"e":[{"f":"4"},{"f":"6"}]}`,
+		},
+		{
+			name:     "include parent",
+			filter:   NewFilter([]string{"b"}, nil, nil, nil),
+			expected: `{"b":{"c":"2","d":"3"}}`,
+		},
+		{
+			name:     "exclude",
+			filter:   NewFilter(nil, []string{"a", "b.c", "e.g"}, nil, nil),
+			expected: `{"b":{"d":"3"},"e":[{"f":"4"},{"f":"6"}],"h":"8"}`,
+		},
+		{
+			name:     "include and exclude",
+			filter:   NewFilter([]string{"b"}, []string{"b.c"}, nil, nil),
+			expected: `{"b":{"d":"3"}}`,
+		},
+		{
+			name:     "rename",
+			filter:   NewFilter(nil, nil, map[string]string{"a": "x", "b.c": "y", "e.f": "z"}, nil),
+			expected: `{"x":"1","b":{"y":"2","d":"3"},"e":[{"z":"4","g":"5"},{"z":"6","g":"7"}],"h":"8"}`,
+		},
+		{
+			name:     "extra",
+			filter:   NewFilter([]string{"a"}, nil, nil, map[string]string{"tenant": "acme", "a": "overwritten"}),
+			expected: `{"a":"overwritten","tenant":"acme"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			doc, err := ParseJSON([]byte(input))
+			if err != nil {
+				t.Fatalf("could not parse JSON: %v", err)
+			}
+			got, err := json.Marshal(tt.filter.Apply(doc))
+			if err != nil {
+				t.Fatalf("could not marshal JSON: %v", err)
+			}
+			if string(got) != tt.expected {
+				t.Fatalf("filter mismatch\nexpected %s\ngot      %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestFilterSteps(t *testing.T) {
+	t.Parallel()
+
+	input := `{"a":"1","b":{"c":"2","d":"3"}}`
+	filter := NewFilter([]string{"b"}, []string{"b.c"}, map[string]string{"b.d": "x"}, map[string]string{"tenant": "acme"})
+
+	tests := []struct {
+		name     string
+		step     func(Document) Document
+		expected string
+	}{
+		{
+			name:     "select",
+			step:     filter.Select,
+			expected: `{"b":{"d":"3"}}`,
+		},
+		{
+			name:     "rename",
+			step:     filter.Rename,
+			expected: `{"a":"1","b":{"c":"2","x":"3"}}`,
+		},
+		{
+			name:     "extend",
+			step:     filter.Extend,
+			expected: `{"a":"1","b":{"c":"2","d":"3"},"tenant":"acme"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			doc, err := ParseJSON([]byte(input))
+			if err != nil {
+				t.Fatalf("could not parse JSON: %v", err)
+			}
+			got, err := json.Marshal(tt.step(doc))
+			if err != nil {
+				t.Fatalf("could not marshal JSON: %v", err)
+			}
+			if string(got) != tt.expected {
+				t.Fatalf("filter mismatch\nexpected %s\ngot      %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestFilterKeep(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		filter *Filter
+		path   string
+		keep   bool
+	}{
+		{name: "nil filter", filter: nil, path: "a", keep: true},
+		{name: "no include", filter: NewFilter(nil, []string{"b"}, nil, nil), path: "a", keep: true},
+		{name: "excluded", filter: NewFilter(nil, []string{"b"}, nil, nil), path: "b", keep: false},
+		{name: "excluded parent", filter: NewFilter(nil, []string{"b"}, nil, nil), path: "b.c", keep: false},
+		{name: "included", filter: NewFilter([]string{"a"}, nil, nil, nil), path: "a", keep: true},
+		{name: "not included", filter: NewFilter([]string{"a"}, nil, nil, nil), path: "b", keep: false},
+		{name: "included parent", filter: NewFilter([]string{"b"}, nil, nil, nil), path: "b.c", keep: true},
+		{name: "included child", filter: NewFilter([]string{"b.c"}, nil, nil, nil), path: "b", keep: true},
+		{name: "included sibling", filter: NewFilter([]string{"b.c"}, nil, nil, nil), path: "b.d", keep: false},
+		{name: "excluded child of included", filter: NewFilter([]string{"b"}, []string{"b.c"}, nil, nil), path: "b.c", keep: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := tt.filter.Keep(tt.path); got != tt.keep {
+				t.Fatalf("expected %t for %s, got %t", tt.keep, tt.path, got)
+			}
+		})
+	}
+
+	filter := NewFilter(nil, nil, map[string]string{"b.c": "x"}, nil)
+	if name, ok := filter.Renamed("b.c"); !ok || name != "x" {
+		t.Fatalf("expected b.c to be renamed to x, got %q %t", name, ok)
+	}
+	if _, ok := filter.Renamed("b"); ok {
+		t.Fatal("expected b not to be renamed")
+	}
+}
@@ -0,0 +1,74 @@
+package gelf
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Options configures a GELF writer
+type Options struct {
+	// Protocol is one of udp, tcp or http
+	Protocol string
+	// Server is host:port for udp and tcp and an URL for http
+	Server string
+	// Compression is one of none, gzip or zlib. Not supported on tcp
+	Compression string
+	// ChunkSize is the maximum size of an UDP datagram
+	ChunkSize int
+	Timeout   time.Duration
+}
+
+const defaultChunkSize = 1420
+
+// New creates a new writer for the configured protocol that sends
+// already serialized GELF messages to a Graylog input. Every call
+// to Write must contain exactly one message.
+func New(ctx context.Context, opts Options) (io.WriteCloser, error) {
+	switch opts.Protocol {
+	case "udp":
+		if opts.ChunkSize <= 0 {
+			opts.ChunkSize = defaultChunkSize
+		}
+		return newUDPWriter(opts)
+	case "tcp":
+		if opts.Compression != "" && opts.Compression != "none" {
+			return nil, fmt.Errorf("compression %s is not supported via tcp", opts.Compression)
+		}
+		return newTCPWriter(opts), nil
+	case "http":
+		// the http input only supports gzip compression
+		if opts.Compression == "zlib" {
+			return nil, fmt.Errorf("compression %s is not supported via http", opts.Compression)
+		}
+		return newHTTPWriter(ctx, opts), nil
+	default:
+		return nil, fmt.Errorf("invalid gelf protocol %q", opts.Protocol)
+	}
+}
+
+func compress(compression string, p []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch compression {
+	case "", "none":
+		return p, nil
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "zlib":
+		w = zlib.NewWriter(&buf)
+	default:
+		return nil, fmt.Errorf("invalid compression %q", compression)
+	}
+	if _, err := w.Write(p); err != nil {
+		return nil, fmt.Errorf("could not compress message: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("could not compress message: %w", err)
+	}
+	return buf.Bytes(), nil
+}
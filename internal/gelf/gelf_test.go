@@ -0,0 +1,215 @@
+package gelf
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestChunk(t *testing.T) {
+	t.Parallel()
+
+	msg := bytes.Repeat([]byte("a"), 100)
+
+	chunks, err := chunk(msg, 200)
+	if err != nil {
+		t.Fatalf("got unexpected error: %v", err)
+	}
+	if len(chunks) != 1 || !bytes.Equal(chunks[0], msg) {
+		t.Fatal("small messages should not be chunked")
+	}
+
+	chunks, err = chunk(msg, 52)
+	if err != nil {
+		t.Fatalf("got unexpected error: %v", err)
+	}
+	if len(chunks) != 3 {
+		t.Fatalf("expected 3 chunks, got %d", len(chunks))
+	}
+	var reassembled []byte
+	for i, c := range chunks {
+		if !bytes.HasPrefix(c, chunkMagic) {
+			t.Fatalf("chunk %d is missing the magic bytes", i)
+		}
+		if !bytes.Equal(c[2:10], chunks[0][2:10]) {
+			t.Fatalf("chunk %d has a different message id", i)
+		}
+		if int(c[10]) != i || int(c[11]) != len(chunks) {
+			t.Fatalf("chunk %d has invalid sequence numbers %d/%d", i, c[10], c[11])
+		}
+		reassembled = append(reassembled, c[chunkHeaderSize:]...)
+	}
+	if !bytes.Equal(reassembled, msg) {
+		t.Fatal("reassembled message does not match")
+	}
+
+	if _, err := chunk(bytes.Repeat([]byte("a"), 2000), 13); err == nil {
+		t.Fatal("expected an error on too many chunks")
+	}
+}
+
+func TestUDPWriter(t *testing.T) {
+	t.Parallel()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	defer conn.Close()
+
+	w, err := New(t.Context(), Options{Protocol: "udp", Server: conn.LocalAddr().String(), Compression: "gzip", Timeout: time.Second})
+	if err != nil {
+		t.Fatalf("could not create writer: %v", err)
+	}
+	defer w.Close()
+
+	msg := []byte(`{"version":"1.1"}`)
+	if _, err := w.Write(msg); err != nil {
+		t.Fatalf("could not write: %v", err)
+	}
+
+	buf := make([]byte, 2048)
+	if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatalf("could not set deadline: %v", err)
+	}
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("could not read: %v", err)
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(buf[:n]))
+	if err != nil {
+		t.Fatalf("message is not gzip compressed: %v", err)
+	}
+	got, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatalf("could not decompress: %v", err)
+	}
+	if !bytes.Equal(got, msg) {
+		t.Fatalf("message mismatch - expected %s got %s", msg, got)
+	}
+}
+
+func TestTCPWriter(t *testing.T) {
+	t.Parallel()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	defer l.Close()
+
+	received := make(chan []byte, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		msg, _ := bufio.NewReader(conn).ReadBytes(0x00)
+		received <- msg
+	}()
+
+	w, err := New(t.Context(), Options{Protocol: "tcp", Server: l.Addr().String(), Timeout: time.Second})
+	if err != nil {
+		t.Fatalf("could not create writer: %v", err)
+	}
+	defer w.Close()
+
+	if _, err := w.Write([]byte("test")); err != nil {
+		t.Fatalf("could not write: %v", err)
+	}
+
+	select {
+	case msg := <-received:
+		if string(msg) != "test\x00" {
+			t.Fatalf("message mismatch - got %q", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for message")
+	}
+
+	if _, err := New(t.Context(), Options{Protocol: "tcp", Server: l.Addr().String(), Compression: "gzip"}); err == nil {
+		t.Fatal("expected an error on compression via tcp")
+	}
+}
+
+func TestTCPWriterStalled(t *testing.T) {
+	t.Parallel()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	defer l.Close()
+
+	// accept the connection but never read from it
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		<-t.Context().Done()
+		conn.Close()
+	}()
+
+	w, err := New(t.Context(), Options{Protocol: "tcp", Server: l.Addr().String(), Timeout: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("could not create writer: %v", err)
+	}
+	defer w.Close()
+
+	// the message is larger than the socket buffers
+	done := make(chan error, 1)
+	go func() {
+		_, err := w.Write(make([]byte, 64*1024*1024))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("expected an error on a stalled receiver")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("write blocked on a stalled receiver")
+	}
+}
+
+func TestHTTPWriter(t *testing.T) {
+	t.Parallel()
+
+	received := make(chan []byte, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := io.Reader(r.Body)
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			gz, err := gzip.NewReader(r.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			body = gz
+		}
+		b, _ := io.ReadAll(body)
+		received <- b
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	w, err := New(t.Context(), Options{Protocol: "http", Server: srv.URL, Compression: "gzip", Timeout: time.Second})
+	if err != nil {
+		t.Fatalf("could not create writer: %v", err)
+	}
+	defer w.Close()
+
+	if _, err := w.Write([]byte("test")); err != nil {
+		t.Fatalf("could not write: %v", err)
+	}
+	if msg := <-received; string(msg) != "test" {
+		t.Fatalf("message mismatch - got %q", msg)
+	}
+}
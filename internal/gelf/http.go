@@ -0,0 +1,61 @@
+package gelf
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+type httpWriter struct {
+	ctx         context.Context
+	url         string
+	compression string
+	client      *http.Client
+}
+
+func newHTTPWriter(ctx context.Context, opts Options) *httpWriter {
+	return &httpWriter{
+		ctx:         ctx,
+		url:         opts.Server,
+		compression: opts.Compression,
+		client: &http.Client{
+			Timeout: opts.Timeout,
+		},
+	}
+}
+
+func (w *httpWriter) Write(p []byte) (int, error) {
+	msg, err := compress(w.compression, p)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(w.ctx, http.MethodPost, w.url, bytes.NewReader(msg))
+	if err != nil {
+		return 0, fmt.Errorf("could not create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.compression == "gzip" {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("could not send gelf message: %w", err)
+	}
+	defer resp.Body.Close()
+	// drain the body so the connection can be reused
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return 0, fmt.Errorf("invalid status code %d from %s", resp.StatusCode, w.url)
+	}
+	return len(p), nil
+}
+
+func (w *httpWriter) Close() error {
+	w.client.CloseIdleConnections()
+	return nil
+}
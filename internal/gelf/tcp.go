@@ -0,0 +1,58 @@
+package gelf
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// tcpWriter sends null byte delimited messages. The connection
+// is established lazily and re-established after errors.
+type tcpWriter struct {
+	server  string
+	timeout time.Duration
+	conn    net.Conn
+}
+
+func newTCPWriter(opts Options) *tcpWriter {
+	return &tcpWriter{
+		server:  opts.Server,
+		timeout: opts.Timeout,
+	}
+}
+
+func (w *tcpWriter) Write(p []byte) (int, error) {
+	if w.conn == nil {
+		conn, err := net.DialTimeout("tcp", w.server, w.timeout)
+		if err != nil {
+			return 0, fmt.Errorf("could not connect to %s: %w", w.server, err)
+		}
+		w.conn = conn
+	}
+
+	msg := make([]byte, 0, len(p)+1)
+	msg = append(msg, p...)
+	msg = append(msg, 0x00)
+	// a stalled receiver must not block the forwarding
+	if w.timeout > 0 {
+		if err := w.conn.SetWriteDeadline(time.Now().Add(w.timeout)); err != nil {
+			_ = w.conn.Close()
+			w.conn = nil
+			return 0, fmt.Errorf("could not set write deadline: %w", err)
+		}
+	}
+	if _, err := w.conn.Write(msg); err != nil {
+		// reconnect on the next write
+		_ = w.conn.Close()
+		w.conn = nil
+		return 0, fmt.Errorf("could not send gelf message: %w", err)
+	}
+	return len(p), nil
+}
+
+func (w *tcpWriter) Close() error {
+	if w.conn == nil {
+		return nil
+	}
+	return w.conn.Close()
+}
@@ -0,0 +1,90 @@
+package gelf
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// https://go2docs.graylog.org/current/getting_in_log_data/gelf.html#GELFviaUDP
+const (
+	chunkHeaderSize = 12
+	maxChunks       = 128
+)
+
+var chunkMagic = []byte{0x1e, 0x0f}
+
+type udpWriter struct {
+	conn        net.Conn
+	compression string
+	chunkSize   int
+}
+
+func newUDPWriter(opts Options) (*udpWriter, error) {
+	if opts.ChunkSize <= chunkHeaderSize {
+		return nil, fmt.Errorf("chunk size %d is too small", opts.ChunkSize)
+	}
+	conn, err := net.DialTimeout("udp", opts.Server, opts.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to %s: %w", opts.Server, err)
+	}
+	return &udpWriter{
+		conn:        conn,
+		compression: opts.Compression,
+		chunkSize:   opts.ChunkSize,
+	}, nil
+}
+
+func (w *udpWriter) Write(p []byte) (int, error) {
+	msg, err := compress(w.compression, p)
+	if err != nil {
+		return 0, err
+	}
+
+	chunks, err := chunk(msg, w.chunkSize)
+	if err != nil {
+		return 0, err
+	}
+	for _, c := range chunks {
+		if _, err := w.conn.Write(c); err != nil {
+			return 0, fmt.Errorf("could not send gelf message: %w", err)
+		}
+	}
+	return len(p), nil
+}
+
+func (w *udpWriter) Close() error {
+	return w.conn.Close()
+}
+
+// chunk splits the message into GELF chunks if it does not fit
+// into a single datagram
+func chunk(msg []byte, chunkSize int) ([][]byte, error) {
+	if len(msg) <= chunkSize {
+		return [][]byte{msg}, nil
+	}
+
+	payloadSize := chunkSize - chunkHeaderSize
+	count := (len(msg) + payloadSize - 1) / payloadSize
+	if count > maxChunks {
+		return nil, fmt.Errorf("message too large: needs %d chunks, only %d allowed", count, maxChunks)
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, errors.New("could not generate message id")
+	}
+
+	chunks := make([][]byte, 0, count)
+	for i := range count {
+		end := min((i+1)*payloadSize, len(msg))
+		c := make([]byte, 0, chunkHeaderSize+end-i*payloadSize)
+		c = append(c, chunkMagic...)
+		c = append(c, id...)
+		c = append(c, byte(i), byte(count))
+		c = append(c, msg[i*payloadSize:end]...)
+		chunks = append(chunks, c)
+	}
+	return chunks, nil
+}
@@ -0,0 +1,201 @@
+// Package geoip enriches IP addresses with the location and the autonomous
+// system from local MaxMind DB (MMDB) files like GeoLite2 or DB-IP Lite.
+package geoip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang/v2"
+)
+
+// Info contains the enrichment of an IP address. Fields not contained in any
+// database are empty.
+type Info struct {
+	// Country is the ISO 3166-1 alpha-2 country code
+	Country string
+	City    string
+	ASN     uint
+	ASOrg   string
+}
+
+// record contains the fields of the supported databases. City, country and
+// ASN databases of MaxMind and DB-IP use the same field names.
+type record struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	ASN   uint   `maxminddb:"autonomous_system_number"`
+	ASOrg string `maxminddb:"autonomous_system_organization"`
+}
+
+type database struct {
+	filename string
+	reader   *maxminddb.Reader
+	modTime  time.Time
+	size     int64
+}
+
+// DB combines multiple databases, for example a city and an ASN database.
+// The databases are reloaded by Watch if the files change on disk.
+type DB struct {
+	mutex     sync.RWMutex
+	databases []*database
+	logger    *slog.Logger
+}
+
+// Open loads all databases
+func Open(filenames []string, logger *slog.Logger) (*DB, error) {
+	if len(filenames) == 0 {
+		return nil, errors.New("no geoip database provided")
+	}
+	db := &DB{logger: logger}
+	for _, filename := range filenames {
+		d, err := load(filename)
+		if err != nil {
+			_ = db.Close()
+			return nil, err
+		}
+		db.databases = append(db.databases, d)
+	}
+	return db, nil
+}
+
+// load reads the database into memory so files replaced or truncated on disk
+// do not affect the loaded database
+func load(filename string) (*database, error) {
+	stat, err := os.Stat(filename)
+	if err != nil {
+		return nil, fmt.Errorf("could not open geoip database: %w", err)
+	}
+	content, err := os.ReadFile(filename) // nolint: gosec
+	if err != nil {
+		return nil, fmt.Errorf("could not read geoip database: %w", err)
+	}
+	reader, err := maxminddb.OpenBytes(content)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse geoip database %s: %w", filename, err)
+	}
+	if err := reader.Verify(); err != nil {
+		_ = reader.Close()
+		return nil, fmt.Errorf("invalid geoip database %s: %w", filename, err)
+	}
+	return &database{
+		filename: filename,
+		reader:   reader,
+		modTime:  stat.ModTime(),
+		size:     stat.Size(),
+	}, nil
+}
+
+// Lookup returns the information of all databases. Invalid or unknown IPs
+// return an empty result. It is safe to call Lookup on a nil DB.
+func (db *DB) Lookup(ip string) Info {
+	var info Info
+	if db == nil {
+		return info
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return info
+	}
+
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	for _, d := range db.databases {
+		var r record
+		if err := d.reader.Lookup(addr.Unmap()).Decode(&r); err != nil {
+			db.logger.Debug("could not lookup ip", slog.String("ip", ip), slog.String("database", d.filename), slog.String("err", err.Error()))
+			continue
+		}
+		if info.Country == "" {
+			info.Country = r.Country.ISOCode
+		}
+		if info.City == "" {
+			info.City = r.City.Names["en"]
+		}
+		if info.ASN == 0 {
+			info.ASN = r.ASN
+		}
+		if info.ASOrg == "" {
+			info.ASOrg = r.ASOrg
+		}
+	}
+	return info
+}
+
+// Watch checks the files every interval and reloads changed databases until
+// the context is done. If a changed file can not be loaded the previous
+// version of the database is kept.
+func (db *DB) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			db.reload()
+		}
+	}
+}
+
+func (db *DB) reload() {
+	for i, d := range db.databasesSnapshot() {
+		stat, err := os.Stat(d.filename)
+		if err != nil {
+			db.logger.Warn("could not check geoip database", slog.String("database", d.filename), slog.String("err", err.Error()))
+			continue
+		}
+		if stat.ModTime().Equal(d.modTime) && stat.Size() == d.size {
+			continue
+		}
+
+		updated, err := load(d.filename)
+		if err != nil {
+			db.logger.Warn("could not reload geoip database, keeping the previous version", slog.String("database", d.filename), slog.String("err", err.Error()))
+			// only retry after the next change of the file
+			db.mutex.Lock()
+			db.databases[i] = &database{filename: d.filename, reader: d.reader, modTime: stat.ModTime(), size: stat.Size()}
+			db.mutex.Unlock()
+			continue
+		}
+
+		db.mutex.Lock()
+		db.databases[i] = updated
+		db.mutex.Unlock()
+		// no lookup can use the old reader after the swap
+		_ = d.reader.Close()
+		db.logger.Info("reloaded geoip database", slog.String("database", d.filename))
+	}
+}
+
+func (db *DB) databasesSnapshot() []*database {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	return append([]*database(nil), db.databases...)
+}
+
+// Close releases all databases
+func (db *DB) Close() error {
+	if db == nil {
+		return nil
+	}
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	var errs []error
+	for _, d := range db.databases {
+		errs = append(errs, d.reader.Close())
+	}
+	db.databases = nil
+	return errors.Join(errs...)
+}
@@ -0,0 +1,107 @@
+package geoip
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLookup(t *testing.T) {
+	t.Parallel()
+
+	db, err := Open([]string{"testdata/city.mmdb", "testdata/asn.mmdb"}, slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatalf("could not open databases: %v", err)
+	}
+	defer db.Close()
+
+	tests := []struct {
+		ip       string
+		expected Info
+	}{
+		{ip: "192.0.2.25", expected: Info{Country: "US", City: "Example City", ASN: 64496, ASOrg: "Example AS"}},
+		{ip: "::ffff:192.0.2.25", expected: Info{Country: "US", City: "Example City", ASN: 64496, ASOrg: "Example AS"}},
+		{ip: "2001:db8::1", expected: Info{Country: "DE", City: "Beispielstadt"}},
+		{ip: "198.51.100.1", expected: Info{}},
+		{ip: "invalid", expected: Info{}},
+	}
+	for _, tt := range tests {
+		if got := db.Lookup(tt.ip); got != tt.expected {
+			t.Errorf("Lookup(%q): expected %+v, got %+v", tt.ip, tt.expected, got)
+		}
+	}
+
+	var empty *DB
+	if got := empty.Lookup("192.0.2.25"); got != (Info{}) {
+		t.Fatalf("expected an empty result on a nil db, got %+v", got)
+	}
+}
+
+func TestOpenInvalid(t *testing.T) {
+	t.Parallel()
+
+	logger := slog.New(slog.DiscardHandler)
+	if _, err := Open(nil, logger); err == nil {
+		t.Fatal("expected an error without databases")
+	}
+	if _, err := Open([]string{"testdata/missing.mmdb"}, logger); err == nil {
+		t.Fatal("expected an error on a missing database")
+	}
+	invalid := filepath.Join(t.TempDir(), "invalid.mmdb")
+	if err := os.WriteFile(invalid, []byte("invalid"), 0o600); err != nil {
+		t.Fatalf("could not write file: %v", err)
+	}
+	if _, err := Open([]string{"testdata/asn.mmdb", invalid}, logger); err == nil {
+		t.Fatal("expected an error on an invalid database")
+	}
+}
+
+func TestReload(t *testing.T) {
+	t.Parallel()
+
+	filename := filepath.Join(t.TempDir(), "city.mmdb")
+	copyFile(t, "testdata/city.mmdb", filename)
+
+	db, err := Open([]string{filename}, slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatalf("could not open database: %v", err)
+	}
+	defer db.Close()
+
+	if got := db.Lookup("192.0.2.25").Country; got != "US" {
+		t.Fatalf("expected US, got %q", got)
+	}
+
+	// an invalid update keeps the previous database
+	if err := os.WriteFile(filename, []byte("partial"), 0o600); err != nil {
+		t.Fatalf("could not write file: %v", err)
+	}
+	db.reload()
+	if got := db.Lookup("192.0.2.25").Country; got != "US" {
+		t.Fatalf("expected the previous database after an invalid update, got %q", got)
+	}
+
+	copyFile(t, "testdata/city-updated.mmdb", filename)
+	// make sure the change is detected on filesystems with a coarse timestamp
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(filename, future, future); err != nil {
+		t.Fatalf("could not change file time: %v", err)
+	}
+	db.reload()
+	if got := db.Lookup("192.0.2.25"); got.Country != "FR" || got.City != "Ville Exemple" {
+		t.Fatalf("expected the updated database, got %+v", got)
+	}
+}
+
+func copyFile(t *testing.T, src, dst string) {
+	t.Helper()
+	content, err := os.ReadFile(src)
+	if err != nil {
+		t.Fatalf("could not read %s: %v", src, err)
+	}
+	if err := os.WriteFile(dst, content, 0o600); err != nil {
+		t.Fatalf("could not write %s: %v", dst, err)
+	}
+}
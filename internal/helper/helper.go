@@ -4,26 +4,115 @@ import (
 	"bytes"
 )
 
+// FileType is the type of an attachment detected by its content
+type FileType int
+
+const (
+	FileTypeUnknown FileType = iota
+	FileTypeGzip
+	FileTypeZip
+	FileTypeXML
+	FileTypeBzip2
+	FileTypeXz
+	FileTypeZstd
+	FileTypeJSON
+)
+
+// String returns the name of the file type
+func (t FileType) String() string {
+	switch t {
+	case FileTypeGzip:
+		return "gzip"
+	case FileTypeZip:
+		return "zip"
+	case FileTypeXML:
+		return "xml"
+	case FileTypeBzip2:
+		return "bzip2"
+	case FileTypeXz:
+		return "xz"
+	case FileTypeZstd:
+		return "zstd"
+	case FileTypeJSON:
+		return "json"
+	default:
+		return "unknown"
+	}
+}
+
 // https://en.wikipedia.org/wiki/List_of_file_signatures
-var magicTable = [][]byte{
-	{31, 139},      // .gz "\x1f\x8b"
-	{80, 75, 3, 4}, // .zip "\x50\x4B\x03\x04"
-	{80, 75, 5, 6}, // .zip "\x50\x4B\x05\x06"
-	{80, 75, 7, 8}, // .zip "\x50\x4B\x07\x08"
+var magicTable = []struct {
+	magic    []byte
+	fileType FileType
+}{
+	{magic: []byte{31, 139}, fileType: FileTypeGzip},               // .gz "\x1f\x8b"
+	{magic: []byte{80, 75, 3, 4}, fileType: FileTypeZip},           // .zip "\x50\x4B\x03\x04"
+	{magic: []byte{80, 75, 5, 6}, fileType: FileTypeZip},           // .zip "\x50\x4B\x05\x06"
+	{magic: []byte{80, 75, 7, 8}, fileType: FileTypeZip},           // .zip "\x50\x4B\x07\x08"
+	{magic: []byte{66, 90, 104}, fileType: FileTypeBzip2},          // .bz2 "BZh"
+	{magic: []byte{253, 55, 122, 88, 90, 0}, fileType: FileTypeXz}, // .xz "\xFD7zXZ\x00"
+	{magic: []byte{40, 181, 47, 253}, fileType: FileTypeZstd},      // .zst "\x28\xB5\x2F\xFD"
 }
 
-func IsSupportedArchive(content []byte) bool {
+var (
+	utf8BOM = []byte{0xef, 0xbb, 0xbf}
+	// XML documents either start with a declaration or the root element.
+	// Some reports are missing the feedback element so they start with
+	// the first report element.
+	xmlPrefixes = [][]byte{
+		[]byte("<?xml"),
+		[]byte("<feedback"),
+		[]byte("<version"),
+		[]byte("<report_metadata"),
+	}
+	// UTF-16 encoded XML documents starting with a byte order mark and "<"
+	utf16Prefixes = [][]byte{
+		{0xff, 0xfe, '<', 0x00},
+		{0xfe, 0xff, 0x00, '<'},
+	}
+)
+
+// DetectFileType detects the type of the content by its magic bytes
+func DetectFileType(content []byte) FileType {
 	sliceEnd := 10
 	if len(content) < sliceEnd {
 		sliceEnd = len(content)
 	}
 	contentStr := content[0:sliceEnd]
 
-	for _, magic := range magicTable {
-		if bytes.HasPrefix(contentStr, magic) {
-			return true
+	for _, m := range magicTable {
+		if bytes.HasPrefix(contentStr, m.magic) {
+			return m.fileType
 		}
 	}
 
-	return false
+	for _, prefix := range utf16Prefixes {
+		if bytes.HasPrefix(content, prefix) {
+			return FileTypeXML
+		}
+	}
+
+	trimmed := bytes.TrimLeft(bytes.TrimPrefix(content, utf8BOM), " \t\r\n")
+	for _, prefix := range xmlPrefixes {
+		if bytes.HasPrefix(trimmed, prefix) {
+			return FileTypeXML
+		}
+	}
+
+	// TLS reports are a single JSON object
+	if bytes.HasPrefix(trimmed, []byte("{")) {
+		return FileTypeJSON
+	}
+
+	return FileTypeUnknown
+}
+
+// IsSupportedArchive checks the magic bytes of the content for a supported archive
+func IsSupportedArchive(content []byte) bool {
+	switch DetectFileType(content) {
+	case FileTypeGzip, FileTypeZip, FileTypeBzip2, FileTypeXz, FileTypeZstd:
+		return true
+	default:
+		return false
+	}
 }
@@ -0,0 +1,51 @@
+package helper
+
+import "testing"
+
+func TestDetectFileType(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		content  []byte
+		expected FileType
+	}{
+		{name: "gzip", content: []byte{0x1f, 0x8b, 0x08, 0x00}, expected: FileTypeGzip},
+		{name: "zip", content: []byte{0x50, 0x4b, 0x03, 0x04, 0x0a}, expected: FileTypeZip},
+		{name: "empty zip", content: []byte{0x50, 0x4b, 0x05, 0x06}, expected: FileTypeZip},
+		{name: "bzip2", content: []byte("BZh91AY&SY"), expected: FileTypeBzip2},
+		{name: "xz", content: []byte{0xfd, 0x37, 0x7a, 0x58, 0x5a, 0x00, 0x00}, expected: FileTypeXz},
+		{name: "zstd", content: []byte{0x28, 0xb5, 0x2f, 0xfd, 0x04}, expected: FileTypeZstd},
+		{name: "xml declaration", content: []byte(`<?xml version="1.0"?><feedback/>`), expected: FileTypeXML},
+		{name: "xml with bom and whitespace", content: []byte("\xef\xbb\xbf\r\n  <feedback>"), expected: FileTypeXML},
+		{name: "xml without feedback", content: []byte("<?xml version=\"1.0\"?>\n<report_metadata>"), expected: FileTypeXML},
+		{name: "xml without declaration and feedback", content: []byte("<version>1.0</version>"), expected: FileTypeXML},
+		{name: "utf-16 little endian", content: []byte{0xff, 0xfe, '<', 0x00, '?', 0x00}, expected: FileTypeXML},
+		{name: "utf-16 big endian", content: []byte{0xfe, 0xff, 0x00, '<', 0x00, '?'}, expected: FileTypeXML},
+		{name: "json", content: []byte("\r\n {\"organization-name\": \"Company-X\"}"), expected: FileTypeJSON},
+		{name: "html", content: []byte("<html><body></body></html>"), expected: FileTypeUnknown},
+		{name: "text", content: []byte("test"), expected: FileTypeUnknown},
+		{name: "empty", content: []byte{}, expected: FileTypeUnknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := DetectFileType(tt.content); got != tt.expected {
+				t.Fatalf("expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestIsSupportedArchive(t *testing.T) {
+	t.Parallel()
+
+	if !IsSupportedArchive([]byte{0x1f, 0x8b}) {
+		t.Fatal("gzip should be a supported archive")
+	}
+	if IsSupportedArchive([]byte("<?xml")) {
+		t.Fatal("xml should not be a supported archive")
+	}
+}
@@ -8,6 +8,10 @@ import (
 	"github.com/firefart/dmarcsyslogforwarder/internal/config"
 )
 
+// RejectedFlag is set on rejected messages. They are kept in the mailbox for
+// manual review and skipped on the next run.
+const RejectedFlag = "$DMARCRejected"
+
 func Connect(conf config.IMAPConfig, logger imap.Logger) (*client.Client, error) {
 	tlsConfig := tls.Config{} // nolint: gosec
 	if conf.IgnoreCert {
@@ -73,3 +77,14 @@ func MarkMessageAsDeleted(c *client.Client, msgUID uint32) error {
 	}
 	return nil
 }
+
+func MarkMessageAsRejected(c *client.Client, msgUID uint32) error {
+	seq := new(imap.SeqSet)
+	seq.AddNum(msgUID)
+	item := imap.FormatFlagsOp(imap.AddFlags, true)
+	flags := []interface{}{RejectedFlag}
+	if err := c.UidStore(seq, item, flags, nil); err != nil {
+		return err
+	}
+	return nil
+}
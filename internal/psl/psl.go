@@ -0,0 +1,163 @@
+// Package psl determines public suffixes and organisational domains using
+// the Public Suffix List (https://publicsuffix.org/).
+//
+// A copy of the list is embedded in the binary. Run go generate to update the
+// embedded copy or use LoadFile to read a newer list at runtime.
+package psl
+
+//go:generate curl -sSfLo public_suffix_list.dat https://publicsuffix.org/list/public_suffix_list.dat
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/idna"
+)
+
+//go:embed public_suffix_list.dat
+var embeddedList []byte
+
+type rule uint8
+
+const (
+	ruleNormal    rule = 1 << iota
+	ruleWildcard       // the children of the domain are public suffixes
+	ruleException      // the domain is not a public suffix despite a wildcard
+)
+
+// List is a parsed Public Suffix List. Both the ICANN and the private
+// section of the list are used.
+type List struct {
+	rules map[string]rule
+}
+
+var defaultList = sync.OnceValue(func() *List {
+	l, err := Parse(bytes.NewReader(embeddedList))
+	if err != nil {
+		panic(fmt.Sprintf("invalid embedded public suffix list: %v", err))
+	}
+	return l
+})
+
+// Default returns the embedded list
+func Default() *List {
+	return defaultList()
+}
+
+// LoadFile reads the list from the file
+func LoadFile(filename string) (*List, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("could not open public suffix list: %w", err)
+	}
+	defer f.Close()
+	l, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse public suffix list %s: %w", filename, err)
+	}
+	return l, nil
+}
+
+// Parse reads a list in the format of public_suffix_list.dat
+func Parse(r io.Reader) (*List, error) {
+	l := &List{rules: make(map[string]rule)}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "//") {
+			continue
+		}
+		// only the first word of a line is the rule
+		line, _, _ = strings.Cut(line, " ")
+
+		kind := ruleNormal
+		switch {
+		case strings.HasPrefix(line, "!"):
+			kind = ruleException
+			line = line[1:]
+		case strings.HasPrefix(line, "*."):
+			kind = ruleWildcard
+			line = line[2:]
+		}
+		domain, err := idna.ToASCII(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rule %q: %w", line, err)
+		}
+		l.rules[domain] |= kind
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(l.rules) == 0 {
+		return nil, errors.New("list contains no rules")
+	}
+	return l, nil
+}
+
+// PublicSuffix returns the public suffix of the domain. Domains not covered by
+// a rule use the implicit "*" rule, so the top level domain is returned.
+// An empty string is returned for invalid domains.
+func (l *List) PublicSuffix(domain string) string {
+	labels, n := l.split(domain)
+	if n == 0 {
+		return ""
+	}
+	return strings.Join(labels[len(labels)-n:], ".")
+}
+
+// OrganizationalDomain returns the public suffix plus one label (RFC 7489
+// section 3.2). An empty string is returned if the domain is a public suffix
+// itself or invalid.
+func (l *List) OrganizationalDomain(domain string) string {
+	labels, n := l.split(domain)
+	if n == 0 || n >= len(labels) {
+		return ""
+	}
+	return strings.Join(labels[len(labels)-n-1:], ".")
+}
+
+// split returns the labels of the normalized domain and the number of labels
+// of the public suffix. The labels keep their original form so unicode
+// domains are returned as unicode.
+func (l *List) split(domain string) ([]string, int) {
+	domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+	if domain == "" {
+		return nil, 0
+	}
+	labels := strings.Split(domain, ".")
+	ascii := make([]string, len(labels))
+	for i, label := range labels {
+		if label == "" {
+			return nil, 0
+		}
+		a, err := idna.ToASCII(label)
+		if err != nil {
+			return nil, 0
+		}
+		ascii[i] = a
+	}
+
+	// the implicit default rule "*"
+	suffix := 1
+	for k := 1; k <= len(ascii); k++ {
+		kind := l.rules[strings.Join(ascii[len(ascii)-k:], ".")]
+		if kind&ruleException != 0 {
+			suffix = k - 1
+			break
+		}
+		if kind&ruleNormal != 0 {
+			suffix = k
+		}
+		if kind&ruleWildcard != 0 && k < len(ascii) {
+			suffix = k + 1
+		}
+	}
+	return labels, suffix
+}
@@ -0,0 +1,111 @@
+package psl
+
+import (
+	"bufio"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// testdata/test_psl.txt contains the official test cases of the list
+var checkPublicSuffix = regexp.MustCompile(`^checkPublicSuffix\((null|'[^']*'), (null|'[^']*')\);`)
+
+func TestOrganizationalDomain(t *testing.T) {
+	t.Parallel()
+
+	f, err := os.Open("testdata/test_psl.txt")
+	if err != nil {
+		t.Fatalf("could not open test cases: %v", err)
+	}
+	defer f.Close()
+
+	l := Default()
+	cases := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		m := checkPublicSuffix.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			continue
+		}
+		cases++
+		input, expected := unquote(m[1]), unquote(m[2])
+		if got := l.OrganizationalDomain(input); got != expected {
+			t.Errorf("OrganizationalDomain(%q): expected %q, got %q", input, expected, got)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("could not read test cases: %v", err)
+	}
+	if cases == 0 {
+		t.Fatal("no test cases found")
+	}
+}
+
+func unquote(s string) string {
+	if s == "null" {
+		return ""
+	}
+	return strings.Trim(s, "'")
+}
+
+func TestPublicSuffix(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		domain   string
+		expected string
+	}{
+		{domain: "example.com", expected: "com"},
+		{domain: "mail.example.co.uk", expected: "co.uk"},
+		{domain: "example.github.io.", expected: "github.io"},
+		{domain: "a.b.c.kobe.jp", expected: "c.kobe.jp"},
+		{domain: "www.ck", expected: "ck"},
+		{domain: "unknown", expected: "unknown"},
+		{domain: "", expected: ""},
+		{domain: "a..com", expected: ""},
+	}
+	l := Default()
+	for _, tt := range tests {
+		if got := l.PublicSuffix(tt.domain); got != tt.expected {
+			t.Errorf("PublicSuffix(%q): expected %q, got %q", tt.domain, tt.expected, got)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	content := `// comment
+example
+
+// ===BEGIN PRIVATE DOMAINS===
+*.wild.example
+!keep.wild.example
+`
+	l, err := Parse(strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("could not parse list: %v", err)
+	}
+	tests := map[string]string{
+		"a.example":             "a.example",
+		"b.a.example":           "a.example",
+		"x.wild.example":        "",
+		"a.x.wild.example":      "a.x.wild.example",
+		"keep.wild.example":     "keep.wild.example",
+		"www.keep.wild.example": "keep.wild.example",
+		"www.example.com":       "example.com",
+	}
+	for domain, expected := range tests {
+		if got := l.OrganizationalDomain(domain); got != expected {
+			t.Errorf("OrganizationalDomain(%q): expected %q, got %q", domain, expected, got)
+		}
+	}
+
+	if _, err := Parse(strings.NewReader("// only comments\n")); err == nil {
+		t.Fatal("expected an error on an empty list")
+	}
+	if _, err := LoadFile("testdata/missing.dat"); err == nil {
+		t.Fatal("expected an error on a missing file")
+	}
+}
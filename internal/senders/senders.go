@@ -0,0 +1,269 @@
+// Package senders classifies the source of DMARC records using an inventory
+// of the known sending services like the own MTAs or newsletter providers.
+package senders
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The classes of a sender
+const (
+	ClassInternal             = "internal"
+	ClassAuthorizedThirdParty = "authorized-third-party"
+	ClassForwarder            = "forwarder"
+	ClassUnknown              = "unknown"
+)
+
+// inventoryFile is the format of the inventory file
+type inventoryFile struct {
+	Senders []senderConfig `json:"senders"`
+}
+
+type senderConfig struct {
+	Name        string   `json:"name"`
+	Class       string   `json:"class"`
+	IPRanges    []string `json:"ipRanges"`
+	PTRSuffixes []string `json:"ptrSuffixes"`
+	DKIMDomains []string `json:"dkimDomains"`
+}
+
+type sender struct {
+	name        string
+	class       string
+	prefixes    []netip.Prefix
+	ptrSuffixes []string
+	dkimDomains []string
+}
+
+// Source contains the identifiers of a record used for the classification
+type Source struct {
+	IP string
+	// Hostnames should only contain forward-confirmed PTR names as
+	// everybody controlling an IP range can set arbitrary PTR records
+	Hostnames []string
+	// DKIMDomains should only contain the domains of passed signatures
+	DKIMDomains []string
+}
+
+// Match is the result of the classification
+type Match struct {
+	Name  string
+	Class string
+}
+
+// Inventory contains the known senders. The file is reloaded by Watch if it
+// changes on disk.
+type Inventory struct {
+	filename string
+	logger   *slog.Logger
+	mutex    sync.RWMutex
+	senders  []sender
+	modTime  time.Time
+	size     int64
+}
+
+// Load reads the inventory file
+func Load(filename string, logger *slog.Logger) (*Inventory, error) {
+	inv := &Inventory{filename: filename, logger: logger}
+	if err := inv.load(); err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+func (inv *Inventory) load() error {
+	stat, err := os.Stat(inv.filename)
+	if err != nil {
+		return fmt.Errorf("could not open sender inventory: %w", err)
+	}
+	content, err := os.ReadFile(inv.filename) // nolint: gosec
+	if err != nil {
+		return fmt.Errorf("could not read sender inventory: %w", err)
+	}
+	senders, err := parse(content)
+	if err != nil {
+		return fmt.Errorf("invalid sender inventory %s: %w", inv.filename, err)
+	}
+
+	inv.mutex.Lock()
+	defer inv.mutex.Unlock()
+	inv.senders = senders
+	inv.modTime = stat.ModTime()
+	inv.size = stat.Size()
+	return nil
+}
+
+func parse(content []byte) ([]sender, error) {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+	var f inventoryFile
+	if err := decoder.Decode(&f); err != nil {
+		return nil, err
+	}
+
+	senders := make([]sender, 0, len(f.Senders))
+	for i, c := range f.Senders {
+		if c.Name == "" {
+			return nil, fmt.Errorf("sender %d has no name", i+1)
+		}
+		switch c.Class {
+		case ClassInternal, ClassAuthorizedThirdParty, ClassForwarder:
+		default:
+			return nil, fmt.Errorf("sender %q has an invalid class %q", c.Name, c.Class)
+		}
+		s := sender{name: c.Name, class: c.Class}
+		for _, r := range c.IPRanges {
+			prefix, err := parsePrefix(r)
+			if err != nil {
+				return nil, fmt.Errorf("sender %q has an invalid ip range %q: %w", c.Name, r, err)
+			}
+			s.prefixes = append(s.prefixes, prefix)
+		}
+		for _, p := range c.PTRSuffixes {
+			s.ptrSuffixes = append(s.ptrSuffixes, normalize(p))
+		}
+		for _, d := range c.DKIMDomains {
+			s.dkimDomains = append(s.dkimDomains, normalize(d))
+		}
+		senders = append(senders, s)
+	}
+	return senders, nil
+}
+
+// parsePrefix parses a CIDR range, a single IP is treated as a range
+// containing only this IP
+func parsePrefix(s string) (netip.Prefix, error) {
+	if !strings.Contains(s, "/") {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return prefix.Masked(), nil
+}
+
+func normalize(domain string) string {
+	return strings.TrimPrefix(strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), "."), ".")
+}
+
+// hasDomainSuffix checks if the name is the domain or a subdomain of it
+func hasDomainSuffix(name, domain string) bool {
+	name = normalize(name)
+	if name == "" || domain == "" {
+		return false
+	}
+	return name == domain || strings.HasSuffix(name, "."+domain)
+}
+
+// Classify returns the sender matching the source. IP ranges are checked
+// first, followed by PTR suffixes and DKIM domains, as third parties may also
+// sign with the own domain. The most specific PTR suffix or DKIM domain wins.
+// Unknown sources return the class unknown. It is safe to call Classify on a
+// nil Inventory, in this case an empty match is returned.
+func (inv *Inventory) Classify(source Source) Match {
+	if inv == nil {
+		return Match{}
+	}
+
+	inv.mutex.RLock()
+	defer inv.mutex.RUnlock()
+
+	if addr, err := netip.ParseAddr(source.IP); err == nil {
+		addr = addr.Unmap()
+		for _, s := range inv.senders {
+			for _, p := range s.prefixes {
+				if p.Contains(addr) {
+					return s.match()
+				}
+			}
+		}
+	}
+	if s := inv.bestMatch(source.Hostnames, func(s sender) []string { return s.ptrSuffixes }); s != nil {
+		return s.match()
+	}
+	if s := inv.bestMatch(source.DKIMDomains, func(s sender) []string { return s.dkimDomains }); s != nil {
+		return s.match()
+	}
+	return Match{Class: ClassUnknown}
+}
+
+// bestMatch returns the sender with the longest domain matching one of the
+// names, nil if no sender matches
+func (inv *Inventory) bestMatch(names []string, domains func(sender) []string) *sender {
+	var best *sender
+	bestLen := 0
+	for i, s := range inv.senders {
+		for _, domain := range domains(s) {
+			if len(domain) <= bestLen {
+				continue
+			}
+			for _, name := range names {
+				if hasDomainSuffix(name, domain) {
+					best = &inv.senders[i]
+					bestLen = len(domain)
+					break
+				}
+			}
+		}
+	}
+	return best
+}
+
+func (s sender) match() Match {
+	return Match{Name: s.name, Class: s.class}
+}
+
+// Watch checks the file every interval and reloads the inventory if it
+// changed until the context is done. If the changed file is invalid the
+// previous inventory is kept.
+func (inv *Inventory) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			inv.reload()
+		}
+	}
+}
+
+func (inv *Inventory) reload() {
+	stat, err := os.Stat(inv.filename)
+	if err != nil {
+		inv.logger.Warn("could not check sender inventory", slog.String("filename", inv.filename), slog.String("err", err.Error()))
+		return
+	}
+	inv.mutex.RLock()
+	unchanged := stat.ModTime().Equal(inv.modTime) && stat.Size() == inv.size
+	inv.mutex.RUnlock()
+	if unchanged {
+		return
+	}
+
+	if err := inv.load(); err != nil {
+		inv.logger.Warn("could not reload sender inventory, keeping the previous version", slog.String("filename", inv.filename), slog.String("err", err.Error()))
+		// only retry after the next change of the file
+		inv.mutex.Lock()
+		inv.modTime = stat.ModTime()
+		inv.size = stat.Size()
+		inv.mutex.Unlock()
+		return
+	}
+	inv.logger.Info("reloaded sender inventory", slog.String("filename", inv.filename))
+}
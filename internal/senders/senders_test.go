@@ -0,0 +1,162 @@
+package senders
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	t.Parallel()
+
+	inv, err := Load("testdata/senders.json", slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatalf("could not load inventory: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		source   Source
+		expected Match
+	}{
+		{
+			name:     "ip range",
+			source:   Source{IP: "192.0.2.25"},
+			expected: Match{Name: "Example MTAs", Class: ClassInternal},
+		},
+		{
+			name:     "ipv6 range",
+			source:   Source{IP: "2001:db8::25"},
+			expected: Match{Name: "Example MTAs", Class: ClassInternal},
+		},
+		{
+			name:     "single ip",
+			source:   Source{IP: "::ffff:198.51.100.10"},
+			expected: Match{Name: "Newsletter Service", Class: ClassAuthorizedThirdParty},
+		},
+		{
+			name:     "ptr suffix",
+			source:   Source{IP: "203.0.113.1", Hostnames: []string{"out1.Lists.Example.org."}},
+			expected: Match{Name: "Mailing List Provider", Class: ClassForwarder},
+		},
+		{
+			name:     "ptr suffix requires a label boundary",
+			source:   Source{IP: "203.0.113.1", Hostnames: []string{"evilmx.example.com"}},
+			expected: Match{Class: ClassUnknown},
+		},
+		{
+			name:     "dkim domain",
+			source:   Source{IP: "203.0.113.1", DKIMDomains: []string{"news.example.com"}},
+			expected: Match{Name: "Newsletter Service", Class: ClassAuthorizedThirdParty},
+		},
+		{
+			name:     "parent dkim domain",
+			source:   Source{IP: "203.0.113.1", DKIMDomains: []string{"mail.example.com"}},
+			expected: Match{Name: "Example MTAs", Class: ClassInternal},
+		},
+		{
+			name:     "ip range is preferred over dkim domain",
+			source:   Source{IP: "198.51.100.10", DKIMDomains: []string{"example.com"}},
+			expected: Match{Name: "Newsletter Service", Class: ClassAuthorizedThirdParty},
+		},
+		{
+			name:     "ptr suffix is preferred over dkim domain",
+			source:   Source{IP: "203.0.113.1", Hostnames: []string{"mail.newsletter.example"}, DKIMDomains: []string{"example.com"}},
+			expected: Match{Name: "Newsletter Service", Class: ClassAuthorizedThirdParty},
+		},
+		{
+			name:     "unknown",
+			source:   Source{IP: "203.0.113.1", Hostnames: []string{"mail.example.net"}, DKIMDomains: []string{"example.net"}},
+			expected: Match{Class: ClassUnknown},
+		},
+		{
+			name:     "invalid ip",
+			source:   Source{IP: "invalid"},
+			expected: Match{Class: ClassUnknown},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if got := inv.Classify(tt.source); got != tt.expected {
+				t.Fatalf("expected %+v, got %+v", tt.expected, got)
+			}
+		})
+	}
+
+	var empty *Inventory
+	if got := empty.Classify(Source{IP: "192.0.2.25"}); got != (Match{}) {
+		t.Fatalf("expected an empty match on a nil inventory, got %+v", got)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{name: "invalid json", content: `{"senders": [`},
+		{name: "unknown field", content: `{"senders": [{"name": "a", "class": "internal", "ip": "192.0.2.1"}]}`},
+		{name: "missing name", content: `{"senders": [{"class": "internal"}]}`},
+		{name: "invalid class", content: `{"senders": [{"name": "a", "class": "unknown"}]}`},
+		{name: "invalid ip range", content: `{"senders": [{"name": "a", "class": "internal", "ipRanges": ["192.0.2.0/33"]}]}`},
+		{name: "invalid ip", content: `{"senders": [{"name": "a", "class": "internal", "ipRanges": ["192.0.2"]}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			filename := filepath.Join(t.TempDir(), "senders.json")
+			if err := os.WriteFile(filename, []byte(tt.content), 0o600); err != nil {
+				t.Fatalf("could not write file: %v", err)
+			}
+			if _, err := Load(filename, slog.New(slog.DiscardHandler)); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+
+	if _, err := Load("testdata/missing.json", slog.New(slog.DiscardHandler)); err == nil {
+		t.Fatal("expected an error on a missing file")
+	}
+}
+
+func TestReload(t *testing.T) {
+	t.Parallel()
+
+	// all versions differ in size so the changes are detected on filesystems
+	// with a coarse timestamp
+	filename := filepath.Join(t.TempDir(), "senders.json")
+	writeFile(t, filename, `{"senders": [{"name": "Old", "class": "internal", "ipRanges": ["192.0.2.0/24"]}]}`)
+
+	inv, err := Load(filename, slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatalf("could not load inventory: %v", err)
+	}
+	source := Source{IP: "192.0.2.25"}
+	if got := inv.Classify(source).Name; got != "Old" {
+		t.Fatalf("expected Old, got %q", got)
+	}
+
+	// an invalid update keeps the previous inventory
+	writeFile(t, filename, `{"senders": [`)
+	inv.reload()
+	if got := inv.Classify(source).Name; got != "Old" {
+		t.Fatalf("expected the previous inventory after an invalid update, got %q", got)
+	}
+
+	writeFile(t, filename, `{"senders": [{"name": "New", "class": "authorized-third-party", "ipRanges": ["192.0.2.0/24"]}]}`)
+	inv.reload()
+	if got := inv.Classify(source); got.Name != "New" || got.Class != ClassAuthorizedThirdParty {
+		t.Fatalf("expected the updated inventory, got %+v", got)
+	}
+}
+
+func writeFile(t *testing.T, filename, content string) {
+	t.Helper()
+	if err := os.WriteFile(filename, []byte(content), 0o600); err != nil {
+		t.Fatalf("could not write %s: %v", filename, err)
+	}
+}
@@ -0,0 +1,363 @@
+package spf
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// term is a mechanism of an SPF record
+type term struct {
+	qualifier byte
+	name      string
+	// domain is the unexpanded domain-spec, empty if the current domain
+	// is used
+	domain string
+	// prefix is set for ip4 and ip6
+	prefix netip.Prefix
+	// cidr4 and cidr6 are the prefix lengths of a and mx
+	cidr4 int
+	cidr6 int
+	raw   string
+}
+
+// record is a parsed SPF record
+type record struct {
+	terms    []term
+	redirect string
+}
+
+// hasAll checks if the record contains an all mechanism. A redirect is
+// ignored in this case.
+func (r record) hasAll() bool {
+	for _, t := range r.terms {
+		if t.name == "all" {
+			return true
+		}
+	}
+	return false
+}
+
+// isSPFRecord checks if the TXT record is an SPF record
+func isSPFRecord(txt string) bool {
+	if len(txt) < 6 || !strings.EqualFold(txt[:6], "v=spf1") {
+		return false
+	}
+	return len(txt) == 6 || txt[6] == ' '
+}
+
+// parseRecord parses an SPF record (RFC 7208 section 4.6)
+func parseRecord(txt string) (record, error) {
+	var r record
+	fields := strings.Fields(txt)
+	if len(fields) == 0 || !strings.EqualFold(fields[0], "v=spf1") {
+		return r, errors.New("not an spf record")
+	}
+	for _, f := range fields[1:] {
+		if name, value, ok := strings.Cut(f, "="); ok && isModifierName(name) {
+			// exp and unknown modifiers do not affect the result
+			if strings.EqualFold(name, "redirect") {
+				if r.redirect != "" {
+					return r, errors.New("multiple redirect modifiers")
+				}
+				if value == "" {
+					return r, errors.New("empty redirect modifier")
+				}
+				r.redirect = value
+			}
+			continue
+		}
+		t, err := parseTerm(f)
+		if err != nil {
+			return r, err
+		}
+		r.terms = append(r.terms, t)
+	}
+	return r, nil
+}
+
+func isModifierName(name string) bool {
+	if name == "" || !isAlpha(name[0]) {
+		return false
+	}
+	for i := 1; i < len(name); i++ {
+		c := name[i]
+		if !isAlpha(c) && (c < '0' || c > '9') && c != '-' && c != '_' && c != '.' {
+			return false
+		}
+	}
+	return true
+}
+
+func isAlpha(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func parseTerm(s string) (term, error) {
+	t := term{qualifier: '+', raw: s, cidr4: 32, cidr6: 128}
+	rest := s
+	switch rest[0] {
+	case '+', '-', '~', '?':
+		t.qualifier = rest[0]
+		rest = rest[1:]
+	}
+
+	// the name ends at the first ":" or "/"
+	end := strings.IndexAny(rest, ":/")
+	if end < 0 {
+		end = len(rest)
+	}
+	t.name = strings.ToLower(rest[:end])
+	rest = rest[end:]
+	var arg string
+	if strings.HasPrefix(rest, ":") {
+		arg = rest[1:]
+		rest = ""
+	}
+
+	var err error
+	switch t.name {
+	case "all":
+		if rest != "" || arg != "" {
+			return t, fmt.Errorf("invalid mechanism %q", s)
+		}
+	case "include", "exists":
+		if rest != "" || arg == "" {
+			return t, fmt.Errorf("invalid mechanism %q", s)
+		}
+		t.domain = arg
+	case "ptr":
+		if rest != "" {
+			return t, fmt.Errorf("invalid mechanism %q", s)
+		}
+		t.domain = arg
+	case "a", "mx":
+		// the cidr follows the domain-spec or the name
+		cidr := rest
+		if arg != "" {
+			if i := strings.Index(arg, "/"); i >= 0 {
+				arg, cidr = arg[:i], arg[i:]
+			}
+		}
+		t.domain = arg
+		if t.cidr4, t.cidr6, err = parseDualCIDR(cidr); err != nil {
+			return t, fmt.Errorf("invalid mechanism %q: %w", s, err)
+		}
+	case "ip4", "ip6":
+		if arg == "" {
+			return t, fmt.Errorf("invalid mechanism %q", s)
+		}
+		if t.prefix, err = parseIPPrefix(arg, t.name == "ip6"); err != nil {
+			return t, fmt.Errorf("invalid mechanism %q: %w", s, err)
+		}
+	default:
+		return t, fmt.Errorf("unknown mechanism %q", s)
+	}
+	return t, nil
+}
+
+// parseDualCIDR parses the prefix lengths of a and mx in the format
+// [ "/" ip4-cidr ] [ "//" ip6-cidr ]
+func parseDualCIDR(s string) (int, int, error) {
+	cidr4, cidr6 := 32, 128
+	if s == "" {
+		return cidr4, cidr6, nil
+	}
+	v4, v6, hasV6 := strings.Cut(s, "//")
+	var err error
+	if hasV6 {
+		if cidr6, err = parseCIDRLength(v6, 128); err != nil {
+			return 0, 0, err
+		}
+	}
+	if v4 != "" {
+		if !strings.HasPrefix(v4, "/") {
+			return 0, 0, fmt.Errorf("invalid cidr %q", s)
+		}
+		if cidr4, err = parseCIDRLength(v4[1:], 32); err != nil {
+			return 0, 0, err
+		}
+	}
+	return cidr4, cidr6, nil
+}
+
+func parseCIDRLength(s string, maxLength int) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 || n > maxLength || (len(s) > 1 && s[0] == '0') {
+		return 0, fmt.Errorf("invalid cidr length %q", s)
+	}
+	return n, nil
+}
+
+func parseIPPrefix(s string, ipv6 bool) (netip.Prefix, error) {
+	if !strings.Contains(s, "/") {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		s = addr.String() + "/" + strconv.Itoa(addr.BitLen())
+	}
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	if prefix.Addr().Is6() != ipv6 {
+		return netip.Prefix{}, fmt.Errorf("address family of %q does not match", s)
+	}
+	return prefix.Masked(), nil
+}
+
+// macroContext contains the values used in macro expansion. The local-part
+// of the sender is not part of DMARC reports so "postmaster" is used as
+// defined in RFC 7208 section 4.3.
+type macroContext struct {
+	ip     netip.Addr
+	sender string
+	domain string
+}
+
+// expand expands the macros of a domain-spec (RFC 7208 section 7)
+func (m macroContext) expand(spec string) (string, error) {
+	if !strings.Contains(spec, "%") {
+		return spec, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(spec); i++ {
+		if spec[i] != '%' {
+			b.WriteByte(spec[i])
+			continue
+		}
+		if i+1 >= len(spec) {
+			return "", fmt.Errorf("invalid macro in %q", spec)
+		}
+		i++
+		switch spec[i] {
+		case '%':
+			b.WriteByte('%')
+		case '_':
+			b.WriteByte(' ')
+		case '-':
+			b.WriteString("%20")
+		case '{':
+			end := strings.IndexByte(spec[i:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated macro in %q", spec)
+			}
+			value, err := m.macro(spec[i+1 : i+end])
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(value)
+			i += end
+		default:
+			return "", fmt.Errorf("invalid macro in %q", spec)
+		}
+	}
+	return truncateDomain(b.String()), nil
+}
+
+// macro expands a single macro like "ir" or "d2"
+func (m macroContext) macro(s string) (string, error) {
+	if s == "" {
+		return "", errors.New("empty macro")
+	}
+	letter := s[0]
+	var value string
+	switch letter | 0x20 {
+	case 's':
+		value = "postmaster@" + m.sender
+	case 'l':
+		value = "postmaster"
+	case 'o', 'h':
+		value = m.sender
+	case 'd':
+		value = m.domain
+	case 'i':
+		value = dotted(m.ip)
+	case 'v':
+		value = "in-addr"
+		if m.ip.Is6() {
+			value = "ip6"
+		}
+	case 'p':
+		value = "unknown"
+	default:
+		// c, r and t are only allowed in explanations
+		return "", fmt.Errorf("invalid macro letter %q", letter)
+	}
+
+	// transformers: an optional number of parts, an optional "r" to
+	// reverse and the delimiters
+	rest := s[1:]
+	digits := 0
+	for digits < len(rest) && rest[digits] >= '0' && rest[digits] <= '9' {
+		digits++
+	}
+	keep := 0
+	if digits > 0 {
+		n, err := strconv.Atoi(rest[:digits])
+		if err != nil || n == 0 {
+			return "", fmt.Errorf("invalid macro %q", s)
+		}
+		keep = n
+	}
+	rest = rest[digits:]
+	reverse := false
+	if rest != "" && (rest[0]|0x20) == 'r' {
+		reverse = true
+		rest = rest[1:]
+	}
+	delimiters := "."
+	if rest != "" {
+		if strings.Trim(rest, ".-+,/_=") != "" {
+			return "", fmt.Errorf("invalid macro delimiter in %q", s)
+		}
+		delimiters = rest
+	}
+
+	parts := strings.FieldsFunc(value, func(r rune) bool { return strings.ContainsRune(delimiters, r) })
+	if reverse {
+		for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+			parts[i], parts[j] = parts[j], parts[i]
+		}
+	}
+	if keep > 0 && keep < len(parts) {
+		parts = parts[len(parts)-keep:]
+	}
+	value = strings.Join(parts, ".")
+	if letter >= 'A' && letter <= 'Z' {
+		value = url.QueryEscape(value)
+	}
+	return value, nil
+}
+
+// dotted returns the IP in the dotted format used by the i macro. IPv6
+// addresses are written as dot separated nibbles.
+func dotted(ip netip.Addr) string {
+	if ip.Is4() {
+		return ip.String()
+	}
+	const hex = "0123456789abcdef"
+	b := ip.As16()
+	nibbles := make([]string, 0, 32)
+	for _, x := range b {
+		nibbles = append(nibbles, string(hex[x>>4]), string(hex[x&0x0f]))
+	}
+	return strings.Join(nibbles, ".")
+}
+
+// truncateDomain removes labels from the left until the domain does not
+// exceed 253 characters
+func truncateDomain(domain string) string {
+	for len(domain) > 253 {
+		_, rest, found := strings.Cut(domain, ".")
+		if !found {
+			break
+		}
+		domain = rest
+	}
+	return domain
+}
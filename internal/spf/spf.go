@@ -0,0 +1,483 @@
+// Package spf evaluates the live SPF record of a domain to explain SPF
+// results of DMARC reports. It follows the check_host() function of RFC 7208
+// and additionally reports the matched mechanism and the number of DNS
+// lookups of the whole include tree.
+package spf
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The results of the evaluation (RFC 7208 section 2.6)
+const (
+	ResultPass      = "pass"
+	ResultFail      = "fail"
+	ResultSoftFail  = "softfail"
+	ResultNeutral   = "neutral"
+	ResultNone      = "none"
+	ResultPermError = "permerror"
+	ResultTempError = "temperror"
+)
+
+const (
+	// maxLookups is the limit of DNS querying terms (RFC 7208 section 4.6.4)
+	maxLookups = 10
+	// maxVoidLookups is the limit of lookups without an answer
+	maxVoidLookups = 2
+	// maxMXHosts is the limit of the hosts of a mx or ptr mechanism
+	maxMXHosts = 10
+	// maxTraversal stops counting the lookups of huge record trees
+	maxTraversal = 100
+)
+
+// Resolver is used for all DNS lookups. Not existing names should return a
+// *net.DNSError with IsNotFound set.
+type Resolver interface {
+	LookupTXT(domain string) ([]string, error)
+	LookupIP(host string) ([]netip.Addr, error)
+	LookupMX(domain string) ([]string, error)
+	LookupAddr(ip string) ([]string, error)
+}
+
+// Result is the outcome of the evaluation
+type Result struct {
+	Result string
+	// Mechanism is the matched mechanism, includes are separated by " > "
+	Mechanism string
+	// Record is the domain of the record containing the matched mechanism
+	Record string
+	// Lookups is the number of DNS querying terms of the whole record
+	// including all includes and the redirect, even if they were not
+	// needed for the evaluation
+	Lookups     int
+	VoidLookups int
+	// OverLimit is set if the record exceeds the limit of 10 lookups
+	OverLimit bool
+	// Error explains permerror and temperror results
+	Error string
+}
+
+// Authorized checks if the IP is allowed to send for the domain
+func (r Result) Authorized() bool {
+	return r.Result == ResultPass
+}
+
+type cacheEntry struct {
+	result    Result
+	timestamp time.Time
+}
+
+// Analyzer evaluates SPF records and caches the results
+type Analyzer struct {
+	resolver     Resolver
+	domains      []string
+	cacheTimeout time.Duration
+	mutex        sync.Mutex
+	cache        map[string]cacheEntry
+}
+
+// NewAnalyzer creates an analyzer for the domains and their subdomains. All
+// domains are analyzed if no domain is provided.
+func NewAnalyzer(resolver Resolver, domains []string, cacheTimeout time.Duration) *Analyzer {
+	normalized := make([]string, 0, len(domains))
+	for _, d := range domains {
+		normalized = append(normalized, normalizeDomain(d))
+	}
+	return &Analyzer{
+		resolver:     resolver,
+		domains:      normalized,
+		cacheTimeout: cacheTimeout,
+		cache:        make(map[string]cacheEntry),
+	}
+}
+
+// Analyze evaluates the SPF record of the domain for the ip. nil is returned
+// if the domain is not covered by the analyzer. It is safe to call Analyze on
+// a nil Analyzer.
+func (a *Analyzer) Analyze(domain, ip string) *Result {
+	if a == nil {
+		return nil
+	}
+	domain = normalizeDomain(domain)
+	if domain == "" || !a.covers(domain) {
+		return nil
+	}
+
+	key := domain + " " + ip
+	a.mutex.Lock()
+	if entry, ok := a.cache[key]; ok && time.Since(entry.timestamp) < a.cacheTimeout {
+		a.mutex.Unlock()
+		return &entry.result
+	}
+	a.mutex.Unlock()
+
+	result := Check(a.resolver, domain, ip)
+	// temporary errors are retried on the next record
+	if result.Result != ResultTempError {
+		a.mutex.Lock()
+		a.cache[key] = cacheEntry{result: result, timestamp: time.Now()}
+		a.mutex.Unlock()
+	}
+	return &result
+}
+
+func (a *Analyzer) covers(domain string) bool {
+	if len(a.domains) == 0 {
+		return true
+	}
+	for _, d := range a.domains {
+		if domain == d || strings.HasSuffix(domain, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeDomain(domain string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+}
+
+// Check evaluates the SPF record of the domain for the ip
+func Check(resolver Resolver, domain, ip string) Result {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return Result{Result: ResultPermError, Error: fmt.Sprintf("invalid ip %q", ip)}
+	}
+	e := &evaluator{resolver: resolver, ip: addr.Unmap(), sender: normalizeDomain(domain)}
+	o := e.checkHost(e.sender, nil)
+	return Result{
+		Result:      o.result,
+		Mechanism:   strings.Join(o.mechanisms, " > "),
+		Record:      o.record,
+		Lookups:     e.lookups,
+		VoidLookups: e.voidLookups,
+		OverLimit:   e.lookups > maxLookups,
+		Error:       o.err,
+	}
+}
+
+type outcome struct {
+	result     string
+	mechanisms []string
+	record     string
+	err        string
+}
+
+func errorOutcome(result string, format string, args ...any) outcome {
+	return outcome{result: result, err: fmt.Sprintf(format, args...)}
+}
+
+type evaluator struct {
+	resolver    Resolver
+	ip          netip.Addr
+	sender      string
+	lookups     int
+	voidLookups int
+}
+
+// lookup counts a DNS querying term. False is returned if the limit is
+// exceeded.
+func (e *evaluator) lookup() bool {
+	e.lookups++
+	return e.lookups <= maxLookups
+}
+
+// void counts a lookup without an answer. False is returned if the limit is
+// exceeded.
+func (e *evaluator) void() bool {
+	e.voidLookups++
+	return e.voidLookups <= maxVoidLookups
+}
+
+// fetch returns the SPF record of the domain
+func (e *evaluator) fetch(domain string) (record, outcome, bool) {
+	txt, err := e.resolver.LookupTXT(domain)
+	if err != nil && !isNotFound(err) {
+		return record{}, errorOutcome(ResultTempError, "could not lookup %s: %v", domain, err), false
+	}
+	var records []string
+	for _, t := range txt {
+		if isSPFRecord(t) {
+			records = append(records, t)
+		}
+	}
+	switch len(records) {
+	case 0:
+		return record{}, errorOutcome(ResultNone, "no spf record found for %s", domain), false
+	case 1:
+	default:
+		return record{}, errorOutcome(ResultPermError, "multiple spf records found for %s", domain), false
+	}
+	r, err := parseRecord(records[0])
+	if err != nil {
+		return record{}, errorOutcome(ResultPermError, "invalid spf record of %s: %v", domain, err), false
+	}
+	return r, outcome{}, true
+}
+
+// checkHost evaluates the record of the domain. The chain contains the
+// domains of the includes leading to the domain to detect loops.
+func (e *evaluator) checkHost(domain string, chain []string) outcome {
+	for _, d := range chain {
+		if d == domain {
+			return errorOutcome(ResultPermError, "include loop detected at %s", domain)
+		}
+	}
+	chain = append(chain, domain)
+
+	r, o, ok := e.fetch(domain)
+	if !ok {
+		return o
+	}
+
+	for i, t := range r.terms {
+		matched, o, stop := e.evaluate(t, domain, chain)
+		if stop || matched {
+			e.count(r.terms[i+1:], r, chain)
+			if stop {
+				return o
+			}
+			return outcome{
+				result:     qualifierResult(t.qualifier),
+				mechanisms: append([]string{t.raw}, o.mechanisms...),
+				record:     o.record,
+			}
+		}
+	}
+
+	if r.redirect == "" || r.hasAll() {
+		// the default result if nothing matches
+		return outcome{result: ResultNeutral, record: domain}
+	}
+	if !e.lookup() {
+		return errorOutcome(ResultPermError, "too many dns lookups")
+	}
+	target, err := e.macros(domain).expand(r.redirect)
+	if err != nil {
+		return errorOutcome(ResultPermError, "invalid redirect of %s: %v", domain, err)
+	}
+	o = e.checkHost(normalizeDomain(target), chain)
+	if o.result == ResultNone {
+		return errorOutcome(ResultPermError, "redirect of %s to %s without spf record", domain, target)
+	}
+	if len(o.mechanisms) > 0 {
+		o.mechanisms = append([]string{"redirect=" + r.redirect}, o.mechanisms...)
+	}
+	return o
+}
+
+func (e *evaluator) macros(domain string) macroContext {
+	return macroContext{ip: e.ip, sender: e.sender, domain: domain}
+}
+
+// evaluate checks if the mechanism matches. For includes the returned
+// outcome contains the matched mechanisms of the included record. If stop
+// is set the evaluation ends with the returned outcome.
+func (e *evaluator) evaluate(t term, domain string, chain []string) (bool, outcome, bool) {
+	switch t.name {
+	case "all":
+		return true, outcome{record: domain}, false
+	case "ip4", "ip6":
+		return t.prefix.Contains(e.ip), outcome{record: domain}, false
+	}
+
+	// all other mechanisms query the DNS
+	if !e.lookup() {
+		return false, errorOutcome(ResultPermError, "too many dns lookups"), true
+	}
+	target := domain
+	if t.domain != "" {
+		expanded, err := e.macros(domain).expand(t.domain)
+		if err != nil {
+			return false, errorOutcome(ResultPermError, "invalid mechanism %s in %s: %v", t.raw, domain, err), true
+		}
+		target = normalizeDomain(expanded)
+	}
+
+	var matched bool
+	var err error
+	switch t.name {
+	case "include":
+		o := e.checkHost(target, chain)
+		switch o.result {
+		case ResultPass:
+			return true, o, false
+		case ResultFail, ResultSoftFail, ResultNeutral:
+			return false, outcome{}, false
+		case ResultNone:
+			return false, errorOutcome(ResultPermError, "include of %s without spf record", target), true
+		default:
+			return false, o, true
+		}
+	case "a":
+		matched, err = e.matchHost(target, t)
+	case "mx":
+		matched, err = e.matchMX(target, t)
+	case "ptr":
+		matched, err = e.matchPTR(target)
+	case "exists":
+		var ips []netip.Addr
+		ips, err = e.resolver.LookupIP(target)
+		matched = len(ips) > 0
+		if err == nil && !matched {
+			err = &net.DNSError{Err: "no addresses", Name: target, IsNotFound: true}
+		}
+	}
+	if err != nil {
+		var limitErr *limitError
+		switch {
+		case errors.As(err, &limitErr):
+			return false, errorOutcome(ResultPermError, "%s in %s: %v", t.raw, domain, err), true
+		case isNotFound(err):
+			if !e.void() {
+				return false, errorOutcome(ResultPermError, "too many void dns lookups"), true
+			}
+		default:
+			return false, errorOutcome(ResultTempError, "could not evaluate %s in %s: %v", t.raw, domain, err), true
+		}
+	}
+	return matched, outcome{record: domain}, false
+}
+
+// limitError is returned if a mechanism exceeds a processing limit
+type limitError struct {
+	msg string
+}
+
+func (e *limitError) Error() string {
+	return e.msg
+}
+
+// matchHost checks if an address of the host is in the network of the ip
+func (e *evaluator) matchHost(host string, t term) (bool, error) {
+	ips, err := e.resolver.LookupIP(host)
+	if err != nil {
+		return false, err
+	}
+	if len(ips) == 0 {
+		return false, &net.DNSError{Err: "no addresses", Name: host, IsNotFound: true}
+	}
+	bits := t.cidr4
+	if e.ip.Is6() {
+		bits = t.cidr6
+	}
+	network, err := e.ip.Prefix(bits)
+	if err != nil {
+		return false, err
+	}
+	for _, ip := range ips {
+		if network.Contains(ip.Unmap()) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (e *evaluator) matchMX(domain string, t term) (bool, error) {
+	hosts, err := e.resolver.LookupMX(domain)
+	if err != nil {
+		return false, err
+	}
+	if len(hosts) > maxMXHosts {
+		return false, &limitError{msg: fmt.Sprintf("more than %d mx records", maxMXHosts)}
+	}
+	for _, h := range hosts {
+		// the lookups of the hosts do not count as void lookups
+		matched, err := e.matchHost(h, t)
+		if err != nil && !isNotFound(err) {
+			return false, err
+		}
+		if matched {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// matchPTR checks if a validated PTR name of the ip is the domain or a
+// subdomain of it
+func (e *evaluator) matchPTR(domain string) (bool, error) {
+	names, err := e.resolver.LookupAddr(e.ip.String())
+	if err != nil {
+		return false, err
+	}
+	if len(names) > maxMXHosts {
+		names = names[:maxMXHosts]
+	}
+	for _, n := range names {
+		n = normalizeDomain(n)
+		if n != domain && !strings.HasSuffix(n, "."+domain) {
+			continue
+		}
+		ips, err := e.resolver.LookupIP(n)
+		if err != nil {
+			continue
+		}
+		for _, ip := range ips {
+			if ip.Unmap() == e.ip {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// count adds the lookups of the terms not needed for the evaluation so the
+// lookups of the whole record are known. Includes and the redirect are
+// followed without evaluating them.
+func (e *evaluator) count(terms []term, r record, chain []string) {
+	for _, t := range terms {
+		switch t.name {
+		case "include":
+			e.lookups++
+			e.countDomain(t.domain, chain)
+		case "a", "mx", "ptr", "exists":
+			e.lookups++
+		}
+	}
+	if r.redirect != "" && !r.hasAll() {
+		e.lookups++
+		e.countDomain(r.redirect, chain)
+	}
+}
+
+func (e *evaluator) countDomain(spec string, chain []string) {
+	if e.lookups > maxTraversal || strings.Contains(spec, "%") {
+		return
+	}
+	domain := normalizeDomain(spec)
+	for _, d := range chain {
+		if d == domain {
+			return
+		}
+	}
+	r, _, ok := e.fetch(domain)
+	if !ok {
+		return
+	}
+	e.count(r.terms, r, append(chain, domain))
+}
+
+func qualifierResult(q byte) string {
+	switch q {
+	case '-':
+		return ResultFail
+	case '~':
+		return ResultSoftFail
+	case '?':
+		return ResultNeutral
+	default:
+		return ResultPass
+	}
+}
+
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
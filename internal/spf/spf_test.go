@@ -0,0 +1,311 @@
+package spf
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeResolver answers from static records
+type fakeResolver struct {
+	txt     map[string][]string
+	hosts   map[string][]string
+	mx      map[string][]string
+	ptr     map[string][]string
+	fail    map[string]bool
+	queries int
+}
+
+func notFound(name string) error {
+	return &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func (f *fakeResolver) LookupTXT(domain string) ([]string, error) {
+	f.queries++
+	if f.fail[domain] {
+		return nil, &net.DNSError{Err: "timeout", Name: domain, IsTimeout: true}
+	}
+	records, ok := f.txt[domain]
+	if !ok {
+		return nil, notFound(domain)
+	}
+	return records, nil
+}
+
+func (f *fakeResolver) LookupIP(host string) ([]netip.Addr, error) {
+	ips, ok := f.hosts[host]
+	if !ok {
+		return nil, notFound(host)
+	}
+	ret := make([]netip.Addr, len(ips))
+	for i, ip := range ips {
+		ret[i] = netip.MustParseAddr(ip)
+	}
+	return ret, nil
+}
+
+func (f *fakeResolver) LookupMX(domain string) ([]string, error) {
+	hosts, ok := f.mx[domain]
+	if !ok {
+		return nil, notFound(domain)
+	}
+	return hosts, nil
+}
+
+func (f *fakeResolver) LookupAddr(ip string) ([]string, error) {
+	names, ok := f.ptr[ip]
+	if !ok {
+		return nil, notFound(ip)
+	}
+	return names, nil
+}
+
+func newFakeResolver() *fakeResolver {
+	tooMany := make([]string, 0, 11)
+	for i := range 11 {
+		tooMany = append(tooMany, fmt.Sprintf("include:_spf%d.example.org", i))
+	}
+	f := &fakeResolver{
+		txt: map[string][]string{
+			"example.com": {
+				"google-site-verification=abc",
+				"v=spf1 ip4:192.0.2.0/24 include:_spf.example.com a:web.example.com/28 mx -all",
+			},
+			"_spf.example.com":  {"v=spf1 ip6:2001:db8::/32 include:_spf.esp.example ~all"},
+			"_spf.esp.example":  {"v=spf1 ip4:198.51.100.0/25 -all"},
+			"soft.example.com":  {"v=spf1 ip4:192.0.2.1 ~all"},
+			"neutral.example":   {"v=spf1 ip4:192.0.2.1"},
+			"redirect.example":  {"v=spf1 redirect=example.com"},
+			"badredirect.test":  {"v=spf1 redirect=nx.example"},
+			"multiple.example":  {"v=spf1 -all", "v=spf1 +all"},
+			"invalid.example":   {"v=spf1 ip4:192.0.2.300 -all"},
+			"unknown.example":   {"v=spf1 foo:bar -all"},
+			"loop.example":      {"v=spf1 include:loop2.example -all"},
+			"loop2.example":     {"v=spf1 include:loop.example -all"},
+			"missing.example":   {"v=spf1 include:nospf.example -all"},
+			"nospf.example":     {"some other record"},
+			"void.example":      {"v=spf1 a:v1.example a:v2.example a:v3.example a:v4.example -all"},
+			"macro.example":     {"v=spf1 exists:%{ir}.%{v}._spf.%{d} -all"},
+			"ptr.example":       {"v=spf1 ptr -all"},
+			"temp.example":      {"v=spf1 include:timeout.example -all"},
+			"toomany.example":   {"v=spf1 " + strings.Join(tooMany, " ") + " -all"},
+			"early.example":     {"v=spf1 ip4:192.0.2.1 " + strings.Join(tooMany, " ") + " -all"},
+			"modifiers.example": {"v=spf1 ip4:192.0.2.1 exp=explain.example foo=bar -all"},
+		},
+		hosts: map[string][]string{
+			"web.example.com":                      {"203.0.113.5"},
+			"mail.example.com":                     {"203.0.113.100", "2001:db8:ffff::25"},
+			"1.2.0.192.in-addr._spf.macro.example": {"127.0.0.2"},
+			"mail.ptr.example":                     {"192.0.2.1"},
+			"spoofed.ptr.example":                  {"192.0.2.99"},
+			"v1.example":                           {"203.0.113.1"},
+		},
+		mx: map[string][]string{
+			"example.com": {"mail.example.com"},
+		},
+		ptr: map[string][]string{
+			"192.0.2.1":      {"mail.ptr.example"},
+			"198.51.100.200": {"spoofed.ptr.example", "other.example"},
+		},
+		fail: map[string]bool{"timeout.example": true},
+	}
+	for i := range 11 {
+		f.txt[fmt.Sprintf("_spf%d.example.org", i)] = []string{fmt.Sprintf("v=spf1 ip4:10.0.0.%d -all", i)}
+	}
+	return f
+}
+
+func TestCheck(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		domain    string
+		ip        string
+		result    string
+		mechanism string
+		record    string
+		lookups   int
+		overLimit bool
+	}{
+		{name: "ip4", domain: "example.com", ip: "192.0.2.10", result: ResultPass, mechanism: "ip4:192.0.2.0/24", record: "example.com", lookups: 4},
+		{name: "ipv4 mapped", domain: "example.com", ip: "::ffff:192.0.2.10", result: ResultPass, mechanism: "ip4:192.0.2.0/24", record: "example.com", lookups: 4},
+		{name: "include", domain: "example.com", ip: "2001:db8::1", result: ResultPass, mechanism: "include:_spf.example.com > ip6:2001:db8::/32", record: "_spf.example.com", lookups: 4},
+		{name: "nested include", domain: "Example.COM.", ip: "198.51.100.1", result: ResultPass, mechanism: "include:_spf.example.com > include:_spf.esp.example > ip4:198.51.100.0/25", record: "_spf.esp.example", lookups: 4},
+		{name: "a with cidr", domain: "example.com", ip: "203.0.113.1", result: ResultPass, mechanism: "a:web.example.com/28", record: "example.com", lookups: 4},
+		{name: "mx", domain: "example.com", ip: "2001:db8:ffff::25", result: ResultPass, mechanism: "include:_spf.example.com > ip6:2001:db8::/32", record: "_spf.example.com", lookups: 4},
+		{name: "mx ipv4", domain: "example.com", ip: "203.0.113.100", result: ResultPass, mechanism: "mx", record: "example.com", lookups: 4},
+		{name: "fail", domain: "example.com", ip: "198.51.100.200", result: ResultFail, mechanism: "-all", record: "example.com", lookups: 4},
+		{name: "softfail", domain: "soft.example.com", ip: "198.51.100.200", result: ResultSoftFail, mechanism: "~all", record: "soft.example.com"},
+		{name: "neutral without all", domain: "neutral.example", ip: "198.51.100.200", result: ResultNeutral, record: "neutral.example"},
+		{name: "redirect", domain: "redirect.example", ip: "192.0.2.10", result: ResultPass, mechanism: "redirect=example.com > ip4:192.0.2.0/24", record: "example.com", lookups: 5},
+		{name: "redirect without record", domain: "badredirect.test", ip: "192.0.2.10", result: ResultPermError, lookups: 1},
+		{name: "no record", domain: "nospf.example", ip: "192.0.2.10", result: ResultNone},
+		{name: "no domain", domain: "nx.example", ip: "192.0.2.10", result: ResultNone},
+		{name: "multiple records", domain: "multiple.example", ip: "192.0.2.10", result: ResultPermError},
+		{name: "invalid record", domain: "invalid.example", ip: "192.0.2.10", result: ResultPermError},
+		{name: "unknown mechanism", domain: "unknown.example", ip: "192.0.2.10", result: ResultPermError},
+		{name: "include loop", domain: "loop.example", ip: "192.0.2.10", result: ResultPermError, lookups: 2},
+		{name: "include without record", domain: "missing.example", ip: "192.0.2.10", result: ResultPermError, lookups: 1},
+		{name: "void lookups", domain: "void.example", ip: "192.0.2.10", result: ResultPermError, lookups: 4},
+		{name: "macro", domain: "macro.example", ip: "192.0.2.1", result: ResultPass, mechanism: "exists:%{ir}.%{v}._spf.%{d}", record: "macro.example", lookups: 1},
+		{name: "macro no match", domain: "macro.example", ip: "192.0.2.9", result: ResultFail, mechanism: "-all", record: "macro.example", lookups: 1},
+		{name: "ptr", domain: "ptr.example", ip: "192.0.2.1", result: ResultPass, mechanism: "ptr", record: "ptr.example", lookups: 1},
+		{name: "ptr not validated", domain: "ptr.example", ip: "198.51.100.200", result: ResultFail, mechanism: "-all", record: "ptr.example", lookups: 1},
+		{name: "temporary error", domain: "temp.example", ip: "192.0.2.10", result: ResultTempError, lookups: 1},
+		{name: "over the limit", domain: "toomany.example", ip: "10.0.0.10", result: ResultPermError, lookups: 11, overLimit: true},
+		{name: "match before the limit", domain: "early.example", ip: "192.0.2.1", result: ResultPass, mechanism: "ip4:192.0.2.1", record: "early.example", lookups: 11, overLimit: true},
+		{name: "modifiers", domain: "modifiers.example", ip: "192.0.2.1", result: ResultPass, mechanism: "ip4:192.0.2.1", record: "modifiers.example"},
+		{name: "invalid ip", domain: "example.com", ip: "invalid", result: ResultPermError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got := Check(newFakeResolver(), tt.domain, tt.ip)
+			if got.Result != tt.result || got.Mechanism != tt.mechanism || got.Record != tt.record || got.Lookups != tt.lookups || got.OverLimit != tt.overLimit {
+				t.Fatalf("expected result=%s mechanism=%q record=%q lookups=%d over_limit=%t, got %+v",
+					tt.result, tt.mechanism, tt.record, tt.lookups, tt.overLimit, got)
+			}
+			if (got.Result == ResultPermError || got.Result == ResultTempError) && got.Error == "" {
+				t.Fatalf("expected an error message, got %+v", got)
+			}
+		})
+	}
+}
+
+func TestParseTerm(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		input  string
+		name   string
+		domain string
+		cidr4  int
+		cidr6  int
+		valid  bool
+	}{
+		{input: "a", name: "a", cidr4: 32, cidr6: 128, valid: true},
+		{input: "-A/24", name: "a", cidr4: 24, cidr6: 128, valid: true},
+		{input: "mx//64", name: "mx", cidr4: 32, cidr6: 64, valid: true},
+		{input: "a:example.com/24//64", name: "a", domain: "example.com", cidr4: 24, cidr6: 64, valid: true},
+		{input: "~mx:example.com", name: "mx", domain: "example.com", cidr4: 32, cidr6: 128, valid: true},
+		{input: "a/33"},
+		{input: "a/024"},
+		{input: "include"},
+		{input: "all:example.com"},
+		{input: "ip4:2001:db8::/32"},
+		{input: "ip6:192.0.2.0/24"},
+		{input: "foo"},
+	}
+	for _, tt := range tests {
+		got, err := parseTerm(tt.input)
+		if !tt.valid {
+			if err == nil {
+				t.Errorf("parseTerm(%q): expected an error, got %+v", tt.input, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseTerm(%q): unexpected error %v", tt.input, err)
+			continue
+		}
+		if got.name != tt.name || got.domain != tt.domain || got.cidr4 != tt.cidr4 || got.cidr6 != tt.cidr6 {
+			t.Errorf("parseTerm(%q): got %+v", tt.input, got)
+		}
+	}
+}
+
+func TestExpand(t *testing.T) {
+	t.Parallel()
+
+	m := macroContext{ip: netip.MustParseAddr("192.0.2.3"), sender: "strong-bad.example.com", domain: "email.example.com"}
+	m6 := macroContext{ip: netip.MustParseAddr("2001:db8::cb01"), sender: "example.com", domain: "example.com"}
+	tests := []struct {
+		ctx      macroContext
+		spec     string
+		expected string
+	}{
+		// examples of RFC 7208 section 7.4
+		{ctx: m, spec: "%{s}", expected: "postmaster@strong-bad.example.com"},
+		{ctx: m, spec: "%{o}", expected: "strong-bad.example.com"},
+		{ctx: m, spec: "%{d}", expected: "email.example.com"},
+		{ctx: m, spec: "%{d4}", expected: "email.example.com"},
+		{ctx: m, spec: "%{d3}", expected: "email.example.com"},
+		{ctx: m, spec: "%{d2}", expected: "example.com"},
+		{ctx: m, spec: "%{d1}", expected: "com"},
+		{ctx: m, spec: "%{dr}", expected: "com.example.email"},
+		{ctx: m, spec: "%{d2r}", expected: "example.email"},
+		{ctx: m, spec: "%{l}", expected: "postmaster"},
+		{ctx: m, spec: "%{ir}.%{v}._spf.%{d2}", expected: "3.2.0.192.in-addr._spf.example.com"},
+		{ctx: m, spec: "%{lr-}.lp._spf.%{d2}", expected: "postmaster.lp._spf.example.com"},
+		{ctx: m6, spec: "%{ir}.%{v}._spf.%{d2}", expected: "1.0.b.c.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6._spf.example.com"},
+		{ctx: m, spec: "a%%b%_c%-d", expected: "a%b c%20d"},
+		{ctx: m, spec: "%{S}", expected: "postmaster%40strong-bad.example.com"},
+	}
+	for _, tt := range tests {
+		got, err := tt.ctx.expand(tt.spec)
+		if err != nil {
+			t.Errorf("expand(%q): unexpected error %v", tt.spec, err)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("expand(%q): expected %q, got %q", tt.spec, tt.expected, got)
+		}
+	}
+
+	for _, spec := range []string{"%{c}", "%{d", "%x", "%", "%{d0}", "%{d2x}"} {
+		if _, err := m.expand(spec); err == nil {
+			t.Errorf("expand(%q): expected an error", spec)
+		}
+	}
+}
+
+func TestAnalyzer(t *testing.T) {
+	t.Parallel()
+
+	fake := newFakeResolver()
+	a := NewAnalyzer(fake, []string{"Example.com."}, time.Hour)
+
+	if got := a.Analyze("other.example", "192.0.2.10"); got != nil {
+		t.Fatalf("expected no analysis of an uncovered domain, got %+v", got)
+	}
+	got := a.Analyze("example.com", "198.51.100.200")
+	if got == nil || got.Result != ResultFail || got.Authorized() {
+		t.Fatalf("expected a fail result, got %+v", got)
+	}
+	queries := fake.queries
+	if got := a.Analyze("EXAMPLE.com", "198.51.100.200"); got == nil || got.Result != ResultFail {
+		t.Fatalf("expected the cached result, got %+v", got)
+	}
+	if fake.queries != queries {
+		t.Fatalf("expected no additional queries, got %d", fake.queries-queries)
+	}
+	if got := a.Analyze("soft.example.com", "192.0.2.1"); got == nil || !got.Authorized() {
+		t.Fatalf("expected subdomains to be analyzed, got %+v", got)
+	}
+
+	all := NewAnalyzer(fake, nil, time.Hour)
+	if got := all.Analyze("neutral.example", "192.0.2.1"); got == nil || !got.Authorized() {
+		t.Fatalf("expected all domains to be analyzed, got %+v", got)
+	}
+
+	var empty *Analyzer
+	if got := empty.Analyze("example.com", "192.0.2.10"); got != nil {
+		t.Fatalf("expected no analysis on a nil analyzer, got %+v", got)
+	}
+}
+
+func TestIsNotFound(t *testing.T) {
+	t.Parallel()
+
+	if !isNotFound(fmt.Errorf("wrapped: %w", notFound("example.com"))) {
+		t.Fatal("expected a wrapped not found error to be detected")
+	}
+	if isNotFound(errors.New("no such host")) {
+		t.Fatal("expected a plain error to not be detected")
+	}
+}
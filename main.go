@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"flag"
@@ -16,9 +17,16 @@ import (
 
 	"github.com/firefart/dmarcsyslogforwarder/internal/config"
 	"github.com/firefart/dmarcsyslogforwarder/internal/dmarc"
+	"github.com/firefart/dmarcsyslogforwarder/internal/dmarcrecord"
 	"github.com/firefart/dmarcsyslogforwarder/internal/dns"
+	"github.com/firefart/dmarcsyslogforwarder/internal/fields"
+	"github.com/firefart/dmarcsyslogforwarder/internal/gelf"
+	"github.com/firefart/dmarcsyslogforwarder/internal/geoip"
 	"github.com/firefart/dmarcsyslogforwarder/internal/helper"
 	"github.com/firefart/dmarcsyslogforwarder/internal/imap"
+	"github.com/firefart/dmarcsyslogforwarder/internal/psl"
+	"github.com/firefart/dmarcsyslogforwarder/internal/senders"
+	"github.com/firefart/dmarcsyslogforwarder/internal/spf"
 
 	goimap "github.com/emersion/go-imap"
 	"github.com/emersion/go-message/mail"
@@ -29,12 +37,26 @@ import (
 )
 
 type app struct {
-	sysLog    *syslog.Writer
+	output    io.Writer
 	dns       *dns.CachedDNSResolver
 	config    config.Configuration
 	devMode   bool
 	debugMode bool
 	log       *slog.Logger
+	formatter dmarc.Formatter
+	limits    dmarc.Limits
+	// knownQuirks contains the known quirks of the reporting organisations
+	knownQuirks dmarc.QuirkCatalogue
+	// publicSuffixList is used to determine organisational domains
+	publicSuffixList *psl.List
+	// geoIP is nil if no GeoIP database is configured
+	geoIP *geoip.DB
+	// senders is nil if no sender inventory is configured
+	senders *senders.Inventory
+	// spfAnalyzer is nil if the SPF analysis is disabled
+	spfAnalyzer *spf.Analyzer
+	// policyFetcher is nil if the policy drift check is disabled
+	policyFetcher *dmarcrecord.Fetcher
 }
 
 func main() {
@@ -102,32 +124,163 @@ func main() {
 	}
 }
 
+// newOutput connects to the configured log server. GELF messages are
+// sent via the GELF transport, all other formats via syslog.
+func newOutput(ctx context.Context, settings config.Configuration) (io.WriteCloser, error) {
+	if settings.Format == "gelf" {
+		return gelf.New(ctx, gelf.Options{
+			Protocol:    settings.GELF.Protocol,
+			Server:      settings.GELF.Server,
+			Compression: settings.GELF.Compression,
+			ChunkSize:   settings.GELF.ChunkSize,
+			Timeout:     settings.GELF.Timeout.Duration,
+		})
+	}
+	return syslog.Dial(settings.SyslogProtocol, settings.SyslogServer,
+		syslog.LOG_WARNING|syslog.LOG_DAEMON, settings.SyslogTag)
+}
+
 func configCheck(configFilename string) error {
-	_, err := config.GetConfig(configFilename)
-	return err
+	settings, err := config.GetConfig(configFilename)
+	if err != nil {
+		return err
+	}
+	// also check if the template is valid
+	if _, err = newFormatter(settings); err != nil {
+		return err
+	}
+	if _, err = newPublicSuffixList(settings); err != nil {
+		return err
+	}
+	if _, err = dmarc.ParseQuirkCatalogue(settings.KnownQuirks); err != nil {
+		return err
+	}
+	// also check if the sender inventory is valid
+	if settings.Senders.Inventory != "" {
+		if _, err = senders.Load(settings.Senders.Inventory, slog.New(slog.DiscardHandler)); err != nil {
+			return err
+		}
+	}
+	// also check if the GeoIP databases are valid
+	if len(settings.GeoIP.Databases) > 0 {
+		db, err := geoip.Open(settings.GeoIP.Databases, slog.New(slog.DiscardHandler))
+		if err != nil {
+			return err
+		}
+		return db.Close()
+	}
+	return nil
+}
+
+// newPublicSuffixList loads the configured public suffix list. The embedded
+// list is used if no file is configured.
+func newPublicSuffixList(settings config.Configuration) (*psl.List, error) {
+	if settings.PublicSuffixList == "" {
+		return psl.Default(), nil
+	}
+	return psl.LoadFile(settings.PublicSuffixList)
+}
+
+// newFormatter creates the formatter for the configured output format
+func newFormatter(settings config.Configuration) (dmarc.Formatter, error) {
+	opts := dmarc.FormatterOptions{
+		Header: dmarc.DeviceHeader{
+			Vendor:  settings.Header.Vendor,
+			Product: settings.Header.Product,
+			Version: settings.Header.Version,
+		},
+		Fields: fields.NewFilter(settings.Fields.Include, settings.Fields.Exclude, settings.Fields.Rename, settings.Fields.Extra),
+	}
+	if settings.Format == "template" {
+		tmpl, err := dmarc.ParseTemplate(settings.Template)
+		if err != nil {
+			return nil, err
+		}
+		opts.Template = tmpl
+	}
+	return dmarc.NewFormatter(settings.Format, opts)
 }
 
 func run(ctx context.Context, settings config.Configuration, logger *slog.Logger, devMode bool, debugMode bool) error {
-	var sysLog *syslog.Writer
+	var output io.WriteCloser
 	if !devMode {
 		var err error
-		sysLog, err = syslog.Dial(settings.SyslogProtocol, settings.SyslogServer,
-			syslog.LOG_WARNING|syslog.LOG_DAEMON, settings.SyslogTag)
+		output, err = newOutput(ctx, settings)
 		if err != nil {
 			return err
 		}
-		defer sysLog.Close()
+		defer output.Close()
 	}
 
 	dnsResolver := dns.NewCachedDNSResolver(ctx, settings.DNSServer, settings.DNSConnectTimeout.Duration, settings.DNSTimeout.Duration, settings.DNSCacheTimeout.Duration, logger)
 
+	formatter, err := newFormatter(settings)
+	if err != nil {
+		return err
+	}
+
+	publicSuffixList, err := newPublicSuffixList(settings)
+	if err != nil {
+		return err
+	}
+
+	var geoIP *geoip.DB
+	if len(settings.GeoIP.Databases) > 0 {
+		geoIP, err = geoip.Open(settings.GeoIP.Databases, logger)
+		if err != nil {
+			return err
+		}
+		defer geoIP.Close()
+		// reload the databases when they are updated on disk
+		go geoIP.Watch(ctx, settings.GeoIP.ReloadInterval.Duration)
+	}
+
+	var senderInventory *senders.Inventory
+	if settings.Senders.Inventory != "" {
+		senderInventory, err = senders.Load(settings.Senders.Inventory, logger)
+		if err != nil {
+			return err
+		}
+		// reload the inventory when it is updated on disk
+		go senderInventory.Watch(ctx, settings.Senders.ReloadInterval.Duration)
+	}
+
+	var spfAnalyzer *spf.Analyzer
+	if settings.SPFAnalysis.Enabled {
+		spfAnalyzer = spf.NewAnalyzer(dnsResolver, settings.SPFAnalysis.Domains, settings.DNSCacheTimeout.Duration)
+	}
+
+	knownQuirks, err := dmarc.ParseQuirkCatalogue(settings.KnownQuirks)
+	if err != nil {
+		return err
+	}
+
+	var policyFetcher *dmarcrecord.Fetcher
+	if settings.PolicyDrift.Enabled {
+		policyFetcher = dmarcrecord.NewFetcher(dnsResolver, settings.DNSCacheTimeout.Duration)
+	}
+
 	app := app{
-		sysLog:    sysLog,
+		output:    output,
 		dns:       dnsResolver,
 		config:    settings,
 		devMode:   devMode,
 		log:       logger,
 		debugMode: debugMode,
+		formatter: formatter,
+		limits: dmarc.Limits{
+			MaxMessageSize:      settings.Limits.MaxMessageSize,
+			MaxAttachmentSize:   settings.Limits.MaxAttachmentSize,
+			MaxDecompressedSize: settings.Limits.MaxDecompressedSize,
+			MaxCompressionRatio: settings.Limits.MaxCompressionRatio,
+			MaxRecords:          settings.Limits.MaxRecords,
+		},
+		publicSuffixList: publicSuffixList,
+		geoIP:            geoIP,
+		senders:          senderInventory,
+		spfAnalyzer:      spfAnalyzer,
+		policyFetcher:    policyFetcher,
+		knownQuirks:      knownQuirks,
 	}
 
 	// print number of goroutines in devmode
@@ -251,13 +404,14 @@ func (a *app) fetchIMAP(ctx context.Context) (bool, error) {
 	)
 
 	criteria := goimap.NewSearchCriteria()
-	criteria.WithoutFlags = []string{goimap.DeletedFlag}
+	// rejected messages are kept in the mailbox but not processed again
+	criteria.WithoutFlags = []string{goimap.DeletedFlag, imap.RejectedFlag}
 	ids, err := c.Search(criteria)
 	if err != nil {
 		return false, fmt.Errorf("could not search for mails: %w", err)
 	}
 
-	a.log.Debug("found mails without the DELETED flag", slog.Int("count", len(ids)))
+	a.log.Debug("found mails without the DELETED and rejected flag", slog.Int("count", len(ids)))
 
 	if len(ids) == 0 {
 		// no mails to process
@@ -280,10 +434,32 @@ func (a *app) fetchIMAP(ctx context.Context) (bool, error) {
 		}
 	}
 
-	a.log.Debug("Fetching messages", slog.String("messages", seqset.String()))
+	toDelete := make(map[uint32]string)
+	toReject := make(map[uint32]string)
 
-	messages := make(chan *goimap.Message)
+	// check the size first so oversized messages are never downloaded
+	a.log.Debug("Fetching message sizes", slog.String("messages", seqset.String()))
+	sizes := make(chan *goimap.Message)
 	done := make(chan error)
+	go func() {
+		done <- c.Fetch(seqset, []goimap.FetchItem{goimap.FetchEnvelope, goimap.FetchRFC822Size, goimap.FetchUid}, sizes)
+	}()
+	bodySet := new(goimap.SeqSet)
+	for msg := range sizes {
+		if err := a.limits.CheckMessageSize(int64(msg.Size)); err != nil {
+			a.logRejected(msg.Uid, err)
+			toReject[msg.Uid] = msg.Envelope.Subject
+			continue
+		}
+		bodySet.AddNum(msg.SeqNum)
+	}
+	if err := <-done; err != nil {
+		return false, fmt.Errorf("error on fetch: %w", err)
+	}
+
+	a.log.Debug("Fetching messages", slog.String("messages", bodySet.String()))
+
+	messages := make(chan *goimap.Message)
 
 	// Get the whole message body
 	section := &goimap.BodySectionName{}
@@ -296,15 +472,24 @@ func (a *app) fetchIMAP(ctx context.Context) (bool, error) {
 		goimap.FetchUid,
 	}
 	go func() {
-		done <- c.Fetch(seqset, items, messages)
+		if bodySet.Empty() {
+			close(messages)
+			done <- nil
+			return
+		}
+		done <- c.Fetch(bodySet, items, messages)
 	}()
 
 	msgCounter := 0
-	toDelete := make(map[uint32]string)
 	for msg := range messages {
 		a.log.Info("Processing email", slog.String("subject", msg.Envelope.Subject), slog.Int("uid", int(msg.Uid)))
 		valid, err := a.processMessage(ctx, msg)
-		if err != nil {
+		if a.logRejected(msg.Uid, err) {
+			// rejected messages are kept for manual review
+			toReject[msg.Uid] = msg.Envelope.Subject
+			msgCounter++
+			continue
+		} else if err != nil {
 			a.log.Error("could not process message", slog.Int("uid", int(msg.Uid)), slog.String("err", err.Error()))
 			// no continue here, so we can check for a valid message
 		}
@@ -325,6 +510,13 @@ func (a *app) fetchIMAP(ctx context.Context) (bool, error) {
 	}
 
 	if !a.devMode {
+		for uid, subject := range toReject {
+			a.log.Info("Marking message as rejected", slog.String("subject", subject), slog.Int("uid", int(uid)))
+			if err := imap.MarkMessageAsRejected(c, uid); err != nil {
+				return false, fmt.Errorf("could not mark message %d as rejected: %w", int(uid), err)
+			}
+		}
+
 		for uid, subject := range toDelete {
 			a.log.Info("Marking message as deleted", slog.String("subject", subject), slog.Int("uid", int(uid)))
 			if err := imap.MarkMessageAsDeleted(c, uid); err != nil {
@@ -338,26 +530,71 @@ func (a *app) fetchIMAP(ctx context.Context) (bool, error) {
 		}
 	}
 
-	a.log.Info("Processed emails", slog.Int("count", msgCounter))
+	a.log.Info("Processed emails", slog.Int("count", msgCounter), slog.Int("rejected", len(toReject)))
 
 	return hasMore, nil
 }
 
+// logRejected logs messages rejected because of a limit or the validation.
+// It returns false for all other errors.
+func (a *app) logRejected(uid uint32, err error) bool {
+	var limitErr *dmarc.LimitError
+	var validationErr *dmarc.ValidationError
+	switch {
+	case errors.As(err, &validationErr):
+		a.log.Warn("Rejected invalid report",
+			slog.Int("uid", int(uid)),
+			slog.Any("warnings", validationErr.Warnings),
+			slog.String("err", err.Error()),
+		)
+	case errors.As(err, &limitErr):
+		a.log.Warn("Rejected oversized report",
+			slog.Int("uid", int(uid)),
+			slog.String("limit", limitErr.Limit),
+			slog.Int64("max", limitErr.Max),
+			slog.Int64("size", limitErr.Size),
+			slog.String("err", err.Error()),
+		)
+	default:
+		return false
+	}
+	return true
+}
+
+// attachment is a report attached to an email
+type attachment struct {
+	filename  string
+	mediaType string
+	body      []byte
+}
+
 func (a *app) processMessage(ctx context.Context, msg *goimap.Message) (bool, error) {
-	// indicates if the email is a valid dmarc report
-	validDmarcReport := false
+	// all attachments are checked before the first entry is sent
+	var attachments []attachment
 	r := msg.GetBody(&goimap.BodySectionName{})
 	if r == nil {
 		return false, errors.New("server didn't return message body")
 	}
 	a.log.Debug("body length", slog.Int("len", r.Len()))
-	m, err := mail.CreateReader(r)
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return false, fmt.Errorf("could not read message body: %w", err)
+	}
+	m, err := mail.CreateReader(bytes.NewReader(body))
 	if err != nil {
 		return false, fmt.Errorf("could not create reader: %w", err)
 	}
 	defer m.Close()
 	a.log.Debug("reader created")
 
+	// failure reports are sent as multipart/report and need the whole message
+	if mediaType, params, err := m.Header.ContentType(); err == nil && dmarc.IsFailureReport(mediaType, params) {
+		if err := a.sendFailureReport(body); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+
 outer:
 	for {
 		select {
@@ -379,7 +616,7 @@ outer:
 			case *mail.InlineHeader:
 				a.log.Debug("inline header")
 				// This is the message's text (can be plain-text or HTML)
-				b, err := io.ReadAll(p.Body)
+				b, err := dmarc.ReadLimited(p.Body, a.limits.MaxAttachmentSize, "attachment size")
 				if err != nil {
 					return false, fmt.Errorf("could not read inlineheader body: %w", err)
 				}
@@ -407,11 +644,8 @@ outer:
 						return false, errors.New("could not determine filename")
 					}
 
-					if err := a.sendAttachment(filename, b); err != nil {
-						return false, err
-					}
-					// we parsed and sent the attachment so it's valid
-					validDmarcReport = true
+					mediaType, _, _ := inlineHeader.ContentType()
+					attachments = append(attachments, attachment{filename: filename, mediaType: mediaType, body: b})
 				} else {
 					a.log.Debug("message", slog.String("content", string(b)))
 				}
@@ -429,58 +663,171 @@ outer:
 					return false, fmt.Errorf("could not get attachment filename: %w", err)
 				}
 
-				b, err := io.ReadAll(p.Body)
+				b, err := dmarc.ReadLimited(p.Body, a.limits.MaxAttachmentSize, "attachment size")
 				if err != nil {
 					return false, fmt.Errorf("could not read attachment: %w", err)
 				}
 
-				if err := a.sendAttachment(filename, b); err != nil {
-					return false, err
-				}
-				// we parsed and sent the attachment so it's valid
-				validDmarcReport = true
+				mediaType, _, _ := h.ContentType()
+				attachments = append(attachments, attachment{filename: filename, mediaType: mediaType, body: b})
 			default:
 				a.log.Info("header type not implemented", slog.String("header", fmt.Sprintf("%v", p.Header)))
 			}
 		}
 	}
-	return validDmarcReport, nil
+	if err := a.sendAttachments(attachments); err != nil {
+		return false, err
+	}
+	// we parsed and sent the attachments so it's a valid dmarc report
+	return len(attachments) > 0, nil
 }
 
-func (a *app) sendAttachment(filename string, body []byte) error {
-	a.log.Info("Got attachment", slog.String("filename", filename))
-	xmlFilename, xmlReport, err := dmarc.ReadFile(filename, body)
-	if err != nil {
-		return fmt.Errorf("could not read file %s: %w", filename, err)
+// sendAttachments checks all attachments before the first entry is sent so a
+// message exceeding a limit is rejected as a whole and not partially sent
+func (a *app) sendAttachments(attachments []attachment) error {
+	for _, att := range attachments {
+		if err := a.checkAttachment(att); err != nil {
+			return err
+		}
+	}
+	for _, att := range attachments {
+		if err := a.sendAttachment(att.filename, att.mediaType, att.body); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	var r [][]byte
-	switch a.config.Format {
-	case "xml":
-		r, err = dmarc.ConvertToSyslogXML(xmlFilename, *xmlReport, a.dns, a.config.EventID, a.config.EventCategory)
-		if err != nil {
-			return fmt.Errorf("could not convert XML: %w", err)
+// checkAttachment reads the whole attachment without sending it
+func (a *app) checkAttachment(att attachment) error {
+	if dmarc.IsTLSReport(att.filename, att.mediaType) {
+		if _, err := dmarc.ReadTLSReport(att.filename, att.mediaType, att.body, a.limits); err != nil {
+			return fmt.Errorf("could not read tls report %s: %w", att.filename, err)
 		}
-	case "json":
-		r, err = dmarc.ConvertToSyslogJSON(xmlFilename, *xmlReport, a.dns, a.config.EventID, a.config.EventCategory)
-		if err != nil {
-			return fmt.Errorf("could not convert JSON: %w", err)
+		return nil
+	}
+	if err := dmarc.CheckFile(att.filename, att.mediaType, att.body, a.readOptions()); err != nil {
+		return fmt.Errorf("could not read file %s: %w", att.filename, err)
+	}
+	return nil
+}
+
+// readOptions returns the settings used when reading reports
+func (a *app) readOptions() dmarc.ReadOptions {
+	return dmarc.ReadOptions{
+		Limits:      a.limits,
+		Validation:  dmarc.ValidationMode(a.config.Validation),
+		KnownQuirks: a.knownQuirks,
+		OnQuirk: func(f *dmarc.ReportFile, q dmarc.Quirk) {
+			attrs := []any{
+				slog.String("filename", f.Filename),
+				slog.String("reporter", f.Reporter()),
+				slog.String("reporter_domain", f.ReporterDomain()),
+				slog.String("quirk", q.Name),
+				slog.String("description", q.Description),
+			}
+			if f.KnownQuirk(q) {
+				a.log.Info("Applied report quirk", attrs...)
+				return
+			}
+			// quirks not listed for the reporter may be a new problem of the reporter
+			a.log.Warn("Applied unknown report quirk", attrs...)
+		},
+	}
+}
+
+func (a *app) sendFailureReport(body []byte) error {
+	a.log.Info("Got failure report")
+	report, err := dmarc.ReadFailureReport(bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("could not read failure report: %w", err)
+	}
+
+	opts := dmarc.ConvertOptions{
+		EventID:       a.config.EventID,
+		EventCategory: a.config.EventCategory,
+	}
+	entry, err := dmarc.ConvertFailureToSyslog(*report, a.dns, opts, a.formatter)
+	if err != nil {
+		return fmt.Errorf("could not convert failure report: %w", err)
+	}
+
+	return a.writeEntries([][]byte{entry})
+}
+
+func (a *app) sendAttachment(filename, mediaType string, body []byte) error {
+	a.log.Info("Got attachment", slog.String("filename", filename), slog.String("content-type", mediaType))
+	if dmarc.IsTLSReport(filename, mediaType) {
+		return a.sendTLSReport(filename, mediaType, body)
+	}
+
+	opts := dmarc.ConvertOptions{
+		EventID:            a.config.EventID,
+		EventCategory:      a.config.EventCategory,
+		FlattenAuthResults: a.config.AuthResults == "flatten",
+		PublicSuffixList:   a.publicSuffixList,
+		GeoIP:              a.geoIP,
+		Senders:            a.senders,
+		SPFAnalyzer:        a.spfAnalyzer,
+		ForwarderSuffixes:  a.config.Forwarding.PTRSuffixes,
+		PolicyFetcher:      a.policyFetcher,
+		ReportAddresses:    a.config.PolicyDrift.ReportAddresses,
+	}
+	// records are converted and sent one at a time so large reports are never kept in memory
+	err := dmarc.ReadFile(filename, mediaType, body, a.readOptions(), func(f *dmarc.ReportFile) error {
+		a.log.Debug("Converting report", slog.String("filename", f.Filename))
+		for {
+			record, err := f.NextRecord()
+			if errors.Is(err, io.EOF) {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			r, err := dmarc.ConvertRecordToSyslog(f, *record, a.dns, opts, a.formatter)
+			if err != nil {
+				return fmt.Errorf("could not convert report %s: %w", f.Filename, err)
+			}
+			if err := a.writeEntries(r); err != nil {
+				return err
+			}
 		}
-	default:
-		return fmt.Errorf("invalid format %s", a.config.Format)
+	})
+	if err != nil {
+		return fmt.Errorf("could not read file %s: %w", filename, err)
 	}
+	return nil
+}
 
+func (a *app) sendTLSReport(filename, mediaType string, body []byte) error {
+	report, err := dmarc.ReadTLSReport(filename, mediaType, body, a.limits)
+	if err != nil {
+		return fmt.Errorf("could not read tls report %s: %w", filename, err)
+	}
+
+	opts := dmarc.ConvertOptions{
+		EventID:       a.config.EventID,
+		EventCategory: a.config.EventCategory,
+	}
+	r, err := dmarc.ConvertTLSToSyslog(*report, opts, a.formatter)
+	if err != nil {
+		return fmt.Errorf("could not convert tls report: %w", err)
+	}
+
+	return a.writeEntries(r)
+}
+
+// writeEntries sends all converted entries to the output
+func (a *app) writeEntries(r [][]byte) error {
 	for _, report := range r {
 		a.log.Debug("Converted entry", slog.String("report", string(report)))
 
 		// hint: we can't check the number returned here because
 		// it's just the len of the input, so pretty useless
 		if !a.devMode {
-			_, err = a.sysLog.Write(report)
-			if err != nil {
-				return fmt.Errorf("could not send syslog entry: %w", err)
+			if _, err := a.output.Write(report); err != nil {
+				return fmt.Errorf("could not send entry: %w", err)
 			}
-			a.log.Debug("wrote message to syslog")
+			a.log.Debug("wrote message to output")
 		}
 	}
 
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/firefart/dmarcsyslogforwarder/internal/config"
+	"github.com/firefart/dmarcsyslogforwarder/internal/dmarc"
+)
+
+// testReport returns a report with one record per source ip
+func testReport(ips ...string) []byte {
+	var sb strings.Builder
+	sb.WriteString(`<feedback><version>1.0</version>`)
+	sb.WriteString(`<report_metadata><org_name>example.net</org_name><email>dmarc@example.net</email><report_id>1</report_id>`)
+	sb.WriteString(`<date_range><begin>1700000000</begin><end>1700086399</end></date_range></report_metadata>`)
+	sb.WriteString(`<policy_published><domain>example.com</domain><p>reject</p></policy_published>`)
+	for _, ip := range ips {
+		fmt.Fprintf(&sb, `<record><row><source_ip>%s</source_ip><count>1</count>`, ip)
+		sb.WriteString(`<policy_evaluated><disposition>none</disposition><dkim>pass</dkim><spf>pass</spf></policy_evaluated></row>`)
+		sb.WriteString(`<identifiers><header_from>example.com</header_from></identifiers>`)
+		sb.WriteString(`<auth_results><spf><domain>example.com</domain><result>pass</result></spf></auth_results></record>`)
+	}
+	sb.WriteString(`</feedback>`)
+	return []byte(sb.String())
+}
+
+func TestSendAttachmentsStrict(t *testing.T) {
+	t.Parallel()
+
+	formatter, err := dmarc.NewFormatter("json", dmarc.FormatterOptions{})
+	if err != nil {
+		t.Fatalf("could not create formatter: %v", err)
+	}
+	var output bytes.Buffer
+	a := &app{
+		output:    &output,
+		config:    config.Configuration{Validation: "strict"},
+		log:       slog.New(slog.DiscardHandler),
+		formatter: formatter,
+	}
+
+	// the second record of the second attachment is invalid
+	attachments := []attachment{
+		{filename: "a.xml", body: testReport("192.0.2.1")},
+		{filename: "b.xml", body: testReport("192.0.2.1", "invalid", "192.0.2.3")},
+	}
+	err = a.sendAttachments(attachments)
+	var validationErr *dmarc.ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("expected a validation error, got %v", err)
+	}
+	if output.Len() != 0 {
+		t.Fatalf("expected nothing to be written, got %q", output.String())
+	}
+}